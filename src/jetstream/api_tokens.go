@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/apitokens"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/localusers"
+)
+
+const (
+	// Prefix for personal API tokens, so that they are easy to recognise (e.g. by secret scanners)
+	apiTokenPrefix = "stratos_"
+	apiTokenSize   = 32
+
+	// Key on the echo Context for the API token a request was authenticated with
+	apiTokenContextKey = "api_token"
+
+	defaultAPITokenLifetime    = 30 * 24 * time.Hour
+	defaultAPITokenMaxLifetime = 365 * 24 * time.Hour
+
+	// Only record the last used time this often, to avoid a database write on every request
+	apiTokenLastUsedInterval = time.Minute
+)
+
+// APITokenRequest - Request body for creating a personal API token
+type APITokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Lifetime of the token in seconds
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// APITokenRes - A newly created token. The token itself is only returned once
+type APITokenRes struct {
+	interfaces.APIToken
+	Token string `json:"token"`
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIToken() (string, error) {
+	b, err := generateRandomBytes(apiTokenSize)
+	if err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func isValidAPITokenScope(scope string) bool {
+	return scope == interfaces.APITokenScopeRead || scope == interfaces.APITokenScopeWrite || scope == interfaces.APITokenScopeAdmin
+}
+
+// getBearerAPIToken returns the personal API token from the Authorization header, if there is one
+func getBearerAPIToken(c echo.Context) (string, bool) {
+	auth := c.Request().Header.Get(echo.HeaderAuthorization)
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[7:])
+	return token, strings.HasPrefix(token, apiTokenPrefix)
+}
+
+// getRequestAPIToken returns the API token the request was authenticated with, or nil for a cookie session
+func getRequestAPIToken(c echo.Context) *interfaces.APIToken {
+	if token, ok := c.Get(apiTokenContextKey).(*interfaces.APIToken); ok {
+		return token
+	}
+	return nil
+}
+
+// authenticateAPIToken checks a personal API token and sets up the request as if it had a session for the token's user.
+// The session is never saved, so no cookie is issued
+func (p *portalProxy) authenticateAPIToken(c echo.Context, rawToken string) error {
+	log.Debug("authenticateAPIToken")
+
+	invalidToken := func(format string, args ...interface{}) error {
+		return interfaces.NewHTTPShadowError(http.StatusUnauthorized, "Invalid API token", format, args...)
+	}
+
+	repo, err := apitokens.NewPgsqlAPITokensRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return err
+	}
+
+	token, err := repo.FindByHash(hashAPIToken(rawToken))
+	if err != nil {
+		return invalidToken("Unknown API token: %v", err)
+	}
+	now := time.Now()
+	if token.Expires != nil && now.After(*token.Expires) {
+		return invalidToken("API token %s has expired", token.GUID)
+	}
+
+	// Tokens stop working if their local user is disabled
+	if interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] == interfaces.Local {
+		localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(p.DatabaseConnectionPool)
+		if err != nil {
+			return err
+		}
+		if user, err := localUsersRepo.FindUser(token.UserGUID); err != nil || user.Disabled {
+			return invalidToken("API token %s belongs to an unknown or disabled user", token.GUID)
+		}
+	}
+
+	// Read only tokens can only make GET and HEAD requests
+	method := c.Request().Method
+	if method != http.MethodGet && method != http.MethodHead && !token.HasScope(interfaces.APITokenScopeWrite) {
+		return interfaces.NewHTTPShadowError(
+			http.StatusForbidden,
+			"API token does not have the write scope",
+			"API token %s does not have the write scope", token.GUID)
+	}
+
+	if token.LastUsed == nil || now.Sub(*token.LastUsed) > apiTokenLastUsedInterval {
+		if err := repo.UpdateLastUsed(token.GUID, now); err != nil {
+			log.Warnf("Unable to update last used time of API token %s: %v", token.GUID, err)
+		}
+	}
+
+	// Use a new session rather than any session cookie that came with the request
+	session := sessions.NewSession(nil, p.SessionCookieName)
+	session.Options = &sessions.Options{}
+	if p.SessionStoreOptions != nil {
+		options := *p.SessionStoreOptions
+		session.Options = &options
+	}
+	session.Values["user_id"] = token.UserGUID
+	session.Values["exp"] = int64(math.MaxInt64)
+	if token.Expires != nil {
+		session.Values["exp"] = token.Expires.Unix()
+	}
+	c.Set(jetStreamSessionContextKey, session)
+
+	c.Set("user_id", token.UserGUID)
+	c.Set(apiTokenContextKey, &token)
+	return nil
+}
+
+func (p *portalProxy) getAPITokensRepo(c echo.Context) (apitokens.Repository, string, error) {
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return nil, "", echo.NewHTTPError(http.StatusForbidden, "Could not find session user_id")
+	}
+
+	repo, err := apitokens.NewPgsqlAPITokensRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, "", interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to access API tokens",
+			"Database error getting repo for API tokens: %v", err)
+	}
+	return repo, userGUID, nil
+}
+
+func (p *portalProxy) listAPITokens(c echo.Context) error {
+	log.Debug("listAPITokens")
+	repo, userGUID, err := p.getAPITokensRepo(c)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := repo.ListByUser(userGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list API tokens",
+			"Unable to list API tokens for user %s: %v", userGUID, err)
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// createAPIToken mints a new personal API token for the session user. Tokens can't be used to create further tokens
+func (p *portalProxy) createAPIToken(c echo.Context) error {
+	log.Debug("createAPIToken")
+	if getRequestAPIToken(c) != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "API tokens can not be used to create API tokens")
+	}
+
+	repo, userGUID, err := p.getAPITokensRepo(c)
+	if err != nil {
+		return err
+	}
+
+	req := &APITokenRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(req); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid API token request",
+			"Invalid API token request: %v", err)
+	}
+	if len(strings.TrimSpace(req.Name)) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Needs a name")
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{interfaces.APITokenScopeRead}
+	}
+	for _, scope := range req.Scopes {
+		if !isValidAPITokenScope(scope) {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid scope '%s'", scope))
+		}
+	}
+
+	maxLifetime := defaultAPITokenMaxLifetime
+	if p.Config.APITokenMaxLifetimeInSecs > 0 {
+		maxLifetime = time.Duration(p.Config.APITokenMaxLifetimeInSecs) * time.Second
+	}
+	lifetime := defaultAPITokenLifetime
+	if req.ExpiresIn > 0 {
+		lifetime = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if lifetime > maxLifetime {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Tokens can not last longer than %d seconds", int64(maxLifetime.Seconds())))
+	}
+
+	rawToken, err := generateAPIToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	expires := now.Add(lifetime)
+	token := interfaces.APIToken{
+		GUID:      uuid.NewV4().String(),
+		UserGUID:  userGUID,
+		Name:      req.Name,
+		TokenHash: hashAPIToken(rawToken),
+		Scopes:    req.Scopes,
+		Created:   now,
+		Expires:   &expires,
+	}
+	setAuditTarget(c, token.GUID)
+	if err := repo.Create(token); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to create API token",
+			"Unable to create API token for user %s: %v", userGUID, err)
+	}
+
+	log.Infof("Created API token %s for user %s", token.GUID, userGUID)
+	return c.JSON(http.StatusCreated, &APITokenRes{APIToken: token, Token: rawToken})
+}
+
+func (p *portalProxy) revokeAPIToken(c echo.Context) error {
+	log.Debug("revokeAPIToken")
+	repo, userGUID, err := p.getAPITokensRepo(c)
+	if err != nil {
+		return err
+	}
+
+	tokenGUID := c.Param("id")
+	if err := repo.Delete(userGUID, tokenGUID); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"API token not found",
+			"Unable to revoke API token %s for user %s: %v", tokenGUID, userGUID, err)
+	}
+
+	log.Infof("Revoked API token %s for user %s", tokenGUID, userGUID)
+	return c.NoContent(http.StatusNoContent)
+}
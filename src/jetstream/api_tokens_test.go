@@ -0,0 +1,193 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	findAPIToken       = `SELECT (.+) FROM api_tokens WHERE token_hash = (.+)`
+	insertAPIToken     = `INSERT INTO api_tokens (.+)`
+	updateAPITokenUsed = `UPDATE api_tokens SET last_used (.+)`
+	deleteAPIToken     = `DELETE FROM api_tokens (.+)`
+)
+
+var rowFieldsForAPIToken = []string{"guid", "user_guid", "name", "scopes", "created", "expires", "last_used"}
+
+func TestAPITokenAuthentication(t *testing.T) {
+	t.Parallel()
+
+	rawToken, _ := generateAPIToken()
+	tokenRow := func(scopes string, expires time.Time) sqlmock.Rows {
+		return sqlmock.NewRows(rowFieldsForAPIToken).
+			AddRow("token-guid", mockUserGUID, "ci", scopes, time.Now(), expires, nil)
+	}
+
+	Convey("API token authentication tests", t, func() {
+		setup := func(method string) (echo.Context, *portalProxy, sqlmock.Sqlmock, func()) {
+			req := setupMockReq(method, "", nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+rawToken)
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			return ctx, pp, mock, func() { db.Close() }
+		}
+		handlerCalled := false
+		handler := func(c echo.Context) error {
+			handlerCalled = true
+			return nil
+		}
+
+		Convey("should authenticate a valid token", func() {
+			ctx, pp, mock, done := setup("POST")
+			defer done()
+
+			mock.ExpectQuery(findAPIToken).WithArgs(hashAPIToken(rawToken)).
+				WillReturnRows(tokenRow("read,write", time.Now().Add(time.Hour)))
+			mock.ExpectExec(updateAPITokenUsed).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			// XSRF checks aren't needed for token auth
+			err := pp.sessionMiddleware(pp.xsrfMiddleware(handler))(ctx)
+			So(err, ShouldBeNil)
+			So(handlerCalled, ShouldBeTrue)
+			So(ctx.Get("user_id"), ShouldEqual, mockUserGUID)
+
+			userGUID, err := pp.GetSessionStringValue(ctx, "user_id")
+			So(err, ShouldBeNil)
+			So(userGUID, ShouldEqual, mockUserGUID)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should reject an expired token", func() {
+			ctx, pp, mock, done := setup("GET")
+			defer done()
+
+			mock.ExpectQuery(findAPIToken).WillReturnRows(tokenRow("read", time.Now().Add(-time.Hour)))
+
+			err := pp.sessionMiddleware(handler)(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusUnauthorized)
+			So(handlerCalled, ShouldBeFalse)
+		})
+
+		Convey("should reject an unknown token", func() {
+			ctx, pp, mock, done := setup("GET")
+			defer done()
+
+			mock.ExpectQuery(findAPIToken).WillReturnRows(sqlmock.NewRows(rowFieldsForAPIToken))
+
+			err := pp.sessionMiddleware(handler)(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("should not allow writes with a read only token", func() {
+			ctx, pp, mock, done := setup("DELETE")
+			defer done()
+
+			mock.ExpectQuery(findAPIToken).WillReturnRows(tokenRow("read", time.Now().Add(time.Hour)))
+
+			err := pp.sessionMiddleware(handler)(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusForbidden)
+			So(handlerCalled, ShouldBeFalse)
+		})
+
+		Convey("should need the admin scope for admin routes", func() {
+			ctx, pp, mock, done := setup("GET")
+			defer done()
+
+			mock.ExpectQuery(findAPIToken).WillReturnRows(tokenRow("read,write", time.Now().Add(time.Hour)))
+			mock.ExpectExec(updateAPITokenUsed).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			err := pp.sessionMiddleware(pp.adminMiddleware(handler))(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusForbidden)
+			So(handlerCalled, ShouldBeFalse)
+		})
+
+		Convey("should not be able to create more tokens", func() {
+			ctx, pp, mock, done := setup("POST")
+			defer done()
+
+			mock.ExpectQuery(findAPIToken).WillReturnRows(tokenRow("read,write", time.Now().Add(time.Hour)))
+			mock.ExpectExec(updateAPITokenUsed).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			err := pp.sessionMiddleware(pp.createAPIToken)(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func TestAPITokenManagement(t *testing.T) {
+	t.Parallel()
+
+	Convey("API token management tests", t, func() {
+		setup := func(method string, body string) (*httptest.ResponseRecorder, echo.Context, *portalProxy, sqlmock.Sqlmock, func()) {
+			req := setupMockReq(method, "", nil)
+			if len(body) > 0 {
+				req.Body = ioutil.NopCloser(strings.NewReader(body))
+			}
+			res, _, ctx, pp, db, mock := setupHTTPTest(req)
+			ctx.Set("user_id", mockUserGUID)
+			return res, ctx, pp, mock, func() { db.Close() }
+		}
+
+		Convey("should create a token", func() {
+			res, ctx, pp, mock, done := setup("POST", `{"name":"ci","scopes":["read","write"],"expires_in":3600}`)
+			defer done()
+
+			mock.ExpectExec(insertAPIToken).
+				WithArgs(sqlmock.AnyArg(), mockUserGUID, "ci", sqlmock.AnyArg(), "read,write", sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.createAPIToken(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusCreated)
+			So(res.Body.String(), ShouldContainSubstring, `"token":"`+apiTokenPrefix)
+			So(res.Body.String(), ShouldNotContainSubstring, "hash")
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should reject invalid requests", func() {
+			for _, body := range []string{`{"scopes":["read"]}`, `{"name":"ci","scopes":["everything"]}`, `{"name":"ci","expires_in":999999999}`} {
+				_, ctx, pp, _, done := setup("POST", body)
+				err := pp.createAPIToken(ctx)
+				So(err, ShouldNotBeNil)
+				So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusBadRequest)
+				done()
+			}
+		})
+
+		Convey("should revoke a token", func() {
+			_, ctx, pp, mock, done := setup("DELETE", "")
+			defer done()
+			ctx.SetParamNames("id")
+			ctx.SetParamValues("token-guid")
+
+			mock.ExpectExec(deleteAPIToken).WithArgs(mockUserGUID, "token-guid").WillReturnResult(sqlmock.NewResult(1, 1))
+			So(pp.revokeAPIToken(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not revoke another user's token", func() {
+			_, ctx, pp, mock, done := setup("DELETE", "")
+			defer done()
+			ctx.SetParamNames("id")
+			ctx.SetParamValues("token-guid")
+
+			mock.ExpectExec(deleteAPIToken).WithArgs(mockUserGUID, "token-guid").WillReturnResult(sqlmock.NewResult(0, 0))
+			err := pp.revokeAPIToken(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
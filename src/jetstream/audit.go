@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/audit"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	// Keys on the echo Context for the target of an audited request, when the handler knows better than the route,
+	// and for more detail about a successful one
+	auditTargetContextKey = "audit_target"
+	auditDetailContextKey = "audit_detail"
+
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+
+	// Events waiting to be forwarded. If the sinks can't keep up, further events are only kept in the database
+	auditForwardQueueSize = 1000
+	auditForwardTimeout   = 10 * time.Second
+
+	// Syslog facility (authpriv) and severities
+	syslogFacilityAuthPriv = 10
+	syslogSeverityNotice   = 5
+	syslogSeverityInfo     = 6
+)
+
+// AuditLogRes - A page of the audit log
+type AuditLogRes struct {
+	Total  int64                   `json:"total"`
+	Events []interfaces.AuditEvent `json:"events"`
+}
+
+func auditErrorDetail(err error) string {
+	switch e := err.(type) {
+	case interfaces.ErrHTTPShadow:
+		if len(e.LogMessage) > 0 {
+			return e.LogMessage
+		}
+		return e.UserFacingError
+	case *echo.HTTPError:
+		return fmt.Sprint(e.Message)
+	}
+	return err.Error()
+}
+
+// Audit records an administrative or security-relevant action in the audit log. Failing to record it is logged,
+// but doesn't fail the action
+func (p *portalProxy) Audit(c echo.Context, action, target string, actionErr error) {
+	event := interfaces.AuditEvent{
+		GUID:      uuid.NewV4().String(),
+		Timestamp: time.Now(),
+		Action:    action,
+		Target:    target,
+		SourceIP:  p.clientIP(c),
+		Outcome:   interfaces.AuditSuccess,
+	}
+
+	if detail, ok := c.Get(auditDetailContextKey).(string); ok {
+		event.Detail = detail
+	}
+	if actionErr != nil {
+		event.Outcome = interfaces.AuditFailure
+		event.Detail = auditErrorDetail(actionErr)
+	} else if status := c.Response().Status; c.Response().Committed && status >= http.StatusBadRequest {
+		event.Outcome = interfaces.AuditFailure
+		event.Detail = http.StatusText(status)
+	}
+
+	if userGUID, err := p.GetSessionStringValue(c, "user_id"); err == nil {
+		event.ActorGUID = userGUID
+		if user, err := p.getRequestUser(c); err == nil {
+			event.ActorName = user.Name
+		}
+	}
+
+	repo, err := audit.NewPgsqlAuditRepository(p.DatabaseConnectionPool)
+	if err == nil {
+		err = repo.Add(event)
+	}
+	if err != nil {
+		log.Errorf("Unable to record %s of %s by %s in the audit log: %v", action, target, event.ActorGUID, err)
+	}
+
+	if p.AuditForwarder != nil {
+		p.AuditForwarder.forward(event)
+	}
+}
+
+// setAuditTarget sets the target of an audited request, for when it isn't known until the handler has run
+func setAuditTarget(c echo.Context, target string) {
+	c.Set(auditTargetContextKey, target)
+}
+
+// setAuditDetail adds detail to the audit event for a successful request
+func setAuditDetail(c echo.Context, detail string) {
+	c.Set(auditDetailContextKey, detail)
+}
+
+func auditParam(name string) func(echo.Context) string {
+	return func(c echo.Context) string {
+		value, _ := url.PathUnescape(c.Param(name))
+		return value
+	}
+}
+
+func auditFormValue(name string) func(echo.Context) string {
+	return func(c echo.Context) string {
+		return c.FormValue(name)
+	}
+}
+
+// auditAction records the outcome of a route in the audit log
+func (p *portalProxy) auditAction(action string, target func(echo.Context) string) echo.MiddlewareFunc {
+	return func(h echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := h(c)
+			auditTarget, ok := c.Get(auditTargetContextKey).(string)
+			if !ok && target != nil {
+				auditTarget = target(c)
+			}
+			p.Audit(c, action, auditTarget, err)
+			return err
+		}
+	}
+}
+
+func parseAuditFilter(c echo.Context) (interfaces.AuditFilter, error) {
+	filter := interfaces.AuditFilter{
+		Actor:   c.QueryParam("actor"),
+		Action:  c.QueryParam("action"),
+		Target:  c.QueryParam("target"),
+		Outcome: c.QueryParam("outcome"),
+		Limit:   defaultAuditPageSize,
+	}
+
+	for name, t := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := c.QueryParam(name); len(value) > 0 {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid '%s' time - expected RFC 3339", name))
+			}
+			*t = &parsed
+		}
+	}
+
+	if value := c.QueryParam("limit"); len(value) > 0 {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			return filter, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid limit - must be between 1 and %d", maxAuditPageSize))
+		}
+		filter.Limit = limit
+	}
+	if value := c.QueryParam("offset"); len(value) > 0 {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return filter, echo.NewHTTPError(http.StatusBadRequest, "Invalid offset")
+		}
+		filter.Offset = offset
+	}
+	return filter, nil
+}
+
+func (p *portalProxy) listAuditLog(c echo.Context) error {
+	log.Debug("listAuditLog")
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return err
+	}
+
+	repo, err := audit.NewPgsqlAuditRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return err
+	}
+	events, total, err := repo.List(filter)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to read the audit log",
+			"Unable to list audit events: %v", err)
+	}
+	return c.JSON(http.StatusOK, &AuditLogRes{Total: total, Events: events})
+}
+
+// auditSink sends an audit event, encoded as JSON, somewhere else
+type auditSink func(event interfaces.AuditEvent, payload []byte) error
+
+// auditForwarder sends audit events to the configured sinks in the background, so that a slow sink doesn't hold up
+// requests
+type auditForwarder struct {
+	events chan interfaces.AuditEvent
+	sinks  []auditSink
+}
+
+func newAuditForwarder(pc interfaces.PortalConfig) (*auditForwarder, error) {
+	sinks := make([]auditSink, 0)
+	if len(pc.AuditLogSyslogAddress) > 0 {
+		sink, err := newSyslogAuditSink(pc.AuditLogSyslogAddress)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(pc.AuditLogWebhookURL) > 0 {
+		sink, err := newHTTPAuditSink(pc.AuditLogWebhookURL)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+
+	f := &auditForwarder{
+		events: make(chan interfaces.AuditEvent, auditForwardQueueSize),
+		sinks:  sinks,
+	}
+	go f.run()
+	return f, nil
+}
+
+func (f *auditForwarder) forward(event interfaces.AuditEvent) {
+	select {
+	case f.events <- event:
+	default:
+		log.Warnf("Audit log forwarding queue is full - event %s was not forwarded", event.GUID)
+	}
+}
+
+func (f *auditForwarder) run() {
+	for event := range f.events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Errorf("Unable to encode audit event %s: %v", event.GUID, err)
+			continue
+		}
+		for _, sink := range f.sinks {
+			if err := sink(event, payload); err != nil {
+				log.Warnf("Unable to forward audit event %s: %v", event.GUID, err)
+			}
+		}
+	}
+}
+
+// newSyslogAuditSink sends events to a syslog server as RFC 5424 messages, e.g. udp://syslog.example.com:514. TCP
+// messages are separated by newlines
+func newSyslogAuditSink(address string) (auditSink, error) {
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("Invalid audit log syslog address '%s' - expected udp://host:port or tcp://host:port", address)
+	}
+	hostname, _ := os.Hostname()
+	if len(hostname) == 0 {
+		hostname = "-"
+	}
+
+	var lock sync.Mutex
+	var conn net.Conn
+	return func(event interfaces.AuditEvent, payload []byte) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		severity := syslogSeverityInfo
+		if event.Outcome == interfaces.AuditFailure {
+			severity = syslogSeverityNotice
+		}
+		message := fmt.Sprintf("<%d>1 %s %s stratos - audit - %s\n", syslogFacilityAuthPriv*8+severity,
+			event.Timestamp.UTC().Format(time.RFC3339Nano), hostname, payload)
+
+		// Reconnect once if the connection has gone away
+		var err error
+		for attempt := 0; attempt < 2; attempt++ {
+			if conn == nil {
+				if conn, err = net.DialTimeout(u.Scheme, u.Host, auditForwardTimeout); err != nil {
+					conn = nil
+					return err
+				}
+			}
+			conn.SetWriteDeadline(time.Now().Add(auditForwardTimeout))
+			if _, err = conn.Write([]byte(message)); err == nil {
+				return nil
+			}
+			conn.Close()
+			conn = nil
+		}
+		return err
+	}, nil
+}
+
+// newHTTPAuditSink POSTs each event to a webhook
+func newHTTPAuditSink(webhookURL string) (auditSink, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("Invalid audit log webhook URL '%s'", webhookURL)
+	}
+
+	client := &http.Client{Timeout: auditForwardTimeout}
+	return func(event interfaces.AuditEvent, payload []byte) error {
+		res, err := client.Post(webhookURL, echo.MIMEApplicationJSON, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode >= http.StatusMultipleChoices {
+			return fmt.Errorf("Audit log webhook responded with %s", res.Status)
+		}
+		return nil
+	}, nil
+}
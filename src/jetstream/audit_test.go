@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	insertAuditEvent = `INSERT INTO audit_log (.+)`
+	countAuditEvents = `SELECT COUNT\(\*\) FROM audit_log`
+	selectAuditLog   = `SELECT (.+) FROM audit_log`
+)
+
+var rowFieldsForAuditEvent = []string{"guid", "event_time", "actor_guid", "actor_name", "action", "target", "source_ip", "outcome", "detail"}
+
+func expectAuditEvent(mock sqlmock.Sqlmock, action, target, outcome, detail string) {
+	mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+		WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("admin", "", UAAAdminIdentifier, "", "", false))
+	mock.ExpectExec(insertAuditEvent).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), mockUserGUID, "admin", action, target, sqlmock.AnyArg(), outcome, detail).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestAuditAction(t *testing.T) {
+	t.Parallel()
+
+	Convey("Audit middleware tests", t, func() {
+		_, ctx, pp, db, mock := setupLocalUserAdminTest("POST", "", "some-user")
+		defer db.Close()
+
+		Convey("should record a successful action against the route's target", func() {
+			expectAuditEvent(mock, interfaces.AuditLocalUserUpdate, "some-user", interfaces.AuditSuccess, "")
+			handler := pp.auditAction(interfaces.AuditLocalUserUpdate, auditParam("id"))(func(c echo.Context) error {
+				return c.NoContent(http.StatusNoContent)
+			})
+			So(handler(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should record the address of the connection rather than a forwarded address", func() {
+			ctx.Request().RemoteAddr = "203.0.113.9:43210"
+			ctx.Request().Header.Set(echo.HeaderXForwardedFor, "198.51.100.1")
+			mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("admin", "", UAAAdminIdentifier, "", "", false))
+			mock.ExpectExec(insertAuditEvent).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), mockUserGUID, "admin", interfaces.AuditLocalUserUpdate, "some-user", "203.0.113.9", interfaces.AuditSuccess, "").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			handler := pp.auditAction(interfaces.AuditLocalUserUpdate, auditParam("id"))(func(c echo.Context) error {
+				return c.NoContent(http.StatusNoContent)
+			})
+			So(handler(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should prefer the target given by the handler", func() {
+			expectAuditEvent(mock, interfaces.AuditLocalUserCreate, "bob", interfaces.AuditSuccess, "")
+			handler := pp.auditAction(interfaces.AuditLocalUserCreate, nil)(func(c echo.Context) error {
+				setAuditTarget(c, "bob")
+				return c.NoContent(http.StatusCreated)
+			})
+			So(handler(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should record the reason for a failed action", func() {
+			expectAuditEvent(mock, interfaces.AuditLocalUserDelete, "some-user", interfaces.AuditFailure, "Unable to delete local user")
+			handler := pp.auditAction(interfaces.AuditLocalUserDelete, auditParam("id"))(func(c echo.Context) error {
+				return interfaces.NewHTTPShadowError(http.StatusInternalServerError, "Unable to delete user", "Unable to delete local user")
+			})
+			So(handler(ctx), ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should treat an error response as a failure", func() {
+			expectAuditEvent(mock, interfaces.AuditConsoleSetup, "", interfaces.AuditFailure, http.StatusText(http.StatusServiceUnavailable))
+			handler := pp.auditAction(interfaces.AuditConsoleSetup, nil)(func(c echo.Context) error {
+				return c.NoContent(http.StatusServiceUnavailable)
+			})
+			So(handler(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not fail the action if it can't be recorded", func() {
+			mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("admin", "", UAAAdminIdentifier, "", "", false))
+			mock.ExpectExec(insertAuditEvent).WillReturnError(errors.New("database is down"))
+			handler := pp.auditAction(interfaces.AuditLocalUserUpdate, auditParam("id"))(func(c echo.Context) error {
+				return c.NoContent(http.StatusNoContent)
+			})
+			So(handler(ctx), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
+
+func TestListAuditLog(t *testing.T) {
+	t.Parallel()
+
+	Convey("Audit log query tests", t, func() {
+		setup := func(query string) (*httptest.ResponseRecorder, echo.Context, *portalProxy, sqlmock.Sqlmock, func()) {
+			req := setupMockReq("GET", "", nil)
+			req.URL.RawQuery = query
+			res, _, ctx, pp, db, mock := setupHTTPTest(req)
+			return res, ctx, pp, mock, func() { db.Close() }
+		}
+
+		Convey("should return a page of matching events", func() {
+			res, ctx, pp, mock, done := setup("actor=admin&action=endpoint.register&from=2019-12-01T00:00:00Z&limit=1&offset=1")
+			defer done()
+
+			from, _ := time.Parse(time.RFC3339, "2019-12-01T00:00:00Z")
+			mock.ExpectQuery(countAuditEvents).
+				WithArgs("admin", "admin", interfaces.AuditEndpointRegister, from).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			mock.ExpectQuery(selectAuditLog+` WHERE (.+) LIMIT 1 OFFSET 1`).
+				WithArgs("admin", "admin", interfaces.AuditEndpointRegister, from).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForAuditEvent).
+					AddRow("event-1", from.Add(time.Hour), mockUserGUID, "admin", interfaces.AuditEndpointRegister,
+						"https://api.example.com", "10.0.0.1", interfaces.AuditSuccess, nil))
+
+			So(pp.listAuditLog(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusOK)
+			auditLog := &AuditLogRes{}
+			So(json.Unmarshal(res.Body.Bytes(), auditLog), ShouldBeNil)
+			So(auditLog.Total, ShouldEqual, 2)
+			So(auditLog.Events, ShouldHaveLength, 1)
+			So(auditLog.Events[0].Target, ShouldEqual, "https://api.example.com")
+			So(auditLog.Events[0].Detail, ShouldBeEmpty)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should reject invalid paging and times", func() {
+			for _, query := range []string{"limit=0", "limit=1000", "offset=-1", "from=yesterday"} {
+				_, ctx, pp, _, done := setup(query)
+				err := pp.listAuditLog(ctx)
+				done()
+				So(err, ShouldNotBeNil)
+				So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+	})
+}
+
+func TestAuditForwarder(t *testing.T) {
+	t.Parallel()
+
+	event := interfaces.AuditEvent{
+		GUID:      "event-1",
+		Timestamp: time.Now(),
+		Action:    interfaces.AuditLogin,
+		Target:    "admin",
+		Outcome:   interfaces.AuditFailure,
+	}
+
+	Convey("Audit forwarding tests", t, func() {
+
+		Convey("should not forward anything by default", func() {
+			forwarder, err := newAuditForwarder(interfaces.PortalConfig{})
+			So(err, ShouldBeNil)
+			So(forwarder, ShouldBeNil)
+		})
+
+		Convey("should reject invalid sinks", func() {
+			_, err := newAuditForwarder(interfaces.PortalConfig{AuditLogSyslogAddress: "localhost:514"})
+			So(err, ShouldNotBeNil)
+			_, err = newAuditForwarder(interfaces.PortalConfig{AuditLogWebhookURL: "ftp://audit.example.com"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should POST events to a webhook", func() {
+			received := make(chan string, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				received <- r.Header.Get(echo.HeaderContentType) + " " + string(body)
+			}))
+			defer server.Close()
+
+			forwarder, err := newAuditForwarder(interfaces.PortalConfig{AuditLogWebhookURL: server.URL})
+			So(err, ShouldBeNil)
+			forwarder.forward(event)
+
+			var body string
+			select {
+			case body = <-received:
+			case <-time.After(5 * time.Second):
+			}
+			So(body, ShouldStartWith, echo.MIMEApplicationJSON+" ")
+			So(body, ShouldContainSubstring, `"guid":"event-1"`)
+			So(body, ShouldContainSubstring, `"outcome":"failure"`)
+		})
+
+		Convey("should send events to syslog", func() {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			defer conn.Close()
+
+			forwarder, err := newAuditForwarder(interfaces.PortalConfig{AuditLogSyslogAddress: "udp://" + conn.LocalAddr().String()})
+			So(err, ShouldBeNil)
+			forwarder.forward(event)
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 4096)
+			n, _, err := conn.ReadFrom(buf)
+			So(err, ShouldBeNil)
+			message := string(buf[:n])
+
+			// authpriv.notice for failures
+			So(message, ShouldStartWith, "<85>1 ")
+			So(message, ShouldContainSubstring, " stratos - audit - {")
+			So(strings.TrimSpace(message), ShouldEndWith, "}")
+			So(message, ShouldContainSubstring, `"action":"login"`)
+		})
+	})
+}
@@ -110,6 +110,10 @@ func (p *portalProxy) initSSOlogin(c echo.Context) error {
 		return err
 	}
 
+	if p.isOIDCLogin() {
+		return p.initOIDCLogin(c, state)
+	}
+
 	redirectURL := fmt.Sprintf("%s/oauth/authorize?response_type=code&client_id=%s&redirect_uri=%s", p.Config.ConsoleConfig.AuthorizationEndpoint, p.Config.ConsoleConfig.ConsoleClient, url.QueryEscape(getSSORedirectURI(state, state, "")))
 	c.Redirect(http.StatusTemporaryRedirect, redirectURL)
 	return nil
@@ -117,12 +121,12 @@ func (p *portalProxy) initSSOlogin(c echo.Context) error {
 
 func safeSSORedirectState(state string, whiteListStr string) bool {
 	if len(whiteListStr) == 0 {
-		return true;
+		return true
 	}
 
 	whiteList := strings.Split(whiteListStr, ",")
 	if len(whiteList) == 0 {
-		return true;
+		return true
 	}
 
 	for _, n := range whiteList {
@@ -167,7 +171,13 @@ func (p *portalProxy) ssoLogoutOfUAA(c echo.Context) error {
 
 	// Redirect to the UAA to logout of the UAA session as well (if configured to do so), otherwise redirect back to the UI login page
 	var redirectURL string
-	if p.hasSSOOption("logout") {
+	if p.hasSSOOption("logout") && p.isOIDCLogin() {
+		var err error
+		if redirectURL, err = p.getOIDCLogoutURL(state); err != nil {
+			log.Warnf("Unable to logout of the identity provider: %v", err)
+			redirectURL = "/login?SSO_Message=You+have+been+logged+out"
+		}
+	} else if p.hasSSOOption("logout") {
 		redirectURL = fmt.Sprintf("%s/logout.do?client_id=%s&redirect=%s", p.Config.ConsoleConfig.UAAEndpoint, p.Config.ConsoleConfig.ConsoleClient, url.QueryEscape(getSSORedirectURI(state, "logout", "")))
 	} else {
 		redirectURL = "/login?SSO_Message=You+have+been+logged+out"
@@ -206,6 +216,11 @@ func (p *portalProxy) ssoLoginToUAA(c echo.Context) error {
 	if state == "logout" {
 		return c.Redirect(http.StatusTemporaryRedirect, "/login?SSO_Message=You+have+been+logged+out")
 	}
+
+	if p.isOIDCLogin() {
+		return p.oidcLoginCallback(c)
+	}
+
 	_, err := p.doLoginToUAA(c)
 	if err != nil {
 		// Send error as query string param
@@ -256,11 +271,16 @@ func (p *portalProxy) loginToUAA(c echo.Context) error {
 func (p *portalProxy) stratosLoginHandler(c echo.Context) error {
 	// Local login
 	if interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] == interfaces.Local {
-		return p.localLogin(c)
+		return p.withLoginLockout(c, c.FormValue("username"), p.localLogin)
+	}
+
+	// LDAP login
+	if p.isLDAPLogin() {
+		return p.withLoginLockout(c, c.FormValue("username"), p.ldapLogin)
 	}
 
 	// UAA login
-	return p.loginToUAA(c)
+	return p.withLoginLockout(c, c.FormValue("username"), p.loginToUAA)
 }
 
 // Use the appropriate logout mechanism
@@ -270,11 +290,13 @@ func (p *portalProxy) stratosLogoutHandler(c echo.Context) error {
 
 func (p *portalProxy) doLoginToUAA(c echo.Context) (*interfaces.LoginRes, error) {
 	log.Debug("doLoginToUAA")
-	uaaRes, u, err := p.login(c, p.Config.ConsoleConfig.SkipSSLValidation, p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.ConsoleClientSecret, p.getUAAIdentityEndpoint())
+	uaaRes, u, err := p.login(c, p.Config.ConsoleConfig.SkipSSLValidation, interfaces.EndpointConnectionSettings{}, p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.ConsoleClientSecret, p.getUAAIdentityEndpoint())
 	if err != nil {
 		// Check the Error
 		errMessage := "Access Denied"
-		if httpError, ok := err.(interfaces.ErrHTTPRequest); ok {
+		if _, ok := err.(*TokenVerificationError); ok {
+			errMessage = "Could not verify the token issued by UAA"
+		} else if httpError, ok := err.(interfaces.ErrHTTPRequest); ok {
 			// Try and parse the Response into UAA error structure
 			authError := &interfaces.UAAErrorResponse{}
 			if err := json.Unmarshal([]byte(httpError.Response), authError); err == nil {
@@ -337,7 +359,7 @@ func (p *portalProxy) localLogin(c echo.Context) error {
 	}
 
 	//Perform the login and fetch session values if successful
-	userGUID, username, err := p.doLocalLogin(c)
+	userGUID, username, scope, err := p.doLocalLogin(c)
 	if err != nil {
 		//Login failed, return response.
 		errMessage := err.Error()
@@ -348,22 +370,37 @@ func (p *portalProxy) localLogin(c echo.Context) error {
 		return err
 	}
 
+	// Users that have enabled TOTP need to provide a code before they are logged in
+	_, totp, err := p.getLocalUserTOTP(userGUID)
+	if err != nil {
+		return err
+	}
+	if totp != nil && totp.Enabled {
+		return p.startTOTPLogin(c, userGUID)
+	}
+
+	return p.completeLocalLogin(c, userGUID, username, scope)
+}
+
+// completeLocalLogin creates the session for a local user, once they have been authenticated
+func (p *portalProxy) completeLocalLogin(c echo.Context, userGUID, username, scope string) error {
 	var expiry int64
 	expiry = math.MaxInt64
 
 	sessionValues := make(map[string]interface{})
 	sessionValues["user_id"] = userGUID
 	sessionValues["exp"] = expiry
+	setAuditTarget(c, username)
 
 	// Ensure that login disregards cookies from the request
 	req := c.Request()
 	req.Header.Set("Cookie", "")
-	if err = p.setSessionValues(c, sessionValues); err != nil {
+	if err := p.setSessionValues(c, sessionValues); err != nil {
 		return err
 	}
 
 	//Makes sure the client gets the right session expiry time
-	if err = p.handleSessionExpiryHeader(c); err != nil {
+	if err := p.handleSessionExpiryHeader(c); err != nil {
 		return err
 	}
 
@@ -371,10 +408,11 @@ func (p *portalProxy) localLogin(c echo.Context) error {
 		Account:     username,
 		TokenExpiry: expiry,
 		APIEndpoint: nil,
-		Admin:       true,
+		Admin:       scope == p.Config.ConsoleConfig.ConsoleAdminScope,
 	}
 
-	if jsonString, err := json.Marshal(resp); err == nil {
+	jsonString, err := json.Marshal(resp)
+	if err == nil {
 		// Add XSRF Token
 		p.ensureXSRFToken(c)
 		c.Response().Header().Set("Content-Type", "application/json")
@@ -384,31 +422,30 @@ func (p *portalProxy) localLogin(c echo.Context) error {
 	return err
 }
 
-func (p *portalProxy) doLocalLogin(c echo.Context) (string, string, error) {
+func (p *portalProxy) doLocalLogin(c echo.Context) (string, string, string, error) {
 	log.Debug("doLocalLogin")
 
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 
 	if len(username) == 0 || len(password) == 0 {
-		return "", username, errors.New("Needs usernameand password")
+		return "", username, "", errors.New("Needs usernameand password")
 	}
 
 	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(p.DatabaseConnectionPool)
 	if err != nil {
 		log.Errorf("Database error getting repo for Local users: %v", err)
-		return "", username, err
+		return "", username, "", err
 	}
 
-	var scopeOK bool
 	var hash []byte
 	var authError error
-	var localUserScope string
+	var user interfaces.LocalUser
 
 	// Get the GUID for the specified user
 	guid, err := localUsersRepo.FindUserGUID(username)
 	if err != nil {
-		return guid, username, fmt.Errorf("Can not find user")
+		return guid, username, "", fmt.Errorf("Can not find user")
 	}
 
 	//Attempt to find the password has for the given user
@@ -417,22 +454,25 @@ func (p *portalProxy) doLocalLogin(c echo.Context) (string, string, error) {
 		//Check the password hash
 	} else if authError = CheckPasswordHash(password, hash); authError != nil {
 		authError = fmt.Errorf("Access Denied - Invalid username/password credentials")
+		if updateFailedErr := localUsersRepo.UpdateLastFailedLoginTime(guid, time.Now()); updateFailedErr != nil {
+			log.Errorf("Failed to update last failed login time for user %s: %v", guid, updateFailedErr)
+		}
+	} else if user, authError = localUsersRepo.FindUser(guid); authError != nil {
+		authError = fmt.Errorf("User not found.")
+	} else if user.Disabled {
+		authError = fmt.Errorf("Access Denied - User is disabled")
+	} else if !p.isValidLocalUserScope(user.Scope) {
+		//Ensure the local user has either the admin or user scope
+		authError = fmt.Errorf("Access Denied - User scope invalid")
 	} else {
-		//Ensure the local user has some kind of admin role configured and we check for it here
-		localUserScope, authError = localUsersRepo.FindUserScope(guid)
-		scopeOK = strings.Contains(localUserScope, p.Config.ConsoleConfig.LocalUserScope)
-		if (authError != nil) || (!scopeOK) {
-			authError = fmt.Errorf("Access Denied - User scope invalid")
-		} else {
-			//Update the last login time here if login was successful
-			loginTime := time.Now()
-			if updateLoginTimeErr := localUsersRepo.UpdateLastLoginTime(guid, loginTime); updateLoginTimeErr != nil {
-				log.Error(updateLoginTimeErr)
-				log.Errorf("Failed to update last login time for user: %s", guid)
-			}
+		//Update the last login time here if login was successful
+		loginTime := time.Now()
+		if updateLoginTimeErr := localUsersRepo.UpdateLastLoginTime(guid, loginTime); updateLoginTimeErr != nil {
+			log.Error(updateLoginTimeErr)
+			log.Errorf("Failed to update last login time for user: %s", guid)
 		}
 	}
-	return guid, username, authError
+	return guid, username, user.Scope, authError
 }
 
 //HashPassword accepts a plaintext password string and generates a salted hash
@@ -464,6 +504,13 @@ func (p *portalProxy) ssoLoginToCNSI(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Could not find correct session value")
 	}
 
+	if err := p.CheckEndpointVisible(c, endpointGUID); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Requested endpoint not registered",
+			"No Endpoint registered with GUID %s: %s", endpointGUID, err)
+	}
+
 	state := c.QueryParam("state")
 	if len(state) == 0 {
 		err := interfaces.NewHTTPShadowError(
@@ -527,6 +574,9 @@ func (p *portalProxy) loginToCNSI(c echo.Context) error {
 	}
 
 	resp, err := p.DoLoginToCNSI(c, cnsiGUID, systemSharedToken)
+	if systemSharedToken {
+		p.Audit(c, interfaces.AuditEndpointConnectShared, cnsiGUID, err)
+	}
 	if err != nil {
 		return err
 	}
@@ -544,6 +594,9 @@ func (p *portalProxy) loginToCNSI(c echo.Context) error {
 func (p *portalProxy) DoLoginToCNSI(c echo.Context, cnsiGUID string, systemSharedToken bool) (*interfaces.LoginRes, error) {
 
 	cnsiRecord, err := p.GetCNSIRecord(cnsiGUID)
+	if err == nil {
+		err = p.CheckEndpointVisible(c, cnsiGUID)
+	}
 	if err != nil {
 		return nil, interfaces.NewHTTPShadowError(
 			http.StatusBadRequest,
@@ -559,14 +612,14 @@ func (p *portalProxy) DoLoginToCNSI(c echo.Context, cnsiGUID string, systemShare
 
 	// Register as a system endpoint?
 	if systemSharedToken {
-		// User needs to be an admin
-		user, err := p.GetUAAUser(userID)
+		// User needs to be able to manage endpoints
+		userRoles, err := p.getRequestUserRoles(c)
 		if err != nil {
 			return nil, echo.NewHTTPError(http.StatusUnauthorized, "Can not connect System Shared endpoint - could not check user")
 		}
 
-		if !user.Admin {
-			return nil, echo.NewHTTPError(http.StatusUnauthorized, "Can not connect System Shared endpoint - user is not an administrator")
+		if !interfaces.RolesHavePermission(userRoles, interfaces.PermissionEndpointsManage) {
+			return nil, echo.NewHTTPError(http.StatusForbidden, "Can not connect System Shared endpoint - user can not manage endpoints")
 		}
 
 		// We are all good to go - change the userID, so we record this token against the system-shared user and not this specific user
@@ -602,6 +655,8 @@ func (p *portalProxy) DoLoginToCNSI(c echo.Context, cnsiGUID string, systemShare
 					"Failed to save Token for endpoint",
 					"Error occurred: %s", err)
 			}
+			// Any earlier refresh failure no longer applies
+			p.TokenRefresher.forget(cnsiGUID, userID)
 
 			// Validate the connection - some endpoints may want to validate that the connected endpoint
 			err = endpointPlugin.Validate(userID, cnsiRecord, *tokenRecord)
@@ -658,7 +713,7 @@ func (p *portalProxy) DoLoginToCNSIwithConsoleUAAtoken(c echo.Context, theCNSIre
 			return errors.New("could not parse current user UAA token")
 		}
 		cfEndpointSpec, _ := p.GetEndpointTypeSpec("cf")
-		cnsiInfo, _, err := cfEndpointSpec.Info(theCNSIrecord.APIEndpoint.String(), true)
+		cnsiInfo, _, err := cfEndpointSpec.Info(theCNSIrecord.APIEndpoint.String(), true, theCNSIrecord.Connection)
 		if err != nil {
 			log.Fatal("Could not get the info for Cloud Foundry", err)
 			return err
@@ -722,7 +777,7 @@ func (p *portalProxy) FetchOAuth2Token(cnsiRecord interfaces.CNSIRecord, c echo.
 
 	tokenEndpoint := fmt.Sprintf("%s/oauth/token", endpoint)
 
-	uaaRes, u, err := p.login(c, cnsiRecord.SkipSSLValidation, cnsiRecord.ClientId, cnsiRecord.ClientSecret, tokenEndpoint)
+	uaaRes, u, err := p.login(c, cnsiRecord.SkipSSLValidation, cnsiRecord.Connection, cnsiRecord.ClientId, cnsiRecord.ClientSecret, tokenEndpoint)
 
 	if err != nil {
 		if httpError, ok := err.(interfaces.ErrHTTPRequest); ok {
@@ -738,6 +793,13 @@ func (p *portalProxy) FetchOAuth2Token(cnsiRecord interfaces.CNSIRecord, c echo.
 				"Could not connect to the endpoint: %s", err)
 		}
 
+		if _, ok := err.(*TokenVerificationError); ok {
+			return nil, nil, nil, interfaces.NewHTTPShadowError(
+				http.StatusUnauthorized,
+				"Could not verify the token issued by the endpoint",
+				"Could not connect to the endpoint: %v", err)
+		}
+
 		return nil, nil, nil, interfaces.NewHTTPShadowError(
 			http.StatusBadRequest,
 			"Login failed",
@@ -771,14 +833,14 @@ func (p *portalProxy) logoutOfCNSI(c echo.Context) error {
 	// Get the existing token to see if it is connected as a system shared endpoint
 	tr, ok := p.GetCNSITokenRecord(cnsiGUID, userGUID)
 	if ok && tr.SystemShared {
-		// User needs to be an admin
-		user, err := p.GetUAAUser(userGUID)
+		// User needs to be able to manage endpoints
+		userRoles, err := p.getRequestUserRoles(c)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusUnauthorized, "Can not disconnect System Shared endpoint - could not check user")
 		}
 
-		if !user.Admin {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Can not disconnect System Shared endpoint - user is not an administrator")
+		if !interfaces.RolesHavePermission(userRoles, interfaces.PermissionEndpointsManage) {
+			return echo.NewHTTPError(http.StatusForbidden, "Can not disconnect System Shared endpoint - user can not manage endpoints")
 		}
 		userGUID = tokens.SystemSharedUserGuid
 	}
@@ -805,17 +867,19 @@ func (p *portalProxy) ClearCNSIToken(cnsiRecord interfaces.CNSIRecord, userGUID
 		}
 	}
 
+	// Cached responses would otherwise still be served to the user
+	p.invalidateProxyCache(cnsiRecord.GUID, userGUID)
 	return nil
 }
 
 func (p *portalProxy) RefreshUAALogin(username, password string, store bool) error {
 	log.Debug("RefreshUAALogin")
-	uaaRes, err := p.getUAATokenWithCreds(p.Config.ConsoleConfig.SkipSSLValidation, username, password, p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.ConsoleClientSecret, p.getUAAIdentityEndpoint())
+	uaaRes, err := p.getUAATokenWithCreds(p.Config.ConsoleConfig.SkipSSLValidation, interfaces.EndpointConnectionSettings{}, username, password, p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.ConsoleClientSecret, p.getUAAIdentityEndpoint())
 	if err != nil {
 		return err
 	}
 
-	u, err := p.GetUserTokenInfo(uaaRes.AccessToken)
+	u, err := p.verifyUAAToken(uaaRes.AccessToken, p.getUAAIdentityEndpoint(), p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.SkipSSLValidation, interfaces.EndpointConnectionSettings{})
 	if err != nil {
 		return err
 	}
@@ -830,14 +894,14 @@ func (p *portalProxy) RefreshUAALogin(username, password string, store bool) err
 	return nil
 }
 
-func (p *portalProxy) login(c echo.Context, skipSSLValidation bool, client string, clientSecret string, endpoint string) (uaaRes *interfaces.UAAResponse, u *interfaces.JWTUserTokenInfo, err error) {
+func (p *portalProxy) login(c echo.Context, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings, client string, clientSecret string, endpoint string) (uaaRes *interfaces.UAAResponse, u *interfaces.JWTUserTokenInfo, err error) {
 	log.Debug("login")
 	if c.Request().Method == http.MethodGet {
 		code := c.QueryParam("code")
 		state := c.QueryParam("state")
 		// If this is login for a CNSI, then the redirect URL is slightly different
 		cnsiGUID := c.QueryParam("guid")
-		uaaRes, err = p.getUAATokenWithAuthorizationCode(skipSSLValidation, code, client, clientSecret, endpoint, state, cnsiGUID)
+		uaaRes, err = p.getUAATokenWithAuthorizationCode(skipSSLValidation, connection, code, client, clientSecret, endpoint, state, cnsiGUID)
 	} else {
 		username := c.FormValue("username")
 		password := c.FormValue("password")
@@ -845,13 +909,13 @@ func (p *portalProxy) login(c echo.Context, skipSSLValidation bool, client strin
 		if len(username) == 0 || len(password) == 0 {
 			return uaaRes, u, errors.New("Needs username and password")
 		}
-		uaaRes, err = p.getUAATokenWithCreds(skipSSLValidation, username, password, client, clientSecret, endpoint)
+		uaaRes, err = p.getUAATokenWithCreds(skipSSLValidation, connection, username, password, client, clientSecret, endpoint)
 	}
 	if err != nil {
 		return uaaRes, u, err
 	}
 
-	u, err = p.GetUserTokenInfo(uaaRes.AccessToken)
+	u, err = p.verifyUAAToken(uaaRes.AccessToken, endpoint, client, skipSSLValidation, connection)
 	if err != nil {
 		return uaaRes, u, err
 	}
@@ -896,7 +960,7 @@ func (p *portalProxy) logout(c echo.Context) error {
 	return c.JSON(http.StatusOK, resp)
 }
 
-func (p *portalProxy) getUAATokenWithAuthorizationCode(skipSSLValidation bool, code, client, clientSecret, authEndpoint string, state string, cnsiGUID string) (*interfaces.UAAResponse, error) {
+func (p *portalProxy) getUAATokenWithAuthorizationCode(skipSSLValidation bool, connection interfaces.EndpointConnectionSettings, code, client, clientSecret, authEndpoint string, state string, cnsiGUID string) (*interfaces.UAAResponse, error) {
 	log.Debug("getUAATokenWithAuthorizationCode")
 
 	body := url.Values{}
@@ -906,10 +970,10 @@ func (p *portalProxy) getUAATokenWithAuthorizationCode(skipSSLValidation bool, c
 	body.Set("client_secret", clientSecret)
 	body.Set("redirect_uri", getSSORedirectURI(state, state, cnsiGUID))
 
-	return p.getUAAToken(body, skipSSLValidation, client, clientSecret, authEndpoint)
+	return p.getUAAToken(body, skipSSLValidation, connection, client, clientSecret, authEndpoint)
 }
 
-func (p *portalProxy) getUAATokenWithCreds(skipSSLValidation bool, username, password, client, clientSecret, authEndpoint string) (*interfaces.UAAResponse, error) {
+func (p *portalProxy) getUAATokenWithCreds(skipSSLValidation bool, connection interfaces.EndpointConnectionSettings, username, password, client, clientSecret, authEndpoint string) (*interfaces.UAAResponse, error) {
 	log.Debug("getUAATokenWithCreds")
 
 	body := url.Values{}
@@ -918,10 +982,10 @@ func (p *portalProxy) getUAATokenWithCreds(skipSSLValidation bool, username, pas
 	body.Set("password", password)
 	body.Set("response_type", "token")
 
-	return p.getUAAToken(body, skipSSLValidation, client, clientSecret, authEndpoint)
+	return p.getUAAToken(body, skipSSLValidation, connection, client, clientSecret, authEndpoint)
 }
 
-func (p *portalProxy) getUAATokenWithRefreshToken(skipSSLValidation bool, refreshToken, client, clientSecret, authEndpoint string, scopes string) (*interfaces.UAAResponse, error) {
+func (p *portalProxy) getUAATokenWithRefreshToken(skipSSLValidation bool, connection interfaces.EndpointConnectionSettings, refreshToken, client, clientSecret, authEndpoint string, scopes string) (*interfaces.UAAResponse, error) {
 	log.Debug("getUAATokenWithRefreshToken")
 
 	body := url.Values{}
@@ -933,10 +997,10 @@ func (p *portalProxy) getUAATokenWithRefreshToken(skipSSLValidation bool, refres
 		body.Set("scope", scopes)
 	}
 
-	return p.getUAAToken(body, skipSSLValidation, client, clientSecret, authEndpoint)
+	return p.getUAAToken(body, skipSSLValidation, connection, client, clientSecret, authEndpoint)
 }
 
-func (p *portalProxy) getUAAToken(body url.Values, skipSSLValidation bool, client, clientSecret, authEndpoint string) (*interfaces.UAAResponse, error) {
+func (p *portalProxy) getUAAToken(body url.Values, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings, client, clientSecret, authEndpoint string) (*interfaces.UAAResponse, error) {
 	log.WithField("authEndpoint", authEndpoint).Debug("getUAAToken")
 	req, err := http.NewRequest("POST", authEndpoint, strings.NewReader(body.Encode()))
 	if err != nil {
@@ -948,7 +1012,7 @@ func (p *portalProxy) getUAAToken(body url.Values, skipSSLValidation bool, clien
 	req.SetBasicAuth(client, clientSecret)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
 
-	var h = p.GetHttpClientForRequest(req, skipSSLValidation)
+	var h = p.GetHttpClientForEndpointRequest(req, skipSSLValidation, connection)
 	res, err := h.Do(req)
 	if err != nil || res.StatusCode != http.StatusOK {
 		log.Errorf("Error performing http request - response: %v, error: %v", res, err)
@@ -1063,8 +1127,10 @@ func (p *portalProxy) verifySession(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusForbidden, msg)
 	}
 
-	if interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] == interfaces.Local {
+	if interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] == interfaces.Local || p.isLDAPLogin() {
 		err = p.verifySessionLocal(c, sessionUser, sessionExpireTime)
+	} else if p.isOIDCLogin() {
+		err = p.verifySessionOIDC(c, sessionUser, sessionExpireTime)
 	} else {
 		err = p.verifySessionUAA(c, sessionUser, sessionExpireTime)
 	}
@@ -1108,16 +1174,18 @@ func (p *portalProxy) verifySessionUAA(c echo.Context, sessionUser string, sessi
 	if time.Now().After(time.Unix(sessionExpireTime, 0)) {
 
 		// UAA Token has expired, refresh the token, if that fails, fail the request
-		uaaRes, tokenErr := p.getUAATokenWithRefreshToken(p.Config.ConsoleConfig.SkipSSLValidation, tr.RefreshToken, p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.ConsoleClientSecret, p.getUAAIdentityEndpoint(), "")
+		uaaRes, tokenErr := p.getUAATokenWithRefreshToken(p.Config.ConsoleConfig.SkipSSLValidation, interfaces.EndpointConnectionSettings{}, tr.RefreshToken, p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.ConsoleClientSecret, p.getUAAIdentityEndpoint(), "")
 		if tokenErr != nil {
 			msg := "Could not refresh UAA token"
 			log.Error(msg, tokenErr)
 			return echo.NewHTTPError(http.StatusForbidden, msg)
 		}
 
-		u, userTokenErr := p.GetUserTokenInfo(uaaRes.AccessToken)
+		u, userTokenErr := p.verifyUAAToken(uaaRes.AccessToken, p.getUAAIdentityEndpoint(), p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.SkipSSLValidation, interfaces.EndpointConnectionSettings{})
 		if userTokenErr != nil {
-			return userTokenErr
+			msg := "Could not verify refreshed UAA token"
+			log.Error(msg, userTokenErr)
+			return echo.NewHTTPError(http.StatusForbidden, msg)
 		}
 
 		if _, err = p.saveAuthToken(*u, uaaRes.AccessToken, uaaRes.RefreshToken); err != nil {
@@ -1147,11 +1215,16 @@ func (p *portalProxy) verifySessionLocal(c echo.Context, sessionUser string, ses
 		return err
 	}
 
-	_, err = localUsersRepo.FindPasswordHash(sessionUser)
-	return err
+	user, err := localUsersRepo.FindUser(sessionUser)
+	if err != nil {
+		return err
+	}
+	if user.Disabled {
+		return errors.New("Local user has been disabled")
+	}
+	return nil
 }
 
-
 // Create a token for XSRF if needed, store it in the session and add the response header for the front-end to pick up
 func (p *portalProxy) ensureXSRFToken(c echo.Context) {
 	token, err := p.GetSessionStringValue(c, XSRFTokenSessionName)
@@ -1225,6 +1298,14 @@ func (p *portalProxy) GetStratosUser(userGUID string) (*interfaces.ConnectedUser
 		return p.getLocalUser(userGUID)
 	}
 
+	if p.isOIDCLogin() {
+		return p.getOIDCUser(userGUID)
+	}
+
+	if p.isLDAPLogin() {
+		return p.getLDAPUser(userGUID)
+	}
+
 	return p.GetUAAUser(userGUID)
 }
 
@@ -1388,15 +1469,15 @@ func (p *portalProxy) RefreshUAAToken(userGUID string) (t interfaces.TokenRecord
 		return t, fmt.Errorf("UAA Token info could not be found for user with GUID %s", userGUID)
 	}
 
-	uaaRes, err := p.getUAATokenWithRefreshToken(p.Config.ConsoleConfig.SkipSSLValidation, userToken.RefreshToken,
+	uaaRes, err := p.getUAATokenWithRefreshToken(p.Config.ConsoleConfig.SkipSSLValidation, interfaces.EndpointConnectionSettings{}, userToken.RefreshToken,
 		p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.ConsoleClientSecret, p.getUAAIdentityEndpoint(), "")
 	if err != nil {
 		return t, fmt.Errorf("UAA Token refresh request failed: %v", err)
 	}
 
-	u, err := p.GetUserTokenInfo(uaaRes.AccessToken)
+	u, err := p.verifyUAAToken(uaaRes.AccessToken, p.getUAAIdentityEndpoint(), p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.SkipSSLValidation, interfaces.EndpointConnectionSettings{})
 	if err != nil {
-		return t, fmt.Errorf("Could not get user token info from access token")
+		return t, fmt.Errorf("Could not get user token info from access token: %v", err)
 	}
 
 	u.UserGUID = userGUID
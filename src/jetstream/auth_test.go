@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
 	"net/http"
 	"net/url"
@@ -101,12 +102,15 @@ func TestLocalLogin(t *testing.T) {
 		rows = sqlmock.NewRows([]string{"password_hash"}).AddRow(passwordHash)
 		mock.ExpectQuery(findPasswordHash).WithArgs(userGUID).WillReturnRows(rows)
 
-		rows = sqlmock.NewRows([]string{"scope"}).AddRow(scope)
-		mock.ExpectQuery(findUserScope).WithArgs(userGUID).WillReturnRows(rows)
+		rows = sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", scope, "", "", false)
+		mock.ExpectQuery(findLocalUser).WithArgs(userGUID).WillReturnRows(rows)
 
 		//Expect exec to update local login time
 		mock.ExpectExec(updateLastLoginTime).WillReturnResult(sqlmock.NewResult(1, 1))
 
+		//The user has not enabled TOTP
+		mock.ExpectQuery(findTOTP).WithArgs(userGUID).WillReturnError(sql.ErrNoRows)
+
 		loginErr := pp.localLogin(ctx)
 
 		Convey("Should not fail to login", func() {
@@ -202,8 +206,8 @@ func TestLocalLoginWithNoAdminScope(t *testing.T) {
 		pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
 
 		//The user trying to log in has a non-admin scope
-		rows = sqlmock.NewRows([]string{"scope"}).AddRow(wrongScope)
-		mock.ExpectQuery(findUserScope).WithArgs(userGUID).WillReturnRows(rows)
+		rows = sqlmock.NewRows(rowFieldsForLocalUser).AddRow(username, "", wrongScope, "", "", false)
+		mock.ExpectQuery(findLocalUser).WithArgs(userGUID).WillReturnRows(rows)
 
 		loginErr := pp.localLogin(ctx)
 
@@ -340,12 +344,14 @@ func TestLoginToCNSI(t *testing.T) {
 			DopplerLoggingEndpoint: mockDopplerEndpoint,
 		}
 
-		expectedCNSIRow := sqlmock.NewRows([]string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso", "sub_type", "meta_data"}).
-			AddRow(mockCNSIGUID, mockCNSI.Name, stringCFType, mockUAA.URL, mockCNSI.AuthorizationEndpoint, mockCNSI.TokenEndpoint, mockCNSI.DopplerLoggingEndpoint, true, mockCNSI.ClientId, cipherClientSecret, true, "", "")
+		expectedCNSIRow := sqlmock.NewRows([]string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso", "sub_type", "meta_data", "ca_cert", "client_cert", "client_cert_key", "proxy_url"}).
+			AddRow(mockCNSIGUID, mockCNSI.Name, stringCFType, mockUAA.URL, mockCNSI.AuthorizationEndpoint, mockCNSI.TokenEndpoint, mockCNSI.DopplerLoggingEndpoint, true, mockCNSI.ClientId, cipherClientSecret, true, "", "", "", "", nil, "")
 
 		mock.ExpectQuery(selectAnyFromCNSIs).
 			WithArgs(mockCNSIGUID).
 			WillReturnRows(expectedCNSIRow)
+		mock.ExpectQuery(listCNSIVisibility).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForCNSIVisibility))
 
 		// Set a dummy userid in session - normally the login to UAA would do this.
 		sessionValues := make(map[string]interface{})
@@ -728,6 +734,10 @@ func TestVerifySession(t *testing.T) {
 		mock.ExpectQuery(findUAATokenSQL).
 			WillReturnRows(rs)
 
+		// No roles have been assigned, so the user gets the default role
+		mock.ExpectQuery(findRoleAssignments).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForRoleAssignment))
+
 		if err := pp.verifySession(ctx); err != nil {
 			t.Error(err)
 		}
@@ -741,7 +751,7 @@ func TestVerifySession(t *testing.T) {
 
 		var expectedScopes = "\"scopes\":[\"openid\",\"scim.read\",\"cloud_controller.admin\",\"uaa.user\",\"cloud_controller.read\",\"password.write\",\"routing.router_groups.read\",\"cloud_controller.write\",\"doppler.firehose\",\"scim.write\"]"
 
-		var expectedBody = "{\"version\":{\"proxy_version\":\"dev\",\"database_version\":20161117141922},\"user\":{\"guid\":\"asd-gjfg-bob\",\"name\":\"admin\",\"admin\":false," + expectedScopes + "},\"endpoints\":{\"cf\":{}},\"plugins\":null,\"config\":{\"enableTechPreview\":false}}"
+		var expectedBody = "{\"version\":{\"proxy_version\":\"dev\",\"database_version\":20161117141922},\"user\":{\"guid\":\"asd-gjfg-bob\",\"name\":\"admin\",\"admin\":false," + expectedScopes + ",\"roles\":[\"endpoint-operator\"],\"permissions\":[\"console.read\",\"endpoints.use\"]},\"endpoints\":{\"cf\":{}},\"plugins\":null,\"config\":{\"enableTechPreview\":false}}"
 
 		Convey("Should contain expected body", func() {
 			So(res, ShouldNotBeNil)
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// Defaults for when the circuit breaker has not been configured
+const (
+	defaultCircuitBreakerFailureThreshold = 5
+	defaultCircuitBreakerOpenInSecs       = 30
+)
+
+// Weight of the latest response in an endpoint's recent latency
+const circuitLatencyWeight = 0.2
+
+// Outcome of a request made through a circuit breaker
+type circuitResult int
+
+const (
+	// The request did not reach the endpoint, so says nothing about its health
+	circuitResultNone circuitResult = iota
+	circuitResultSuccess
+	circuitResultFailure
+)
+
+// circuitBreaker tracks the health of a single endpoint
+type circuitBreaker struct {
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+	latency  time.Duration
+}
+
+// circuitBreakers tracks the health of each endpoint. Once an endpoint has failed enough requests in a row, requests to it
+// fail fast until the open period has passed, after which a single probe request is allowed through to test the endpoint.
+// Responses slower than the slow call time, if there is one, count as failures
+type circuitBreakers struct {
+	sync.Mutex
+	breakers     map[string]*circuitBreaker
+	threshold    int
+	openDuration time.Duration
+	slowCall     time.Duration
+}
+
+func newCircuitBreakers(threshold int, openInSecs, slowCallInMillis int64) *circuitBreakers {
+	return &circuitBreakers{
+		breakers:     make(map[string]*circuitBreaker),
+		threshold:    threshold,
+		openDuration: time.Duration(openInSecs) * time.Second,
+		slowCall:     time.Duration(slowCallInMillis) * time.Millisecond,
+	}
+}
+
+func (cb *circuitBreakers) enabled() bool {
+	return cb != nil && cb.threshold > 0
+}
+
+func (cb *circuitBreakers) get(endpointGUID string) *circuitBreaker {
+	breaker, ok := cb.breakers[endpointGUID]
+	if !ok {
+		breaker = &circuitBreaker{state: circuitClosed}
+		cb.breakers[endpointGUID] = breaker
+	}
+	return breaker
+}
+
+// allow returns whether a request can be made to the endpoint - if it returns true, then record must be called afterwards
+func (cb *circuitBreakers) allow(endpointGUID string) bool {
+	if !cb.enabled() {
+		return true
+	}
+
+	cb.Lock()
+	defer cb.Unlock()
+
+	breaker := cb.get(endpointGUID)
+	switch breaker.state {
+	case circuitOpen:
+		if time.Since(breaker.openedAt) < cb.openDuration {
+			return false
+		}
+		breaker.state = circuitHalfOpen
+		fallthrough
+	case circuitHalfOpen:
+		// Only one probe at a time
+		if breaker.probing {
+			return false
+		}
+		breaker.probing = true
+	}
+
+	return true
+}
+
+// record updates the state of the endpoint's circuit breaker with the outcome of a request
+func (cb *circuitBreakers) record(endpointGUID string, result circuitResult) {
+	if !cb.enabled() {
+		return
+	}
+
+	cb.Lock()
+	defer cb.Unlock()
+
+	breaker := cb.get(endpointGUID)
+	breaker.probing = false
+	switch result {
+	case circuitResultSuccess:
+		breaker.state = circuitClosed
+		breaker.failures = 0
+	case circuitResultFailure:
+		breaker.failures++
+		if breaker.state == circuitHalfOpen || breaker.failures >= cb.threshold {
+			if breaker.state != circuitOpen {
+				log.Warnf("Circuit breaker opened for endpoint %s after %d failure(s)", endpointGUID, breaker.failures)
+			}
+			breaker.state = circuitOpen
+			breaker.openedAt = time.Now()
+		}
+	}
+}
+
+// observeLatency updates the endpoint's recent latency with the time taken for a response, and returns whether the
+// response was slow enough to count as a failure
+func (cb *circuitBreakers) observeLatency(endpointGUID string, latency time.Duration) bool {
+	if !cb.enabled() {
+		return false
+	}
+
+	cb.Lock()
+	defer cb.Unlock()
+
+	breaker := cb.get(endpointGUID)
+	if breaker.latency == 0 {
+		breaker.latency = latency
+	} else {
+		breaker.latency += time.Duration(circuitLatencyWeight * float64(latency-breaker.latency))
+	}
+	return cb.slowCall > 0 && latency > cb.slowCall
+}
+
+// status returns the state of the endpoint's circuit breaker, or nil if circuit breakers are disabled
+func (cb *circuitBreakers) status(endpointGUID string) *interfaces.CircuitBreakerStatus {
+	if !cb.enabled() {
+		return nil
+	}
+
+	cb.Lock()
+	defer cb.Unlock()
+
+	breaker := cb.get(endpointGUID)
+	status := &interfaces.CircuitBreakerStatus{
+		State:           breaker.state,
+		Failures:        breaker.failures,
+		LatencyInMillis: int64(breaker.latency / time.Millisecond),
+	}
+	if breaker.state == circuitOpen {
+		status.RetryAt = breaker.openedAt.Add(cb.openDuration).Unix()
+	}
+	return status
+}
+
+// circuitOpenError is returned when a request is not made because the endpoint's circuit breaker is open
+type circuitOpenError struct {
+	endpointGUID string
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("Endpoint %s is unavailable", e.endpointGUID)
+}
+
+// endpointRequestError is returned when a request could not be sent to the endpoint, or no response was received
+type endpointRequestError struct {
+	err error
+}
+
+func (e *endpointRequestError) Error() string {
+	return fmt.Sprintf("Request failed: %v", e.err)
+}
+
+// Did the request fail to reach the endpoint? Other errors, e.g. a user's token that could not be refreshed, are
+// specific to the request and say nothing about the endpoint. Failures caused by the endpoint's configuration, e.g. a
+// certificate that isn't trusted or a host name that doesn't resolve, would happen every time so are not included
+// either, and neither are requests that were cancelled
+func isTransportError(err error) bool {
+	transport := false
+	for err != nil {
+		switch e := err.(type) {
+		case *endpointRequestError:
+			transport, err = true, e.err
+		case *url.Error:
+			transport, err = true, e.Err
+		case *net.OpError:
+			transport, err = true, e.Err
+		case *net.DNSError:
+			return e.IsTimeout || e.IsTemporary
+		case *endpointSettingsError, x509.UnknownAuthorityError, x509.HostnameError, x509.CertificateInvalidError,
+			x509.SystemRootsError, x509.ConstraintViolationError, tls.RecordHeaderError:
+			return false
+		default:
+			if err == context.Canceled || err == context.DeadlineExceeded {
+				return false
+			}
+			// Newer versions of Go wrap TLS verification and system call errors
+			if wrapper, ok := err.(interface{ Unwrap() error }); ok {
+				err = wrapper.Unwrap()
+				continue
+			}
+			_, isNetError := err.(net.Error)
+			return transport || isNetError
+		}
+	}
+	return transport
+}
+
+// Is the status one that routers and load balancers return when the endpoint is unavailable?
+func isUnavailableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Is the response from the endpoint one that shows that the endpoint is not healthy?
+func isCircuitFailure(res *http.Response, err error) bool {
+	if err != nil {
+		return res == nil && isTransportError(err)
+	}
+	return isUnavailableStatus(res.StatusCode)
+}
+
+func makeCircuitOpenError(endpointGUID string, retryAt int64) []byte {
+	errorResponse := map[string]interface{}{
+		"error_code":  "circuitBreakerOpen",
+		"description": fmt.Sprintf("Endpoint %s is unavailable after repeated failures", endpointGUID),
+		"retry_at":    retryAt,
+	}
+	res, err := json.Marshal(errorResponse)
+	if err != nil {
+		log.Errorf("makeCircuitOpenError: could not marshal JSON: %+v", err)
+	}
+	return res
+}
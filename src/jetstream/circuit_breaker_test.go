@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	Convey("Circuit breaker tests", t, func() {
+		cb := newCircuitBreakers(2, 30, 0)
+
+		Convey("should allow requests while closed", func() {
+			So(cb.allow(mockCFGUID), ShouldBeTrue)
+			cb.record(mockCFGUID, circuitResultFailure)
+			So(cb.allow(mockCFGUID), ShouldBeTrue)
+			So(cb.status(mockCFGUID).State, ShouldEqual, circuitClosed)
+			So(cb.status(mockCFGUID).Failures, ShouldEqual, 1)
+		})
+
+		Convey("should open after the failure threshold is reached", func() {
+			cb.record(mockCFGUID, circuitResultFailure)
+			cb.record(mockCFGUID, circuitResultFailure)
+			So(cb.allow(mockCFGUID), ShouldBeFalse)
+			So(cb.status(mockCFGUID).State, ShouldEqual, circuitOpen)
+			So(cb.status(mockCFGUID).RetryAt, ShouldBeGreaterThan, time.Now().Unix())
+
+			Convey("should not affect other endpoints", func() {
+				So(cb.allow(mockCEGUID), ShouldBeTrue)
+			})
+		})
+
+		Convey("should reset the failure count on success", func() {
+			cb.record(mockCFGUID, circuitResultFailure)
+			cb.record(mockCFGUID, circuitResultSuccess)
+			cb.record(mockCFGUID, circuitResultFailure)
+			So(cb.allow(mockCFGUID), ShouldBeTrue)
+		})
+
+		Convey("should allow a single probe once the open period has passed", func() {
+			cb.openDuration = 0
+			cb.record(mockCFGUID, circuitResultFailure)
+			cb.record(mockCFGUID, circuitResultFailure)
+
+			So(cb.allow(mockCFGUID), ShouldBeTrue)
+			So(cb.status(mockCFGUID).State, ShouldEqual, circuitHalfOpen)
+			So(cb.allow(mockCFGUID), ShouldBeFalse)
+
+			Convey("should close if the probe succeeds", func() {
+				cb.record(mockCFGUID, circuitResultSuccess)
+				So(cb.status(mockCFGUID).State, ShouldEqual, circuitClosed)
+			})
+
+			Convey("should open again if the probe fails", func() {
+				cb.record(mockCFGUID, circuitResultFailure)
+				So(cb.status(mockCFGUID).State, ShouldEqual, circuitOpen)
+			})
+
+			Convey("should allow another probe if the probe did not reach the endpoint", func() {
+				cb.record(mockCFGUID, circuitResultNone)
+				So(cb.allow(mockCFGUID), ShouldBeTrue)
+			})
+		})
+
+		Convey("should report the endpoint's recent latency", func() {
+			So(cb.observeLatency(mockCFGUID, 100*time.Millisecond), ShouldBeFalse)
+			So(cb.status(mockCFGUID).LatencyInMillis, ShouldEqual, 100)
+			cb.observeLatency(mockCFGUID, 600*time.Millisecond)
+			So(cb.status(mockCFGUID).LatencyInMillis, ShouldEqual, 200)
+		})
+
+		Convey("should count slow responses as failures if configured to", func() {
+			cb.slowCall = 500 * time.Millisecond
+			So(cb.observeLatency(mockCFGUID, 100*time.Millisecond), ShouldBeFalse)
+			So(cb.observeLatency(mockCFGUID, time.Second), ShouldBeTrue)
+		})
+
+		Convey("should be disabled with a threshold of zero or less", func() {
+			disabled := newCircuitBreakers(-1, 30, 0)
+			disabled.record(mockCFGUID, circuitResultFailure)
+			So(disabled.allow(mockCFGUID), ShouldBeTrue)
+			So(disabled.status(mockCFGUID), ShouldBeNil)
+		})
+	})
+}
+
+func TestIsCircuitFailure(t *testing.T) {
+	t.Parallel()
+
+	Convey("Circuit failure tests", t, func() {
+		So(isCircuitFailure(nil, &endpointRequestError{err: errors.New("connection refused")}), ShouldBeTrue)
+		So(isCircuitFailure(nil, &url.Error{Op: "Get", URL: mockAPIEndpoint, Err: errors.New("EOF")}), ShouldBeTrue)
+		// Errors for a single user, e.g. a missing token or a failed token refresh, don't count against the endpoint
+		So(isCircuitFailure(nil, errors.New("Couldn't refresh token for CNSI")), ShouldBeFalse)
+		So(isCircuitFailure(&http.Response{StatusCode: http.StatusUnauthorized}, errors.New("Failed to authorize")), ShouldBeFalse)
+		So(isCircuitFailure(&http.Response{StatusCode: http.StatusBadGateway}, nil), ShouldBeTrue)
+		So(isCircuitFailure(&http.Response{StatusCode: http.StatusNotFound}, nil), ShouldBeFalse)
+		// Configuration errors are reported as they are, rather than as the endpoint being down
+		So(isCircuitFailure(nil, &url.Error{Op: "Get", URL: mockAPIEndpoint, Err: x509.UnknownAuthorityError{}}), ShouldBeFalse)
+		So(isCircuitFailure(nil, &url.Error{Op: "Get", URL: mockAPIEndpoint, Err: &endpointSettingsError{errors.New("Invalid proxy URL")}}), ShouldBeFalse)
+	})
+}
+
+func TestCircuitBreakerSlowCalls(t *testing.T) {
+	t.Parallel()
+
+	Convey("Slow responses should count as failures once a slow call time is configured", t, func() {
+		mockCFServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprint(w, jsonMust(mockV2InfoResponse))
+		}))
+		defer mockCFServer.Close()
+
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		pp.CircuitBreakers = newCircuitBreakers(1, 30, 10)
+
+		expectProxyRequestQueries(mock, pp.Config.EncryptionKeyInBytes, 1)
+
+		done := make(chan *interfaces.CNSIRequest)
+		go pp.doRequest(&interfaces.CNSIRequest{
+			GUID:     mockCFGUID,
+			UserGUID: mockUserGUID,
+			Method:   "GET",
+			URL:      urlMust(mockCFServer.URL + "/v2/info"),
+		}, done)
+		res := <-done
+
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+		So(res.StatusCode, ShouldEqual, http.StatusOK)
+		status := pp.CircuitBreakers.status(mockCFGUID)
+		So(status.State, ShouldEqual, circuitOpen)
+		So(status.LatencyInMillis, ShouldBeGreaterThanOrEqualTo, 50)
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+)
+
+// parseTrustedProxies parses the IP addresses and CIDR ranges of the proxies that are trusted to report the address of
+// the client. Invalid entries are ignored
+func parseTrustedProxies(entries []string) []*net.IPNet {
+	trusted := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip = ip.To4()
+					bits = 8 * net.IPv4len
+				}
+				trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Warnf("Ignoring invalid trusted proxy '%s'", entry)
+			continue
+		}
+		trusted = append(trusted, ipNet)
+	}
+	return trusted
+}
+
+func (p *portalProxy) isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, trusted := range p.TrustedProxies {
+		if trusted.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client that made the request. The X-Forwarded-For and X-Real-IP headers can
+// be set by the client, so are only used if the request came through a trusted proxy
+func (p *portalProxy) clientIP(c echo.Context) string {
+	req := c.Request()
+	remoteIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remoteIP = req.RemoteAddr
+	}
+	if !p.isTrustedProxy(remoteIP) {
+		return remoteIP
+	}
+
+	// Each proxy appends the address that it received the request from - the client is the last that isn't trusted
+	if forwardedFor := req.Header.Get(echo.HeaderXForwardedFor); len(forwardedFor) > 0 {
+		addresses := strings.Split(forwardedFor, ",")
+		for i := len(addresses) - 1; i >= 0; i-- {
+			address := strings.TrimSpace(addresses[i])
+			if i == 0 || !p.isTrustedProxy(address) {
+				return address
+			}
+		}
+	}
+	if realIP := req.Header.Get(echo.HeaderXRealIP); len(realIP) > 0 {
+		return realIP
+	}
+	return remoteIP
+}
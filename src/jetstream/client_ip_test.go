@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	Convey("Client IP address tests", t, func() {
+		req := setupMockReq("GET", "", nil)
+		req.RemoteAddr = "10.0.0.5:43210"
+		req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.9, 198.51.100.7, 10.0.0.4")
+		req.Header.Set(echo.HeaderXRealIP, "203.0.113.10")
+		_, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		Convey("should ignore forwarded headers without trusted proxies", func() {
+			So(pp.clientIP(ctx), ShouldEqual, "10.0.0.5")
+		})
+
+		Convey("should ignore forwarded headers from an untrusted proxy", func() {
+			pp.TrustedProxies = parseTrustedProxies([]string{"192.168.0.1"})
+			So(pp.clientIP(ctx), ShouldEqual, "10.0.0.5")
+		})
+
+		Convey("should take the last untrusted address from a trusted proxy", func() {
+			pp.TrustedProxies = parseTrustedProxies([]string{"10.0.0.0/8"})
+			So(pp.clientIP(ctx), ShouldEqual, "198.51.100.7")
+		})
+
+		Convey("should use X-Real-IP from a trusted proxy if there is no X-Forwarded-For", func() {
+			req.Header.Del(echo.HeaderXForwardedFor)
+			pp.TrustedProxies = parseTrustedProxies([]string{"10.0.0.5"})
+			So(pp.clientIP(ctx), ShouldEqual, "203.0.113.10")
+		})
+
+		Convey("should ignore invalid trusted proxies", func() {
+			So(parseTrustedProxies([]string{"10.0.0.0/8", " ", "proxy.example.com", "::1"}), ShouldHaveLength, 2)
+		})
+	})
+}
@@ -59,12 +59,20 @@ func (p *portalProxy) RegisterEndpoint(c echo.Context, fetchInfo interfaces.Info
 	cnsiClientSecret := c.FormValue("cnsi_client_secret")
 	subType := c.FormValue("sub_type")
 
+	// Optional CA bundle, client certificate and proxy to use when connecting to the endpoint
+	connection := interfaces.EndpointConnectionSettings{
+		CACert:        c.FormValue("ca_cert"),
+		ClientCert:    c.FormValue("client_cert"),
+		ClientCertKey: c.FormValue("client_cert_key"),
+		ProxyURL:      c.FormValue("proxy_url"),
+	}
+
 	if cnsiClientId == "" {
 		cnsiClientId = p.GetConfig().CFClient
 		cnsiClientSecret = p.GetConfig().CFClientSecret
 	}
 
-	newCNSI, err := p.DoRegisterEndpoint(cnsiName, apiEndpoint, skipSSLValidation, cnsiClientId, cnsiClientSecret, ssoAllowed, subType, fetchInfo)
+	newCNSI, err := p.DoRegisterEndpoint(cnsiName, apiEndpoint, skipSSLValidation, connection, cnsiClientId, cnsiClientSecret, ssoAllowed, subType, fetchInfo)
 	if err != nil {
 		return err
 	}
@@ -73,7 +81,7 @@ func (p *portalProxy) RegisterEndpoint(c echo.Context, fetchInfo interfaces.Info
 	return nil
 }
 
-func (p *portalProxy) DoRegisterEndpoint(cnsiName string, apiEndpoint string, skipSSLValidation bool, clientId string, clientSecret string, ssoAllowed bool, subType string, fetchInfo interfaces.InfoFunc) (interfaces.CNSIRecord, error) {
+func (p *portalProxy) DoRegisterEndpoint(cnsiName string, apiEndpoint string, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings, clientId string, clientSecret string, ssoAllowed bool, subType string, fetchInfo interfaces.InfoFunc) (interfaces.CNSIRecord, error) {
 
 	if len(cnsiName) == 0 || len(apiEndpoint) == 0 {
 		return interfaces.CNSIRecord{}, interfaces.NewHTTPShadowError(
@@ -104,7 +112,15 @@ func (p *portalProxy) DoRegisterEndpoint(cnsiName string, apiEndpoint string, sk
 		)
 	}
 
-	newCNSI, _, err := fetchInfo(apiEndpoint, skipSSLValidation)
+	if err := p.validateEndpointConnection(connection); err != nil {
+		return interfaces.CNSIRecord{}, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			err.Error(),
+			"Invalid endpoint connection settings: %v",
+			err)
+	}
+
+	newCNSI, _, err := fetchInfo(apiEndpoint, skipSSLValidation, connection)
 	if err != nil {
 		if ok, detail := isSSLRelatedError(err); ok {
 			return interfaces.CNSIRecord{}, interfaces.NewHTTPShadowError(
@@ -127,6 +143,7 @@ func (p *portalProxy) DoRegisterEndpoint(cnsiName string, apiEndpoint string, sk
 	newCNSI.Name = cnsiName
 	newCNSI.APIEndpoint = apiEndpointURL
 	newCNSI.SkipSSLValidation = skipSSLValidation
+	newCNSI.Connection = connection
 	newCNSI.ClientId = clientId
 	newCNSI.ClientSecret = clientSecret
 	newCNSI.SSOAllowed = ssoAllowed
@@ -162,6 +179,10 @@ func (p *portalProxy) unregisterCluster(c echo.Context) error {
 	p.unsetCNSIRecord(cnsiGUID)
 
 	p.unsetCNSITokenRecords(cnsiGUID)
+	p.TokenRefresher.forgetEndpoint(cnsiGUID)
+	if p.ProxyResponseCache != nil {
+		p.ProxyResponseCache.InvalidateEndpoint(cnsiGUID)
+	}
 
 	ufe := userfavoritesendpoints.Constructor(p, cnsiGUID)
 	ufe.RemoveFavorites()
@@ -169,9 +190,27 @@ func (p *portalProxy) unregisterCluster(c echo.Context) error {
 	return nil
 }
 
+// buildCNSIList lists the endpoints that the request's user can see
 func (p *portalProxy) buildCNSIList(c echo.Context) ([]*interfaces.CNSIRecord, error) {
 	log.Debug("buildCNSIList")
-	return p.ListEndpoints()
+	cnsiList, err := p.ListEndpoints()
+	if err != nil {
+		return cnsiList, err
+	}
+
+	isVisible, err := p.getEndpointVisibility(c)
+	if err != nil {
+		return nil, err
+	}
+	filterMetadata := p.rewriteRulesFilter(c)
+	visibleList := make([]*interfaces.CNSIRecord, 0, len(cnsiList))
+	for _, cnsi := range cnsiList {
+		if isVisible(cnsi.GUID) {
+			cnsi.Metadata = filterMetadata(cnsi.Metadata)
+			visibleList = append(visibleList, cnsi)
+		}
+	}
+	return visibleList, nil
 }
 
 func (p *portalProxy) ListEndpoints() ([]*interfaces.CNSIRecord, error) {
@@ -242,6 +281,25 @@ func (p *portalProxy) listRegisteredCNSIs(c echo.Context) error {
 		)
 	}
 
+	isVisible, err := p.getEndpointVisibility(c)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to retrieve list of clusters",
+			"Unable to check endpoint visibility: %v", err,
+		)
+	}
+	filterMetadata := p.rewriteRulesFilter(c)
+	visibleList := make([]*interfaces.ConnectedEndpoint, 0, len(clusterList))
+	for _, cluster := range clusterList {
+		if isVisible(cluster.GUID) {
+			cluster.EndpointMetadata = filterMetadata(cluster.EndpointMetadata)
+			cluster.CircuitBreaker = p.CircuitBreakers.status(cluster.GUID)
+			visibleList = append(visibleList, cluster)
+		}
+	}
+	clusterList = visibleList
+
 	jsonString, err = marshalClusterList(clusterList)
 	if err != nil {
 		return err
@@ -379,6 +437,10 @@ func (p *portalProxy) unsetCNSIRecord(guid string) error {
 		return fmt.Errorf(msg, err)
 	}
 
+	if err := cnsiRepo.SetVisibility(guid, nil); err != nil {
+		log.Warnf("Unable to remove visibility restrictions of endpoint %s: %v", guid, err)
+	}
+
 	if lookupErr == nil {
 		// Notify plugins if they support the notification interface
 		for _, plugin := range p.Plugins {
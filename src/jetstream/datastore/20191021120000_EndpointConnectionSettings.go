@@ -0,0 +1,44 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191021120000, "EndpointConnectionSettings", func(txn *sql.Tx, conf *goose.DBConf) error {
+		binaryDataType := "BYTEA"
+		if strings.Contains(conf.Driver.Name, "mysql") {
+			binaryDataType = "BLOB"
+		}
+
+		addColumn := "ALTER TABLE cnsis ADD ca_cert TEXT DEFAULT NULL"
+		_, err := txn.Exec(addColumn)
+		if err != nil {
+			return err
+		}
+
+		addColumn = "ALTER TABLE cnsis ADD client_cert TEXT DEFAULT NULL"
+		_, err = txn.Exec(addColumn)
+		if err != nil {
+			return err
+		}
+
+		// The client certificate's private key is encrypted, in the same way as the client secret
+		addColumn = "ALTER TABLE cnsis ADD client_cert_key " + binaryDataType
+		_, err = txn.Exec(addColumn)
+		if err != nil {
+			return err
+		}
+
+		addColumn = "ALTER TABLE cnsis ADD proxy_url VARCHAR(255) DEFAULT NULL"
+		_, err = txn.Exec(addColumn)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,20 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191104100000, "LocalUsersDisabled", func(txn *sql.Tx, conf *goose.DBConf) error {
+		// Local users can be disabled by an admin, rather than deleted
+		addColumn := "ALTER TABLE local_users ADD disabled BOOLEAN NOT NULL DEFAULT FALSE"
+		_, err := txn.Exec(addColumn)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,39 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191111100000, "LocalUsersTOTP", func(txn *sql.Tx, conf *goose.DBConf) error {
+		binaryDataType := "BYTEA"
+		if strings.Contains(conf.Driver.Name, "mysql") {
+			binaryDataType = "BLOB"
+		}
+
+		// TOTP enrollment for local users. The secret is encrypted, recovery codes are stored as hashes
+		createTOTP := "CREATE TABLE IF NOT EXISTS local_users_totp ("
+		createTOTP += "user_guid      VARCHAR(36) NOT NULL, "
+		createTOTP += "secret         " + binaryDataType + " NOT NULL, "
+		createTOTP += "enabled        BOOLEAN NOT NULL DEFAULT FALSE, "
+		createTOTP += "recovery_codes TEXT, "
+		createTOTP += "last_used_step BIGINT NOT NULL DEFAULT 0, "
+		createTOTP += "PRIMARY KEY (user_guid) )"
+
+		if strings.Contains(conf.Driver.Name, "postgres") {
+			createTOTP += " WITH (OIDS=FALSE);"
+		} else {
+			createTOTP += ";"
+		}
+
+		_, err := txn.Exec(createTOTP)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
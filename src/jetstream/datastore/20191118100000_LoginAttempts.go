@@ -0,0 +1,48 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191118100000, "LoginAttempts", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		// Failed login attempts, by username and by source IP address, used to lock out brute force attacks
+		createLoginAttempts := "CREATE TABLE IF NOT EXISTS login_attempts ("
+		createLoginAttempts += "attempt_type  VARCHAR(16) NOT NULL, "
+		createLoginAttempts += "attempt_key   VARCHAR(255) NOT NULL, "
+		createLoginAttempts += "failures      INT NOT NULL DEFAULT 0, "
+		createLoginAttempts += "last_failure  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, "
+		createLoginAttempts += "locked_until  TIMESTAMP NULL, "
+		createLoginAttempts += "PRIMARY KEY (attempt_type, attempt_key) )"
+
+		if strings.Contains(conf.Driver.Name, "postgres") {
+			createLoginAttempts += " WITH (OIDS=FALSE);"
+		} else {
+			createLoginAttempts += ";"
+		}
+
+		_, err := txn.Exec(createLoginAttempts)
+		if err != nil {
+			return err
+		}
+
+		// Local users also record their last failed login
+		addColumn := "ALTER TABLE local_users ADD last_failed_login TIMESTAMP NULL"
+		_, err = txn.Exec(addColumn)
+		if err != nil {
+			return err
+		}
+
+		addColumn = "ALTER TABLE local_users ADD failed_login_count INT NOT NULL DEFAULT 0"
+		_, err = txn.Exec(addColumn)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
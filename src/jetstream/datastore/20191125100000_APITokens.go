@@ -0,0 +1,44 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191125100000, "APITokens", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		// Personal API tokens - only a hash of the token is stored
+		createAPITokens := "CREATE TABLE IF NOT EXISTS api_tokens ("
+		createAPITokens += "guid        VARCHAR(36) NOT NULL, "
+		createAPITokens += "user_guid   VARCHAR(36) NOT NULL, "
+		createAPITokens += "name        VARCHAR(255) NOT NULL, "
+		createAPITokens += "token_hash  VARCHAR(64) UNIQUE NOT NULL, "
+		createAPITokens += "scopes      VARCHAR(255) NOT NULL, "
+		createAPITokens += "created     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, "
+		createAPITokens += "expires     TIMESTAMP NULL, "
+		createAPITokens += "last_used   TIMESTAMP NULL, "
+		createAPITokens += "PRIMARY KEY (guid) )"
+
+		if strings.Contains(conf.Driver.Name, "postgres") {
+			createAPITokens += " WITH (OIDS=FALSE);"
+		} else {
+			createAPITokens += ";"
+		}
+
+		_, err := txn.Exec(createAPITokens)
+		if err != nil {
+			return err
+		}
+
+		createIndex := "CREATE INDEX api_tokens_user_guid ON api_tokens (user_guid);"
+		_, err = txn.Exec(createIndex)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,29 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191202100000, "RoleAssignments", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		// Console roles given to users, or to UAA/OIDC groups
+		createRoleAssignments := "CREATE TABLE IF NOT EXISTS role_assignments ("
+		createRoleAssignments += "principal_type  VARCHAR(16) NOT NULL, "
+		createRoleAssignments += "principal       VARCHAR(255) NOT NULL, "
+		createRoleAssignments += "role            VARCHAR(64) NOT NULL, "
+		createRoleAssignments += "PRIMARY KEY (principal_type, principal, role) )"
+
+		if strings.Contains(conf.Driver.Name, "postgres") {
+			createRoleAssignments += " WITH (OIDS=FALSE);"
+		} else {
+			createRoleAssignments += ";"
+		}
+
+		_, err := txn.Exec(createRoleAssignments)
+		return err
+	})
+}
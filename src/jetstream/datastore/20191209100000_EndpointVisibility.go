@@ -0,0 +1,29 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191209100000, "EndpointVisibility", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		// Users and groups that can see a restricted endpoint. Endpoints without any can be seen by everyone
+		createCNSIVisibility := "CREATE TABLE IF NOT EXISTS cnsi_visibility ("
+		createCNSIVisibility += "cnsi_guid       VARCHAR(36) NOT NULL, "
+		createCNSIVisibility += "principal_type  VARCHAR(16) NOT NULL, "
+		createCNSIVisibility += "principal       VARCHAR(255) NOT NULL, "
+		createCNSIVisibility += "PRIMARY KEY (cnsi_guid, principal_type, principal) )"
+
+		if strings.Contains(conf.Driver.Name, "postgres") {
+			createCNSIVisibility += " WITH (OIDS=FALSE);"
+		} else {
+			createCNSIVisibility += ";"
+		}
+
+		_, err := txn.Exec(createCNSIVisibility)
+		return err
+	})
+}
@@ -0,0 +1,40 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20191216100000, "AuditLog", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		// Append-only record of administrative and security-relevant actions
+		createAuditLog := "CREATE TABLE IF NOT EXISTS audit_log ("
+		createAuditLog += "guid        VARCHAR(36) NOT NULL, "
+		createAuditLog += "event_time  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, "
+		createAuditLog += "actor_guid  VARCHAR(255), "
+		createAuditLog += "actor_name  VARCHAR(255), "
+		createAuditLog += "action      VARCHAR(64) NOT NULL, "
+		createAuditLog += "target      VARCHAR(255), "
+		createAuditLog += "source_ip   VARCHAR(64), "
+		createAuditLog += "outcome     VARCHAR(16) NOT NULL, "
+		createAuditLog += "detail      TEXT, "
+		createAuditLog += "PRIMARY KEY (guid) )"
+
+		if strings.Contains(conf.Driver.Name, "postgres") {
+			createAuditLog += " WITH (OIDS=FALSE);"
+		} else {
+			createAuditLog += ";"
+		}
+
+		if _, err := txn.Exec(createAuditLog); err != nil {
+			return err
+		}
+
+		// Most searches are for recent events
+		_, err := txn.Exec("CREATE INDEX audit_log_event_time ON audit_log (event_time);")
+		return err
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+// EndpointConnectionsRevokeRes - response returned when endpoint connections are revoked
+type EndpointConnectionsRevokeRes struct {
+	Revoked int64 `json:"revoked"`
+}
+
+func parseEndpointTokenFilter(c echo.Context) (interfaces.EndpointTokenFilter, error) {
+	filter := interfaces.EndpointTokenFilter{
+		UserGUID: c.QueryParam("user"),
+		CNSIGUID: c.QueryParam("cnsi_guid"),
+	}
+
+	if value := c.QueryParam("expired_before"); len(value) > 0 {
+		expiredBefore, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, echo.NewHTTPError(http.StatusBadRequest, "Invalid 'expired_before' time - expected RFC 3339")
+		}
+		filter.ExpiredBefore = expiredBefore.Unix()
+	}
+	return filter, nil
+}
+
+// auditEndpointTokenFilter describes the connections being revoked, for the audit log
+func auditEndpointTokenFilter(c echo.Context) string {
+	target := make([]string, 0)
+	for _, name := range []string{"user", "cnsi_guid", "expired_before"} {
+		if value := c.QueryParam(name); len(value) > 0 {
+			target = append(target, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+	return strings.Join(target, " ")
+}
+
+// listEndpointConnections lists the connections of all users to endpoints, optionally filtered by user, endpoint and age
+func (p *portalProxy) listEndpointConnections(c echo.Context) error {
+	log.Debug("listEndpointConnections")
+	filter, err := parseEndpointTokenFilter(c)
+	if err != nil {
+		return err
+	}
+
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return err
+	}
+	connections, err := tokenRepo.ListCNSITokens(filter)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list endpoint connections",
+			"Unable to list endpoint tokens: %v", err)
+	}
+
+	// Only local user names are stored - look up the others, once per user
+	userNames := make(map[string]string)
+	for i := range connections {
+		connection := &connections[i]
+		if len(connection.UserName) > 0 || connection.SystemShared {
+			continue
+		}
+		userName, ok := userNames[connection.UserGUID]
+		if !ok {
+			if user, err := p.GetStratosUser(connection.UserGUID); err == nil {
+				userName = user.Name
+			}
+			userNames[connection.UserGUID] = userName
+		}
+		connection.UserName = userName
+	}
+
+	return c.JSON(http.StatusOK, connections)
+}
+
+// revokeEndpointConnections disconnects the users that match the filter from endpoints. At least one of the user,
+// endpoint and age must be given
+func (p *portalProxy) revokeEndpointConnections(c echo.Context) error {
+	log.Debug("revokeEndpointConnections")
+	filter, err := parseEndpointTokenFilter(c)
+	if err != nil {
+		return err
+	}
+	if filter.IsEmpty() {
+		return echo.NewHTTPError(http.StatusBadRequest, "A user, endpoint or expired_before time must be given")
+	}
+
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return err
+	}
+
+	connections, err := tokenRepo.RevokeCNSITokens(filter)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to revoke endpoint connections",
+			"Unable to delete endpoint tokens: %v", err)
+	}
+
+	for _, connection := range connections {
+		p.TokenRefresher.forget(connection.CNSIGUID, connection.UserGUID)
+		// Cached responses would otherwise still be served to the user
+		p.invalidateProxyCache(connection.CNSIGUID, connection.UserGUID)
+	}
+
+	revoked := int64(len(connections))
+	log.Infof("Revoked %d endpoint connection(s) matching %s", revoked, auditEndpointTokenFilter(c))
+	setAuditDetail(c, fmt.Sprintf("Revoked %d connection(s)", revoked))
+	return c.JSON(http.StatusOK, &EndpointConnectionsRevokeRes{Revoked: revoked})
+}
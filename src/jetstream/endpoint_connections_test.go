@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	listEndpointTokens    = `SELECT (.+) FROM tokens t LEFT JOIN local_users u (.+) LEFT JOIN cnsis c (.+)`
+	deleteEndpointToken   = `DELETE FROM tokens WHERE token_type = 'cnsi' AND token_guid = (.+)`
+	findUAATokenForUser   = `SELECT (.+) FROM tokens WHERE token_type = 'uaa' AND (.+)`
+	otherEndpointUserGUID = "other-user-guid"
+)
+
+var rowFieldsForEndpointToken = []string{"token_guid", "user_guid", "user_name", "cnsi_guid", "name", "cnsi_type", "auth_type", "token_expiry", "disconnected", "linked_token"}
+
+func TestListEndpointConnections(t *testing.T) {
+	t.Parallel()
+
+	Convey("Listing users' endpoint connections", t, func() {
+		req := setupMockReq("GET", "", nil)
+		req.URL.RawQuery = "cnsi_guid=" + mockCFGUID
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		mock.ExpectQuery(listEndpointTokens).
+			WithArgs(mockCFGUID).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForEndpointToken).
+				AddRow(mockTokenGUID, mockUserGUID, "admin", mockCFGUID, "cf", "cf", "OAuth2", mockTokenExpiry, false, nil).
+				AddRow("shared-token", mockAdminGUID, nil, mockCFGUID, "cf", "cf", "OAuth2", mockTokenExpiry, false, nil).
+				AddRow("other-token", otherEndpointUserGUID, nil, mockCFGUID, "cf", "cf", "OAuth2", mockTokenExpiry, true, nil))
+		// The name of a user that isn't a local user is looked up - once
+		mock.ExpectQuery(findUAATokenForUser).
+			WithArgs(otherEndpointUserGUID).
+			WillReturnError(errors.New("no token"))
+
+		err := pp.listEndpointConnections(ctx)
+		So(err, ShouldBeNil)
+		So(res.Code, ShouldEqual, http.StatusOK)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+		var connections []interfaces.EndpointTokenInfo
+		So(json.Unmarshal(res.Body.Bytes(), &connections), ShouldBeNil)
+		So(connections, ShouldHaveLength, 3)
+		So(connections[0].UserName, ShouldEqual, "admin")
+		So(connections[1].SystemShared, ShouldBeTrue)
+		So(connections[2].UserName, ShouldBeEmpty)
+		So(connections[2].Disconnected, ShouldBeTrue)
+		So(res.Body.String(), ShouldNotContainSubstring, "auth_token")
+	})
+
+	Convey("Listing with an invalid expiry time should fail", t, func() {
+		req := setupMockReq("GET", "", nil)
+		req.URL.RawQuery = "expired_before=yesterday"
+		_, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		err := pp.listEndpointConnections(ctx)
+		So(err, ShouldNotBeNil)
+		So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusBadRequest)
+	})
+}
+
+func TestRevokeEndpointConnections(t *testing.T) {
+	t.Parallel()
+
+	Convey("Revoking users' endpoint connections", t, func() {
+
+		Convey("should require a user, endpoint or expiry", func() {
+			req := setupMockReq("DELETE", "", nil)
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+
+			err := pp.revokeEndpointConnections(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusBadRequest)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should revoke old connections of a user", func() {
+			req := setupMockReq("DELETE", "", nil)
+			req.URL.RawQuery = "user=" + mockUserGUID + "&expired_before=2019-12-01T00:00:00Z"
+			res, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+
+			pp.TokenRefresher.recordRefresh(mockCFGUID, mockUserGUID, errors.New("refresh failed"))
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(listEndpointTokens).
+				WithArgs(mockUserGUID, int64(1575158400)).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForEndpointToken).
+					AddRow(mockTokenGUID, mockUserGUID, "admin", mockCFGUID, "cf", "cf", "OAuth2", 1575000000, false, nil))
+			mock.ExpectExec(deleteEndpointToken).
+				WithArgs(mockTokenGUID, mockUserGUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			err := pp.revokeEndpointConnections(ctx)
+			So(err, ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusOK)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+			var revokeRes EndpointConnectionsRevokeRes
+			So(json.Unmarshal(res.Body.Bytes(), &revokeRes), ShouldBeNil)
+			So(revokeRes.Revoked, ShouldEqual, 1)
+			So(pp.TokenRefresher.status(mockCFGUID, mockUserGUID, nil), ShouldBeNil)
+			So(auditEndpointTokenFilter(ctx), ShouldEqual, "user="+mockUserGUID+" expired_before=2019-12-01T00:00:00Z")
+		})
+
+		Convey("should fail if the tokens can't be deleted", func() {
+			req := setupMockReq("DELETE", "", nil)
+			req.URL.RawQuery = "cnsi_guid=" + mockCFGUID
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(listEndpointTokens).
+				WithArgs(mockCFGUID, mockAdminGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForEndpointToken).
+					AddRow(mockTokenGUID, mockUserGUID, "admin", mockCFGUID, "cf", "cf", "OAuth2", mockTokenExpiry, false, nil))
+			mock.ExpectExec(deleteEndpointToken).
+				WithArgs(mockTokenGUID, mockUserGUID).
+				WillReturnError(errors.New("database error"))
+			mock.ExpectRollback()
+
+			err := pp.revokeEndpointConnections(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusInternalServerError)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Transports for endpoints that have their own connection settings, keyed by a fingerprint of those settings.
+// Transports are kept so that connections to the endpoint can be reused
+var endpointTransports = struct {
+	sync.Mutex
+	transports map[string]http.RoundTripper
+}{
+	transports: make(map[string]http.RoundTripper),
+}
+
+// errorTransport fails every request - used when an endpoint's connection settings are invalid, so that we never fall back
+// to connecting without them
+type errorTransport struct {
+	err error
+}
+
+func (t *errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, &endpointSettingsError{t.err}
+}
+
+// endpointSettingsError is returned for requests to an endpoint whose connection settings are invalid
+type endpointSettingsError struct {
+	err error
+}
+
+func (e *endpointSettingsError) Error() string {
+	return e.err.Error()
+}
+
+// GetEndpointTLSConfig returns the TLS config for connecting to an endpoint, trusting its CA bundle and presenting its client certificate
+func (p *portalProxy) GetEndpointTLSConfig(skipSSLValidation bool, connection interfaces.EndpointConnectionSettings) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: skipSSLValidation}
+
+	if len(connection.CACert) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(connection.CACert)) {
+			return nil, errors.New("CA certificate bundle does not contain any PEM encoded certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(connection.ClientCert) > 0 || len(connection.ClientCertKey) > 0 {
+		cert, err := tls.X509KeyPair([]byte(connection.ClientCert), []byte(connection.ClientCertKey))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid client certificate or key: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// GetEndpointProxy returns the proxy function for connecting to an endpoint. Endpoints without their own proxy use the environment
+func (p *portalProxy) GetEndpointProxy(connection interfaces.EndpointConnectionSettings) (func(*http.Request) (*url.URL, error), error) {
+	if len(connection.ProxyURL) == 0 {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(connection.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid proxy URL: %v", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("Unsupported proxy URL scheme '%s' - must be http, https or socks5", proxyURL.Scheme)
+	}
+	if len(proxyURL.Host) == 0 {
+		return nil, errors.New("Invalid proxy URL: missing host")
+	}
+
+	return http.ProxyURL(proxyURL), nil
+}
+
+// validateEndpointConnection checks that the connection settings for an endpoint can be used
+func (p *portalProxy) validateEndpointConnection(connection interfaces.EndpointConnectionSettings) error {
+	if _, err := p.GetEndpointTLSConfig(false, connection); err != nil {
+		return err
+	}
+	_, err := p.GetEndpointProxy(connection)
+	return err
+}
+
+func (p *portalProxy) getEndpointTransport(skipSSLValidation bool, connection interfaces.EndpointConnectionSettings) http.RoundTripper {
+	h := sha256.New()
+	for _, value := range []string{strconv.FormatBool(skipSSLValidation), connection.CACert, connection.ClientCert, connection.ClientCertKey, connection.ProxyURL} {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	fingerprint := hex.EncodeToString(h.Sum(nil))
+
+	endpointTransports.Lock()
+	defer endpointTransports.Unlock()
+
+	if transport, ok := endpointTransports.transports[fingerprint]; ok {
+		return transport
+	}
+
+	var transport http.RoundTripper
+	tlsConfig, err := p.GetEndpointTLSConfig(skipSSLValidation, connection)
+	if err == nil {
+		var proxy func(*http.Request) (*url.URL, error)
+		if proxy, err = p.GetEndpointProxy(connection); err == nil {
+			transport = &http.Transport{
+				Proxy:               proxy,
+				Dial:                httpDialer,
+				TLSHandshakeTimeout: 10 * time.Second, // 10 seconds is a sound default value (default is 0)
+				TLSClientConfig:     tlsConfig,
+				MaxIdleConnsPerHost: 6, // (default is 2)
+			}
+		}
+	}
+	if err != nil {
+		// Don't keep this, so that the error is logged for each request
+		log.Errorf("Invalid endpoint connection settings: %v", err)
+		return &errorTransport{fmt.Errorf("Invalid endpoint connection settings: %v", err)}
+	}
+
+	endpointTransports.transports[fingerprint] = transport
+	return transport
+}
+
+// getEndpointConnection returns the connection settings for an endpoint
+func (p *portalProxy) getEndpointConnection(cnsiGUID string) (interfaces.EndpointConnectionSettings, error) {
+	cnsiRecord, err := p.GetCNSIRecord(cnsiGUID)
+	if err != nil {
+		return interfaces.EndpointConnectionSettings{}, fmt.Errorf("Info could not be found for CNSI with GUID %s: %s", cnsiGUID, err)
+	}
+	return cnsiRecord.Connection, nil
+}
+
+// GetHttpClientForEndpoint returns an Http Client for an endpoint, using the endpoint's own connection settings if it has any
+func (p *portalProxy) GetHttpClientForEndpoint(skipSSLValidation bool, connection interfaces.EndpointConnectionSettings) http.Client {
+	if !connection.IsSet() {
+		return p.GetHttpClient(skipSSLValidation)
+	}
+
+	return http.Client{
+		Transport: p.getEndpointTransport(skipSSLValidation, connection),
+		Timeout:   time.Duration(p.Config.HTTPClientTimeoutInSecs) * time.Second,
+	}
+}
+
+// GetHttpClientForEndpointRequest returns an Http Client for the given request to an endpoint, using the endpoint's own
+// connection settings if it has any
+func (p *portalProxy) GetHttpClientForEndpointRequest(req *http.Request, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings) http.Client {
+	if !connection.IsSet() {
+		return p.GetHttpClientForRequest(req, skipSSLValidation)
+	}
+
+	timeout := p.Config.HTTPClientTimeoutInSecs
+	if isMutatingRequest(req) {
+		timeout = p.Config.HTTPClientTimeoutMutatingInSecs
+	}
+	client := http.Client{
+		Transport: p.getEndpointTransport(skipSSLValidation, connection),
+		Timeout:   time.Duration(timeout) * time.Second,
+	}
+	return p.getHttpClientForRequestTimeout(req, client)
+}
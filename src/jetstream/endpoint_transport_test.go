@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestEndpointConnectionSettings(t *testing.T) {
+	t.Parallel()
+
+	Convey("Endpoint connection settings tests", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		Convey("should accept empty settings", func() {
+			So(pp.validateEndpointConnection(interfaces.EndpointConnectionSettings{}), ShouldBeNil)
+		})
+
+		Convey("should reject a CA bundle without certificates", func() {
+			err := pp.validateEndpointConnection(interfaces.EndpointConnectionSettings{CACert: "not a certificate"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should reject an invalid client certificate", func() {
+			err := pp.validateEndpointConnection(interfaces.EndpointConnectionSettings{ClientCert: "cert", ClientCertKey: "key"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should validate the proxy URL", func() {
+			So(pp.validateEndpointConnection(interfaces.EndpointConnectionSettings{ProxyURL: "http://proxy.example.com:3128"}), ShouldBeNil)
+			So(pp.validateEndpointConnection(interfaces.EndpointConnectionSettings{ProxyURL: "socks5://proxy.example.com:1080"}), ShouldBeNil)
+			So(pp.validateEndpointConnection(interfaces.EndpointConnectionSettings{ProxyURL: "ftp://proxy.example.com"}), ShouldNotBeNil)
+			So(pp.validateEndpointConnection(interfaces.EndpointConnectionSettings{ProxyURL: "http://"}), ShouldNotBeNil)
+		})
+
+		Convey("should use the endpoint's proxy", func() {
+			proxy, err := pp.GetEndpointProxy(interfaces.EndpointConnectionSettings{ProxyURL: "http://proxy.example.com:3128"})
+			So(err, ShouldBeNil)
+			proxyURL, err := proxy(req)
+			So(err, ShouldBeNil)
+			So(proxyURL.String(), ShouldEqual, "http://proxy.example.com:3128")
+		})
+
+		Convey("should fail requests when the settings are invalid", func() {
+			client := pp.GetHttpClientForEndpoint(false, interfaces.EndpointConnectionSettings{CACert: "not a certificate"})
+			_, ok := client.Transport.(*errorTransport)
+			So(ok, ShouldBeTrue)
+
+			outbound, _ := http.NewRequest("GET", "https://api.example.com", nil)
+			_, err := client.Do(outbound)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should reuse the transport for the same settings", func() {
+			connection := interfaces.EndpointConnectionSettings{ProxyURL: "http://proxy.example.com:3128"}
+			first := pp.GetHttpClientForEndpoint(true, connection)
+			second := pp.GetHttpClientForEndpoint(true, connection)
+			So(first.Transport, ShouldEqual, second.Transport)
+		})
+	})
+}
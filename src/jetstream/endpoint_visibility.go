@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Endpoints that the user can't see look exactly as if they weren't registered
+var errEndpointNotVisible = errors.New("No match for that Endpoint")
+
+// isEndpointVisibleTo checks if the user is one of a restricted endpoint's principals, or is in one of its groups
+func isEndpointVisibleTo(principals []interfaces.EndpointPrincipal, user *interfaces.ConnectedUser) bool {
+	for _, principal := range principals {
+		switch principal.PrincipalType {
+		case interfaces.RolePrincipalUser:
+			if principal.Principal == user.GUID {
+				return true
+			}
+		case interfaces.RolePrincipalGroup:
+			if ArrayContainsString(user.Scopes, principal.Principal) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getEndpointVisibility returns a check for the endpoints that the request's user can see. Endpoints without any
+// principals can be seen by everyone, and users that can manage endpoints can see all of them
+func (p *portalProxy) getEndpointVisibility(c echo.Context) (func(cnsiGUID string) bool, error) {
+	visibleToAll := func(string) bool { return true }
+
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, err
+	}
+	restricted, err := cnsiRepo.ListVisibility()
+	if err != nil {
+		return nil, err
+	}
+	if len(restricted) == 0 {
+		return visibleToAll, nil
+	}
+
+	user, err := p.getRequestUser(c)
+	if err != nil {
+		return nil, err
+	}
+	userRoles, err := p.getRequestUserRoles(c)
+	if err != nil {
+		return nil, err
+	}
+	if interfaces.RolesHavePermission(userRoles, interfaces.PermissionEndpointsManage) {
+		return visibleToAll, nil
+	}
+
+	return func(cnsiGUID string) bool {
+		principals, ok := restricted[cnsiGUID]
+		return !ok || isEndpointVisibleTo(principals, user)
+	}, nil
+}
+
+// CheckEndpointVisible returns the same error as for an unregistered endpoint if the request's user can't see any
+// of the endpoints
+func (p *portalProxy) CheckEndpointVisible(c echo.Context, cnsiGUIDs ...string) error {
+	isVisible, err := p.getEndpointVisibility(c)
+	if err != nil {
+		return fmt.Errorf("Unable to check endpoint visibility: %v", err)
+	}
+	for _, cnsiGUID := range cnsiGUIDs {
+		if !isVisible(cnsiGUID) {
+			log.Warnf("Endpoint %s is not visible to the session user", cnsiGUID)
+			return errEndpointNotVisible
+		}
+	}
+	return nil
+}
+
+func validateEndpointPrincipals(principals []interfaces.EndpointPrincipal) error {
+	for _, principal := range principals {
+		if principal.PrincipalType != interfaces.RolePrincipalUser && principal.PrincipalType != interfaces.RolePrincipalGroup {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid principal type '%s'", principal.PrincipalType))
+		}
+		if len(principal.Principal) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Needs a principal")
+		}
+	}
+	return nil
+}
+
+func (p *portalProxy) getEndpointVisibilityRepo(c echo.Context) (cnsis.Repository, string, error) {
+	cnsiGUID := c.Param("id")
+	if _, err := p.GetCNSIRecord(cnsiGUID); err != nil {
+		return nil, "", echo.NewHTTPError(http.StatusNotFound, "Endpoint not found")
+	}
+
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, "", interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to access endpoint visibility",
+			"Database error getting repo for endpoints: %v", err)
+	}
+	return cnsiRepo, cnsiGUID, nil
+}
+
+func (p *portalProxy) getEndpointVisibilityHandler(c echo.Context) error {
+	log.Debug("getEndpointVisibilityHandler")
+	cnsiRepo, cnsiGUID, err := p.getEndpointVisibilityRepo(c)
+	if err != nil {
+		return err
+	}
+
+	principals, err := cnsiRepo.FindVisibility(cnsiGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to read endpoint visibility",
+			"Unable to read visibility of endpoint %s: %v", cnsiGUID, err)
+	}
+	return c.JSON(http.StatusOK, principals)
+}
+
+// updateEndpointVisibility restricts an endpoint to the given users and groups. An empty list makes it visible to
+// everyone again
+func (p *portalProxy) updateEndpointVisibility(c echo.Context) error {
+	log.Debug("updateEndpointVisibility")
+	principals := make([]interfaces.EndpointPrincipal, 0)
+	if err := json.NewDecoder(c.Request().Body).Decode(&principals); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid endpoint visibility",
+			"Invalid endpoint visibility: %v", err)
+	}
+	if err := validateEndpointPrincipals(principals); err != nil {
+		return err
+	}
+
+	cnsiRepo, cnsiGUID, err := p.getEndpointVisibilityRepo(c)
+	if err != nil {
+		return err
+	}
+	if err := cnsiRepo.SetVisibility(cnsiGUID, principals); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to update endpoint visibility",
+			"Unable to update visibility of endpoint %s: %v", cnsiGUID, err)
+	}
+
+	log.Infof("Restricted endpoint %s to %d user(s) and group(s)", cnsiGUID, len(principals))
+	return c.JSON(http.StatusOK, principals)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestEndpointVisibility(t *testing.T) {
+	t.Parallel()
+
+	Convey("Endpoint visibility tests", t, func() {
+		setup := func() (echo.Context, *portalProxy, sqlmock.Sqlmock, func()) {
+			req := setupMockReq("GET", "", nil)
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			pp.Config.ConsoleConfig.ConsoleAdminScope = UAAAdminIdentifier
+			pp.setSessionValues(ctx, map[string]interface{}{"user_id": mockUserGUID})
+			return ctx, pp, mock, func() { db.Close() }
+		}
+		expectVisibility := func(mock sqlmock.Sqlmock, rows ...[]string) {
+			visibility := sqlmock.NewRows(rowFieldsForCNSIVisibility)
+			for _, row := range rows {
+				visibility.AddRow(row[0], row[1], row[2])
+			}
+			mock.ExpectQuery(listCNSIVisibility).WillReturnRows(visibility)
+		}
+		expectUser := func(mock sqlmock.Sqlmock, scope string) {
+			mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("user", "", scope, "", "", false))
+			mock.ExpectQuery(findRoleAssignments).WillReturnRows(sqlmock.NewRows(rowFieldsForRoleAssignment))
+		}
+
+		Convey("should not look up the user if no endpoints are restricted", func() {
+			ctx, pp, mock, done := setup()
+			defer done()
+			expectVisibility(mock)
+
+			So(pp.CheckEndpointVisible(ctx, "cf-1"), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should hide endpoints restricted to other users and groups", func() {
+			ctx, pp, mock, done := setup()
+			defer done()
+			expectVisibility(mock, []string{"cf-1", "user", "someone-else"}, []string{"cf-1", "group", "ops"})
+			expectUser(mock, "stratos.user")
+
+			err := pp.CheckEndpointVisible(ctx, "cf-2", "cf-1")
+			So(err, ShouldEqual, errEndpointNotVisible)
+		})
+
+		Convey("should show endpoints restricted to the user or one of their groups", func() {
+			ctx, pp, mock, done := setup()
+			defer done()
+			expectVisibility(mock, []string{"cf-1", "user", mockUserGUID}, []string{"cf-2", "group", "stratos.user"})
+			expectUser(mock, "stratos.user")
+
+			So(pp.CheckEndpointVisible(ctx, "cf-1", "cf-2", "cf-3"), ShouldBeNil)
+		})
+
+		Convey("should show every endpoint to users that can manage them", func() {
+			ctx, pp, mock, done := setup()
+			defer done()
+			expectVisibility(mock, []string{"cf-1", "user", "someone-else"})
+			expectUser(mock, UAAAdminIdentifier)
+
+			So(pp.CheckEndpointVisible(ctx, "cf-1"), ShouldBeNil)
+		})
+
+		Convey("should filter the list of endpoints", func() {
+			ctx, pp, mock, done := setup()
+			defer done()
+
+			mock.ExpectQuery(`SELECT (.+) FROM cnsis`).WillReturnRows(sqlmock.NewRows(rowFieldsForCNSI).
+				AddRow("cf-1", "Hidden", "cf", "https://api.hidden", "", "", "", true, "", cipherClientSecret, true, "", "", "", "", nil, "").
+				AddRow("cf-2", "Visible", "cf", "https://api.visible", "", "", "", true, "", cipherClientSecret, true, "", "", "", "", nil, ""))
+			expectVisibility(mock, []string{"cf-1", "group", "ops"})
+			expectUser(mock, "stratos.user")
+
+			cnsiList, err := pp.buildCNSIList(ctx)
+			So(err, ShouldBeNil)
+			So(cnsiList, ShouldHaveLength, 1)
+			So(cnsiList[0].GUID, ShouldEqual, "cf-2")
+		})
+
+		Convey("should hide rewrite rules from users that can not manage endpoints", func() {
+			ctx, pp, mock, done := setup()
+			defer done()
+
+			rules := `{"rewrite":{"request_headers":{"add":{"X-Tenant-Secret":"secret"}}}}`
+			mock.ExpectQuery(`SELECT (.+) FROM cnsis`).WillReturnRows(sqlmock.NewRows(rowFieldsForCNSI).
+				AddRow("cf-1", "Gateway", "cf", "https://api.gateway", "", "", "", true, "", cipherClientSecret, true, "", rules, "", "", nil, "").
+				AddRow("cf-2", "Other", "cf", "https://api.other", "", "", "", true, "", cipherClientSecret, true, "", "", "", "", nil, ""))
+			expectVisibility(mock)
+			expectUser(mock, "stratos.user")
+
+			cnsiList, err := pp.buildCNSIList(ctx)
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			So(cnsiList, ShouldHaveLength, 2)
+			So(cnsiList[0].Metadata, ShouldBeEmpty)
+
+			info, err := json.Marshal(cnsiList)
+			So(err, ShouldBeNil)
+			So(string(info), ShouldNotContainSubstring, "secret")
+		})
+
+		Convey("should show rewrite rules to users that can manage endpoints", func() {
+			ctx, pp, mock, done := setup()
+			defer done()
+
+			rules := `{"rewrite":{"host":"gateway"}}`
+			mock.ExpectQuery(`SELECT (.+) FROM cnsis`).WillReturnRows(sqlmock.NewRows(rowFieldsForCNSI).
+				AddRow("cf-1", "Gateway", "cf", "https://api.gateway", "", "", "", true, "", cipherClientSecret, true, "", rules, "", "", nil, ""))
+			expectVisibility(mock)
+			expectUser(mock, UAAAdminIdentifier)
+
+			cnsiList, err := pp.buildCNSIList(ctx)
+			So(err, ShouldBeNil)
+			So(cnsiList[0].Metadata, ShouldEqual, rules)
+		})
+
+		Convey("should reject invalid principals", func() {
+			ctx, pp, _, done := setup()
+			defer done()
+			ctx.Request().Body = ioutil.NopCloser(strings.NewReader(`[{"principal_type":"team","principal":"ops"}]`))
+
+			err := pp.updateEndpointVisibility(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
@@ -14,7 +14,7 @@ func (p *portalProxy) doHttpBasicFlowRequest(cnsiRequest *interfaces.CNSIRequest
 	authHandler := func(tokenRec interfaces.TokenRecord, cnsi interfaces.CNSIRecord) (*http.Response, error) {
 		// Http Basic has no token refresh or expiry - so much simpler than the OAuth flow
 		req.Header.Set("Authorization", "basic "+tokenRec.AuthToken)
-		client := p.GetHttpClientForRequest(req, cnsi.SkipSSLValidation)
+		client := p.GetHttpClientForEndpointRequest(req, cnsi.SkipSSLValidation, cnsi.Connection)
 		return client.Do(req)
 	}
 	return p.DoAuthFlowRequest(cnsiRequest, req, authHandler)
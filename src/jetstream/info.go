@@ -47,6 +47,13 @@ func (p *portalProxy) getInfo(c echo.Context) (*interfaces.Info, error) {
 		return nil, errors.New("Could not load session user data")
 	}
 
+	userRoles, err := p.getUserRoles(uaaUser)
+	if err != nil {
+		return nil, errors.New("Could not load session user roles")
+	}
+	uaaUser.Roles = userRoles
+	uaaUser.Permissions = getPermissions(userRoles)
+
 	// create initial info struct
 	s := &interfaces.Info{
 		Versions:     versions,
@@ -59,7 +66,7 @@ func (p *portalProxy) getInfo(c echo.Context) (*interfaces.Info, error) {
 	s.Configuration.TechPreview = p.Config.EnableTechPreview
 
 	// Only add diagnostics information if the user is an admin
-	if uaaUser.Admin {
+	if interfaces.RolesHavePermission(userRoles, interfaces.PermissionConsoleAdmin) {
 		s.Diagnostics = p.Diagnostics
 	}
 
@@ -85,6 +92,7 @@ func (p *portalProxy) getInfo(c echo.Context) (*interfaces.Info, error) {
 			EndpointMetadata:  marshalEndpointMetadata(cnsi.Metadata),
 			Metadata:          make(map[string]string),
 			SystemSharedToken: false,
+			CircuitBreaker:    p.CircuitBreakers.status(cnsi.GUID),
 		}
 		// try to get the user info for this cnsi for the user
 		cnsiUser, token, ok := p.GetCNSIUserAndToken(cnsi.GUID, userGUID)
@@ -93,6 +101,7 @@ func (p *portalProxy) getInfo(c echo.Context) (*interfaces.Info, error) {
 			endpoint.TokenMetadata = token.Metadata
 			endpoint.SystemSharedToken = token.SystemShared
 		}
+		endpoint.TokenHealth = p.TokenRefresher.status(cnsi.GUID, userGUID, token)
 		cnsiType := cnsi.CNSIType
 		s.Endpoints[cnsiType][cnsi.GUID] = endpoint
 	}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Response header containing the ID of the job tracking a long-running request
+const jobIDHeader = "x-cap-job-id"
+
+// Default time for which the results of a completed job are kept
+const defaultJobRetentionInSecs = 600
+
+const (
+	jobRunning   = "running"
+	jobComplete  = "complete"
+	jobCancelled = "cancelled"
+)
+
+// proxyJob tracks a long-running request to one or more endpoints
+type proxyJob struct {
+	ID        string
+	UserGUID  string
+	CNSIList  []string
+	Created   time.Time
+	Completed time.Time
+	Status    string
+	responses map[string]*interfaces.CNSIRequest
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// JobStatus - Response returned to the caller when getting a job
+type JobStatus struct {
+	ID        string                      `json:"id"`
+	Status    string                      `json:"status"`
+	Created   int64                       `json:"created"`
+	Completed int64                       `json:"completed,omitempty"`
+	Endpoints []string                    `json:"endpoints"`
+	Results   map[string]*json.RawMessage `json:"results"`
+}
+
+// jobStore holds the jobs for long-running requests, until a while after they have completed
+type jobStore struct {
+	sync.Mutex
+	jobs      map[string]*proxyJob
+	retention time.Duration
+}
+
+func newJobStore(retentionInSecs int64) *jobStore {
+	if retentionInSecs <= 0 {
+		retentionInSecs = defaultJobRetentionInSecs
+	}
+	return &jobStore{
+		jobs:      make(map[string]*proxyJob),
+		retention: time.Duration(retentionInSecs) * time.Second,
+	}
+}
+
+func (s *jobStore) create(userGUID string, cnsiList []string) *proxyJob {
+	s.Lock()
+	defer s.Unlock()
+
+	s.purge()
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &proxyJob{
+		ID:        uuid.NewV4().String(),
+		UserGUID:  userGUID,
+		CNSIList:  cnsiList,
+		Created:   time.Now(),
+		Status:    jobRunning,
+		responses: make(map[string]*interfaces.CNSIRequest),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	s.jobs[job.ID] = job
+	return job
+}
+
+// get returns the job if it belongs to the user
+func (s *jobStore) get(id, userGUID string) (*proxyJob, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.purge()
+	job, ok := s.jobs[id]
+	if !ok || job.UserGUID != userGUID {
+		return nil, false
+	}
+	return job, true
+}
+
+func (s *jobStore) remove(id string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.jobs, id)
+}
+
+// Remove jobs that completed longer ago than the retention window
+func (s *jobStore) purge() {
+	for id, job := range s.jobs {
+		if job.Status != jobRunning && time.Since(job.Completed) > s.retention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// collect records the responses for the job as they arrive
+func (s *jobStore) collect(job *proxyJob, responses <-chan *interfaces.CNSIRequest) {
+	for range job.CNSIList {
+		res := <-responses
+		s.Lock()
+		job.responses[res.GUID] = res
+		s.Unlock()
+	}
+
+	s.Lock()
+	if job.Status == jobRunning {
+		job.Status = jobComplete
+	}
+	job.Completed = time.Now()
+	s.Unlock()
+
+	job.cancel()
+	close(job.done)
+}
+
+// snapshot returns the current status of the job, including the results for those endpoints that have responded
+func (s *jobStore) snapshot(job *proxyJob) *JobStatus {
+	s.Lock()
+	defer s.Unlock()
+
+	status := &JobStatus{
+		ID:        job.ID,
+		Status:    job.Status,
+		Created:   job.Created.Unix(),
+		Endpoints: job.CNSIList,
+	}
+	if !job.Completed.IsZero() {
+		status.Completed = job.Completed.Unix()
+	}
+
+	var completed []string
+	for _, guid := range job.CNSIList {
+		if _, ok := job.responses[guid]; ok {
+			completed = append(completed, guid)
+		}
+	}
+	status.Results = buildJSONResponse(completed, job.responses)
+	return status
+}
+
+// responsesOrTimeout returns the responses for the job, with a "still active" response for those endpoints
+// that have not yet responded
+func (s *jobStore) responsesOrTimeout(job *proxyJob, method string) map[string]*interfaces.CNSIRequest {
+	s.Lock()
+	defer s.Unlock()
+
+	responses := make(map[string]*interfaces.CNSIRequest)
+	for _, id := range job.CNSIList {
+		if res, ok := job.responses[id]; ok {
+			responses[id] = res
+			continue
+		}
+		// Did not get a response for the endpoint
+		responses[id] = &interfaces.CNSIRequest{
+			GUID:         id,
+			UserGUID:     job.UserGUID,
+			Method:       method,
+			StatusCode:   http.StatusAccepted,
+			Status:       "Long Running Operation still active",
+			Response:     makeLongRunningTimeoutError(job.ID),
+			Error:        nil,
+			ResponseGUID: id,
+		}
+	}
+	return responses
+}
+
+// doLongRunningRequests makes the requests as a job. If they have not all completed before the long-running timeout,
+// we respond with the job ID and the requests carry on - their results can then be fetched via the jobs API
+func (p *portalProxy) doLongRunningRequests(c echo.Context, userGUID string, cnsiRequests []*interfaces.CNSIRequest) (map[string]*interfaces.CNSIRequest, error) {
+	log.Debug("doLongRunningRequests")
+	cnsiList := make([]string, 0, len(cnsiRequests))
+	for _, cnsiRequest := range cnsiRequests {
+		cnsiList = append(cnsiList, cnsiRequest.GUID)
+	}
+
+	job := p.Jobs.create(userGUID, cnsiList)
+	done := make(chan *interfaces.CNSIRequest)
+	for _, cnsiRequest := range cnsiRequests {
+		go p.doRequestWithContext(job.ctx, cnsiRequest, done)
+	}
+	go p.Jobs.collect(job, done)
+
+	select {
+	case <-job.done:
+		// Completed in time, so nobody needs to ask for the job
+		p.Jobs.remove(job.ID)
+	case <-time.After(longRunningRequestTimeout * time.Second):
+		log.Infof("Long running request still active - tracking as job %s", job.ID)
+		c.Response().Header().Set(jobIDHeader, job.ID)
+	}
+
+	return p.Jobs.responsesOrTimeout(job, c.Request().Method), nil
+}
+
+func (p *portalProxy) getJob(c echo.Context) error {
+	log.Debug("getJob")
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	job, ok := p.Jobs.get(c.Param("id"), userGUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Job not found")
+	}
+
+	return c.JSON(http.StatusOK, p.Jobs.snapshot(job))
+}
+
+// cancelJob stops any requests for the job that are still running and discards the job
+func (p *portalProxy) cancelJob(c echo.Context) error {
+	log.Debug("cancelJob")
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	job, ok := p.Jobs.get(c.Param("id"), userGUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Job not found")
+	}
+
+	p.Jobs.Lock()
+	if job.Status == jobRunning {
+		job.Status = jobCancelled
+	}
+	p.Jobs.Unlock()
+	job.cancel()
+	p.Jobs.remove(job.ID)
+
+	return c.NoContent(http.StatusNoContent)
+}
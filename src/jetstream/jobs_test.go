@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestJobStore(t *testing.T) {
+	t.Parallel()
+
+	Convey("Job store tests", t, func() {
+		store := newJobStore(60)
+		job := store.create(mockUserGUID, []string{mockCFGUID, mockCEGUID})
+
+		cfResponse := &interfaces.CNSIRequest{GUID: mockCFGUID, StatusCode: http.StatusOK, Response: []byte(`{"name":"a"}`)}
+
+		Convey("should only be visible to the user that created it", func() {
+			_, ok := store.get(job.ID, mockUserGUID)
+			So(ok, ShouldBeTrue)
+			_, ok = store.get(job.ID, "another-user")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("should report the results of completed requests while running", func() {
+			job.responses[mockCFGUID] = cfResponse
+
+			status := store.snapshot(job)
+			So(status.Status, ShouldEqual, jobRunning)
+			So(status.Results, ShouldContainKey, mockCFGUID)
+			So(status.Results, ShouldNotContainKey, mockCEGUID)
+
+			responses := store.responsesOrTimeout(job, "GET")
+			So(responses[mockCFGUID].StatusCode, ShouldEqual, http.StatusOK)
+			So(responses[mockCEGUID].StatusCode, ShouldEqual, http.StatusAccepted)
+
+			var timeoutError PassthroughError
+			So(json.Unmarshal(responses[mockCEGUID].Response, &timeoutError), ShouldBeNil)
+			So(string(*timeoutError.ErrorResponse), ShouldContainSubstring, job.ID)
+		})
+
+		Convey("should complete once all requests have responded", func() {
+			responses := make(chan *interfaces.CNSIRequest)
+			go store.collect(job, responses)
+			responses <- cfResponse
+			responses <- &interfaces.CNSIRequest{GUID: mockCEGUID, StatusCode: http.StatusOK}
+			<-job.done
+
+			status := store.snapshot(job)
+			So(status.Status, ShouldEqual, jobComplete)
+			So(status.Completed, ShouldBeGreaterThan, 0)
+			So(status.Results, ShouldContainKey, mockCEGUID)
+			So(job.ctx.Err(), ShouldNotBeNil)
+
+			Convey("should be discarded after the retention window", func() {
+				store.retention = 0
+				job.Completed = time.Now().Add(-time.Second)
+				_, ok := store.get(job.ID, mockUserGUID)
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestGetJob(t *testing.T) {
+	t.Parallel()
+
+	Convey("Get job tests", t, func() {
+		req := setupMockReq("GET", "", nil)
+		res, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+		ctx.Set("user_id", mockUserGUID)
+
+		job := pp.Jobs.create(mockUserGUID, []string{mockCFGUID})
+
+		Convey("should return the job", func() {
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(job.ID)
+			So(pp.getJob(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusOK)
+
+			var status JobStatus
+			So(json.Unmarshal(res.Body.Bytes(), &status), ShouldBeNil)
+			So(status.ID, ShouldEqual, job.ID)
+			So(status.Status, ShouldEqual, jobRunning)
+		})
+
+		Convey("should cancel the job", func() {
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(job.ID)
+			So(pp.cancelJob(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusNoContent)
+			So(job.ctx.Err(), ShouldNotBeNil)
+			_, ok := pp.Jobs.get(job.ID, mockUserGUID)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("should not find an unknown job", func() {
+			ctx.SetParamNames("id")
+			ctx.SetParamValues("unknown")
+			So(pp.getJob(ctx), ShouldNotBeNil)
+		})
+	})
+}
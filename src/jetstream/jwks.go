@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SermoDigital/jose/jws"
+	"github.com/SermoDigital/jose/jwt"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// How long a key set is cached before it is fetched again
+const jwksCacheTTL = time.Hour
+
+// Minimum time between fetches of a key set when a token is signed with a key that is not in it (e.g. after key rotation)
+const jwksMinRefreshInterval = time.Minute
+
+// Allowed clock skew when checking the expiry of a token
+const jwtExpiryLeeway = 30 * time.Second
+
+// JSONWebKey - A public key in a JSON Web Key Set (RFC 7517). UAA also provides the key in PEM format as 'value'
+type JSONWebKey struct {
+	Kty   string `json:"kty"`
+	Kid   string `json:"kid"`
+	Use   string `json:"use"`
+	Alg   string `json:"alg"`
+	N     string `json:"n"`
+	E     string `json:"e"`
+	Crv   string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+	Value string `json:"value"`
+}
+
+// JSONWebKeySet - A JSON Web Key Set
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+type cachedKeySet struct {
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+// Key sets, keyed by their URL
+var jwksCache = struct {
+	sync.Mutex
+	keySets map[string]*cachedKeySet
+}{
+	keySets: make(map[string]*cachedKeySet),
+}
+
+// publicKey returns the RSA or ECDSA public key
+func (k *JSONWebKey) publicKey() (interface{}, error) {
+	if len(k.N) == 0 && len(k.X) == 0 && len(k.Value) > 0 {
+		block, _ := pem.Decode([]byte(k.Value))
+		if block == nil {
+			return nil, errors.New("Key value is not PEM encoded")
+		}
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.N, "="))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid RSA modulus: %v", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.E, "="))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid RSA exponent: %v", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Unsupported elliptic curve: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.X, "="))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid EC x coordinate: %v", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.Y, "="))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid EC y coordinate: %v", err)
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported key type: %s", k.Kty)
+}
+
+// fetchJWKS fetches a key set, returning the signing keys by key ID
+func (p *portalProxy) fetchJWKS(jwksURL string, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings) (map[string]interface{}, error) {
+	log.Debugf("fetchJWKS: %s", jwksURL)
+	req, err := http.NewRequest("GET", jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	h := p.GetHttpClientForEndpoint(skipSSLValidation, connection)
+	res, err := h.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return nil, interfaces.LogHTTPError(res, err)
+	}
+	defer res.Body.Close()
+
+	keySet := &JSONWebKeySet{}
+	if err := json.NewDecoder(res.Body).Decode(keySet); err != nil {
+		return nil, fmt.Errorf("Unable to decode key set: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, key := range keySet.Keys {
+		if len(key.Use) > 0 && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			// Skip keys we can't use, rather than failing for all of them
+			log.Warnf("Ignoring key '%s' from %s: %v", key.Kid, jwksURL, err)
+			continue
+		}
+		keys[key.Kid] = publicKey
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Key set %s does not contain any usable signing keys", jwksURL)
+	}
+	return keys, nil
+}
+
+// getJWKSKey returns the signing key with the given ID. The key set is fetched if it is not cached, has expired or does not
+// contain the key, so that rotated keys are picked up
+func (p *portalProxy) getJWKSKey(jwksURL, kid string, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings) (interface{}, error) {
+	jwksCache.Lock()
+	keySet, ok := jwksCache.keySets[jwksURL]
+	jwksCache.Unlock()
+
+	if ok && time.Since(keySet.fetched) < jwksCacheTTL {
+		if key, found := keySet.lookup(kid); found {
+			return key, nil
+		}
+		if time.Since(keySet.fetched) < jwksMinRefreshInterval {
+			return nil, fmt.Errorf("Unknown signing key '%s'", kid)
+		}
+	}
+
+	keys, err := p.fetchJWKS(jwksURL, skipSSLValidation, connection)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch signing keys: %v", err)
+	}
+	keySet = &cachedKeySet{keys: keys, fetched: time.Now()}
+
+	jwksCache.Lock()
+	jwksCache.keySets[jwksURL] = keySet
+	jwksCache.Unlock()
+
+	if key, found := keySet.lookup(kid); found {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unknown signing key '%s'", kid)
+}
+
+// lookup finds the key with the given ID. Tokens without a key ID can only be checked against a key set with a single key
+func (k *cachedKeySet) lookup(kid string) (interface{}, bool) {
+	if key, ok := k.keys[kid]; ok {
+		return key, true
+	}
+	if len(kid) == 0 && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// TokenVerificationError - A token could not be verified, so its claims must not be trusted
+type TokenVerificationError struct {
+	Err error
+}
+
+func (e *TokenVerificationError) Error() string {
+	return fmt.Sprintf("Token verification failed: %v", e.Err)
+}
+
+// verifyJWT checks the signature of a token against the key set and validates its expiry and the expected claims.
+// Returns the token's claims, or a TokenVerificationError
+func (p *portalProxy) verifyJWT(token, jwksURL string, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings, expected jwt.Claims) (jwt.Claims, error) {
+	log.Debug("verifyJWT")
+	claims, err := p.doVerifyJWT(token, jwksURL, skipSSLValidation, connection, expected)
+	if err != nil {
+		return nil, &TokenVerificationError{Err: err}
+	}
+	return claims, nil
+}
+
+func (p *portalProxy) doVerifyJWT(token, jwksURL string, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings, expected jwt.Claims) (jwt.Claims, error) {
+	parsed, err := jws.ParseJWT([]byte(strings.TrimPrefix(token, "bearer ")))
+	if err != nil {
+		return nil, fmt.Errorf("Token was poorly formed: %v", err)
+	}
+	header := parsed.(jws.JWS).Protected()
+	alg, _ := header.Get("alg").(string)
+	kid, _ := header.Get("kid").(string)
+
+	key, err := p.getJWKSKey(jwksURL, kid, skipSSLValidation, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only allow an algorithm that matches the type of the key - never 'none' or HMAC
+	method := jws.GetSigningMethod(alg)
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") && !strings.HasPrefix(alg, "PS") {
+			method = nil
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			method = nil
+		}
+	default:
+		method = nil
+	}
+	if method == nil {
+		return nil, fmt.Errorf("Unsupported signing algorithm '%s'", alg)
+	}
+
+	validator := &jwt.Validator{Expected: expected, EXP: jwtExpiryLeeway, NBF: jwtExpiryLeeway}
+	if err := parsed.Validate(key, method, validator); err != nil {
+		return nil, err
+	}
+	if _, ok := parsed.Claims().Expiration(); !ok {
+		return nil, errors.New("Token has no expiry")
+	}
+
+	return parsed.Claims(), nil
+}
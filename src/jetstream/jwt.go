@@ -6,11 +6,14 @@ import (
 	"errors"
 	"strings"
 
+	"github.com/SermoDigital/jose/jwt"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
 )
 
+// GetUserTokenInfo decodes the claims of a token. The signature is not checked, so this must only be used for tokens that
+// were verified when they were received (see verifyUAAToken) or have been read back from the database
 func (p *portalProxy) GetUserTokenInfo(tok string) (u *interfaces.JWTUserTokenInfo, err error) {
 	log.Debug("getUserTokenInfo")
 	accessToken := strings.TrimPrefix(tok, "bearer ")
@@ -20,7 +23,11 @@ func (p *portalProxy) GetUserTokenInfo(tok string) (u *interfaces.JWTUserTokenIn
 		return u, errors.New("Token was poorly formed.")
 	}
 
-	decoded, err := base64.RawStdEncoding.DecodeString(splits[1])
+	// JWTs are base64url encoded - fall back to standard encoding for tokens that were stored that way
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(splits[1], "="))
+	if err != nil {
+		decoded, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(splits[1], "="))
+	}
 	if err != nil {
 		return u, errors.New("Unable to decode token string.")
 	}
@@ -31,3 +38,37 @@ func (p *portalProxy) GetUserTokenInfo(tok string) (u *interfaces.JWTUserTokenIn
 
 	return u, err
 }
+
+// verifyUAAToken verifies a token that has just been issued by the UAA with the given token endpoint and returns its claims.
+// The signature is checked against the UAA's token keys, and the token must be issued by the UAA for the client and not expired
+func (p *portalProxy) verifyUAAToken(tok, tokenEndpoint, client string, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings) (*interfaces.JWTUserTokenInfo, error) {
+	log.Debug("verifyUAAToken")
+	if p.Config.SkipTokenVerification {
+		return p.GetUserTokenInfo(tok)
+	}
+
+	issuer, jwksURL := p.getUAATokenIssuer(tokenEndpoint, skipSSLValidation, connection)
+	expected := jwt.Claims{}
+	expected.SetIssuer(issuer)
+	if len(client) > 0 {
+		expected.SetAudience(client)
+	}
+
+	if _, err := p.verifyJWT(tok, jwksURL, skipSSLValidation, connection, expected); err != nil {
+		log.Warnf("Rejecting token from %s: %v", tokenEndpoint, err)
+		return nil, err
+	}
+	return p.GetUserTokenInfo(tok)
+}
+
+// getUAATokenIssuer returns the issuer and the key set URL for tokens from the UAA with the given token endpoint. These are
+// taken from the UAA's discovery document, falling back to the UAA defaults if it does not have one
+func (p *portalProxy) getUAATokenIssuer(tokenEndpoint string, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings) (string, string) {
+	uaaURL := strings.TrimSuffix(strings.TrimRight(tokenEndpoint, "/"), "/oauth/token")
+	metadata, err := p.getProviderMetadata(uaaURL, skipSSLValidation, connection)
+	if err == nil && len(metadata.Issuer) > 0 && len(metadata.JWKSURI) > 0 {
+		return metadata.Issuer, metadata.JWKSURI
+	}
+	log.Debugf("Using default issuer and token keys for %s: %v", uaaURL, err)
+	return uaaURL + "/oauth/token", uaaURL + "/token_keys"
+}
@@ -1,6 +1,18 @@
 package main
 
-import "testing"
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
 
 func TestGetUserTokenInfo(t *testing.T) {
 	t.Parallel()
@@ -28,3 +40,72 @@ func TestGetUserTokenInfoBadToken(t *testing.T) {
 		t.Error("Should not get user token info from invalid token")
 	}
 }
+
+func TestVerifyUAAToken(t *testing.T) {
+	t.Parallel()
+
+	Convey("UAA token verification tests", t, func() {
+		// A UAA without a discovery document, serving its key in PEM format
+		provider := newMockOIDCProvider()
+		defer provider.server.Close()
+		publicKey, _ := x509.MarshalPKIXPublicKey(&provider.key.PublicKey)
+		uaa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/token_keys" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(&JSONWebKeySet{Keys: []JSONWebKey{{
+				Kty:   "RSA",
+				Kid:   provider.kid,
+				Value: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+			}}})
+		}))
+		defer uaa.Close()
+		tokenEndpoint := uaa.URL + "/oauth/token"
+
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+		pp.Config.SkipTokenVerification = false
+
+		claims := func() map[string]interface{} {
+			return map[string]interface{}{
+				"iss":       tokenEndpoint,
+				"aud":       []interface{}{"console", "openid"},
+				"user_id":   mockUserGUID,
+				"user_name": "admin",
+				"scope":     []interface{}{"openid", UAAAdminIdentifier},
+				"exp":       time.Now().Add(time.Hour).Unix(),
+			}
+		}
+
+		Convey("should verify a token from the UAA", func() {
+			u, err := pp.verifyUAAToken(provider.sign(claims(), provider.key, provider.kid), tokenEndpoint, "console", false, interfaces.EndpointConnectionSettings{})
+			So(err, ShouldBeNil)
+			So(u.UserGUID, ShouldEqual, mockUserGUID)
+			So(u.Scope, ShouldContain, UAAAdminIdentifier)
+		})
+
+		Convey("should reject a token from another issuer", func() {
+			c := claims()
+			c["iss"] = "https://uaa.example.com/oauth/token"
+			_, err := pp.verifyUAAToken(provider.sign(c, provider.key, provider.kid), tokenEndpoint, "console", false, interfaces.EndpointConnectionSettings{})
+			So(err, ShouldHaveSameTypeAs, &TokenVerificationError{})
+		})
+
+		Convey("should reject a token for another client", func() {
+			_, err := pp.verifyUAAToken(provider.sign(claims(), provider.key, provider.kid), tokenEndpoint, "cf", false, interfaces.EndpointConnectionSettings{})
+			So(err, ShouldHaveSameTypeAs, &TokenVerificationError{})
+		})
+
+		Convey("should reject an unsigned token", func() {
+			_, err := pp.verifyUAAToken(mockUAAToken, tokenEndpoint, "console", false, interfaces.EndpointConnectionSettings{})
+			So(err, ShouldHaveSameTypeAs, &TokenVerificationError{})
+		})
+
+		Convey("should reject a token signed with an unknown key", func() {
+			_, err := pp.verifyUAAToken(provider.sign(claims(), provider.key, "key-2"), tokenEndpoint, "console", false, interfaces.EndpointConnectionSettings{})
+			So(err, ShouldHaveSameTypeAs, &TokenVerificationError{})
+		})
+	})
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	ldap "gopkg.in/ldap.v3"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/localusers"
+)
+
+const (
+	defaultLDAPUserFilter        = "(uid=%s)"
+	defaultLDAPUsernameAttribute = "uid"
+	defaultLDAPEmailAttribute    = "mail"
+	defaultLDAPGroupAttribute    = "memberOf"
+	defaultLDAPGroupFilter       = "(member=%s)"
+	ldapTimeout                  = 30 * time.Second
+)
+
+// ldapConnection is the subset of an LDAP connection used to authenticate users
+type ldapConnection interface {
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+// ldapDial connects to the directory - replaced in tests
+var ldapDial = dialLDAP
+
+// ldapUser is a user that has been authenticated by the directory
+type ldapUser struct {
+	DN         string
+	Username   string
+	Email      string
+	GivenName  string
+	FamilyName string
+	Groups     []string
+	Admin      bool
+}
+
+func (p *portalProxy) isLDAPLogin() bool {
+	return interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] == interfaces.LDAP
+}
+
+// initialiseLDAPConfiguration checks the directory config and fills in the defaults
+func initialiseLDAPConfiguration(consoleConfig *interfaces.ConsoleConfig) error {
+	if len(consoleConfig.LDAPURL) == 0 {
+		return errors.New("LDAP_URL not found")
+	}
+	ldapURL, err := url.Parse(consoleConfig.LDAPURL)
+	if err != nil || len(ldapURL.Host) == 0 || (ldapURL.Scheme != "ldap" && ldapURL.Scheme != "ldaps") {
+		return fmt.Errorf("LDAP_URL must be an ldap:// or ldaps:// URL: %s", consoleConfig.LDAPURL)
+	}
+	if ldapURL.Scheme == "ldaps" && consoleConfig.LDAPStartTLS {
+		return errors.New("LDAP_START_TLS can not be used with an ldaps:// URL")
+	}
+	if len(consoleConfig.LDAPUserBaseDN) == 0 && len(consoleConfig.LDAPUserDNTemplate) == 0 {
+		return errors.New("One of LDAP_USER_BASE_DN or LDAP_USER_DN_TEMPLATE must be set")
+	}
+	if len(consoleConfig.LDAPUserDNTemplate) > 0 && strings.Count(consoleConfig.LDAPUserDNTemplate, "%s") != 1 {
+		return errors.New("LDAP_USER_DN_TEMPLATE must contain a single %s for the username")
+	}
+
+	if len(consoleConfig.LDAPUserFilter) == 0 {
+		consoleConfig.LDAPUserFilter = defaultLDAPUserFilter
+	}
+	if len(consoleConfig.LDAPUsernameAttribute) == 0 {
+		consoleConfig.LDAPUsernameAttribute = defaultLDAPUsernameAttribute
+	}
+	if len(consoleConfig.LDAPEmailAttribute) == 0 {
+		consoleConfig.LDAPEmailAttribute = defaultLDAPEmailAttribute
+	}
+	if len(consoleConfig.LDAPGroupAttribute) == 0 {
+		consoleConfig.LDAPGroupAttribute = defaultLDAPGroupAttribute
+	}
+	if len(consoleConfig.LDAPGroupFilter) == 0 {
+		consoleConfig.LDAPGroupFilter = defaultLDAPGroupFilter
+	}
+	if len(consoleConfig.ConsoleAdminScope) == 0 {
+		consoleConfig.ConsoleAdminScope = UAAAdminIdentifier
+	}
+	if len(consoleConfig.LDAPAdminGroup) == 0 {
+		log.Warn("LDAP_ADMIN_GROUP is not set - no directory user will be a console admin")
+	}
+
+	return nil
+}
+
+// dialLDAP connects to the directory, upgrading the connection with StartTLS if configured
+func dialLDAP(consoleConfig *interfaces.ConsoleConfig) (ldapConnection, error) {
+	ldapURL, err := url.Parse(consoleConfig.LDAPURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := ldapURL.Hostname()
+	port := ldapURL.Port()
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: consoleConfig.SkipSSLValidation}
+
+	var conn *ldap.Conn
+	if ldapURL.Scheme == "ldaps" {
+		if len(port) == 0 {
+			port = "636"
+		}
+		conn, err = ldap.DialTLS("tcp", net.JoinHostPort(host, port), tlsConfig)
+	} else {
+		if len(port) == 0 {
+			port = "389"
+		}
+		conn, err = ldap.Dial("tcp", net.JoinHostPort(host, port))
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if consoleConfig.LDAPStartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS failed: %v", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// authenticateLDAP checks the user's credentials against the directory and looks up their details and group membership.
+// The user is either bound directly using the DN template, or found with a search (as the bind DN, if set) and then bound
+func authenticateLDAP(consoleConfig *interfaces.ConsoleConfig, username, password string) (*ldapUser, error) {
+	log.Debug("authenticateLDAP")
+
+	// An empty password would be an unauthenticated bind, which most directories allow
+	if len(username) == 0 || len(password) == 0 {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Needs username and password",
+			"Needs username and password")
+	}
+
+	conn, err := ldapDial(consoleConfig)
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusServiceUnavailable,
+			"Could not connect to the LDAP server",
+			"Could not connect to the LDAP server %s: %v", consoleConfig.LDAPURL, err)
+	}
+	defer conn.Close()
+
+	attributes := []string{
+		consoleConfig.LDAPUsernameAttribute,
+		consoleConfig.LDAPEmailAttribute,
+		consoleConfig.LDAPGroupAttribute,
+		"givenName",
+		"sn",
+	}
+
+	var entry *ldap.Entry
+	if len(consoleConfig.LDAPUserDNTemplate) > 0 {
+		userDN := fmt.Sprintf(consoleConfig.LDAPUserDNTemplate, escapeLDAPDN(username))
+		if err := conn.Bind(userDN, password); err != nil {
+			return nil, ldapBindError(username, err)
+		}
+		entry, err = searchLDAPEntry(conn, userDN, ldap.ScopeBaseObject, "(objectClass=*)", attributes)
+	} else {
+		if err := bindLDAPServiceAccount(conn, consoleConfig); err != nil {
+			return nil, err
+		}
+		filter := fmt.Sprintf(consoleConfig.LDAPUserFilter, ldap.EscapeFilter(username))
+		entry, err = searchLDAPEntry(conn, consoleConfig.LDAPUserBaseDN, ldap.ScopeWholeSubtree, filter, attributes)
+		if err == nil {
+			err = conn.Bind(entry.DN, password)
+			if err != nil {
+				return nil, ldapBindError(username, err)
+			}
+		} else if _, ok := err.(ldapNotFoundError); ok {
+			// Don't reveal whether the user exists
+			return nil, ldapBindError(username, err)
+		}
+	}
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Access Denied - Could not find user in the directory",
+			"LDAP Login failed for %s: %v", username, err)
+	}
+
+	user := &ldapUser{
+		DN:         entry.DN,
+		Username:   entry.GetAttributeValue(consoleConfig.LDAPUsernameAttribute),
+		Email:      entry.GetAttributeValue(consoleConfig.LDAPEmailAttribute),
+		GivenName:  entry.GetAttributeValue("givenName"),
+		FamilyName: entry.GetAttributeValue("sn"),
+		Groups:     entry.GetAttributeValues(consoleConfig.LDAPGroupAttribute),
+	}
+	if len(user.Username) == 0 {
+		user.Username = username
+	}
+
+	// Directories without a memberOf attribute need a search for the groups that the user is a member of
+	if len(consoleConfig.LDAPGroupBaseDN) > 0 {
+		if err := bindLDAPServiceAccount(conn, consoleConfig); err != nil {
+			return nil, err
+		}
+		groups, err := conn.Search(ldap.NewSearchRequest(consoleConfig.LDAPGroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(ldapTimeout.Seconds()), false,
+			fmt.Sprintf(consoleConfig.LDAPGroupFilter, ldap.EscapeFilter(user.DN)), []string{"dn"}, nil))
+		if err != nil {
+			return nil, interfaces.NewHTTPShadowError(
+				http.StatusUnauthorized,
+				"Access Denied - Could not find the user's groups in the directory",
+				"LDAP group search failed for %s: %v", username, err)
+		}
+		for _, group := range groups.Entries {
+			user.Groups = append(user.Groups, group.DN)
+		}
+	}
+
+	user.Admin = isLDAPGroupMember(user.Groups, consoleConfig.LDAPAdminGroup)
+	return user, nil
+}
+
+// bindLDAPServiceAccount binds as the configured bind DN. With no bind DN, searches are made anonymously
+func bindLDAPServiceAccount(conn ldapConnection, consoleConfig *interfaces.ConsoleConfig) error {
+	if len(consoleConfig.LDAPBindDN) == 0 {
+		return nil
+	}
+	if err := conn.Bind(consoleConfig.LDAPBindDN, consoleConfig.LDAPBindPassword); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusServiceUnavailable,
+			"Could not bind to the LDAP server",
+			"Could not bind to the LDAP server as %s: %v", consoleConfig.LDAPBindDN, err)
+	}
+	return nil
+}
+
+type ldapNotFoundError struct {
+	count int
+}
+
+func (e ldapNotFoundError) Error() string {
+	return fmt.Sprintf("Expected a single directory entry, found %d", e.count)
+}
+
+// searchLDAPEntry searches for a single entry
+func searchLDAPEntry(conn ldapConnection, baseDN string, scope int, filter string, attributes []string) (*ldap.Entry, error) {
+	res, err := conn.Search(ldap.NewSearchRequest(baseDN, scope, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false, filter, attributes, nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Entries) != 1 {
+		return nil, ldapNotFoundError{count: len(res.Entries)}
+	}
+	return res.Entries[0], nil
+}
+
+func ldapBindError(username string, err error) error {
+	return interfaces.NewHTTPShadowError(
+		http.StatusUnauthorized,
+		"Access Denied - Invalid username/password credentials",
+		"LDAP Login failed for %s: %v", username, err)
+}
+
+// isLDAPGroupMember checks if the admin group is in the list of groups. The group can be given as its DN or its name (the
+// value of the first part of its DN)
+func isLDAPGroupMember(groups []string, group string) bool {
+	if len(group) == 0 {
+		return false
+	}
+	for _, dn := range groups {
+		if strings.EqualFold(dn, group) {
+			return true
+		}
+		if parsed, err := ldap.ParseDN(dn); err == nil && len(parsed.RDNs) > 0 && len(parsed.RDNs[0].Attributes) > 0 {
+			if strings.EqualFold(parsed.RDNs[0].Attributes[0].Value, group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// escapeLDAPDN escapes a value for use in a DN (RFC 4514)
+func escapeLDAPDN(value string) string {
+	var escaped strings.Builder
+	for i, c := range value {
+		switch {
+		case strings.ContainsRune(",+\"\\<>;=", c):
+			escaped.WriteRune('\\')
+			escaped.WriteRune(c)
+		case c == 0:
+			escaped.WriteString("\\00")
+		case (c == ' ' || c == '#') && i == 0:
+			escaped.WriteRune('\\')
+			escaped.WriteRune(c)
+		case c == ' ' && i == len(value)-1:
+			escaped.WriteString("\\ ")
+		default:
+			escaped.WriteRune(c)
+		}
+	}
+	return escaped.String()
+}
+
+// provisionLDAPUser creates or updates the local user record for a directory user, returning the user's GUID
+func (p *portalProxy) provisionLDAPUser(user *ldapUser) (string, error) {
+	log.Debug("provisionLDAPUser")
+	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf("Database error getting repo for Local users: %v", err)
+		return "", err
+	}
+
+	scope := defaultUserScope
+	if user.Admin {
+		scope = p.Config.ConsoleConfig.ConsoleAdminScope
+	}
+
+	record := interfaces.LocalUser{
+		Username:   user.Username,
+		Email:      user.Email,
+		Scope:      scope,
+		GivenName:  user.GivenName,
+		FamilyName: user.FamilyName,
+	}
+
+	guid, err := localUsersRepo.FindUserGUID(user.Username)
+	if err != nil {
+		// First login - the password is checked by the directory, so store a random one that can't be used
+		secret, err := generateRandomBytes(32)
+		if err != nil {
+			return "", err
+		}
+		if record.PasswordHash, err = HashPassword(string(secret)); err != nil {
+			return "", err
+		}
+		record.UserGUID = uuid.NewV4().String()
+		if err = localUsersRepo.AddLocalUser(record); err != nil {
+			log.Errorf("Unable to add directory user %s: %v", user.Username, err)
+			return "", err
+		}
+		log.Infof("Added directory user %s", user.Username)
+	} else {
+		// Keep the user's details and admin scope in step with the directory
+		record.UserGUID = guid
+		if record.PasswordHash, err = localUsersRepo.FindPasswordHash(guid); err != nil {
+			return "", err
+		}
+		if err = localUsersRepo.UpdateLocalUser(record); err != nil {
+			log.Errorf("Unable to update directory user %s: %v", user.Username, err)
+			return "", err
+		}
+	}
+
+	if err := localUsersRepo.UpdateLastLoginTime(record.UserGUID, time.Now()); err != nil {
+		log.Errorf("Failed to update last login time for user: %s", record.UserGUID)
+	}
+
+	return record.UserGUID, nil
+}
+
+func (p *portalProxy) ldapLogin(c echo.Context) error {
+	log.Debug("ldapLogin")
+
+	user, err := authenticateLDAP(p.Config.ConsoleConfig, c.FormValue("username"), c.FormValue("password"))
+	if err != nil {
+		return err
+	}
+
+	userGUID, err := p.provisionLDAPUser(user)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to store user",
+			"Unable to store directory user %s: %v", user.Username, err)
+	}
+
+	// As with local users, the session lasts until logout
+	var expiry int64
+	expiry = math.MaxInt64
+
+	sessionValues := make(map[string]interface{})
+	sessionValues["user_id"] = userGUID
+	sessionValues["exp"] = expiry
+
+	// Ensure that login disregards cookies from the request
+	req := c.Request()
+	req.Header.Set("Cookie", "")
+	if err = p.setSessionValues(c, sessionValues); err != nil {
+		return err
+	}
+
+	if err = p.handleSessionExpiryHeader(c); err != nil {
+		return err
+	}
+
+	err = p.ExecuteLoginHooks(c)
+	if err != nil {
+		log.Warnf("Login hooks failed: %v", err)
+	}
+
+	resp := &interfaces.LoginRes{
+		Account:     user.Username,
+		TokenExpiry: expiry,
+		APIEndpoint: nil,
+		Admin:       user.Admin,
+	}
+
+	jsonString, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	// Add XSRF Token
+	p.ensureXSRFToken(c)
+	c.Response().Header().Set("Content-Type", "application/json")
+	c.Response().Write(jsonString)
+	return nil
+}
+
+// getLDAPUser gets the provisioned record for a directory user. Unlike local users, they can't change their password
+func (p *portalProxy) getLDAPUser(userGUID string) (*interfaces.ConnectedUser, error) {
+	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf("Database error getting repo for Local users: %v", err)
+		return nil, err
+	}
+
+	user, err := localUsersRepo.FindUser(userGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &interfaces.ConnectedUser{
+		GUID:   userGUID,
+		Name:   user.Username,
+		Email:  user.Email,
+		Admin:  user.Scope == p.Config.ConsoleConfig.ConsoleAdminScope,
+		Scopes: []string{user.Scope},
+	}, nil
+}
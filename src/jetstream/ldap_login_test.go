@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	ldap "gopkg.in/ldap.v3"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	mockLDAPUserDN    = "uid=jdoe,ou=people,dc=example,dc=com"
+	mockLDAPServiceDN = "cn=stratos,ou=services,dc=example,dc=com"
+	mockLDAPAdminDN   = "cn=stratos-admins,ou=groups,dc=example,dc=com"
+)
+
+// fakeLDAPDirectory is a directory with a single user, which answers searches by their filter (or base DN for base object
+// searches)
+type fakeLDAPDirectory struct {
+	passwords map[string]string
+	results   map[string][]*ldap.Entry
+	boundDN   string
+}
+
+func (d *fakeLDAPDirectory) Bind(username, password string) error {
+	if expected, ok := d.passwords[username]; !ok || expected != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("Invalid Credentials"))
+	}
+	d.boundDN = username
+	return nil
+}
+
+func (d *fakeLDAPDirectory) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	key := searchRequest.Filter
+	if searchRequest.Scope == ldap.ScopeBaseObject {
+		key = searchRequest.BaseDN
+	}
+	return &ldap.SearchResult{Entries: d.results[key]}, nil
+}
+
+func (d *fakeLDAPDirectory) Close() {}
+
+func newFakeLDAPDirectory() *fakeLDAPDirectory {
+	user := ldap.NewEntry(mockLDAPUserDN, map[string][]string{
+		"uid":       {"jdoe"},
+		"mail":      {"jdoe@example.com"},
+		"givenName": {"Jane"},
+		"sn":        {"Doe"},
+		"memberOf":  {"cn=developers,ou=groups,dc=example,dc=com", mockLDAPAdminDN},
+	})
+	return &fakeLDAPDirectory{
+		passwords: map[string]string{
+			mockLDAPUserDN:    "changeme",
+			mockLDAPServiceDN: "secret",
+		},
+		results: map[string][]*ldap.Entry{
+			"(uid=jdoe)":   {user},
+			mockLDAPUserDN: {user},
+		},
+	}
+}
+
+func mockLDAPConsoleConfig() *interfaces.ConsoleConfig {
+	consoleConfig := &interfaces.ConsoleConfig{
+		AuthEndpointType: string(interfaces.LDAP),
+		LDAPURL:          "ldap://ldap.example.com",
+		LDAPBindDN:       mockLDAPServiceDN,
+		LDAPBindPassword: "secret",
+		LDAPUserBaseDN:   "ou=people,dc=example,dc=com",
+		LDAPAdminGroup:   mockLDAPAdminDN,
+	}
+	initialiseLDAPConfiguration(consoleConfig)
+	return consoleConfig
+}
+
+func TestLDAPConfiguration(t *testing.T) {
+	t.Parallel()
+
+	Convey("LDAP configuration tests", t, func() {
+		Convey("should fill in the defaults", func() {
+			consoleConfig := mockLDAPConsoleConfig()
+			So(consoleConfig.LDAPUserFilter, ShouldEqual, defaultLDAPUserFilter)
+			So(consoleConfig.LDAPGroupAttribute, ShouldEqual, defaultLDAPGroupAttribute)
+			So(consoleConfig.ConsoleAdminScope, ShouldEqual, UAAAdminIdentifier)
+			So(consoleConfig.IsSetupComplete(), ShouldBeTrue)
+		})
+
+		Convey("should reject invalid config", func() {
+			So(initialiseLDAPConfiguration(&interfaces.ConsoleConfig{}), ShouldNotBeNil)
+			So(initialiseLDAPConfiguration(&interfaces.ConsoleConfig{LDAPURL: "http://ldap.example.com", LDAPUserBaseDN: "dc=example"}), ShouldNotBeNil)
+			So(initialiseLDAPConfiguration(&interfaces.ConsoleConfig{LDAPURL: "ldap://ldap.example.com"}), ShouldNotBeNil)
+			So(initialiseLDAPConfiguration(&interfaces.ConsoleConfig{LDAPURL: "ldaps://ldap.example.com", LDAPStartTLS: true, LDAPUserBaseDN: "dc=example"}), ShouldNotBeNil)
+			So(initialiseLDAPConfiguration(&interfaces.ConsoleConfig{LDAPURL: "ldap://ldap.example.com", LDAPUserDNTemplate: "uid=jdoe,dc=example"}), ShouldNotBeNil)
+		})
+
+		Convey("should escape usernames in DNs", func() {
+			So(escapeLDAPDN("jdoe"), ShouldEqual, "jdoe")
+			So(escapeLDAPDN("doe,jane+admin"), ShouldEqual, "doe\\,jane\\+admin")
+			So(escapeLDAPDN("#jdoe "), ShouldEqual, "\\#jdoe\\ ")
+		})
+
+		Convey("should match the admin group by DN or name", func() {
+			groups := []string{"cn=developers,ou=groups,dc=example,dc=com", "CN=Stratos-Admins,OU=Groups,DC=example,DC=com"}
+			So(isLDAPGroupMember(groups, mockLDAPAdminDN), ShouldBeTrue)
+			So(isLDAPGroupMember(groups, "stratos-admins"), ShouldBeTrue)
+			So(isLDAPGroupMember(groups, "operators"), ShouldBeFalse)
+			So(isLDAPGroupMember(groups, ""), ShouldBeFalse)
+		})
+	})
+}
+
+func TestLDAPAuthentication(t *testing.T) {
+	var directory *fakeLDAPDirectory
+	ldapDial = func(consoleConfig *interfaces.ConsoleConfig) (ldapConnection, error) {
+		return directory, nil
+	}
+	defer func() { ldapDial = dialLDAP }()
+
+	Convey("LDAP authentication tests", t, func() {
+		directory = newFakeLDAPDirectory()
+		consoleConfig := mockLDAPConsoleConfig()
+
+		Convey("should search for the user and bind with their password", func() {
+			user, err := authenticateLDAP(consoleConfig, "jdoe", "changeme")
+			So(err, ShouldBeNil)
+			So(user.DN, ShouldEqual, mockLDAPUserDN)
+			So(user.Username, ShouldEqual, "jdoe")
+			So(user.Email, ShouldEqual, "jdoe@example.com")
+			So(user.Admin, ShouldBeTrue)
+			So(directory.boundDN, ShouldEqual, mockLDAPUserDN)
+		})
+
+		Convey("should bind directly with the DN template", func() {
+			consoleConfig.LDAPUserBaseDN = ""
+			consoleConfig.LDAPUserDNTemplate = "uid=%s,ou=people,dc=example,dc=com"
+			user, err := authenticateLDAP(consoleConfig, "jdoe", "changeme")
+			So(err, ShouldBeNil)
+			So(user.DN, ShouldEqual, mockLDAPUserDN)
+		})
+
+		Convey("should search for the user's groups", func() {
+			consoleConfig.LDAPGroupBaseDN = "ou=groups,dc=example,dc=com"
+			consoleConfig.LDAPAdminGroup = "operators"
+			directory.results["(member="+mockLDAPUserDN+")"] = []*ldap.Entry{ldap.NewEntry("cn=operators,ou=groups,dc=example,dc=com", nil)}
+			user, err := authenticateLDAP(consoleConfig, "jdoe", "changeme")
+			So(err, ShouldBeNil)
+			So(user.Admin, ShouldBeTrue)
+			So(user.Groups, ShouldContain, "cn=operators,ou=groups,dc=example,dc=com")
+		})
+
+		Convey("should not be admin without the admin group", func() {
+			consoleConfig.LDAPAdminGroup = "operators"
+			user, err := authenticateLDAP(consoleConfig, "jdoe", "changeme")
+			So(err, ShouldBeNil)
+			So(user.Admin, ShouldBeFalse)
+		})
+
+		Convey("should reject a wrong password", func() {
+			_, err := authenticateLDAP(consoleConfig, "jdoe", "wrong")
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("should reject an unknown user", func() {
+			_, err := authenticateLDAP(consoleConfig, "nobody", "changeme")
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("should not allow an empty password", func() {
+			directory.passwords[mockLDAPUserDN] = ""
+			_, err := authenticateLDAP(consoleConfig, "jdoe", "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should fail if the service account can't bind", func() {
+			consoleConfig.LDAPBindPassword = "wrong"
+			_, err := authenticateLDAP(consoleConfig, "jdoe", "changeme")
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusServiceUnavailable)
+		})
+
+		Convey("should provision the user on first login", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"username": "jdoe",
+				"password": "changeme",
+			})
+			res, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+			pp.Config.ConsoleConfig = consoleConfig
+
+			mock.ExpectQuery(findUserGUID).WillReturnRows(sqlmock.NewRows([]string{"user_guid"}))
+			mock.ExpectExec(addLocalUser).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "jdoe", "jdoe@example.com", UAAAdminIdentifier, "Jane", "Doe").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(updateLastLoginTime).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			err := pp.stratosLoginHandler(ctx)
+			So(err, ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusOK)
+			So(res.Body.String(), ShouldContainSubstring, `"admin":true`)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should update an existing user", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"username": "jdoe",
+				"password": "changeme",
+			})
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+			consoleConfig.LDAPAdminGroup = "operators"
+			pp.Config.ConsoleConfig = consoleConfig
+
+			mock.ExpectQuery(findUserGUID).WillReturnRows(sqlmock.NewRows([]string{"user_guid"}).AddRow(mockUserGUID))
+			mock.ExpectQuery(findPasswordHash).WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow([]byte("hash")))
+			mock.ExpectExec(`UPDATE local_users SET password_hash(.+)`).
+				WithArgs([]byte("hash"), "jdoe", "jdoe@example.com", defaultUserScope, "Jane", "Doe", mockUserGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(updateLastLoginTime).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			err := pp.stratosLoginHandler(ctx)
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -1,7 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/labstack/echo"
 	uuid "github.com/satori/go.uuid"
@@ -44,6 +51,9 @@ func (p *portalProxy) AddLocalUser(c echo.Context) (string, error) {
 	if len(username) == 0 || len(password) == 0 || len(scope) == 0 {
 		return "", errors.New("Needs username, password and scope")
 	}
+	if err := p.CheckPasswordPolicy(username, password); err != nil {
+		return "", err
+	}
 	
 	//Generate a user GUID and hash the password
 	userGUID := uuid.NewV4().String()
@@ -65,4 +75,363 @@ func (p *portalProxy) AddLocalUser(c echo.Context) (string, error) {
 		}		
 	}
 	return userGUID, nil
-}
\ No newline at end of file
+}
+// Scope given to local users that are not admins
+const defaultUserScope = "stratos.user"
+
+const defaultPasswordMinLength = 8
+
+// LocalUserInfo - A local user, as returned by the local user admin API
+type LocalUserInfo struct {
+	GUID       string     `json:"guid"`
+	Username   string     `json:"username"`
+	Email      string     `json:"email"`
+	Scope      string     `json:"scope"`
+	GivenName  string     `json:"given_name"`
+	FamilyName string     `json:"family_name"`
+	Admin      bool       `json:"admin"`
+	Disabled   bool       `json:"disabled"`
+	LastLogin  *time.Time `json:"last_login,omitempty"`
+}
+
+// LocalUserRequest - Request body for creating or updating a local user. Fields that are not set are left unchanged on update
+type LocalUserRequest struct {
+	Username   string  `json:"username"`
+	Password   string  `json:"password"`
+	Email      *string `json:"email"`
+	Scope      *string `json:"scope"`
+	GivenName  *string `json:"given_name"`
+	FamilyName *string `json:"family_name"`
+	Disabled   *bool   `json:"disabled"`
+}
+
+// CheckPasswordPolicy checks that a new password for a local user is long enough, doesn't contain the username and, if
+// configured, has upper and lower case letters and a number
+func (p *portalProxy) CheckPasswordPolicy(username, password string) error {
+	minLength := p.Config.LocalUserPasswordMinLength
+	if minLength <= 0 {
+		minLength = defaultPasswordMinLength
+	}
+	if utf8.RuneCountInString(password) < minLength {
+		return fmt.Errorf("Password must be at least %d characters", minLength)
+	}
+	if len(username) > 0 && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("Password must not contain the username")
+	}
+
+	if p.Config.LocalUserPasswordComplexity {
+		var upper, lower, digit bool
+		for _, c := range password {
+			switch {
+			case unicode.IsUpper(c):
+				upper = true
+			case unicode.IsLower(c):
+				lower = true
+			case unicode.IsDigit(c):
+				digit = true
+			}
+		}
+		if !upper || !lower || !digit {
+			return errors.New("Password must contain upper and lower case letters and a number")
+		}
+	}
+
+	return nil
+}
+
+// isValidLocalUserScope checks that a local user's scope is one that can log in - the admin scope or the user scope
+func (p *portalProxy) isValidLocalUserScope(scope string) bool {
+	if len(scope) == 0 {
+		return false
+	}
+	return scope == p.Config.ConsoleConfig.ConsoleAdminScope || scope == p.Config.ConsoleConfig.LocalUserScope || scope == defaultUserScope
+}
+
+func (p *portalProxy) toLocalUserInfo(user interfaces.LocalUser) *LocalUserInfo {
+	return &LocalUserInfo{
+		GUID:       user.UserGUID,
+		Username:   user.Username,
+		Email:      user.Email,
+		Scope:      user.Scope,
+		GivenName:  user.GivenName,
+		FamilyName: user.FamilyName,
+		Admin:      user.Scope == p.Config.ConsoleConfig.ConsoleAdminScope,
+		Disabled:   user.Disabled,
+		LastLogin:  user.LastLogin,
+	}
+}
+
+// getLocalUsersRepo returns the local users repository, if local users are enabled
+func (p *portalProxy) getLocalUsersRepo() (localusers.Repository, error) {
+	if interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] != interfaces.Local {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Local users are not enabled",
+			"Local users are not enabled")
+	}
+
+	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to access local users",
+			"Database error getting repo for local users: %v", err)
+	}
+	return localUsersRepo, nil
+}
+
+// checkNotSelf stops an admin from locking themselves out by disabling, deleting or demoting their own account
+func (p *portalProxy) checkNotSelf(c echo.Context, userGUID string, action string) error {
+	sessionUser, err := p.GetSessionStringValue(c, "user_id")
+	if err == nil && sessionUser == userGUID {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			fmt.Sprintf("You can not %s your own account", action),
+			"User %s tried to %s their own account", userGUID, action)
+	}
+	return nil
+}
+
+func (p *portalProxy) findLocalUser(localUsersRepo localusers.Repository, userGUID string) (interfaces.LocalUser, error) {
+	user, err := localUsersRepo.FindUser(userGUID)
+	if err != nil {
+		return user, interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"User not found",
+			"Unable to find local user %s: %v", userGUID, err)
+	}
+	user.UserGUID = userGUID
+	return user, nil
+}
+
+func (p *portalProxy) listLocalUsers(c echo.Context) error {
+	log.Debug("listLocalUsers")
+	localUsersRepo, err := p.getLocalUsersRepo()
+	if err != nil {
+		return err
+	}
+
+	users, err := localUsersRepo.ListLocalUsers()
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list local users",
+			"Unable to list local users: %v", err)
+	}
+
+	infos := make([]*LocalUserInfo, len(users))
+	for i, user := range users {
+		infos[i] = p.toLocalUserInfo(user)
+	}
+	return c.JSON(http.StatusOK, infos)
+}
+
+func (p *portalProxy) getLocalUserInfo(c echo.Context) error {
+	log.Debug("getLocalUserInfo")
+	localUsersRepo, err := p.getLocalUsersRepo()
+	if err != nil {
+		return err
+	}
+
+	user, err := p.findLocalUser(localUsersRepo, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, p.toLocalUserInfo(user))
+}
+
+func (p *portalProxy) createLocalUser(c echo.Context) error {
+	log.Debug("createLocalUser")
+	localUsersRepo, err := p.getLocalUsersRepo()
+	if err != nil {
+		return err
+	}
+
+	req := &LocalUserRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(req); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid user",
+			"Invalid user: %v", err)
+	}
+	setAuditTarget(c, req.Username)
+	if len(req.Username) == 0 || len(req.Password) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Needs username and password")
+	}
+	if err := p.CheckPasswordPolicy(req.Username, req.Password); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	user := interfaces.LocalUser{
+		UserGUID: uuid.NewV4().String(),
+		Username: req.Username,
+		Scope:    defaultUserScope,
+	}
+	if req.Scope != nil {
+		user.Scope = *req.Scope
+	}
+	if !p.isValidLocalUserScope(user.Scope) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid scope '%s'", user.Scope))
+	}
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	if req.GivenName != nil {
+		user.GivenName = *req.GivenName
+	}
+	if req.FamilyName != nil {
+		user.FamilyName = *req.FamilyName
+	}
+
+	if _, err := localUsersRepo.FindUserGUID(user.Username); err == nil {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("User '%s' already exists", user.Username))
+	}
+
+	if user.PasswordHash, err = HashPassword(req.Password); err != nil {
+		return err
+	}
+	if err := localUsersRepo.AddLocalUser(user); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to add user",
+			"Unable to add local user %s: %v", user.Username, err)
+	}
+	if req.Disabled != nil && *req.Disabled {
+		if err := localUsersRepo.SetLocalUserDisabled(user.UserGUID, true); err != nil {
+			return err
+		}
+		user.Disabled = true
+	}
+
+	log.Infof("Added local user %s", user.Username)
+	return c.JSON(http.StatusCreated, p.toLocalUserInfo(user))
+}
+
+func (p *portalProxy) updateLocalUser(c echo.Context) error {
+	log.Debug("updateLocalUser")
+	localUsersRepo, err := p.getLocalUsersRepo()
+	if err != nil {
+		return err
+	}
+
+	userGUID := c.Param("id")
+	user, err := p.findLocalUser(localUsersRepo, userGUID)
+	if err != nil {
+		return err
+	}
+
+	req := &LocalUserRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(req); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid user",
+			"Invalid user: %v", err)
+	}
+
+	if req.Scope != nil && *req.Scope != user.Scope {
+		if !p.isValidLocalUserScope(*req.Scope) {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid scope '%s'", *req.Scope))
+		}
+		if err := p.checkNotSelf(c, userGUID, "change the scope of"); err != nil {
+			return err
+		}
+		user.Scope = *req.Scope
+	}
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	if req.GivenName != nil {
+		user.GivenName = *req.GivenName
+	}
+	if req.FamilyName != nil {
+		user.FamilyName = *req.FamilyName
+	}
+
+	if user.PasswordHash, err = localUsersRepo.FindPasswordHash(userGUID); err != nil {
+		return err
+	}
+	if err := localUsersRepo.UpdateLocalUser(user); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to update user",
+			"Unable to update local user %s: %v", userGUID, err)
+	}
+
+	if req.Disabled != nil && *req.Disabled != user.Disabled {
+		if *req.Disabled {
+			if err := p.checkNotSelf(c, userGUID, "disable"); err != nil {
+				return err
+			}
+		}
+		if err := localUsersRepo.SetLocalUserDisabled(userGUID, *req.Disabled); err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusInternalServerError,
+				"Unable to update user",
+				"Unable to disable local user %s: %v", userGUID, err)
+		}
+		user.Disabled = *req.Disabled
+	}
+
+	return c.JSON(http.StatusOK, p.toLocalUserInfo(user))
+}
+
+func (p *portalProxy) resetLocalUserPassword(c echo.Context) error {
+	log.Debug("resetLocalUserPassword")
+	localUsersRepo, err := p.getLocalUsersRepo()
+	if err != nil {
+		return err
+	}
+
+	userGUID := c.Param("id")
+	user, err := p.findLocalUser(localUsersRepo, userGUID)
+	if err != nil {
+		return err
+	}
+
+	req := &LocalUserRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(req); err != nil || len(req.Password) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Needs password")
+	}
+	if err := p.CheckPasswordPolicy(user.Username, req.Password); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if user.PasswordHash, err = HashPassword(req.Password); err != nil {
+		return err
+	}
+	if err := localUsersRepo.UpdateLocalUser(user); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to reset password",
+			"Unable to reset password for local user %s: %v", userGUID, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (p *portalProxy) deleteLocalUser(c echo.Context) error {
+	log.Debug("deleteLocalUser")
+	localUsersRepo, err := p.getLocalUsersRepo()
+	if err != nil {
+		return err
+	}
+
+	userGUID := c.Param("id")
+	if err := p.checkNotSelf(c, userGUID, "delete"); err != nil {
+		return err
+	}
+	user, err := p.findLocalUser(localUsersRepo, userGUID)
+	if err != nil {
+		return err
+	}
+
+	if err := localUsersRepo.DeleteLocalUser(userGUID); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to delete user",
+			"Unable to delete local user %s: %v", userGUID, err)
+	}
+
+	log.Infof("Deleted local user %s", user.Username)
+	return c.NoContent(http.StatusNoContent)
+}
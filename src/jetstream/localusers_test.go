@@ -2,10 +2,19 @@ package main
 
 import (
 
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo"
+
 	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
 	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
@@ -241,4 +250,285 @@ func TestUpdateLastLoginTime(t *testing.T) {
 			So(mock.ExpectationsWereMet(), ShouldBeNil)
 		})
 	})
-}
\ No newline at end of file
+}
+func TestCheckPasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	Convey("Password policy tests", t, func() {
+		pp := setupPortalProxy(nil)
+
+		Convey("should require the minimum length", func() {
+			So(pp.CheckPasswordPolicy("testuser", "changeme"), ShouldBeNil)
+			So(pp.CheckPasswordPolicy("testuser", "short"), ShouldNotBeNil)
+			pp.Config.LocalUserPasswordMinLength = 12
+			So(pp.CheckPasswordPolicy("testuser", "changeme"), ShouldNotBeNil)
+		})
+
+		Convey("should not allow the username in the password", func() {
+			So(pp.CheckPasswordPolicy("testuser", "MyTestUser1"), ShouldNotBeNil)
+		})
+
+		Convey("should require complexity if configured", func() {
+			pp.Config.LocalUserPasswordComplexity = true
+			So(pp.CheckPasswordPolicy("testuser", "changeme"), ShouldNotBeNil)
+			So(pp.CheckPasswordPolicy("testuser", "Changeme1"), ShouldBeNil)
+		})
+	})
+}
+
+func setupLocalUserAdminTest(method string, body string, userGUID string) (*httptest.ResponseRecorder, echo.Context, *portalProxy, *sql.DB, sqlmock.Sqlmock) {
+	req := setupMockReq(method, "", nil)
+	if len(body) > 0 {
+		req.Body = ioutil.NopCloser(strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	res, _, ctx, pp, db, mock := setupHTTPTest(req)
+	pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+	pp.Config.ConsoleConfig.LocalUserScope = UAAAdminIdentifier
+	if len(userGUID) > 0 {
+		ctx.SetParamNames("id")
+		ctx.SetParamValues(userGUID)
+	}
+
+	// The admin making the requests
+	sessionValues := map[string]interface{}{"user_id": mockUserGUID}
+	pp.setSessionValues(ctx, sessionValues)
+	return res, ctx, pp, db, mock
+}
+
+func TestLocalUserAdmin(t *testing.T) {
+	t.Parallel()
+
+	otherUserGUID := uuid.NewV4().String()
+
+	Convey("Local user admin tests", t, func() {
+
+		Convey("should not be available without local users", func() {
+			_, ctx, pp, db, _ := setupLocalUserAdminTest("GET", "", "")
+			defer db.Close()
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Remote)
+
+			err := pp.listLocalUsers(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("should list users", func() {
+			res, ctx, pp, db, mock := setupLocalUserAdminTest("GET", "", "")
+			defer db.Close()
+
+			rows := sqlmock.NewRows([]string{"user_guid", "user_name", "user_email", "user_scope", "given_name", "family_name", "disabled", "last_login"}).
+				AddRow(mockUserGUID, "admin", "admin@example.com", UAAAdminIdentifier, "", "", false, time.Now()).
+				AddRow(otherUserGUID, "dev", nil, defaultUserScope, nil, nil, true, nil)
+			mock.ExpectQuery(listLocalUsers).WillReturnRows(rows)
+
+			So(pp.listLocalUsers(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusOK)
+
+			var users []LocalUserInfo
+			So(json.Unmarshal(res.Body.Bytes(), &users), ShouldBeNil)
+			So(users, ShouldHaveLength, 2)
+			So(users[0].Admin, ShouldBeTrue)
+			So(users[1].Admin, ShouldBeFalse)
+			So(users[1].Disabled, ShouldBeTrue)
+			So(users[1].LastLogin, ShouldBeNil)
+			So(res.Body.String(), ShouldNotContainSubstring, "password")
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should create a user", func() {
+			res, ctx, pp, db, mock := setupLocalUserAdminTest("POST", `{"username":"dev","password":"changeme","email":"dev@example.com"}`, "")
+			defer db.Close()
+
+			mock.ExpectQuery(findUserGUID).WithArgs("dev").WillReturnRows(sqlmock.NewRows([]string{"user_guid"}))
+			mock.ExpectExec(addLocalUser).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "dev", "dev@example.com", defaultUserScope, "", "").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.createLocalUser(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusCreated)
+			So(res.Body.String(), ShouldContainSubstring, `"admin":false`)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not create a user that fails the password policy", func() {
+			_, ctx, pp, db, mock := setupLocalUserAdminTest("POST", `{"username":"dev","password":"dev"}`, "")
+			defer db.Close()
+
+			err := pp.createLocalUser(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusBadRequest)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not create a user with an unknown scope", func() {
+			_, ctx, pp, db, _ := setupLocalUserAdminTest("POST", `{"username":"dev","password":"changeme","scope":"cloud_controller.admin"}`, "")
+			defer db.Close()
+
+			err := pp.createLocalUser(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should not create a duplicate user", func() {
+			_, ctx, pp, db, mock := setupLocalUserAdminTest("POST", `{"username":"dev","password":"changeme"}`, "")
+			defer db.Close()
+
+			mock.ExpectQuery(findUserGUID).WithArgs("dev").WillReturnRows(sqlmock.NewRows([]string{"user_guid"}).AddRow(otherUserGUID))
+
+			err := pp.createLocalUser(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("should disable and promote a user", func() {
+			res, ctx, pp, db, mock := setupLocalUserAdminTest("PUT", `{"disabled":true,"scope":"stratos.admin"}`, otherUserGUID)
+			defer db.Close()
+
+			mock.ExpectQuery(findLocalUser).WithArgs(otherUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("dev", "", defaultUserScope, "", "", false))
+			mock.ExpectQuery(findPasswordHash).WithArgs(otherUserGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow([]byte("hash")))
+			mock.ExpectExec(`UPDATE local_users SET password_hash(.+)`).
+				WithArgs([]byte("hash"), "dev", "", UAAAdminIdentifier, "", "", otherUserGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(`UPDATE local_users SET disabled(.+)`).
+				WithArgs(true, otherUserGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.updateLocalUser(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusOK)
+			So(res.Body.String(), ShouldContainSubstring, `"admin":true`)
+			So(res.Body.String(), ShouldContainSubstring, `"disabled":true`)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not let an admin disable their own account", func() {
+			_, ctx, pp, db, mock := setupLocalUserAdminTest("PUT", `{"disabled":true}`, mockUserGUID)
+			defer db.Close()
+
+			mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("admin", "", UAAAdminIdentifier, "", "", false))
+			mock.ExpectQuery(findPasswordHash).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow([]byte("hash")))
+			mock.ExpectExec(`UPDATE local_users SET password_hash(.+)`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			err := pp.updateLocalUser(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should not let an admin change their own scope", func() {
+			_, ctx, pp, db, mock := setupLocalUserAdminTest("PUT", `{"scope":"stratos.user"}`, mockUserGUID)
+			defer db.Close()
+
+			mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("admin", "", UAAAdminIdentifier, "", "", false))
+
+			err := pp.updateLocalUser(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusBadRequest)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not let an admin delete their own account", func() {
+			_, ctx, pp, db, mock := setupLocalUserAdminTest("DELETE", "", mockUserGUID)
+			defer db.Close()
+
+			err := pp.deleteLocalUser(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusBadRequest)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should delete a user", func() {
+			res, ctx, pp, db, mock := setupLocalUserAdminTest("DELETE", "", otherUserGUID)
+			defer db.Close()
+
+			mock.ExpectQuery(findLocalUser).WithArgs(otherUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("dev", "", defaultUserScope, "", "", false))
+			mock.ExpectExec(`DELETE FROM local_users_totp (.+)`).WithArgs(otherUserGUID).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`DELETE FROM local_users (.+)`).WithArgs(otherUserGUID).WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.deleteLocalUser(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusNoContent)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should reset a user's password", func() {
+			res, ctx, pp, db, mock := setupLocalUserAdminTest("POST", `{"password":"newpassword"}`, otherUserGUID)
+			defer db.Close()
+
+			mock.ExpectQuery(findLocalUser).WithArgs(otherUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("dev", "", defaultUserScope, "", "", false))
+			mock.ExpectExec(`UPDATE local_users SET password_hash(.+)`).
+				WithArgs(sqlmock.AnyArg(), "dev", "", defaultUserScope, "", "", otherUserGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.resetLocalUserPassword(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusNoContent)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
+
+func TestLocalLoginDisabledUser(t *testing.T) {
+	t.Parallel()
+
+	Convey("Disabled local users should not be able to log in", t, func() {
+		passwordHash, _ := HashPassword("changeme")
+		req := setupMockReq("POST", "", map[string]string{
+			"username": "dev",
+			"password": "changeme",
+		})
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+
+		mock.ExpectQuery(findUserGUID).WithArgs("dev").WillReturnRows(sqlmock.NewRows([]string{"user_guid"}).AddRow(mockUserGUID))
+		mock.ExpectQuery(findPasswordHash).WithArgs(mockUserGUID).WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(passwordHash))
+		mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("dev", "", defaultUserScope, "", "", true))
+
+		err := pp.localLogin(ctx)
+		So(err, ShouldNotBeNil)
+		So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusUnauthorized)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+}
+
+func TestDisabledLocalUserSession(t *testing.T) {
+	t.Parallel()
+
+	Convey("Sessions of local users", t, func() {
+		_, ctx, pp, db, mock := setupLocalUserAdminTest("GET", "", "")
+		defer db.Close()
+		pp.SessionStoreOptions = &sessions.Options{}
+
+		handlerCalled := false
+		handler := func(c echo.Context) error {
+			handlerCalled = true
+			return nil
+		}
+
+		Convey("should be accepted while the user is enabled", func() {
+			mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("admin", "", UAAAdminIdentifier, "", "", false))
+
+			So(pp.sessionMiddleware(handler)(ctx), ShouldBeNil)
+			So(handlerCalled, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should end once the user is disabled", func() {
+			mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("admin", "", UAAAdminIdentifier, "", "", true))
+
+			err := pp.sessionMiddleware(handler)(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusUnauthorized)
+			So(handlerCalled, ShouldBeFalse)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
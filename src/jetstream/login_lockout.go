@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/loginattempts"
+)
+
+const (
+	defaultLoginLockoutDuration    = 30 * time.Second
+	defaultLoginLockoutMaxDuration = time.Hour
+)
+
+// Set when a login has checked the user's password, but is waiting for a second factor
+const loginPendingContextKey = "login_pending"
+
+// loginLockoutKey is a username or IP address that failed logins are counted against
+type loginLockoutKey struct {
+	Type      string
+	Key       string
+	Threshold int
+}
+
+func (p *portalProxy) getLoginLockoutKeys(username, ip string) []loginLockoutKey {
+	keys := make([]loginLockoutKey, 0, 2)
+	if p.Config.LoginLockoutThreshold > 0 && len(username) > 0 {
+		keys = append(keys, loginLockoutKey{interfaces.LoginAttemptsUser, username, p.Config.LoginLockoutThreshold})
+	}
+	if p.Config.LoginLockoutIPThreshold > 0 && len(ip) > 0 {
+		keys = append(keys, loginLockoutKey{interfaces.LoginAttemptsIP, ip, p.Config.LoginLockoutIPThreshold})
+	}
+	return keys
+}
+
+func (p *portalProxy) getLoginLockoutDurations() (time.Duration, time.Duration) {
+	duration := defaultLoginLockoutDuration
+	if p.Config.LoginLockoutDurationInSecs > 0 {
+		duration = time.Duration(p.Config.LoginLockoutDurationInSecs) * time.Second
+	}
+	maxDuration := defaultLoginLockoutMaxDuration
+	if p.Config.LoginLockoutMaxDurationInSecs > 0 {
+		maxDuration = time.Duration(p.Config.LoginLockoutMaxDurationInSecs) * time.Second
+	}
+	if maxDuration < duration {
+		maxDuration = duration
+	}
+	return duration, maxDuration
+}
+
+// loginLockoutDuration is how long to lock out for, once the threshold has been reached. This doubles with each
+// further failure
+func loginLockoutDuration(failures, threshold int, duration, maxDuration time.Duration) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	for i := threshold; i < failures; i++ {
+		duration *= 2
+		if duration >= maxDuration {
+			return maxDuration
+		}
+	}
+	return duration
+}
+
+// recordLoginFailure counts a failed login. Failures are forgotten once there haven't been any for the max lockout time
+func (p *portalProxy) recordLoginFailure(repo loginattempts.Repository, key loginLockoutKey, now time.Time) error {
+	duration, maxDuration := p.getLoginLockoutDurations()
+	failures, err := repo.AddFailure(key.Type, key.Key, now, now.Add(-maxDuration))
+	if err != nil {
+		return err
+	}
+
+	if lockout := loginLockoutDuration(failures, key.Threshold, duration, maxDuration); lockout > 0 {
+		log.Warnf("Locking out logins for %s %s for %v after %d failed attempts", key.Type, key.Key, lockout, failures)
+		return repo.LockUntil(key.Type, key.Key, now.Add(lockout))
+	}
+	return nil
+}
+
+// isLoginFailure checks if a login failed because of the user's credentials, rather than anything else going wrong
+func isLoginFailure(err error) bool {
+	switch e := err.(type) {
+	case interfaces.ErrHTTPShadow:
+		return e.HTTPError.Code == http.StatusUnauthorized
+	case *echo.HTTPError:
+		return e.Code == http.StatusUnauthorized
+	}
+	return false
+}
+
+func loginLockedOut(c echo.Context, wait time.Duration) error {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	return echo.NewHTTPError(http.StatusTooManyRequests, fmt.Sprintf("Too many failed login attempts - retry after %d second(s)", retryAfter))
+}
+
+// setLoginPending records that the user still has to provide a second factor, so their failed logins aren't cleared yet
+func setLoginPending(c echo.Context) {
+	c.Set(loginPendingContextKey, true)
+}
+
+func isLoginPending(c echo.Context) bool {
+	pending, _ := c.Get(loginPendingContextKey).(bool)
+	return pending
+}
+
+// clearLoginFailures forgets the user's failed logins once they have logged in
+func (p *portalProxy) clearLoginFailures(username string) {
+	if p.Config.LoginLockoutThreshold <= 0 || len(username) == 0 {
+		return
+	}
+	repo, err := loginattempts.NewPgsqlLoginAttemptsRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return
+	}
+	if err := repo.Delete(interfaces.LoginAttemptsUser, username); err != nil {
+		log.Warnf("Unable to clear failed login attempts for %s: %v", username, err)
+	}
+}
+
+// withLoginLockout wraps a login, refusing it while the user or source IP address is locked out and counting it if it
+// fails. The user's failures are only cleared once the login has completed, including any second factor
+func (p *portalProxy) withLoginLockout(c echo.Context, username string, login echo.HandlerFunc) error {
+	keys := p.getLoginLockoutKeys(username, p.clientIP(c))
+	if len(keys) == 0 {
+		return login(c)
+	}
+
+	repo, err := loginattempts.NewPgsqlLoginAttemptsRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		attempts, err := repo.Find(key.Type, key.Key)
+		if err != nil {
+			log.Warnf("Unable to check failed login attempts for %s %s: %v", key.Type, key.Key, err)
+			continue
+		}
+		if attempts.LockedUntil != nil && attempts.LockedUntil.After(now) {
+			log.Warnf("Refusing login for locked out %s %s", key.Type, key.Key)
+			return loginLockedOut(c, attempts.LockedUntil.Sub(now))
+		}
+	}
+
+	err = login(c)
+	if isLoginFailure(err) {
+		for _, key := range keys {
+			if recordErr := p.recordLoginFailure(repo, key, now); recordErr != nil {
+				log.Warnf("Unable to record failed login attempt for %s %s: %v", key.Type, key.Key, recordErr)
+			}
+		}
+	} else if err == nil && !isLoginPending(c) {
+		// A successful login clears the user's failures, but not those of the IP address
+		p.clearLoginFailures(username)
+	}
+	return err
+}
+
+func (p *portalProxy) listLoginLockouts(c echo.Context) error {
+	log.Debug("listLoginLockouts")
+	repo, err := loginattempts.NewPgsqlLoginAttemptsRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return err
+	}
+
+	locked, err := repo.ListLocked(time.Now())
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list locked out users",
+			"Unable to list login lockouts: %v", err)
+	}
+	return c.JSON(http.StatusOK, locked)
+}
+
+// unlockLogin lets an admin clear the failed login attempts for a username or IP address
+func (p *portalProxy) unlockLogin(c echo.Context) error {
+	log.Debug("unlockLogin")
+	attemptType := c.Param("type")
+	if attemptType != interfaces.LoginAttemptsUser && attemptType != interfaces.LoginAttemptsIP {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid lockout type '%s'", attemptType))
+	}
+	key, err := url.PathUnescape(c.Param("key"))
+	if err != nil || len(key) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid lockout key")
+	}
+
+	repo, err := loginattempts.NewPgsqlLoginAttemptsRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return err
+	}
+	if err := repo.Delete(attemptType, key); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to unlock login",
+			"Unable to unlock login for %s %s: %v", attemptType, key, err)
+	}
+
+	log.Infof("Unlocked logins for %s %s", attemptType, key)
+	return c.NoContent(http.StatusNoContent)
+}
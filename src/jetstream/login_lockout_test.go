@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	findLoginAttempts   = `SELECT (.+) FROM login_attempts WHERE (.+)`
+	findLoginFailures   = `SELECT failures FROM login_attempts WHERE (.+)`
+	addLoginFailure     = `UPDATE login_attempts SET failures (.+)`
+	lockLoginAttempts   = `UPDATE login_attempts SET locked_until (.+)`
+	insertLoginAttempts = `INSERT INTO login_attempts (.+)`
+	deleteLoginAttempts = `DELETE FROM login_attempts (.+)`
+)
+
+func TestLoginLockoutDuration(t *testing.T) {
+	t.Parallel()
+
+	Convey("Lockout duration should back off exponentially", t, func() {
+		So(loginLockoutDuration(4, 5, time.Minute, time.Hour), ShouldEqual, 0)
+		So(loginLockoutDuration(5, 5, time.Minute, time.Hour), ShouldEqual, time.Minute)
+		So(loginLockoutDuration(6, 5, time.Minute, time.Hour), ShouldEqual, 2*time.Minute)
+		So(loginLockoutDuration(8, 5, time.Minute, time.Hour), ShouldEqual, 8*time.Minute)
+		So(loginLockoutDuration(500, 5, time.Minute, time.Hour), ShouldEqual, time.Hour)
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	t.Parallel()
+
+	attemptsRows := func(failures int, lastFailure time.Time, lockedUntil *time.Time) sqlmock.Rows {
+		return sqlmock.NewRows([]string{"failures", "last_failure", "locked_until"}).AddRow(failures, lastFailure, lockedUntil)
+	}
+
+	Convey("Login lockout tests", t, func() {
+		req := setupMockReq("POST", "", map[string]string{
+			"username": "admin",
+			"password": "wrong",
+		})
+		req.RemoteAddr = "10.0.0.1:43210"
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		pp.Config.LoginLockoutThreshold = 3
+		pp.Config.LoginLockoutIPThreshold = 10
+
+		loginCalled := false
+		failedLogin := func(c echo.Context) error {
+			loginCalled = true
+			return interfaces.NewHTTPShadowError(http.StatusUnauthorized, "Access Denied", "Access Denied")
+		}
+
+		Convey("should refuse logins while locked out", func() {
+			lockedUntil := time.Now().Add(time.Minute)
+			mock.ExpectQuery(findLoginAttempts).WithArgs(interfaces.LoginAttemptsUser, "admin").
+				WillReturnRows(attemptsRows(3, time.Now(), &lockedUntil))
+
+			err := pp.withLoginLockout(ctx, "admin", failedLogin)
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusTooManyRequests)
+			So(res.Header().Get("Retry-After"), ShouldNotBeEmpty)
+			So(loginCalled, ShouldBeFalse)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should count failures against the user and IP address", func() {
+			mock.ExpectQuery(findLoginAttempts).WithArgs(interfaces.LoginAttemptsUser, "admin").
+				WillReturnRows(attemptsRows(2, time.Now(), nil))
+			mock.ExpectQuery(findLoginAttempts).WithArgs(interfaces.LoginAttemptsIP, "10.0.0.1").
+				WillReturnError(sql.ErrNoRows)
+
+			// The third failure locks out the user
+			mock.ExpectBegin()
+			mock.ExpectExec(addLoginFailure).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), interfaces.LoginAttemptsUser, "admin").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(findLoginFailures).WithArgs(interfaces.LoginAttemptsUser, "admin").
+				WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(3))
+			mock.ExpectCommit()
+			mock.ExpectExec(lockLoginAttempts).
+				WithArgs(sqlmock.AnyArg(), interfaces.LoginAttemptsUser, "admin", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			// First failure from this IP address
+			mock.ExpectBegin()
+			mock.ExpectExec(addLoginFailure).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(insertLoginAttempts).
+				WithArgs(interfaces.LoginAttemptsIP, "10.0.0.1", 1, sqlmock.AnyArg(), nil).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			err := pp.withLoginLockout(ctx, "admin", failedLogin)
+			So(isLoginFailure(err), ShouldBeTrue)
+			So(loginCalled, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not lock out below the threshold", func() {
+			pp.Config.LoginLockoutIPThreshold = 0
+			mock.ExpectQuery(findLoginAttempts).WillReturnRows(attemptsRows(2, time.Now().Add(-2*time.Hour), nil))
+			mock.ExpectBegin()
+			mock.ExpectExec(addLoginFailure).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(findLoginFailures).WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(1))
+			mock.ExpectCommit()
+
+			pp.withLoginLockout(ctx, "admin", failedLogin)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should count a failure added at the same time as the first", func() {
+			pp.Config.LoginLockoutIPThreshold = 0
+			mock.ExpectQuery(findLoginAttempts).WillReturnError(sql.ErrNoRows)
+			mock.ExpectBegin()
+			mock.ExpectExec(addLoginFailure).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(insertLoginAttempts).WillReturnError(errors.New("duplicate key"))
+			mock.ExpectRollback()
+			mock.ExpectBegin()
+			mock.ExpectExec(addLoginFailure).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(findLoginFailures).WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(2))
+			mock.ExpectCommit()
+
+			pp.withLoginLockout(ctx, "admin", failedLogin)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should clear the user's failures on success", func() {
+			mock.ExpectQuery(findLoginAttempts).WillReturnRows(attemptsRows(2, time.Now(), nil))
+			mock.ExpectQuery(findLoginAttempts).WillReturnError(sql.ErrNoRows)
+			mock.ExpectExec(deleteLoginAttempts).WithArgs(interfaces.LoginAttemptsUser, "admin").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			err := pp.withLoginLockout(ctx, "admin", func(c echo.Context) error { return nil })
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not clear the user's failures while a second factor is pending", func() {
+			mock.ExpectQuery(findLoginAttempts).WillReturnRows(attemptsRows(2, time.Now(), nil))
+			mock.ExpectQuery(findLoginAttempts).WillReturnError(sql.ErrNoRows)
+
+			err := pp.withLoginLockout(ctx, "admin", func(c echo.Context) error {
+				setLoginPending(c)
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not count other errors", func() {
+			mock.ExpectQuery(findLoginAttempts).WillReturnError(sql.ErrNoRows)
+			mock.ExpectQuery(findLoginAttempts).WillReturnError(sql.ErrNoRows)
+
+			err := pp.withLoginLockout(ctx, "admin", func(c echo.Context) error { return errors.New("UAA is down") })
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should let an admin unlock a user", func() {
+			ctx.SetParamNames("type", "key")
+			ctx.SetParamValues(interfaces.LoginAttemptsUser, "admin")
+			mock.ExpectExec(deleteLoginAttempts).WithArgs(interfaces.LoginAttemptsUser, "admin").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.unlockLogin(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusNoContent)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
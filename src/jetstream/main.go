@@ -34,12 +34,16 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/apitokens"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/audit"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/console_config"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces/config"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/localusers"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/loginattempts"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/roles"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
 )
 
@@ -52,6 +56,10 @@ const (
 	UpgradeLockFileName  = "UPGRADE_LOCK_FILENAME"
 	VCapApplication      = "VCAP_APPLICATION"
 	defaultSessionSecret = "wheeee!"
+
+	// Alternative sources of the encryption key
+	encryptionKeyProviderVault    = "vault"
+	encryptionKeyProviderEnvelope = "envelope"
 )
 
 var appVersion string
@@ -63,6 +71,8 @@ var (
 	// Clients to use typically for mutating operations - typically allow a longer request timeout
 	httpClientMutating        = http.Client{}
 	httpClientMutatingSkipSSL = http.Client{}
+	// Dialer shared by all transports, including those for endpoints with their own connection settings
+	httpDialer func(network, addr string) (net.Conn, error)
 )
 
 func cleanup(dbc *sql.DB, ss HttpSessionStore) {
@@ -164,6 +174,9 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err = setPreviousEncryptionKeys(portalConfig); err != nil {
+		log.Fatal(err)
+	}
 	log.Info("Encryption key set.")
 
 	// Load database configuration
@@ -180,6 +193,10 @@ func main() {
 	tokens.InitRepositoryProvider(dc.DatabaseProvider)
 	console_config.InitRepositoryProvider(dc.DatabaseProvider)
 	localusers.InitRepositoryProvider(dc.DatabaseProvider)
+	loginattempts.InitRepositoryProvider(dc.DatabaseProvider)
+	apitokens.InitRepositoryProvider(dc.DatabaseProvider)
+	roles.InitRepositoryProvider(dc.DatabaseProvider)
+	audit.InitRepositoryProvider(dc.DatabaseProvider)
 
 	// Establish a Postgresql connection pool
 	var databaseConnectionPool *sql.DB
@@ -236,6 +253,12 @@ func main() {
 
 	// Setup the global interface for the proxy
 	portalProxy := newPortalProxy(portalConfig, databaseConnectionPool, sessionStore, sessionStoreOptions, envLookup)
+
+	// Forward audit events to syslog and/or a webhook, if configured
+	portalProxy.AuditForwarder, err = newAuditForwarder(portalConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
 	log.Info("Initialization complete.")
 
 	c := make(chan os.Signal, 2)
@@ -256,6 +279,11 @@ func main() {
 	// Initialise Plugins
 	portalProxy.loadPlugins()
 
+	// Refresh endpoint tokens before they expire
+	if portalProxy.TokenRefresher.enabled() {
+		go portalProxy.runTokenRefresh()
+	}
+
 	initedPlugins := make(map[string]interfaces.StratosPlugin)
 	portalProxy.PluginsStatus = make(map[string]bool)
 
@@ -286,6 +314,10 @@ func main() {
 		showSSOConfig(portalProxy)
 	}
 
+	if portalProxy.Config.SkipTokenVerification {
+		log.Warn("Token verification is disabled - tokens from UAA and endpoints will be trusted without checking their signature")
+	}
+
 	// Get Diagnostics and store them once - ensure this is done after plugins are loaded
 	portalProxy.StoreDiagnostics()
 
@@ -352,6 +384,21 @@ func showStratosConfig(config *interfaces.ConsoleConfig) {
 		if val == interfaces.Local {
 			log.Infof("... Local User              : %s", config.LocalUser)
 			log.Infof("... Local User Scope        : %s", config.LocalUserScope)
+		} else if val == interfaces.OIDC {
+			log.Infof("... OIDC Issuer             : %s", config.OIDCIssuerURL)
+			log.Infof("... Console Client          : %s", config.ConsoleClient)
+			log.Infof("... OIDC Scopes             : %s", config.OIDCScopes)
+			log.Infof("... OIDC User Claims        : %s, %s, %s", config.OIDCUserIDClaim, config.OIDCUserNameClaim, config.OIDCEmailClaim)
+			log.Infof("... OIDC Admin Claim        : %s = %s", config.OIDCAdminClaim, config.OIDCAdminValue)
+		} else if val == interfaces.LDAP {
+			log.Infof("... LDAP URL                : %s", config.LDAPURL)
+			log.Infof("... LDAP StartTLS           : %t", config.LDAPStartTLS)
+			if len(config.LDAPUserDNTemplate) > 0 {
+				log.Infof("... LDAP User DN Template   : %s", config.LDAPUserDNTemplate)
+			} else {
+				log.Infof("... LDAP User Search        : %s %s", config.LDAPUserBaseDN, config.LDAPUserFilter)
+			}
+			log.Infof("... LDAP Admin Group        : %s", config.LDAPAdminGroup)
 		} else { //Auth type is set to remote
 			log.Infof("... UAA Endpoint            : %s", config.UAAEndpoint)
 			log.Infof("... Authorization Endpoint  : %s", config.AuthorizationEndpoint)
@@ -375,29 +422,121 @@ func showSSOConfig(portalProxy *portalProxy) {
 func getEncryptionKey(pc interfaces.PortalConfig) ([]byte, error) {
 	log.Debug("getEncryptionKey")
 
-	// If it exists in "EncryptionKey" we must be in compose; use it.
-	if len(pc.EncryptionKey) > 0 {
-		key32bytes, err := hex.DecodeString(string(pc.EncryptionKey))
-		if err != nil {
-			log.Error(err)
+	provider, err := newSecretProvider(pc)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := provider.EncryptionKey()
+	if err != nil {
+		log.Errorf("Unable to get the encryption key: %v", err)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// newSecretProvider returns the configured source of the encryption key
+func newSecretProvider(pc interfaces.PortalConfig) (crypto.SecretProvider, error) {
+	switch pc.EncryptionKeyProvider {
+	case "":
+		// If it exists in "EncryptionKey" we must be in compose; use it.
+		if len(pc.EncryptionKey) > 0 {
+			return &crypto.StaticKeyProvider{Key: pc.EncryptionKey}, nil
+		}
+
+		// Check we have volume and filename
+		if len(pc.EncryptionKeyVolume) == 0 && len(pc.EncryptionKeyFilename) == 0 {
+			return nil, errors.New("You must configure either an Encryption key or the Encryption key filename")
 		}
 
-		return key32bytes, nil
+		// Read the key from the shared volume
+		return &crypto.FileKeyProvider{Volume: pc.EncryptionKeyVolume, Filename: pc.EncryptionKeyFilename}, nil
+	case encryptionKeyProviderVault:
+		return newVaultKeyProvider(pc), nil
+	case encryptionKeyProviderEnvelope:
+		return &crypto.EnvelopeKeyProvider{KeyEncryptionKey: newKEKProvider(pc), WrappedKey: pc.EncryptionKeyWrapped}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown encryption key provider '%s'", pc.EncryptionKeyProvider)
+}
+
+func newVaultKeyProvider(pc interfaces.PortalConfig) crypto.SecretProvider {
+	return &crypto.VaultKeyProvider{
+		Address: pc.VaultAddress,
+		Token:   pc.VaultToken,
+		Path:    pc.EncryptionKeyVaultPath,
+		Field:   pc.EncryptionKeyVaultField,
 	}
+}
 
-	// Check we have volume and filename
-	if len(pc.EncryptionKeyVolume) == 0 && len(pc.EncryptionKeyFilename) == 0 {
-		return nil, errors.New("You must configure either an Encryption key or the Encryption key filename")
+// newKEKProvider returns the source of the key-encryption-key for envelope encryption - a file, or Vault
+func newKEKProvider(pc interfaces.PortalConfig) crypto.SecretProvider {
+	if len(pc.EncryptionKEKFile) > 0 {
+		return &crypto.FileKeyProvider{Path: pc.EncryptionKEKFile}
 	}
+	return newVaultKeyProvider(pc)
+}
 
-	// Read the key from the shared volume
-	key, err := crypto.ReadEncryptionKey(pc.EncryptionKeyVolume, pc.EncryptionKeyFilename)
+// setPreviousEncryptionKeys allows secrets encrypted with keys that have since been rotated to still be decrypted
+func setPreviousEncryptionKeys(pc interfaces.PortalConfig) error {
+	keys, err := getPreviousEncryptionKeys(pc)
 	if err != nil {
-		log.Errorf("Unable to read the encryption key from the shared volume: %v", err)
-		return nil, err
+		return fmt.Errorf("Unable to read the previous encryption keys: %v", err)
 	}
+	crypto.SetPreviousKeys(keys)
 
-	return key, nil
+	log.Infof("Encryption key ID: %s", crypto.KeyID(pc.EncryptionKeyInBytes))
+	if len(keys) > 0 {
+		log.Infof("%d previous encryption key(s) - run the rekey migration to re-encrypt secrets with the current key", len(keys))
+	}
+	return nil
+}
+
+// getPreviousEncryptionKeys reads the previous keys through the configured key provider. ENCRYPTION_PREVIOUS_KEYS
+// lists them in the same form as the current key - hex keys, key files on the volume, fields of the Vault secret or
+// wrapped keys
+func getPreviousEncryptionKeys(pc interfaces.PortalConfig) ([][]byte, error) {
+	keys := make([][]byte, 0)
+	for _, previousKey := range strings.Split(pc.EncryptionPreviousKeys, ",") {
+		previousKey = strings.TrimSpace(previousKey)
+		if len(previousKey) == 0 {
+			continue
+		}
+		provider, err := newPreviousKeyProvider(pc, previousKey)
+		if err != nil {
+			return nil, err
+		}
+		key, err := provider.EncryptionKey()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("Previous encryption key '%s' is empty or invalid", previousKey)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// newPreviousKeyProvider returns a provider of the same kind as the one for the current key, for a previous key
+func newPreviousKeyProvider(pc interfaces.PortalConfig, previousKey string) (crypto.SecretProvider, error) {
+	switch pc.EncryptionKeyProvider {
+	case "":
+		if len(pc.EncryptionKey) > 0 {
+			if _, err := hex.DecodeString(previousKey); err != nil {
+				return nil, fmt.Errorf("Invalid encryption key: %v", err)
+			}
+			pc.EncryptionKey = previousKey
+		} else {
+			pc.EncryptionKeyFilename = previousKey
+		}
+	case encryptionKeyProviderVault:
+		pc.EncryptionKeyVaultField = previousKey
+	case encryptionKeyProviderEnvelope:
+		pc.EncryptionKeyWrapped = previousKey
+	}
+	return newSecretProvider(pc)
 }
 
 func initConnPool(dc datastore.DatabaseConfig, env *env.VarSet) (*sql.DB, error) {
@@ -504,6 +643,36 @@ func loadPortalConfig(pc interfaces.PortalConfig, env *env.VarSet) (interfaces.P
 		pc.HTTPClientTimeoutMutatingInSecs = pc.HTTPClientTimeoutInSecs
 	}
 
+	// Circuit breakers are on by default - a negative threshold disables them
+	if pc.CircuitBreakerFailureThreshold == 0 {
+		pc.CircuitBreakerFailureThreshold = defaultCircuitBreakerFailureThreshold
+	}
+	if pc.CircuitBreakerOpenInSecs <= 0 {
+		pc.CircuitBreakerOpenInSecs = defaultCircuitBreakerOpenInSecs
+	}
+
+	// Refresh tokens in the background by default - a negative interval disables this
+	if pc.TokenRefreshIntervalInSecs == 0 {
+		pc.TokenRefreshIntervalInSecs = defaultTokenRefreshIntervalInSecs
+	}
+	if pc.TokenRefreshWindowInSecs <= 0 {
+		pc.TokenRefreshWindowInSecs = defaultTokenRefreshWindowInSecs
+	}
+	if pc.TokenRefreshActiveUserInSecs <= 0 {
+		pc.TokenRefreshActiveUserInSecs = defaultTokenRefreshActiveUserInSecs
+	}
+
+	// Retry transient failures by default - a single attempt disables retries
+	if pc.ProxyRetryMaxAttempts <= 0 {
+		pc.ProxyRetryMaxAttempts = defaultProxyRetryMaxAttempts
+	}
+	if pc.ProxyRetryBaseDelayInMillis <= 0 {
+		pc.ProxyRetryBaseDelayInMillis = defaultProxyRetryBaseDelayInMillis
+	}
+	if pc.ProxyRetryBudgetInSecs <= 0 {
+		pc.ProxyRetryBudgetInSecs = defaultProxyRetryBudgetInSecs
+	}
+
 	return pc, nil
 }
 
@@ -590,9 +759,20 @@ func newPortalProxy(pc interfaces.PortalConfig, dcp *sql.DB, ss HttpSessionStore
 		SessionCookieName:      cookieName,
 		EmptyCookieMatcher:     regexp.MustCompile(cookieName + "=(?:;[ ]*|$)"),
 		AuthProviders:          make(map[string]interfaces.AuthProvider),
+		CircuitBreakers:        newCircuitBreakers(pc.CircuitBreakerFailureThreshold, pc.CircuitBreakerOpenInSecs, pc.CircuitBreakerSlowCallInMillis),
+		TokenRefresher:         newTokenRefresher(pc.TokenRefreshIntervalInSecs, pc.TokenRefreshWindowInSecs, pc.TokenRefreshActiveUserInSecs),
+		Jobs:                   newJobStore(pc.LongRunningJobRetentionInSecs),
+		RateLimiters:           newRateLimiters(pc),
+		TrustedProxies:         parseTrustedProxies(pc.TrustedProxies),
+		RoutePermissions:       make(map[string]string),
 		env:                    env,
 	}
 
+	// Cache responses to proxied GET requests if a TTL has been configured
+	if pc.ProxyCacheTTLInSecs > 0 {
+		pp.ProxyResponseCache = newMemoryProxyCache(pc.ProxyCacheMaxEntries)
+	}
+
 	// Initialize built-in auth providers
 
 	// Basic Auth
@@ -604,6 +784,7 @@ func newPortalProxy(pc interfaces.PortalConfig, dcp *sql.DB, ss HttpSessionStore
 	// OIDC
 	pp.AddAuthProvider(interfaces.AuthTypeOIDC, interfaces.AuthProvider{
 		Handler: pp.doOidcFlowRequest,
+		Refresh: pp.RefreshOidcToken,
 	})
 
 	return pp
@@ -617,6 +798,7 @@ func initializeHTTPClients(timeout int64, timeoutMutating int64, connectionTimeo
 		Timeout:   time.Duration(connectionTimeout) * time.Second,
 		KeepAlive: 30 * time.Second, // should be less than any proxy connection timeout (typically 2-3 minutes)
 	}).Dial
+	httpDialer = dial
 
 	tr := &http.Transport{
 		Proxy:               http.ProxyFromEnvironment,
@@ -733,9 +915,16 @@ func (p *portalProxy) GetHttpClient(skipSSLValidation bool) http.Client {
 
 // GetHttpClientForRequest returns an Http Client for the giving request
 func (p *portalProxy) GetHttpClientForRequest(req *http.Request, skipSSLValidation bool) http.Client {
-	isMutating := req.Method != "GET" && req.Method != "HEAD"
-	client := p.getHttpClient(skipSSLValidation, isMutating)
+	client := p.getHttpClient(skipSSLValidation, isMutatingRequest(req))
+	return p.getHttpClientForRequestTimeout(req, client)
+}
 
+func isMutatingRequest(req *http.Request) bool {
+	return req.Method != "GET" && req.Method != "HEAD"
+}
+
+// Long-running and streamed requests need a different timeout to the client's usual one
+func (p *portalProxy) getHttpClientForRequestTimeout(req *http.Request, client http.Client) http.Client {
 	// Is this is a long-running request, then use a different timeout
 	if req.Header.Get(longRunningTimeoutHeader) == "true" {
 		longRunningClient := http.Client{}
@@ -744,6 +933,13 @@ func (p *portalProxy) GetHttpClientForRequest(req *http.Request, skipSSLValidati
 		return longRunningClient
 	}
 
+	// Streamed passthrough responses can be arbitrarily large, so don't time out whilst the body is being read
+	if req.Header.Get(passthroughHeader) == "true" {
+		streamingClient := http.Client{}
+		streamingClient.Transport = client.Transport
+		return streamingClient
+	}
+
 	return client
 }
 
@@ -787,15 +983,16 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 	// Add middleware to block requests if unconfigured
 	if needSetupMiddleware {
 		e.Use(p.SetupMiddleware())
-		pp.POST("/v1/setup", p.setupConsole)
+		pp.POST("/v1/setup", p.setupConsole, p.auditAction(interfaces.AuditConsoleSetup, auditFormValue("uaa_endpoint")))
 		pp.POST("/v1/setup/check", p.setupConsoleCheck)
 	}
 
-	pp.POST("/v1/auth/login/uaa", p.stratosLoginHandler)
+	pp.POST("/v1/auth/login/uaa", p.stratosLoginHandler, p.loginRateLimitMiddleware, p.auditAction(interfaces.AuditLogin, auditFormValue("username")))
+	pp.POST("/v1/auth/login/totp", p.localLoginTOTP, p.loginRateLimitMiddleware, p.auditAction(interfaces.AuditLogin, nil))
 	pp.POST("/v1/auth/logout", p.logout)
 
 	// SSO Routes will only respond if SSO is enabled
-	pp.GET("/v1/auth/sso_login", p.initSSOlogin)
+	pp.GET("/v1/auth/sso_login", p.initSSOlogin, p.loginRateLimitMiddleware)
 	pp.GET("/v1/auth/sso_logout", p.ssoLogoutOfUAA)
 
 	// Local User login/logout
@@ -803,7 +1000,7 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 	// pp.POST("/v1/auth/local_logout", p.logout)
 
 	// Callback is used by both login to Stratos and login to an Endpoint
-	pp.GET("/v1/auth/sso_login_callback", p.ssoLoginToUAA)
+	pp.GET("/v1/auth/sso_login_callback", p.ssoLoginToUAA, p.loginRateLimitMiddleware)
 
 	// Version info
 	pp.GET("/v1/version", p.getVersions)
@@ -812,6 +1009,8 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 	sessionGroup := pp.Group("/v1")
 	sessionGroup.Use(p.sessionMiddleware)
 	sessionGroup.Use(p.xsrfMiddleware)
+	sessionGroup.Use(p.userRateLimitMiddleware)
+	sessionGroup.Use(p.permissionMiddleware)
 
 	for _, plugin := range p.Plugins {
 		middlewarePlugin, err := plugin.GetMiddlewarePlugin()
@@ -826,7 +1025,7 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 	sessionGroup.POST("/auth/login/cnsi", p.loginToCNSI)
 
 	// Connect to Enpoint (SSO)
-	sessionGroup.GET("/auth/login/cnsi", p.ssoLoginToCNSI)
+	p.RequirePermission(sessionGroup.GET("/auth/login/cnsi", p.ssoLoginToCNSI), interfaces.PermissionEndpointsUse)
 
 	// Disconnect endpoint
 	sessionGroup.POST("/auth/logout/cnsi", p.logoutOfCNSI)
@@ -834,6 +1033,18 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 	// Verify Session
 	sessionGroup.GET("/auth/session/verify", p.verifySession)
 
+	// Personal API tokens
+	sessionGroup.GET("/api_tokens", p.listAPITokens)
+	p.RequirePermission(sessionGroup.POST("/api_tokens", p.createAPIToken, p.auditAction(interfaces.AuditAPITokenCreate, nil)), interfaces.PermissionConsoleRead)
+	p.RequirePermission(sessionGroup.DELETE("/api_tokens/:id", p.revokeAPIToken, p.auditAction(interfaces.AuditAPITokenRevoke, auditParam("id"))), interfaces.PermissionConsoleRead)
+
+	// TOTP second factor for local users
+	sessionGroup.GET("/auth/totp", p.getTOTPStatus)
+	p.RequirePermission(sessionGroup.POST("/auth/totp", p.enrollTOTP), interfaces.PermissionConsoleRead)
+	p.RequirePermission(sessionGroup.POST("/auth/totp/verify", p.confirmTOTP), interfaces.PermissionConsoleRead)
+	p.RequirePermission(sessionGroup.POST("/auth/totp/recovery_codes", p.regenerateTOTPRecoveryCodes), interfaces.PermissionConsoleRead)
+	p.RequirePermission(sessionGroup.POST("/auth/totp/disable", p.disableTOTP), interfaces.PermissionConsoleRead)
+
 	// CNSI operations
 	sessionGroup.GET("/cnsis", p.listCNSIs)
 	sessionGroup.GET("/cnsis/registered", p.listRegisteredCNSIs)
@@ -841,6 +1052,10 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 	// Info
 	sessionGroup.GET("/info", p.info)
 
+	// Long running request jobs
+	sessionGroup.GET("/jobs/:id", p.getJob)
+	sessionGroup.DELETE("/jobs/:id", p.cancelJob)
+
 	for _, plugin := range p.Plugins {
 		routePlugin, err := plugin.GetRoutePlugin()
 		if err != nil {
@@ -850,8 +1065,12 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 		routePlugin.AddSessionGroupRoutes(sessionGroup)
 	}
 
+	// Make several requests in one go
+	sessionGroup.POST("/proxy/batch", p.proxyBatch)
+
 	// This is used for passthru of requests
 	group := sessionGroup.Group("/proxy")
+	group.Use(p.endpointRateLimitMiddleware)
 	group.Any("/*", p.proxy)
 
 	// The admin-only routes need to be last as the admin middleware will be
@@ -864,7 +1083,8 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 		if err == nil {
 			// Plugin supports endpoint plugin
 			endpointType := endpointPlugin.GetType()
-			adminGroup.POST("/register/"+endpointType, endpointPlugin.Register)
+			p.RequirePermission(adminGroup.POST("/register/"+endpointType, endpointPlugin.Register,
+				p.auditAction(interfaces.AuditEndpointRegister, auditFormValue("api_endpoint"))), interfaces.PermissionEndpointsManage)
 		}
 
 		routePlugin, err := plugin.GetRoutePlugin()
@@ -873,7 +1093,41 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, needSetupMiddleware bool) {
 		}
 	}
 
-	adminGroup.POST("/unregister", p.unregisterCluster)
+	p.RequirePermission(adminGroup.POST("/unregister", p.unregisterCluster, p.auditAction(interfaces.AuditEndpointUnregister, auditFormValue("cnsi_guid"))), interfaces.PermissionEndpointsManage)
+
+	// Rewrite rules for requests proxied to an endpoint
+	p.RequirePermission(adminGroup.GET("/cnsis/:id/rewrite", p.getEndpointRewriteRules), interfaces.PermissionEndpointsManage)
+	p.RequirePermission(adminGroup.PUT("/cnsis/:id/rewrite", p.updateEndpointRewriteRules, p.auditAction(interfaces.AuditEndpointRewriteRules, auditParam("id"))), interfaces.PermissionEndpointsManage)
+	p.RequirePermission(adminGroup.DELETE("/cnsis/:id/rewrite", p.deleteEndpointRewriteRules, p.auditAction(interfaces.AuditEndpointRewriteRules, auditParam("id"))), interfaces.PermissionEndpointsManage)
+
+	// Users and groups that can see an endpoint
+	p.RequirePermission(adminGroup.GET("/cnsis/:id/visibility", p.getEndpointVisibilityHandler), interfaces.PermissionEndpointsManage)
+	p.RequirePermission(adminGroup.PUT("/cnsis/:id/visibility", p.updateEndpointVisibility, p.auditAction(interfaces.AuditEndpointVisibility, auditParam("id"))), interfaces.PermissionEndpointsManage)
+
+	// Users' connections to endpoints
+	p.RequirePermission(adminGroup.GET("/connections", p.listEndpointConnections), interfaces.PermissionEndpointsManage)
+	p.RequirePermission(adminGroup.DELETE("/connections", p.revokeEndpointConnections, p.auditAction(interfaces.AuditConnectionsRevoke, auditEndpointTokenFilter)), interfaces.PermissionEndpointsManage)
+
+	// Local user management
+	p.RequirePermission(adminGroup.GET("/users/local", p.listLocalUsers), interfaces.PermissionUsersManage)
+	p.RequirePermission(adminGroup.POST("/users/local", p.createLocalUser, p.auditAction(interfaces.AuditLocalUserCreate, nil)), interfaces.PermissionUsersManage)
+	p.RequirePermission(adminGroup.GET("/users/local/:id", p.getLocalUserInfo), interfaces.PermissionUsersManage)
+	p.RequirePermission(adminGroup.PUT("/users/local/:id", p.updateLocalUser, p.auditAction(interfaces.AuditLocalUserUpdate, auditParam("id"))), interfaces.PermissionUsersManage)
+	p.RequirePermission(adminGroup.DELETE("/users/local/:id", p.deleteLocalUser, p.auditAction(interfaces.AuditLocalUserDelete, auditParam("id"))), interfaces.PermissionUsersManage)
+	p.RequirePermission(adminGroup.POST("/users/local/:id/password", p.resetLocalUserPassword, p.auditAction(interfaces.AuditLocalUserPassword, auditParam("id"))), interfaces.PermissionUsersManage)
+	p.RequirePermission(adminGroup.DELETE("/users/local/:id/totp", p.resetLocalUserTOTP, p.auditAction(interfaces.AuditLocalUserTOTPReset, auditParam("id"))), interfaces.PermissionUsersManage)
+
+	// Login lockouts
+	p.RequirePermission(adminGroup.GET("/lockouts", p.listLoginLockouts), interfaces.PermissionUsersManage)
+	p.RequirePermission(adminGroup.DELETE("/lockouts/:type/:key", p.unlockLogin, p.auditAction(interfaces.AuditLoginUnlock, auditParam("key"))), interfaces.PermissionUsersManage)
+
+	// Console roles
+	adminGroup.GET("/roles", p.listRoles)
+	adminGroup.POST("/roles/assignments", p.assignRole, p.auditAction(interfaces.AuditRoleAssign, nil))
+	adminGroup.DELETE("/roles/assignments/:type/:principal/:role", p.unassignRole, p.auditAction(interfaces.AuditRoleUnassign, auditRoleAssignment))
+
+	// Audit log
+	adminGroup.GET("/audit", p.listAuditLog)
 	// sessionGroup.DELETE("/cnsis", p.removeCluster)
 
 	// Serve up static resources
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/govau/cf-common/env"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
 )
 
@@ -155,3 +165,64 @@ func TestLoadDatabaseConfigWithInvalidSSLMode(t *testing.T) {
 		t.Errorf("Unexpected success - should not be able to load database configs with an invalid SSL Mode specified.")
 	}
 }
+
+func TestGetPreviousEncryptionKeys(t *testing.T) {
+	var pc interfaces.PortalConfig
+	key := bytes.Repeat([]byte{0x01}, 32)
+	previousKey := bytes.Repeat([]byte{0x02}, 32)
+	olderKey := bytes.Repeat([]byte{0x03}, 32)
+	expected := [][]byte{previousKey, olderKey}
+
+	pc.EncryptionKey = hex.EncodeToString(key)
+	pc.EncryptionPreviousKeys = hex.EncodeToString(previousKey) + ", " + hex.EncodeToString(olderKey) + ","
+	if keys, err := getPreviousEncryptionKeys(pc); err != nil || !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Unable to get the previous hex encoded keys: %v", err)
+	}
+
+	pc.EncryptionPreviousKeys = "not-hex"
+	if _, err := getPreviousEncryptionKeys(pc); err == nil {
+		t.Error("Invalid previous keys should be rejected")
+	}
+
+	volume, err := ioutil.TempDir("", "stratos-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(volume)
+	ioutil.WriteFile(filepath.Join(volume, "previous.key"), []byte(hex.EncodeToString(previousKey)), 0600)
+	ioutil.WriteFile(filepath.Join(volume, "older.key"), []byte(hex.EncodeToString(olderKey)), 0600)
+
+	pc = interfaces.PortalConfig{EncryptionKeyVolume: volume, EncryptionKeyFilename: "current.key"}
+	pc.EncryptionPreviousKeys = "previous.key,older.key"
+	if keys, err := getPreviousEncryptionKeys(pc); err != nil || !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Unable to get the previous keys from the volume: %v", err)
+	}
+
+	kek := bytes.Repeat([]byte{0x04}, 32)
+	kekFile := filepath.Join(volume, "kek")
+	ioutil.WriteFile(kekFile, []byte(hex.EncodeToString(kek)), 0600)
+	wrappedPrevious, _ := crypto.WrapKey(kek, previousKey)
+	wrappedOlder, _ := crypto.WrapKey(kek, olderKey)
+
+	pc = interfaces.PortalConfig{EncryptionKeyProvider: encryptionKeyProviderEnvelope, EncryptionKEKFile: kekFile}
+	pc.EncryptionPreviousKeys = wrappedPrevious + "," + wrappedOlder
+	if keys, err := getPreviousEncryptionKeys(pc); err != nil || !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Unable to unwrap the previous keys: %v", err)
+	}
+
+	pc.EncryptionPreviousKeys = hex.EncodeToString(previousKey)
+	if _, err := getPreviousEncryptionKeys(pc); err == nil {
+		t.Error("Previous keys that are not wrapped should be rejected")
+	}
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data": {"data": {"encryption_key": "%x", "previous": "%x", "older": "%x"}}}`, key, previousKey, olderKey)
+	}))
+	defer vault.Close()
+
+	pc = interfaces.PortalConfig{EncryptionKeyProvider: encryptionKeyProviderVault, VaultAddress: vault.URL, EncryptionKeyVaultPath: "secret/data/stratos"}
+	pc.EncryptionPreviousKeys = "previous,older"
+	if keys, err := getPreviousEncryptionKeys(pc); err != nil || !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Unable to get the previous keys from Vault: %v", err)
+	}
+}
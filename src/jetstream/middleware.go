@@ -65,9 +65,23 @@ func (p *portalProxy) sessionMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
 
 		p.removeEmptyCookie(c)
 
+		// Personal API tokens can be used instead of a session
+		if token, ok := getBearerAPIToken(c); ok {
+			if err := p.authenticateAPIToken(c, token); err != nil {
+				return err
+			}
+			return h(c)
+		}
+
 		userID, err := p.GetSessionValue(c, "user_id")
+		if err == nil {
+			err = p.checkSessionUserEnabled(c, userID)
+		}
 		if err == nil {
 			c.Set("user_id", userID)
+			if userGUID, ok := userID.(string); ok {
+				p.TokenRefresher.touch(userGUID)
+			}
 			return h(c)
 		}
 
@@ -94,6 +108,20 @@ func (p *portalProxy) sessionMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// checkSessionUserEnabled ends the sessions of local users that have been disabled - local sessions don't expire, so
+// would otherwise last until the user logs out
+func (p *portalProxy) checkSessionUserEnabled(c echo.Context, userID interface{}) error {
+	if interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] != interfaces.Local {
+		return nil
+	}
+
+	userGUID, ok := userID.(string)
+	if !ok {
+		return errors.New("Invalid session user")
+	}
+	return p.verifySessionLocal(c, userGUID, 0)
+}
+
 // Support for Angular XSRF
 func (p *portalProxy) xsrfMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -103,6 +131,11 @@ func (p *portalProxy) xsrfMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
 		if c.Request().Method == "GET" || c.Request().Method == "HEAD" {
 			return h(c)
 		}
+
+		// Requests authenticated with an API token don't come from a browser, so aren't open to XSRF
+		if getRequestAPIToken(c) != nil {
+			return h(c)
+		}
 		errMsg := "Failed to get stored XSRF token from user session"
 		token, err := p.GetSessionStringValue(c, XSRFTokenSessionName)
 		if err == nil {
@@ -178,23 +211,19 @@ func (p *portalProxy) setSecureCacheContentMiddleware(h echo.HandlerFunc) echo.H
 
 func (p *portalProxy) adminMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		// if user is an admin, passthrough request
-
-		// get the user guid
-		userID, err := p.GetSessionValue(c, "user_id")
-		if err == nil {
-			// check their admin status in UAA
-			u, err := p.GetStratosUser(userID.(string))
-			if err != nil {
-				return c.NoContent(http.StatusUnauthorized)
-			}
-
-			if u.Admin == true {
-				return h(c)
-			}
+		// API tokens need the admin scope, as well as belonging to a user with the permission
+		if token := getRequestAPIToken(c); token != nil && !token.HasScope(interfaces.APITokenScopeAdmin) {
+			return interfaces.NewHTTPShadowError(
+				http.StatusForbidden,
+				"API token does not have the admin scope",
+				"API token %s does not have the admin scope", token.GUID)
 		}
 
-		return handleSessionError(p.Config, c, errors.New("Unauthorized"), false, "You must be a Stratos admin to access this API")
+		// Admin routes need console.admin, unless they have said otherwise
+		if err := p.checkPermission(c, interfaces.PermissionConsoleAdmin); err != nil {
+			return err
+		}
+		return h(c)
 	}
 }
 
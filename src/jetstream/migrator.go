@@ -14,6 +14,11 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/localusers"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
 )
 
 const (
@@ -64,6 +69,10 @@ func migrateDatabase(env *env.VarSet) bool {
 		statusRun()
 	case "dbversion":
 		dbVersionRun()
+	case "rekey":
+		rekeyRun(env)
+	case "wrapkey":
+		wrapKeyRun(env)
 	default:
 		log.Fatal("Command not supported")
 	}
@@ -160,6 +169,93 @@ func statusRun() {
 	}
 }
 
+// Re-encrypt the secrets stored in the database with the current encryption key, once it has been rotated
+func rekeyRun(env *env.VarSet) {
+
+	var portalConfig interfaces.PortalConfig
+	portalConfig, err := loadPortalConfig(portalConfig, env)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if portalConfig.EncryptionKeyInBytes, err = getEncryptionKey(portalConfig); err != nil {
+		log.Fatal(err)
+	}
+	if err = setPreviousEncryptionKeys(portalConfig); err != nil {
+		log.Fatal(err)
+	}
+
+	conf, err := dbConfFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := goose.OpenDBFromDBConf(conf)
+	if err != nil {
+		log.Fatal("Failed to open database connection")
+	}
+	defer db.Close()
+
+	databaseProvider := datastore.PGSQL
+	switch conf.Driver.Dialect.(type) {
+	case *goose.MySqlDialect:
+		databaseProvider = datastore.MYSQL
+	case *goose.Sqlite3Dialect:
+		databaseProvider = datastore.SQLITE
+	}
+	tokens.InitRepositoryProvider(databaseProvider)
+	cnsis.InitRepositoryProvider(databaseProvider)
+	localusers.InitRepositoryProvider(databaseProvider)
+
+	key := portalConfig.EncryptionKeyInBytes
+	log.Printf("Re-encrypting secrets with key %s\n", crypto.KeyID(key))
+
+	tokenRepo, _ := tokens.NewPgsqlTokenRepository(db)
+	updated, err := tokenRepo.ReEncrypt(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("    %d tokens re-encrypted\n", updated)
+
+	cnsiRepo, _ := cnsis.NewPostgresCNSIRepository(db)
+	if updated, err = cnsiRepo.ReEncrypt(key); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("    %d endpoints re-encrypted\n", updated)
+
+	localUsersRepo, _ := localusers.NewPgsqlLocalUsersRepository(db)
+	if updated, err = localUsersRepo.ReEncryptTOTP(key); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("    %d TOTP secrets re-encrypted\n", updated)
+}
+
+// Print the encryption key wrapped with the key-encryption-key, for the envelope encryption key provider
+func wrapKeyRun(env *env.VarSet) {
+
+	var portalConfig interfaces.PortalConfig
+	portalConfig, err := loadPortalConfig(portalConfig, env)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Wrap the key from ENCRYPTION_KEY or the key volume
+	portalConfig.EncryptionKeyProvider = ""
+	key, err := getEncryptionKey(portalConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	kek, err := newKEKProvider(portalConfig).EncryptionKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	wrappedKey, err := crypto.WrapKey(kek, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("ENCRYPTION_KEY_WRAPPED=%s\n", wrappedKey)
+}
+
 func printMigrationStatus(db *sql.DB, version int64, script string) {
 	var row goose.MigrationRecord
 	q := fmt.Sprintf("SELECT tstamp, is_applied FROM goose_db_version WHERE version_id=%d ORDER BY tstamp DESC LIMIT 1", version)
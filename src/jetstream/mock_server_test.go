@@ -136,6 +136,8 @@ func setupPortalProxy(db *sql.DB) *portalProxy {
 		SessionStoreSecret:   "hiddenraisinsohno!",
 		EncryptionKeyInBytes: mockEncryptionKey,
 		CFAdminIdentifier:    CFAdminIdentifier,
+		// The mock UAA tokens are not signed
+		SkipTokenVerification: true,
 	}
 
 	pp := newPortalProxy(pc, db, nil, nil, env.NewVarSet())
@@ -156,18 +158,18 @@ func expectOneRow() sqlmock.Rows {
 
 func expectCFRow() sqlmock.Rows {
 	return sqlmock.NewRows(rowFieldsForCNSI).
-		AddRow(mockCFGUID, "Some fancy CF Cluster", "cf", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "")
+		AddRow(mockCFGUID, "Some fancy CF Cluster", "cf", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "", "", "", nil, "")
 }
 
 func expectCERow() sqlmock.Rows {
 	return sqlmock.NewRows(rowFieldsForCNSI).
-		AddRow(mockCEGUID, "Some fancy HCE Cluster", "hce", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, "", true, mockClientId, cipherClientSecret, true, "", "")
+		AddRow(mockCEGUID, "Some fancy HCE Cluster", "hce", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, "", true, mockClientId, cipherClientSecret, true, "", "", "", "", nil, "")
 }
 
 func expectCFAndCERows() sqlmock.Rows {
 	return sqlmock.NewRows(rowFieldsForCNSI).
-		AddRow(mockCFGUID, "Some fancy CF Cluster", "cf", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, false, "", "").
-		AddRow(mockCEGUID, "Some fancy HCE Cluster", "hce", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, "", true, mockClientId, cipherClientSecret, false, "", "")
+		AddRow(mockCFGUID, "Some fancy CF Cluster", "cf", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, false, "", "", "", "", nil, "").
+		AddRow(mockCEGUID, "Some fancy HCE Cluster", "hce", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, "", true, mockClientId, cipherClientSecret, false, "", "", "", "", nil, "")
 }
 
 func expectTokenRow() sqlmock.Rows {
@@ -277,12 +279,23 @@ const (
 	addLocalUser        = `INSERT INTO local_users (.+)`
 	findPasswordHash    = `SELECT password_hash FROM local_users WHERE (.+)`
 	findUserScope       = `SELECT user_scope FROM local_users WHERE (.+)`
+	findLocalUser       = `SELECT user_name, user_email, user_scope, given_name, family_name, disabled FROM local_users WHERE (.+)`
+	listLocalUsers      = `SELECT (.+) FROM local_users ORDER BY user_name`
+	findTOTP            = `SELECT (.+) FROM local_users_totp WHERE (.+)`
+	findRoleAssignments = `SELECT (.+) FROM role_assignments WHERE (.+)`
+	listCNSIVisibility  = `SELECT (.+) FROM cnsi_visibility`
 	updateLastLoginTime = `UPDATE local_users (.+)`
 	findLastLoginTime   = `SELECT last_login FROM local_users WHERE (.+)`
 	getDbVersion        = `SELECT version_id FROM goose_db_version WHERE is_applied = '1' ORDER BY id DESC LIMIT 1`
 )
 
-var rowFieldsForCNSI = []string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso", "sub_type", "meta_data"}
+var rowFieldsForCNSI = []string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso", "sub_type", "meta_data", "ca_cert", "client_cert", "client_cert_key", "proxy_url"}
+
+var rowFieldsForLocalUser = []string{"user_name", "user_email", "user_scope", "given_name", "family_name", "disabled"}
+
+var rowFieldsForRoleAssignment = []string{"principal_type", "principal", "role"}
+
+var rowFieldsForCNSIVisibility = []string{"cnsi_guid", "principal_type", "principal"}
 
 var mockEncryptionKey = make([]byte, 32)
 
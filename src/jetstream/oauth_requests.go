@@ -20,6 +20,7 @@ func (p *portalProxy) OAuthHandlerFunc(cnsiRequest *interfaces.CNSIRequest, req
 			expTime := time.Unix(tokenRec.TokenExpiry, 0)
 			if got401 || expTime.Before(time.Now()) {
 				refreshedTokenRec, err := refreshOAuthTokenFunc(cnsi.SkipSSLValidation, cnsiRequest.GUID, cnsiRequest.UserGUID, cnsi.ClientId, cnsi.ClientSecret, cnsi.TokenEndpoint)
+				p.TokenRefresher.recordRefresh(cnsiRequest.GUID, cnsiRequest.UserGUID, err)
 				if err != nil {
 					log.Info(err)
 					return nil, fmt.Errorf("Couldn't refresh token for CNSI with GUID %s", cnsiRequest.GUID)
@@ -29,10 +30,10 @@ func (p *portalProxy) OAuthHandlerFunc(cnsiRequest *interfaces.CNSIRequest, req
 			req.Header.Set("Authorization", "bearer "+tokenRec.AuthToken)
 
 			var client http.Client
-			client = p.GetHttpClientForRequest(req, cnsi.SkipSSLValidation)
+			client = p.GetHttpClientForEndpointRequest(req, cnsi.SkipSSLValidation, cnsi.Connection)
 			res, err := client.Do(req)
 			if err != nil {
-				return nil, fmt.Errorf("Request failed: %v", err)
+				return nil, &endpointRequestError{err: err}
 			}
 
 			if res.StatusCode != 401 {
@@ -42,6 +43,8 @@ func (p *portalProxy) OAuthHandlerFunc(cnsiRequest *interfaces.CNSIRequest, req
 			if got401 {
 				return res, errors.New("Failed to authorize")
 			}
+			// We're going to retry, so discard this response
+			res.Body.Close()
 			got401 = true
 		}
 	}
@@ -77,16 +80,21 @@ func (p *portalProxy) RefreshOAuthToken(skipSSLValidation bool, cnsiGUID, userGU
 		return t, fmt.Errorf("Info could not be found for user with GUID %s", userGUID)
 	}
 
+	connection, err := p.getEndpointConnection(cnsiGUID)
+	if err != nil {
+		return t, err
+	}
+
 	tokenEndpointWithPath := fmt.Sprintf("%s/oauth/token", tokenEndpoint)
 
-	uaaRes, err := p.getUAATokenWithRefreshToken(skipSSLValidation, userToken.RefreshToken, client, clientSecret, tokenEndpointWithPath, "")
+	uaaRes, err := p.getUAATokenWithRefreshToken(skipSSLValidation, connection, userToken.RefreshToken, client, clientSecret, tokenEndpointWithPath, "")
 	if err != nil {
-		return t, fmt.Errorf("Token refresh request failed: %v", err)
+		return t, interfaces.NewTokenRefreshError(err)
 	}
 
-	u, err := p.GetUserTokenInfo(uaaRes.AccessToken)
+	u, err := p.verifyUAAToken(uaaRes.AccessToken, tokenEndpointWithPath, client, skipSSLValidation, connection)
 	if err != nil {
-		return t, fmt.Errorf("Could not get user token info from access token")
+		return t, fmt.Errorf("Could not get user token info from access token: %v", err)
 	}
 
 	u.UserGUID = userGUID
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SermoDigital/jose/jws"
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// How long the OpenID Connect discovery document is cached
+const oidcDiscoveryCacheTTL = time.Hour
+
+// Session values used during the OpenID Connect login flow
+const (
+	oidcStateSessionName        = "oidc_state"
+	oidcNonceSessionName        = "oidc_nonce"
+	oidcCodeVerifierSessionName = "oidc_code_verifier"
+	oidcRedirectURISessionName  = "oidc_redirect_uri"
+	oidcReturnURLSessionName    = "oidc_return_url"
+)
+
+// Defaults for the OpenID Connect settings
+const (
+	defaultOIDCScopes        = "openid profile email"
+	defaultOIDCUserIDClaim   = "sub"
+	defaultOIDCUserNameClaim = "preferred_username"
+	defaultOIDCEmailClaim    = "email"
+	defaultOIDCAdminClaim    = "groups"
+)
+
+// OIDCProviderMetadata - The parts of the OpenID Connect discovery document that we use
+type OIDCProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+var oidcProviders = struct {
+	sync.Mutex
+	metadata map[string]*OIDCProviderMetadata
+	fetched  map[string]time.Time
+}{
+	metadata: make(map[string]*OIDCProviderMetadata),
+	fetched:  make(map[string]time.Time),
+}
+
+func (p *portalProxy) isOIDCLogin() bool {
+	return interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] == interfaces.OIDC
+}
+
+// initialiseOIDCConfiguration checks the OpenID Connect settings and fills in the defaults
+func initialiseOIDCConfiguration(consoleConfig *interfaces.ConsoleConfig) error {
+	if len(consoleConfig.OIDCIssuerURL) == 0 {
+		return errors.New("OIDC_ISSUER_URL not found")
+	}
+	if issuer, err := url.Parse(consoleConfig.OIDCIssuerURL); err != nil || len(issuer.Host) == 0 {
+		return fmt.Errorf("OIDC_ISSUER_URL is not a valid URL: %s", consoleConfig.OIDCIssuerURL)
+	}
+
+	if len(consoleConfig.OIDCScopes) == 0 {
+		consoleConfig.OIDCScopes = defaultOIDCScopes
+	}
+	if len(consoleConfig.OIDCUserIDClaim) == 0 {
+		consoleConfig.OIDCUserIDClaim = defaultOIDCUserIDClaim
+	}
+	if len(consoleConfig.OIDCUserNameClaim) == 0 {
+		consoleConfig.OIDCUserNameClaim = defaultOIDCUserNameClaim
+	}
+	if len(consoleConfig.OIDCEmailClaim) == 0 {
+		consoleConfig.OIDCEmailClaim = defaultOIDCEmailClaim
+	}
+	if len(consoleConfig.OIDCAdminClaim) == 0 {
+		consoleConfig.OIDCAdminClaim = defaultOIDCAdminClaim
+	}
+	if len(consoleConfig.ConsoleAdminScope) == 0 {
+		consoleConfig.ConsoleAdminScope = UAAAdminIdentifier
+	}
+	if len(consoleConfig.OIDCAdminValue) == 0 {
+		consoleConfig.OIDCAdminValue = consoleConfig.ConsoleAdminScope
+	}
+
+	// Login is always via a redirect to the provider
+	consoleConfig.UseSSO = true
+	return nil
+}
+
+// getOIDCProvider returns the console's provider's metadata from its discovery document
+func (p *portalProxy) getOIDCProvider() (*OIDCProviderMetadata, error) {
+	issuer := strings.TrimRight(p.Config.ConsoleConfig.OIDCIssuerURL, "/")
+	metadata, err := p.getProviderMetadata(issuer, p.Config.ConsoleConfig.SkipSSLValidation, interfaces.EndpointConnectionSettings{})
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimRight(metadata.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OpenID Connect discovery document is for issuer '%s', expected '%s'", metadata.Issuer, issuer)
+	}
+	if len(metadata.AuthorizationEndpoint) == 0 || len(metadata.TokenEndpoint) == 0 || len(metadata.JWKSURI) == 0 {
+		return nil, errors.New("OpenID Connect discovery document is missing the authorization, token or JWKS endpoint")
+	}
+	return metadata, nil
+}
+
+// getProviderMetadata returns the metadata from the discovery document of the provider at the given URL
+func (p *portalProxy) getProviderMetadata(providerURL string, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings) (*OIDCProviderMetadata, error) {
+	oidcProviders.Lock()
+	metadata, ok := oidcProviders.metadata[providerURL]
+	fetched := oidcProviders.fetched[providerURL]
+	oidcProviders.Unlock()
+	if ok && time.Since(fetched) < oidcDiscoveryCacheTTL {
+		return metadata, nil
+	}
+
+	req, err := http.NewRequest("GET", providerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	h := p.GetHttpClientForEndpoint(skipSSLValidation, connection)
+	res, err := h.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return nil, interfaces.LogHTTPError(res, err)
+	}
+	defer res.Body.Close()
+
+	metadata = &OIDCProviderMetadata{}
+	if err := json.NewDecoder(res.Body).Decode(metadata); err != nil {
+		return nil, fmt.Errorf("Unable to decode OpenID Connect discovery document: %v", err)
+	}
+
+	oidcProviders.Lock()
+	oidcProviders.metadata[providerURL] = metadata
+	oidcProviders.fetched[providerURL] = time.Now()
+	oidcProviders.Unlock()
+	return metadata, nil
+}
+
+func getOIDCRedirectURI(base string) string {
+	baseURL, _ := url.Parse(base)
+	baseURL.Path = ""
+	baseURL.RawQuery = ""
+	baseURL.Fragment = ""
+	return fmt.Sprintf("%s/pp/v1/auth/sso_login_callback", strings.TrimRight(baseURL.String(), "?"))
+}
+
+func generateOIDCSecret() (string, error) {
+	b, err := generateRandomBytes(32)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Start the OpenID Connect authorization code flow (with PKCE). The return URL has already been checked against the whitelist
+func (p *portalProxy) initOIDCLogin(c echo.Context, returnURL string) error {
+	log.Debug("initOIDCLogin")
+	provider, err := p.getOIDCProvider()
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusServiceUnavailable,
+			"Unable to contact the identity provider",
+			"Unable to get OpenID Connect provider metadata: %v", err)
+	}
+
+	sessionValues := make(map[string]interface{})
+	for _, name := range []string{oidcStateSessionName, oidcNonceSessionName, oidcCodeVerifierSessionName} {
+		value, err := generateOIDCSecret()
+		if err != nil {
+			return err
+		}
+		sessionValues[name] = value
+	}
+	redirectURI := getOIDCRedirectURI(returnURL)
+	sessionValues[oidcRedirectURISessionName] = redirectURI
+	sessionValues[oidcReturnURLSessionName] = returnURL
+	if err := p.setSessionValues(c, sessionValues); err != nil {
+		return err
+	}
+
+	challenge := sha256.Sum256([]byte(sessionValues[oidcCodeVerifierSessionName].(string)))
+
+	authURL, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		return fmt.Errorf("Invalid authorization endpoint: %v", err)
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.Config.ConsoleConfig.ConsoleClient)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", p.Config.ConsoleConfig.OIDCScopes)
+	query.Set("state", sessionValues[oidcStateSessionName].(string))
+	query.Set("nonce", sessionValues[oidcNonceSessionName].(string))
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return c.Redirect(http.StatusTemporaryRedirect, authURL.String())
+}
+
+// Callback - invoked by the provider after the user has logged in
+func (p *portalProxy) oidcLoginCallback(c echo.Context) error {
+	log.Debug("oidcLoginCallback")
+	returnURL, err := p.GetSessionStringValue(c, oidcReturnURLSessionName)
+	if err != nil || len(returnURL) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"SSO Login: Login was not started",
+			"SSO Login: Login was not started")
+	}
+
+	_, err = p.doOIDCLogin(c)
+
+	// The login flow values are only good for one attempt
+	for _, name := range []string{oidcStateSessionName, oidcNonceSessionName, oidcCodeVerifierSessionName, oidcRedirectURISessionName, oidcReturnURLSessionName} {
+		p.unsetSessionValue(c, name)
+	}
+
+	if err != nil {
+		// Send error as query string param
+		msg := err.Error()
+		if httpError, ok := err.(interfaces.ErrHTTPShadow); ok {
+			msg = httpError.UserFacingError
+		}
+		returnURL = fmt.Sprintf("%s/login?SSO_Message=%s", returnURL, url.QueryEscape(msg))
+	}
+
+	return c.Redirect(http.StatusTemporaryRedirect, returnURL)
+}
+
+func (p *portalProxy) doOIDCLogin(c echo.Context) (*interfaces.LoginRes, error) {
+	log.Debug("doOIDCLogin")
+
+	if providerError := c.QueryParam("error"); len(providerError) > 0 {
+		msg := c.QueryParam("error_description")
+		if len(msg) == 0 {
+			msg = providerError
+		}
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			msg,
+			"OIDC Login failed: %s", providerError)
+	}
+
+	expectedState, err := p.GetSessionStringValue(c, oidcStateSessionName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(expectedState), []byte(c.QueryParam("state"))) != 1 {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Access Denied",
+			"OIDC Login failed: state does not match")
+	}
+	nonce, _ := p.GetSessionStringValue(c, oidcNonceSessionName)
+	codeVerifier, _ := p.GetSessionStringValue(c, oidcCodeVerifierSessionName)
+	redirectURI, _ := p.GetSessionStringValue(c, oidcRedirectURISessionName)
+
+	provider, err := p.getOIDCProvider()
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusServiceUnavailable,
+			"Unable to contact the identity provider",
+			"Unable to get OpenID Connect provider metadata: %v", err)
+	}
+
+	body := url.Values{}
+	body.Set("grant_type", "authorization_code")
+	body.Set("code", c.QueryParam("code"))
+	body.Set("redirect_uri", redirectURI)
+	body.Set("code_verifier", codeVerifier)
+	tokenRes, err := p.getOIDCToken(provider, body)
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Access Denied",
+			"OIDC Login failed: %v", err)
+	}
+
+	claims, err := p.verifyOIDCIDToken(provider, tokenRes.IDToken, nonce)
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Access Denied",
+			"OIDC Login failed: %v", err)
+	}
+
+	user, err := p.oidcUserFromClaims(claims)
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Access Denied",
+			"OIDC Login failed: %v", err)
+	}
+	expiry, _ := claims.Expiration()
+
+	sessionValues := make(map[string]interface{})
+	sessionValues["user_id"] = user.GUID
+	sessionValues["exp"] = expiry.Unix()
+	if err = p.setSessionValues(c, sessionValues); err != nil {
+		return nil, err
+	}
+
+	if err = p.handleSessionExpiryHeader(c); err != nil {
+		return nil, err
+	}
+
+	// The ID token is kept as the auth token, since it's what describes the user
+	u := interfaces.JWTUserTokenInfo{
+		UserGUID:    user.GUID,
+		UserName:    user.Name,
+		TokenExpiry: expiry.Unix(),
+	}
+	if _, err = p.saveAuthToken(u, tokenRes.IDToken, tokenRes.RefreshToken); err != nil {
+		return nil, err
+	}
+
+	if err = p.ExecuteLoginHooks(c); err != nil {
+		log.Warnf("Login hooks failed: %v", err)
+	}
+
+	return &interfaces.LoginRes{
+		Account:     user.Name,
+		TokenExpiry: expiry.Unix(),
+		APIEndpoint: nil,
+		Admin:       user.Admin,
+	}, nil
+}
+
+// getOIDCToken makes a request to the provider's token endpoint. Public clients (no secret) identify themselves in the body
+func (p *portalProxy) getOIDCToken(provider *OIDCProviderMetadata, body url.Values) (*interfaces.UAAResponse, error) {
+	log.Debug("getOIDCToken")
+	client := p.Config.ConsoleConfig.ConsoleClient
+	clientSecret := p.Config.ConsoleConfig.ConsoleClientSecret
+	if len(clientSecret) == 0 {
+		body.Set("client_id", client)
+	}
+
+	req, err := http.NewRequest("POST", provider.TokenEndpoint, strings.NewReader(body.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create token request: %v", err)
+	}
+	if len(clientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(client), url.QueryEscape(clientSecret))
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.Header.Set("Accept", "application/json")
+
+	h := p.GetHttpClientForRequest(req, p.Config.ConsoleConfig.SkipSSLValidation)
+	res, err := h.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return nil, interfaces.LogHTTPError(res, err)
+	}
+	defer res.Body.Close()
+
+	response := &interfaces.UAAResponse{}
+	if err = json.NewDecoder(res.Body).Decode(response); err != nil {
+		return nil, fmt.Errorf("Unable to decode token response: %v", err)
+	}
+	return response, nil
+}
+
+// verifyOIDCIDToken checks the ID token's signature against the provider's keys, and that it was issued by the provider
+// for us. The nonce is only checked when given, since refreshed ID tokens need not have one
+func (p *portalProxy) verifyOIDCIDToken(provider *OIDCProviderMetadata, idToken, nonce string) (jwt.Claims, error) {
+	if len(idToken) == 0 {
+		return nil, errors.New("No ID token in the token response")
+	}
+
+	expected := jwt.Claims{}
+	expected.SetIssuer(provider.Issuer)
+	expected.SetAudience(p.Config.ConsoleConfig.ConsoleClient)
+	claims, err := p.verifyJWT(idToken, provider.JWKSURI, p.Config.ConsoleConfig.SkipSSLValidation, interfaces.EndpointConnectionSettings{}, expected)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) > 0 {
+		tokenNonce, _ := claims.Get("nonce").(string)
+		if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+			return nil, errors.New("ID token nonce does not match")
+		}
+	}
+	return claims, nil
+}
+
+// getClaim gets a claim, which can be nested using '.' - e.g. 'realm_access.roles'
+func getClaim(claims map[string]interface{}, name string) interface{} {
+	var value interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = values[part]
+	}
+	return value
+}
+
+// getClaimValues gets a claim as a list of strings. Strings are split on spaces, as for the 'scope' claim
+func getClaimValues(claims map[string]interface{}, name string) []string {
+	switch value := getClaim(claims, name).(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// oidcUserFromClaims maps the ID token's claims to the Stratos user
+func (p *portalProxy) oidcUserFromClaims(claims map[string]interface{}) (*interfaces.ConnectedUser, error) {
+	consoleConfig := p.Config.ConsoleConfig
+
+	userGUID, _ := getClaim(claims, consoleConfig.OIDCUserIDClaim).(string)
+	if len(userGUID) == 0 {
+		return nil, fmt.Errorf("ID token does not have the user ID claim '%s'", consoleConfig.OIDCUserIDClaim)
+	}
+	name, _ := getClaim(claims, consoleConfig.OIDCUserNameClaim).(string)
+	if len(name) == 0 {
+		name = userGUID
+	}
+	email, _ := getClaim(claims, consoleConfig.OIDCEmailClaim).(string)
+
+	user := &interfaces.ConnectedUser{
+		GUID:   userGUID,
+		Name:   name,
+		Email:  email,
+		Scopes: getClaimValues(claims, consoleConfig.OIDCAdminClaim),
+	}
+
+	// Admin if the claim is true, or contains the admin value
+	if admin, ok := getClaim(claims, consoleConfig.OIDCAdminClaim).(bool); ok {
+		user.Admin = admin
+	} else {
+		user.Admin = ArrayContainsString(user.Scopes, consoleConfig.OIDCAdminValue)
+	}
+	if user.Scopes == nil {
+		user.Scopes = []string{}
+	}
+	return user, nil
+}
+
+// getOIDCUser gets the user from the ID token saved at login. This was verified when it was issued
+func (p *portalProxy) getOIDCUser(userGUID string) (*interfaces.ConnectedUser, error) {
+	log.Debug("getOIDCUser")
+	tokenRecord, err := p.GetUAATokenRecord(userGUID)
+	if err != nil {
+		return nil, errors.New("Unable to retrieve ID token record")
+	}
+
+	idToken, err := jws.ParseJWT([]byte(tokenRecord.AuthToken))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse ID token: %v", err)
+	}
+
+	user, err := p.oidcUserFromClaims(idToken.Claims())
+	if err != nil {
+		return nil, err
+	}
+	user.GUID = userGUID
+	return user, nil
+}
+
+// verifySessionOIDC refreshes the ID token if the session has expired
+func (p *portalProxy) verifySessionOIDC(c echo.Context, sessionUser string, sessionExpireTime int64) error {
+	if !time.Now().After(time.Unix(sessionExpireTime, 0)) {
+		// Still need to extend the expires_on of the Session
+		return p.setSessionValues(c, nil)
+	}
+
+	tokenRecord, err := p.refreshOIDCToken(sessionUser)
+	if err != nil {
+		log.Errorf("Could not refresh ID token: %v", err)
+		return echo.NewHTTPError(http.StatusForbidden, "Could not refresh ID token")
+	}
+
+	sessionValues := make(map[string]interface{})
+	sessionValues["user_id"] = sessionUser
+	sessionValues["exp"] = tokenRecord.TokenExpiry
+	return p.setSessionValues(c, sessionValues)
+}
+
+// refreshOIDCToken uses the refresh token to get a new ID token for the user
+func (p *portalProxy) refreshOIDCToken(userGUID string) (t interfaces.TokenRecord, err error) {
+	log.Debug("refreshOIDCToken")
+	tokenRecord, err := p.GetUAATokenRecord(userGUID)
+	if err != nil {
+		return t, fmt.Errorf("ID token could not be found for user with GUID %s", userGUID)
+	}
+	if len(tokenRecord.RefreshToken) == 0 {
+		return t, errors.New("No refresh token - the user must log in again")
+	}
+
+	provider, err := p.getOIDCProvider()
+	if err != nil {
+		return t, err
+	}
+
+	body := url.Values{}
+	body.Set("grant_type", "refresh_token")
+	body.Set("refresh_token", tokenRecord.RefreshToken)
+	tokenRes, err := p.getOIDCToken(provider, body)
+	if err != nil {
+		return t, fmt.Errorf("Token refresh request failed: %v", err)
+	}
+
+	claims, err := p.verifyOIDCIDToken(provider, tokenRes.IDToken, "")
+	if err != nil {
+		return t, err
+	}
+	subject, _ := getClaim(claims, p.Config.ConsoleConfig.OIDCUserIDClaim).(string)
+	if subject != userGUID {
+		return t, errors.New("Refreshed ID token is for a different user")
+	}
+	expiry, _ := claims.Expiration()
+
+	refreshToken := tokenRes.RefreshToken
+	if len(refreshToken) == 0 {
+		refreshToken = tokenRecord.RefreshToken
+	}
+	u := interfaces.JWTUserTokenInfo{
+		UserGUID:    userGUID,
+		TokenExpiry: expiry.Unix(),
+	}
+	return p.saveAuthToken(u, tokenRes.IDToken, refreshToken)
+}
+
+// Logout of the provider, if it supports it
+func (p *portalProxy) getOIDCLogoutURL(state string) (string, error) {
+	provider, err := p.getOIDCProvider()
+	if err != nil {
+		return "", err
+	}
+	if len(provider.EndSessionEndpoint) == 0 {
+		return "", errors.New("Provider does not support logout")
+	}
+
+	logoutURL, err := url.Parse(provider.EndSessionEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := logoutURL.Query()
+	query.Set("client_id", p.Config.ConsoleConfig.ConsoleClient)
+	query.Set("post_logout_redirect_uri", getSSORedirectURI(state, "logout", ""))
+	logoutURL.RawQuery = query.Encode()
+	return logoutURL.String(), nil
+}
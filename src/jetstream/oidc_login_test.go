@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/SermoDigital/jose/crypto"
+	"github.com/SermoDigital/jose/jws"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const mockOIDCClient = "stratos"
+
+// mockOIDCProvider is an OpenID Connect provider that issues ID tokens signed with its key
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+	claims map[string]interface{}
+	// Form values of the last token request
+	tokenRequest url.Values
+}
+
+func newMockOIDCProvider() *mockOIDCProvider {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	provider := &mockOIDCProvider{key: key, kid: "key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&OIDCProviderMetadata{
+			Issuer:                provider.server.URL,
+			AuthorizationEndpoint: provider.server.URL + "/authorize",
+			TokenEndpoint:         provider.server.URL + "/token",
+			JWKSURI:               provider.server.URL + "/keys",
+			EndSessionEndpoint:    provider.server.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&JSONWebKeySet{Keys: []JSONWebKey{{
+			Kty: "RSA",
+			Kid: provider.kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(provider.key.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(provider.key.PublicKey.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		provider.tokenRequest = r.PostForm
+		claims := map[string]interface{}{}
+		for k, v := range provider.claims {
+			claims[k] = v
+		}
+		json.NewEncoder(w).Encode(&interfaces.UAAResponse{
+			AccessToken:  "opaque-access-token",
+			RefreshToken: "refresh-token",
+			IDToken:      provider.sign(claims, provider.key, provider.kid),
+		})
+	})
+	provider.server = httptest.NewServer(mux)
+	return provider
+}
+
+func (m *mockOIDCProvider) sign(claims map[string]interface{}, key *rsa.PrivateKey, kid string) string {
+	token := jws.NewJWT(jws.Claims(claims), crypto.SigningMethodRS256)
+	token.(jws.JWS).Protected().Set("kid", kid)
+	raw, _ := token.Serialize(key)
+	return string(raw)
+}
+
+func (m *mockOIDCProvider) idTokenClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                m.server.URL,
+		"aud":                mockOIDCClient,
+		"sub":                mockUserGUID,
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"preferred_username": "jdoe",
+		"email":              "jdoe@example.com",
+		"realm_access":       map[string]interface{}{"roles": []interface{}{"user", "stratos.admin"}},
+	}
+}
+
+func setupOIDCPortalProxy(pp *portalProxy, provider *mockOIDCProvider) {
+	pp.Config.ConsoleConfig = &interfaces.ConsoleConfig{
+		AuthEndpointType: string(interfaces.OIDC),
+		ConsoleClient:    mockOIDCClient,
+		OIDCIssuerURL:    provider.server.URL,
+		OIDCAdminClaim:   "realm_access.roles",
+	}
+	initialiseOIDCConfiguration(pp.Config.ConsoleConfig)
+	pp.Config.SSOLogin = pp.Config.ConsoleConfig.UseSSO
+}
+
+func TestOIDCLogin(t *testing.T) {
+	t.Parallel()
+
+	Convey("OIDC login tests", t, func() {
+		provider := newMockOIDCProvider()
+		defer provider.server.Close()
+
+		Convey("should redirect to the provider with PKCE", func() {
+			req := setupMockReq("GET", "/pp/v1/auth/sso_login?state="+url.QueryEscape("https://stratos.example.com/home"), nil)
+			res, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+			setupOIDCPortalProxy(pp, provider)
+
+			So(pp.initSSOlogin(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusTemporaryRedirect)
+
+			location, err := url.Parse(res.Header().Get("Location"))
+			So(err, ShouldBeNil)
+			So(location.Path, ShouldEqual, "/authorize")
+			query := location.Query()
+			So(query.Get("client_id"), ShouldEqual, mockOIDCClient)
+			So(query.Get("redirect_uri"), ShouldEqual, "https://stratos.example.com/pp/v1/auth/sso_login_callback")
+			So(query.Get("scope"), ShouldEqual, defaultOIDCScopes)
+			So(query.Get("code_challenge_method"), ShouldEqual, "S256")
+			So(query.Get("code_challenge"), ShouldNotBeEmpty)
+
+			state, _ := pp.GetSessionStringValue(ctx, oidcStateSessionName)
+			So(query.Get("state"), ShouldEqual, state)
+			nonce, _ := pp.GetSessionStringValue(ctx, oidcNonceSessionName)
+			So(query.Get("nonce"), ShouldEqual, nonce)
+		})
+
+		Convey("callback", func() {
+			req := setupMockReq("GET", "/pp/v1/auth/sso_login_callback?code=auth-code&state=expected-state", nil)
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			defer db.Close()
+			setupOIDCPortalProxy(pp, provider)
+
+			So(pp.setSessionValues(ctx, map[string]interface{}{
+				oidcStateSessionName:        "expected-state",
+				oidcNonceSessionName:        "expected-nonce",
+				oidcCodeVerifierSessionName: "code-verifier",
+				oidcRedirectURISessionName:  "https://stratos.example.com/pp/v1/auth/sso_login_callback",
+				oidcReturnURLSessionName:    "https://stratos.example.com",
+			}), ShouldBeNil)
+			provider.claims = provider.idTokenClaims()
+
+			Convey("should log in with a valid ID token", func() {
+				provider.claims["nonce"] = "expected-nonce"
+				mock.ExpectQuery(selectAnyFromTokens).
+					WillReturnRows(expectNoRows())
+				mock.ExpectExec(insertIntoTokens).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				resp, err := pp.doOIDCLogin(ctx)
+				So(err, ShouldBeNil)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+				So(resp.Account, ShouldEqual, "jdoe")
+				So(resp.Admin, ShouldBeTrue)
+
+				So(provider.tokenRequest.Get("code"), ShouldEqual, "auth-code")
+				So(provider.tokenRequest.Get("code_verifier"), ShouldEqual, "code-verifier")
+				So(provider.tokenRequest.Get("client_id"), ShouldEqual, mockOIDCClient)
+
+				userGUID, _ := pp.GetSessionStringValue(ctx, "user_id")
+				So(userGUID, ShouldEqual, mockUserGUID)
+			})
+
+			Convey("should fail if the nonce does not match", func() {
+				provider.claims["nonce"] = "another-nonce"
+				_, err := pp.doOIDCLogin(ctx)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("should fail if the state does not match", func() {
+				ctx.Request().URL.RawQuery = "code=auth-code&state=another-state"
+				_, err := pp.doOIDCLogin(ctx)
+				So(err, ShouldNotBeNil)
+				So(provider.tokenRequest, ShouldBeNil)
+			})
+
+			Convey("should fail if the ID token is for another client", func() {
+				provider.claims["nonce"] = "expected-nonce"
+				provider.claims["aud"] = "another-client"
+				_, err := pp.doOIDCLogin(ctx)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestVerifyJWT(t *testing.T) {
+	t.Parallel()
+
+	Convey("JWT verification tests", t, func() {
+		provider := newMockOIDCProvider()
+		defer provider.server.Close()
+
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+		jwksURL := provider.server.URL + "/keys"
+
+		Convey("should verify a valid token", func() {
+			claims, err := pp.verifyJWT(provider.sign(provider.idTokenClaims(), provider.key, provider.kid), jwksURL, false, interfaces.EndpointConnectionSettings{}, nil)
+			So(err, ShouldBeNil)
+			So(claims.Get("sub"), ShouldEqual, mockUserGUID)
+		})
+
+		Convey("should reject an expired token", func() {
+			claims := provider.idTokenClaims()
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			_, err := pp.verifyJWT(provider.sign(claims, provider.key, provider.kid), jwksURL, false, interfaces.EndpointConnectionSettings{}, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should reject a token signed with another key", func() {
+			otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+			_, err := pp.verifyJWT(provider.sign(provider.idTokenClaims(), otherKey, provider.kid), jwksURL, false, interfaces.EndpointConnectionSettings{}, nil)
+			So(err, ShouldNotBeNil)
+
+			_, err = pp.verifyJWT(provider.sign(provider.idTokenClaims(), otherKey, "key-2"), jwksURL, false, interfaces.EndpointConnectionSettings{}, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should reject an HMAC signed token", func() {
+			token := jws.NewJWT(jws.Claims(provider.idTokenClaims()), crypto.SigningMethodHS256)
+			token.(jws.JWS).Protected().Set("kid", provider.kid)
+			raw, _ := token.Serialize([]byte("secret"))
+			_, err := pp.verifyJWT(string(raw), jwksURL, false, interfaces.EndpointConnectionSettings{}, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should pick up a rotated key", func() {
+			_, err := pp.verifyJWT(provider.sign(provider.idTokenClaims(), provider.key, provider.kid), jwksURL, false, interfaces.EndpointConnectionSettings{}, nil)
+			So(err, ShouldBeNil)
+
+			provider.key, _ = rsa.GenerateKey(rand.Reader, 2048)
+			provider.kid = "key-2"
+			token := provider.sign(provider.idTokenClaims(), provider.key, provider.kid)
+
+			// Key set was only just fetched
+			_, err = pp.verifyJWT(token, jwksURL, false, interfaces.EndpointConnectionSettings{}, nil)
+			So(err, ShouldNotBeNil)
+
+			jwksCache.Lock()
+			jwksCache.keySets[jwksURL].fetched = time.Now().Add(-2 * jwksMinRefreshInterval)
+			jwksCache.Unlock()
+
+			_, err = pp.verifyJWT(token, jwksURL, false, interfaces.EndpointConnectionSettings{}, nil)
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestOIDCClaimMapping(t *testing.T) {
+	t.Parallel()
+
+	Convey("OIDC claim mapping tests", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+		pp.Config.ConsoleConfig = &interfaces.ConsoleConfig{OIDCIssuerURL: "https://idp.example.com"}
+		So(initialiseOIDCConfiguration(pp.Config.ConsoleConfig), ShouldBeNil)
+
+		Convey("should map the default claims", func() {
+			user, err := pp.oidcUserFromClaims(map[string]interface{}{
+				"sub":                "user-1",
+				"preferred_username": "jdoe",
+				"email":              "jdoe@example.com",
+				"groups":             []interface{}{"developers", UAAAdminIdentifier},
+			})
+			So(err, ShouldBeNil)
+			So(user.GUID, ShouldEqual, "user-1")
+			So(user.Name, ShouldEqual, "jdoe")
+			So(user.Email, ShouldEqual, "jdoe@example.com")
+			So(user.Admin, ShouldBeTrue)
+		})
+
+		Convey("should not be an admin without the admin value", func() {
+			user, err := pp.oidcUserFromClaims(map[string]interface{}{"sub": "user-1", "groups": "developers"})
+			So(err, ShouldBeNil)
+			So(user.Name, ShouldEqual, "user-1")
+			So(user.Admin, ShouldBeFalse)
+		})
+
+		Convey("should map nested and boolean claims", func() {
+			pp.Config.ConsoleConfig.OIDCUserIDClaim = "ext.id"
+			pp.Config.ConsoleConfig.OIDCAdminClaim = "ext.admin"
+			user, err := pp.oidcUserFromClaims(map[string]interface{}{
+				"ext": map[string]interface{}{"id": "user-2", "admin": true},
+			})
+			So(err, ShouldBeNil)
+			So(user.GUID, ShouldEqual, "user-2")
+			So(user.Admin, ShouldBeTrue)
+		})
+
+		Convey("should require the user ID claim", func() {
+			_, err := pp.oidcUserFromClaims(map[string]interface{}{"email": "jdoe@example.com"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should require an issuer", func() {
+			So(initialiseOIDCConfiguration(&interfaces.ConsoleConfig{}), ShouldNotBeNil)
+			So(initialiseOIDCConfiguration(&interfaces.ConsoleConfig{OIDCIssuerURL: "not a url"}), ShouldNotBeNil)
+		})
+	})
+}
@@ -50,9 +50,14 @@ func (p *portalProxy) RefreshOidcToken(skipSSLValidation bool, cnsiGUID, userGUI
 		}
 	}
 
-	uaaRes, err := p.getUAATokenWithRefreshToken(skipSSLValidation, userToken.RefreshToken, client, clientSecret, tokenEndpointWithPath, scopes)
+	connection, err := p.getEndpointConnection(cnsiGUID)
 	if err != nil {
-		return t, fmt.Errorf("Token refresh request failed: %v", err)
+		return t, err
+	}
+
+	uaaRes, err := p.getUAATokenWithRefreshToken(skipSSLValidation, connection, userToken.RefreshToken, client, clientSecret, tokenEndpointWithPath, scopes)
+	if err != nil {
+		return t, interfaces.NewTokenRefreshError(err)
 	}
 
 	u, err := p.GetUserTokenInfo(uaaRes.IDToken)
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,6 +24,23 @@ const apiPrefix = "api."
 
 const longRunningTimeoutHeader = "x-cap-long-running"
 
+// Header used to request that the response from a single endpoint is passed straight through to the client
+const passthroughHeader = "x-cap-passthrough"
+
+// Response headers from the endpoint that should not be copied to the client when streaming.
+// Hop-by-hop headers only apply to our connection with the endpoint and "Set-Cookie" would set the endpoint's cookies on our domain
+var streamSkipResponseHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Set-Cookie",
+}
+
 // Timeout for long-running requests, after which we will return indicating request it still active
 // to prevent hitting the 2 minute browser timeout
 const longRunningRequestTimeout = 30
@@ -35,6 +53,7 @@ type PassthroughErrorStatus struct {
 type PassthroughError struct {
 	Error         *PassthroughErrorStatus `json:"error"`
 	ErrorResponse *json.RawMessage        `json:"errorResponse"`
+	Attempts      int                     `json:"attempts,omitempty"`
 }
 
 func getEchoURL(c echo.Context) url.URL {
@@ -132,6 +151,10 @@ func buildJSONResponse(cnsiList []string, responses map[string]*interfaces.CNSIR
 				Error:         errorStatus,
 				ErrorResponse: (*json.RawMessage)(&errorResponse),
 			}
+			// Only report the number of attempts if the request was retried
+			if ok && cnsiResponse.Attempts > 1 {
+				passthroughError.Attempts = cnsiResponse.Attempts
+			}
 			res, _ := json.Marshal(passthroughError)
 			jsonResponse[guid] = (*json.RawMessage)(&res)
 		} else {
@@ -177,7 +200,7 @@ func (p *portalProxy) buildCNSIRequest(cnsiGUID string, userGUID string, method
 	return cnsiRequest, nil
 }
 
-func (p *portalProxy) validateCNSIList(cnsiList []string) error {
+func (p *portalProxy) validateCNSIList(c echo.Context, cnsiList []string) error {
 	log.Debug("validateCNSIList")
 	for _, cnsiGUID := range cnsiList {
 		if _, err := p.GetCNSIRecord(cnsiGUID); err != nil {
@@ -185,7 +208,7 @@ func (p *portalProxy) validateCNSIList(cnsiList []string) error {
 		}
 	}
 
-	return nil
+	return p.CheckEndpointVisible(c, cnsiList...)
 }
 
 func fwdCNSIStandardHeaders(cnsiRequest *interfaces.CNSIRequest, req *http.Request) {
@@ -210,7 +233,16 @@ func fwdCNSIStandardHeaders(cnsiRequest *interfaces.CNSIRequest, req *http.Reque
 
 func (p *portalProxy) proxy(c echo.Context) error {
 	log.Debug("proxy")
-	responses, err := p.ProxyRequest(c, makeRequestURI(c))
+	uri := makeRequestURI(c)
+
+	// Passthrough requests are streamed straight back to the client rather than being buffered.
+	// Long-running and paginated requests still need to be buffered, since they can time out with a 202 response or are merged
+	if "true" == c.Request().Header.Get(passthroughHeader) && "true" != c.Request().Header.Get(longRunningTimeoutHeader) &&
+		paginateAll != c.Request().Header.Get(paginateHeader) {
+		return p.ProxyStreamRequest(c, uri)
+	}
+
+	responses, err := p.ProxyRequest(c, uri)
 	if err != nil {
 		return err
 	}
@@ -221,10 +253,11 @@ func (p *portalProxy) proxy(c echo.Context) error {
 func (p *portalProxy) ProxyRequest(c echo.Context, uri *url.URL) (map[string]*interfaces.CNSIRequest, error) {
 	log.Debug("proxy")
 	cnsiList := strings.Split(c.Request().Header.Get("x-cap-cnsi-list"), ",")
-	shouldPassthrough := "true" == c.Request().Header.Get("x-cap-passthrough")
+	shouldPassthrough := "true" == c.Request().Header.Get(passthroughHeader)
 	longRunning := "true" == c.Request().Header.Get(longRunningTimeoutHeader)
+	paginate := paginateAll == c.Request().Header.Get(paginateHeader)
 
-	if err := p.validateCNSIList(cnsiList); err != nil {
+	if err := p.validateCNSIList(c, cnsiList); err != nil {
 		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
@@ -248,86 +281,179 @@ func (p *portalProxy) ProxyRequest(c echo.Context, uri *url.URL) (map[string]*in
 		}
 	}
 
-	// Only support one endpoint for long running operation (due to way we do timeout with the response channel)
-	if longRunning {
-		if len(cnsiList) > 1 {
-			err := errors.New("Requested long-running proxy to multiple CNSIs. Only single CNSI is supported for long running passthrough")
-			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
-		}
-	}
-
-	// send the request to each CNSI
-	done := make(chan *interfaces.CNSIRequest)
+	// Build the request for each CNSI
+	cnsiRequests := make([]*interfaces.CNSIRequest, 0, len(cnsiList))
 	for _, cnsi := range cnsiList {
 		cnsiRequest, buildErr := p.buildCNSIRequest(cnsi, portalUserGUID, req.Method, uri, body, header)
 		if buildErr != nil {
 			return nil, echo.NewHTTPError(http.StatusBadRequest, buildErr.Error())
 		}
 		cnsiRequest.LongRunning = longRunning
-		// Allow the host part of the API URL to be overridden
-		apiHost := c.Request().Header.Get("x-cap-api-host")
-		// Don't allow any '.' chars in the api name
-		if apiHost != "" && !strings.ContainsAny(apiHost, ".") {
-			// Add trailing . for when we replace
-			apiHost = apiHost + "."
-			// Override the API URL if needed
-			if strings.HasPrefix(cnsiRequest.URL.Host, apiPrefix) {
-				// Replace 'api.' prefix with supplied prefix
-				cnsiRequest.URL.Host = strings.Replace(cnsiRequest.URL.Host, apiPrefix, apiHost, 1)
-			} else {
-				// Add supplied prefix to the domain
-				cnsiRequest.URL.Host = apiHost + cnsiRequest.URL.Host
-			}
+		overrideAPIHost(c, &cnsiRequest)
+		cnsiRequests = append(cnsiRequests, &cnsiRequest)
+	}
+
+	// Long running requests are tracked as a job, so that they can complete after we have responded
+	if longRunning {
+		return p.doLongRunningRequests(c, portalUserGUID, cnsiRequests)
+	}
+
+	// send the request to each CNSI
+	done := make(chan *interfaces.CNSIRequest)
+	for _, cnsiRequest := range cnsiRequests {
+		// Only lists can be paginated
+		if paginate && req.Method == "GET" {
+			go p.doPaginatedRequest(cnsiRequest, done)
+		} else if p.isCacheableRequest(c, cnsiRequest) {
+			go p.doCachedRequest(cnsiRequest, done)
+		} else {
+			go p.doRequest(cnsiRequest, done)
 		}
-		go p.doRequest(&cnsiRequest, done)
 	}
 
 	// Wait for all responses
 	responses := make(map[string]*interfaces.CNSIRequest)
+	for range cnsiList {
+		res := <-done
+		responses[res.GUID] = res
+	}
+
+	return responses, nil
+}
 
-	if !longRunning {
-		for range cnsiList {
-			res := <-done
-			responses[res.GUID] = res
+// Allow the host part of the API URL to be overridden via the x-cap-api-host header
+func overrideAPIHost(c echo.Context, cnsiRequest *interfaces.CNSIRequest) {
+	apiHost := c.Request().Header.Get("x-cap-api-host")
+	// Don't allow any '.' chars in the api name
+	if apiHost != "" && !strings.ContainsAny(apiHost, ".") {
+		// Add trailing . for when we replace
+		apiHost = apiHost + "."
+		// Override the API URL if needed
+		if strings.HasPrefix(cnsiRequest.URL.Host, apiPrefix) {
+			// Replace 'api.' prefix with supplied prefix
+			cnsiRequest.URL.Host = strings.Replace(cnsiRequest.URL.Host, apiPrefix, apiHost, 1)
+		} else {
+			// Add supplied prefix to the domain
+			cnsiRequest.URL.Host = apiHost + cnsiRequest.URL.Host
 		}
-	} else {
-		// Long running has a timeout
-		for range cnsiList {
-			select {
-			case res := <-done:
-				responses[res.GUID] = res
-			case <-time.After(longRunningRequestTimeout * time.Second):
-				// For all those that have not completed, add a timeout response
-				for _, id := range cnsiList {
-					if _, ok := responses[id]; !ok {
-						// Did not get a response for the endpoint
-						responses[id] = &interfaces.CNSIRequest{
-							GUID:         id,
-							UserGUID:     portalUserGUID,
-							Method:       req.Method,
-							StatusCode:   http.StatusAccepted,
-							Status:       "Long Running Operation still active",
-							Response:     makeLongRunningTimeoutError(),
-							Error:        nil,
-							ResponseGUID: id,
-						}
-					}
-				}
-				break
-			}
+	}
+}
+
+// ProxyStreamRequest proxies a request to a single endpoint and streams the response (status, headers and body)
+// straight back to the client, without buffering the body in memory
+func (p *portalProxy) ProxyStreamRequest(c echo.Context, uri *url.URL) error {
+	log.Debug("ProxyStreamRequest")
+	cnsiList := strings.Split(c.Request().Header.Get("x-cap-cnsi-list"), ",")
+	if len(cnsiList) > 1 {
+		err := errors.New("Requested passthrough to multiple CNSIs. Only single CNSI passthroughs are supported")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := p.validateCNSIList(c, cnsiList); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	header := getEchoHeaders(c)
+	header.Del("Cookie")
+
+	portalUserGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	req, body, err := getRequestParts(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	cnsiRequest, err := p.buildCNSIRequest(cnsiList[0], portalUserGUID, req.Method, uri, body, header)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	cnsiRequest.PassThrough = true
+	overrideAPIHost(c, &cnsiRequest)
+
+	// The upstream request is cancelled if the client goes away. The usual client timeout only applies
+	// until the endpoint has responded - after that the body can take as long as it needs to stream
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	timeout := p.Config.HTTPClientTimeoutInSecs
+	if req.Method != "GET" && req.Method != "HEAD" {
+		timeout = p.Config.HTTPClientTimeoutMutatingInSecs
+	}
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(time.Duration(timeout)*time.Second, cancel)
+	}
+
+	res, err := p.sendRequest(ctx, &cnsiRequest)
+	if timer != nil {
+		timer.Stop()
+	}
+	if res != nil && res.Body != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		statusCode := cnsiRequest.StatusCode
+		if statusCode <= 0 {
+			statusCode = http.StatusInternalServerError
+		}
+		log.Warnf("Passthrough response: URL: %s, Status Code: %d, Status: %s", cnsiRequest.URL.String(), statusCode, cnsiRequest.Status)
+		c.Response().WriteHeader(statusCode)
+		if _, err := c.Response().Write(cnsiRequest.Response); err != nil {
+			log.Errorf("Failed to write passthrough response %v", err)
 		}
+		return nil
 	}
 
-	return responses, nil
+	if res.StatusCode >= 400 {
+		log.Warnf("Passthrough response: URL: %s, Status Code: %d, Status: %s, Content Type: %s, Length: %d",
+			cnsiRequest.URL.String(), res.StatusCode, res.Status, res.Header.Get("Content-Type"), res.ContentLength)
+	}
+
+	// Don't overwrite headers that our own middleware has already set (e.g. cache control)
+	responseHeader := c.Response().Header()
+	for k, v := range res.Header {
+		if _, ok := responseHeader[k]; ok {
+			continue
+		}
+		responseHeader[k] = v
+	}
+	for _, k := range streamSkipResponseHeaders {
+		if _, ok := res.Header[k]; ok {
+			responseHeader.Del(k)
+		}
+	}
+	c.Response().WriteHeader(res.StatusCode)
+
+	// Copying through the response applies backpressure - we only read from the endpoint as fast as the client reads from us
+	if _, err := io.Copy(&flushWriter{c.Response()}, res.Body); err != nil {
+		log.Errorf("Failed to stream passthrough response %v", err)
+	}
+
+	return nil
 }
 
-func makeLongRunningTimeoutError() []byte {
+// flushWriter flushes each chunk through to the client as soon as it has been written
+type flushWriter struct {
+	res *echo.Response
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.res.Write(p)
+	if err == nil {
+		fw.res.Flush()
+	}
+	return n, err
+}
+
+func makeLongRunningTimeoutError(jobID string) []byte {
 	description := "Long Running Operation still active"
 	var errorStatus = &PassthroughErrorStatus{
 		StatusCode: http.StatusAccepted,
 		Status:     description,
 	}
-	errorResponse := []byte(fmt.Sprint("{\"longRunningTimeout\": true, \"description\": \"" + description + "\", \"error_code\": \"longRunningTimeout\"}"))
+	errorResponse := []byte(fmt.Sprint("{\"longRunningTimeout\": true, \"description\": \"" + description + "\", \"error_code\": \"longRunningTimeout\", \"job_id\": \"" + jobID + "\"}"))
 	passthroughError := &PassthroughError{}
 	passthroughError.Error = errorStatus
 	passthroughError.ErrorResponse = (*json.RawMessage)(&errorResponse)
@@ -397,11 +523,18 @@ func (p *portalProxy) DoProxySingleRequest(cnsiGUID, userGUID, method, requestUr
 }
 
 func (p *portalProxy) SendProxiedResponse(c echo.Context, responses map[string]*interfaces.CNSIRequest) error {
-	shouldPassthrough := "true" == c.Request().Header.Get("x-cap-passthrough")
+	shouldPassthrough := "true" == c.Request().Header.Get(passthroughHeader)
 
 	var cnsiList []string
-	for k := range responses {
+	var attempts []string
+	for k, res := range responses {
 		cnsiList = append(cnsiList, k)
+		if res.Attempts > 1 {
+			attempts = append(attempts, fmt.Sprintf("%s=%d", k, res.Attempts))
+		}
+	}
+	if len(attempts) > 0 {
+		c.Response().Header().Set(attemptsHeader, strings.Join(attempts, ","))
 	}
 
 	if shouldPassthrough {
@@ -433,11 +566,81 @@ func (p *portalProxy) SendProxiedResponse(c echo.Context, responses map[string]*
 }
 
 func (p *portalProxy) doRequest(cnsiRequest *interfaces.CNSIRequest, done chan<- *interfaces.CNSIRequest) {
+	p.doRequestWithContext(context.Background(), cnsiRequest, done)
+}
+
+// doRequestWithContext makes the request, which will be abandoned if the context is cancelled
+func (p *portalProxy) doRequestWithContext(ctx context.Context, cnsiRequest *interfaces.CNSIRequest, done chan<- *interfaces.CNSIRequest) {
 	log.Debug("doRequest")
+	res, err := p.sendRequestWithRetry(ctx, cnsiRequest)
+	if err == nil && res.Body != nil {
+		cnsiRequest.StatusCode = res.StatusCode
+		cnsiRequest.Status = res.Status
+		cnsiRequest.ResponseHeader = res.Header
+		cnsiRequest.Response, cnsiRequest.Error = ioutil.ReadAll(res.Body)
+	}
+	if res != nil && res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	// If Status Code >=400, log this as a warning
+	if cnsiRequest.StatusCode >= 400 {
+		var contentType = "Unknown"
+		var contentLength int64 = -1
+		if res != nil {
+			contentType = res.Header.Get("Content-Type")
+			contentLength = res.ContentLength
+		}
+		log.Warnf("Passthrough response: URL: %s, Status Code: %d, Status: %s, Content Type: %s, Length: %d",
+			cnsiRequest.URL.String(), cnsiRequest.StatusCode, cnsiRequest.Status, contentType, contentLength)
+		log.Warn(string(cnsiRequest.Response))
+	}
+
+	if done != nil {
+		done <- cnsiRequest
+	}
+}
+
+// sendRequest makes the request to the endpoint and returns the response without reading the body - the caller must close it.
+// On error, the status and error details are recorded in the CNSI Request
+func (p *portalProxy) sendRequest(ctx context.Context, cnsiRequest *interfaces.CNSIRequest) (*http.Response, error) {
+	log.Debug("sendRequest")
+	if err := p.allowRequest(cnsiRequest); err != nil {
+		return nil, err
+	}
+	res, outcome, err := p.sendRequestAttempt(ctx, cnsiRequest)
+	p.CircuitBreakers.record(cnsiRequest.GUID, outcome)
+	return res, err
+}
+
+// allowRequest fails fast if the endpoint has been failing. If the request is allowed, then the outcome must be
+// recorded with the endpoint's circuit breaker
+func (p *portalProxy) allowRequest(cnsiRequest *interfaces.CNSIRequest) error {
+	if p.CircuitBreakers.allow(cnsiRequest.GUID) {
+		return nil
+	}
+
+	var retryAt int64
+	if status := p.CircuitBreakers.status(cnsiRequest.GUID); status != nil {
+		retryAt = status.RetryAt
+	}
+	err := &circuitOpenError{endpointGUID: cnsiRequest.GUID}
+	cnsiRequest.Error = err
+	cnsiRequest.StatusCode = http.StatusServiceUnavailable
+	cnsiRequest.Status = "Endpoint is unavailable"
+	cnsiRequest.Response = makeCircuitOpenError(cnsiRequest.GUID, retryAt)
+	return err
+}
+
+// sendRequestAttempt makes a single attempt at the request, without checking the endpoint's circuit breaker. It
+// returns what the attempt says about the health of the endpoint
+func (p *portalProxy) sendRequestAttempt(ctx context.Context, cnsiRequest *interfaces.CNSIRequest) (*http.Response, circuitResult, error) {
+	log.Debug("sendRequestAttempt")
 	var body io.Reader
 	var res *http.Response
 	var req *http.Request
 	var err error
+	outcome := circuitResultNone
 
 	if len(cnsiRequest.Body) > 0 {
 		body = bytes.NewReader(cnsiRequest.Body)
@@ -445,66 +648,75 @@ func (p *portalProxy) doRequest(cnsiRequest *interfaces.CNSIRequest, done chan<-
 	req, err = http.NewRequest(cnsiRequest.Method, cnsiRequest.URL.String(), body)
 	if err != nil {
 		cnsiRequest.Error = err
-		if done != nil {
-			done <- cnsiRequest
-		}
-		return
+		return nil, outcome, err
 	}
+	req = req.WithContext(ctx)
 
 	// get a cnsi token record and a cnsi record
-	tokenRec, _, err := p.getCNSIRequestRecords(cnsiRequest)
+	tokenRec, cnsiRec, err := p.getCNSIRequestRecords(cnsiRequest)
 	if err != nil {
 		cnsiRequest.Error = err
-		if done != nil {
-			cnsiRequest.StatusCode = 400
-			cnsiRequest.Status = "Unable to retrieve CNSI token record"
-			done <- cnsiRequest
-		}
-		return
+		cnsiRequest.StatusCode = 400
+		cnsiRequest.Status = "Unable to retrieve CNSI token record"
+		return nil, outcome, err
 	}
 
 	// Copy original headers through, except custom portal-proxy Headers
 	fwdCNSIStandardHeaders(cnsiRequest, req)
 
+	// Apply any rewrite rules configured for the endpoint (e.g. for endpoints that sit behind a gateway)
+	rewriteRules, rulesErr := parseRewriteRules(cnsiRec.Metadata)
+	if rulesErr != nil {
+		log.Warnf("Ignoring invalid rewrite rules for endpoint %s: %v", cnsiRequest.GUID, rulesErr)
+	}
+	if rewriteRules != nil {
+		rewriteRules.rewriteRequest(req)
+	}
+
 	// If this is a long running request, add a header which we can use at request time to change the timeout
 	if cnsiRequest.LongRunning {
 		req.Header.Set(longRunningTimeoutHeader, "true")
 	}
 
+	// Streamed responses are not subject to the overall client timeout, so flag these in the same way
+	if cnsiRequest.PassThrough {
+		req.Header.Set(passthroughHeader, "true")
+	}
+
 	// Find the auth provider for the auth type - default ot oauthflow
 	authHandler := p.GetAuthProvider(tokenRec.AuthType)
+	start := time.Now()
 	if authHandler.Handler != nil {
 		res, err = authHandler.Handler(cnsiRequest, req)
 	} else {
 		res, err = p.doOauthFlowRequest(cnsiRequest, req)
 	}
 
+	// Requests cancelled by us or the client say nothing about the health of the endpoint
+	if ctx.Err() == nil {
+		slow := res != nil && p.CircuitBreakers.observeLatency(cnsiRequest.GUID, time.Since(start))
+		if slow || isCircuitFailure(res, err) {
+			outcome = circuitResultFailure
+		} else {
+			outcome = circuitResultSuccess
+		}
+	}
+
+	if err == nil && rewriteRules != nil {
+		err = rewriteRules.rewriteResponse(res)
+	}
+
+	// Cached responses for the endpoint may no longer be valid if anything has been changed
+	if p.ProxyResponseCache != nil && cnsiRequest.Method != "GET" && cnsiRequest.Method != "HEAD" {
+		p.ProxyResponseCache.InvalidateEndpoint(cnsiRequest.GUID)
+	}
+
 	if err != nil {
 		cnsiRequest.StatusCode = 500
 		cnsiRequest.Status = "Error proxing request"
 		cnsiRequest.Response = []byte(err.Error())
 		cnsiRequest.Error = err
-	} else if res.Body != nil {
-		cnsiRequest.StatusCode = res.StatusCode
-		cnsiRequest.Status = res.Status
-		cnsiRequest.Response, cnsiRequest.Error = ioutil.ReadAll(res.Body)
-		defer res.Body.Close()
 	}
 
-	// If Status Code >=400, log this as a warning
-	if cnsiRequest.StatusCode >= 400 {
-		var contentType = "Unknown"
-		var contentLength int64 = -1
-		if res != nil {
-			contentType = res.Header.Get("Content-Type")
-			contentLength = res.ContentLength
-		}
-		log.Warnf("Passthrough response: URL: %s, Status Code: %d, Status: %s, Content Type: %s, Length: %d",
-			cnsiRequest.URL.String(), cnsiRequest.StatusCode, cnsiRequest.Status, contentType, contentLength)
-		log.Warn(string(cnsiRequest.Response))
-	}
-
-	if done != nil {
-		done <- cnsiRequest
-	}
+	return res, outcome, err
 }
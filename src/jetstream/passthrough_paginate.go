@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Header used to request that all pages of a Cloud Foundry list are fetched and merged into a single response
+const paginateHeader = "x-cap-paginate"
+
+const paginateAll = "all"
+
+// Default maximum number of pages that will be fetched for a single paginated request
+const defaultPaginateMaxPages = 50
+
+// Minimal view of a Cloud Foundry v2 or v3 list response - everything else in the first page is passed back untouched
+type paginatedResponse struct {
+	Resources  []json.RawMessage `json:"resources"`
+	NextURL    *string           `json:"next_url"`
+	Pagination *struct {
+		Next *struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+}
+
+// Get the link to the next page - v2 uses a relative next_url, v3 uses an absolute pagination.next.href
+func (r *paginatedResponse) nextPage() string {
+	if r.NextURL != nil {
+		return *r.NextURL
+	}
+	if r.Pagination != nil && r.Pagination.Next != nil {
+		return r.Pagination.Next.Href
+	}
+	return ""
+}
+
+// doPaginatedRequest makes the request and then follows the next page links, merging the resources from each page
+// into the first page. If the page cap is reached, the next page link is left in place so that the client can carry on
+func (p *portalProxy) doPaginatedRequest(cnsiRequest *interfaces.CNSIRequest, done chan<- *interfaces.CNSIRequest) {
+	log.Debug("doPaginatedRequest")
+	p.doRequest(cnsiRequest, nil)
+	p.followPages(cnsiRequest)
+	if done != nil {
+		done <- cnsiRequest
+	}
+}
+
+// followPages fetches the remaining pages for a request whose first page has already been fetched
+func (p *portalProxy) followPages(cnsiRequest *interfaces.CNSIRequest) {
+	if cnsiRequest.Error != nil || cnsiRequest.StatusCode >= 400 {
+		return
+	}
+
+	var first map[string]*json.RawMessage
+	var page paginatedResponse
+	if err := json.Unmarshal(cnsiRequest.Response, &first); err != nil {
+		// Not a JSON object, so not a list that we can paginate
+		return
+	}
+	if err := json.Unmarshal(cnsiRequest.Response, &page); err != nil || first["resources"] == nil {
+		return
+	}
+
+	maxPages := p.Config.ProxyPaginateMaxPages
+	if maxPages <= 0 {
+		maxPages = defaultPaginateMaxPages
+	}
+
+	resources := page.Resources
+	next := page.nextPage()
+	for pages := 1; next != "" && pages < maxPages; pages++ {
+		nextURL, err := url.Parse(next)
+		if err != nil {
+			log.Warnf("Unable to parse next page link '%s': %v", next, err)
+			break
+		}
+
+		// Keep the scheme and host of the original request (which may have been overridden) - only take the path and query
+		pageRequest := *cnsiRequest
+		pageRequest.URL = new(url.URL)
+		*pageRequest.URL = *cnsiRequest.URL
+		pageRequest.URL.Path = nextURL.Path
+		pageRequest.URL.RawQuery = nextURL.RawQuery
+		pageRequest.Response = nil
+
+		p.doRequest(&pageRequest, nil)
+		if pageRequest.Error != nil || pageRequest.StatusCode >= 400 {
+			// Report the failed page rather than a partial list
+			*cnsiRequest = pageRequest
+			return
+		}
+
+		page = paginatedResponse{}
+		if err := json.Unmarshal(pageRequest.Response, &page); err != nil {
+			log.Warnf("Unable to parse page of results from '%s': %v", pageRequest.URL.String(), err)
+			break
+		}
+		resources = append(resources, page.Resources...)
+		next = page.nextPage()
+	}
+
+	if resources == nil {
+		resources = make([]json.RawMessage, 0)
+	}
+	setRawJSON(first, "resources", resources)
+	if _, ok := first["next_url"]; ok {
+		if next == "" {
+			first["next_url"] = nil
+		} else {
+			setRawJSON(first, "next_url", next)
+		}
+	}
+	if raw, ok := first["pagination"]; ok && raw != nil {
+		var pagination map[string]*json.RawMessage
+		if err := json.Unmarshal(*raw, &pagination); err == nil && pagination != nil {
+			if next == "" {
+				pagination["next"] = nil
+			} else {
+				setRawJSON(pagination, "next", map[string]string{"href": next})
+			}
+			setRawJSON(first, "pagination", pagination)
+		}
+	}
+
+	merged, err := json.Marshal(first)
+	if err != nil {
+		log.Errorf("Unable to marshal merged pages: %v", err)
+		return
+	}
+	cnsiRequest.Response = merged
+}
+
+func setRawJSON(obj map[string]*json.RawMessage, key string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	raw := json.RawMessage(data)
+	obj[key] = &raw
+}
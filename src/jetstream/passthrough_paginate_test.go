@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func setupMockPagedServer() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "2":
+			fmt.Fprint(w, `{"total_results":3,"total_pages":2,"next_url":null,"resources":[{"name":"c"}]}`)
+		default:
+			fmt.Fprint(w, `{"total_results":3,"total_pages":2,"next_url":"/v2/apps?page=2","resources":[{"name":"a"},{"name":"b"}]}`)
+		}
+	}))
+}
+
+func expectProxyRequestQueries(mock sqlmock.Sqlmock, encryptionKey []byte, requests int) {
+	// For each request, both p.sendRequest and p.doOauthFlowRequest call p.getCNSIRequestRecords
+	for i := 0; i < requests*2; i++ {
+		mock.ExpectQuery(selectAnyFromTokens).
+			WithArgs(mockCFGUID, mockUserGUID, mockAdminGUID).
+			WillReturnRows(expectEncryptedTokenRow(encryptionKey))
+		mock.ExpectQuery(selectAnyFromCNSIs).
+			WithArgs(mockCFGUID).
+			WillReturnRows(expectCFRow())
+	}
+}
+
+func TestPassthroughPaginatedRequest(t *testing.T) {
+	t.Parallel()
+
+	Convey("Paginated request tests", t, func() {
+		mockCFServer := setupMockPagedServer()
+		defer mockCFServer.Close()
+
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		cnsiRequest := &interfaces.CNSIRequest{
+			GUID:     mockCFGUID,
+			UserGUID: mockUserGUID,
+			Method:   "GET",
+			URL:      urlMust(mockCFServer.URL + "/v2/apps"),
+		}
+
+		Convey("should merge the resources from all pages", func() {
+			expectProxyRequestQueries(mock, pp.Config.EncryptionKeyInBytes, 2)
+
+			done := make(chan *interfaces.CNSIRequest)
+			go pp.doPaginatedRequest(cnsiRequest, done)
+			res := <-done
+
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			So(res.Error, ShouldBeNil)
+
+			var merged map[string]interface{}
+			So(json.Unmarshal(res.Response, &merged), ShouldBeNil)
+			So(merged["resources"], ShouldHaveLength, 3)
+			So(merged["next_url"], ShouldBeNil)
+			So(merged["total_results"], ShouldEqual, 3)
+		})
+
+		Convey("should stop at the page cap and leave the next page link", func() {
+			pp.Config.ProxyPaginateMaxPages = 1
+			expectProxyRequestQueries(mock, pp.Config.EncryptionKeyInBytes, 1)
+
+			done := make(chan *interfaces.CNSIRequest)
+			go pp.doPaginatedRequest(cnsiRequest, done)
+			res := <-done
+
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+			var merged map[string]interface{}
+			So(json.Unmarshal(res.Response, &merged), ShouldBeNil)
+			So(merged["resources"], ShouldHaveLength, 2)
+			So(merged["next_url"], ShouldEqual, "/v2/apps?page=2")
+		})
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"testing"
 
+	"github.com/labstack/echo"
 	. "github.com/smartystreets/goconvey/convey"
 	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
 
@@ -108,6 +109,87 @@ func TestPassthroughDoRequest(t *testing.T) {
 	})
 }
 
+func TestPassthroughProxyStreamRequest(t *testing.T) {
+	t.Parallel()
+
+	Convey("Passthrough stream request tests", t, func() {
+		mockCFServer := setupMockServer(t,
+			msRoute("/v2/info"),
+			msMethod("GET"),
+			msStatus(http.StatusOK),
+			msBody(jsonMust(mockV2InfoResponse)))
+		defer mockCFServer.Close()
+
+		req := setupMockReq("GET", "", nil)
+		req.Header.Set("x-cap-cnsi-list", mockCFGUID)
+		req.Header.Set(passthroughHeader, "true")
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		ctx.Set("user_id", mockUserGUID)
+
+		mockCFRow := func() sqlmock.Rows {
+			return sqlmock.NewRows(rowFieldsForCNSI).
+				AddRow(mockCFGUID, "Some fancy CF Cluster", "cf", mockCFServer.URL, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "", "", "", nil, "")
+		}
+
+		// p.validateCNSIList and p.buildCNSIRequest
+		mock.ExpectQuery(selectAnyFromCNSIs).
+			WithArgs(mockCFGUID).
+			WillReturnRows(mockCFRow())
+		mock.ExpectQuery(listCNSIVisibility).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForCNSIVisibility))
+		mock.ExpectQuery(selectAnyFromCNSIs).
+			WithArgs(mockCFGUID).
+			WillReturnRows(mockCFRow())
+
+		// p.sendRequest and p.doOauthFlowRequest both call p.getCNSIRequestRecords
+		for i := 0; i < 2; i++ {
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCFGUID, mockUserGUID, mockAdminGUID).
+				WillReturnRows(expectEncryptedTokenRow(pp.Config.EncryptionKeyInBytes))
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WithArgs(mockCFGUID).
+				WillReturnRows(mockCFRow())
+		}
+
+		err := pp.ProxyStreamRequest(ctx, urlMust("/v2/info"))
+
+		Convey("should not return an error", func() {
+			So(err, ShouldBeNil)
+		})
+
+		Convey("should have all expectations met", func() {
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should pass through the status, headers and body of the response", func() {
+			So(res.Code, ShouldEqual, http.StatusOK)
+			So(res.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+			So(res.Body.String(), ShouldEqual, jsonMust(mockV2InfoResponse))
+		})
+	})
+}
+
+func TestPassthroughProxyStreamRequestMultipleCNSIs(t *testing.T) {
+	t.Parallel()
+
+	Convey("Passthrough stream request to multiple endpoints", t, func() {
+		req := setupMockReq("GET", "", nil)
+		req.Header.Set("x-cap-cnsi-list", mockCFGUID+","+mockCEGUID)
+		req.Header.Set(passthroughHeader, "true")
+		_, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		err := pp.ProxyStreamRequest(ctx, urlMust("/v2/info"))
+
+		Convey("should be rejected", func() {
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
 func B2S(bs []byte) string {
 	return string(bs[:])
 }
@@ -269,15 +351,17 @@ func TestValidateCNSIListWithValidGUID(t *testing.T) {
 		cnsiGUIDList = append(cnsiGUIDList, "valid-guid-abc123")
 
 		req := setupMockReq("GET", "", nil)
-		_, _, _, pp, db, mock := setupHTTPTest(req)
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
 		defer db.Close()
 
-		expectedCNSIRecordRow := sqlmock.NewRows([]string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso", "sub_type", "meta_data"}).
-			AddRow("valid-guid-abc123", "mock-name", "cf", "http://localhost", "http://localhost", "http://localhost", mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "")
+		expectedCNSIRecordRow := sqlmock.NewRows([]string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso", "sub_type", "meta_data", "ca_cert", "client_cert", "client_cert_key", "proxy_url"}).
+			AddRow("valid-guid-abc123", "mock-name", "cf", "http://localhost", "http://localhost", "http://localhost", mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "", "", "", nil, "")
 		mock.ExpectQuery(selectAnyFromCNSIs).
 			WithArgs("valid-guid-abc123").
 			WillReturnRows(expectedCNSIRecordRow)
-		So(pp.validateCNSIList(cnsiGUIDList), ShouldBeNil)
+		mock.ExpectQuery(listCNSIVisibility).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForCNSIVisibility))
+		So(pp.validateCNSIList(ctx, cnsiGUIDList), ShouldBeNil)
 		Convey("should have all expectations met", func() {
 			So(mock.ExpectationsWereMet(), ShouldBeNil)
 		})
@@ -293,13 +377,13 @@ func TestValidateCNSIListWithInvalidGUID(t *testing.T) {
 		cnsiGUIDList = append(cnsiGUIDList, "fake-guid-abc123")
 
 		req := setupMockReq("GET", "", nil)
-		_, _, _, pp, db, mock := setupHTTPTest(req)
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
 		defer db.Close()
 
 		// Mock a database error
 		mock.ExpectQuery(selectAnyFromCNSIs).
 			WillReturnError(errors.New("Unknown Database Error"))
-		So(pp.validateCNSIList(cnsiGUIDList), ShouldNotBeNil)
+		So(pp.validateCNSIList(ctx, cnsiGUIDList), ShouldNotBeNil)
 
 		Convey("should have all expectations met", func() {
 			So(mock.ExpectationsWereMet(), ShouldBeNil)
@@ -42,7 +42,8 @@ func (cfAppPush *CFAppPush) AddAdminGroupRoutes(echoGroup *echo.Group) {
 // AddSessionGroupRoutes adds the session routes for this plugin to the Echo server
 func (cfAppPush *CFAppPush) AddSessionGroupRoutes(echoGroup *echo.Group) {
 	// Deploy Endpoint
-	echoGroup.GET("/:cnsiGuid/:orgGuid/:spaceGuid/deploy", cfAppPush.deploy)
+	// A websocket upgrade, so a GET, but it pushes an app
+	cfAppPush.portalProxy.RequirePermission(echoGroup.GET("/:cnsiGuid/:orgGuid/:spaceGuid/deploy", cfAppPush.deploy), interfaces.PermissionEndpointsUse)
 }
 
 // Init performs plugin initialization
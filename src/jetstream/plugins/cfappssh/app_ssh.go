@@ -16,6 +16,7 @@ import (
 	"github.com/labstack/echo"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
 )
 
 // See: https://docs.cloudfoundry.org/devguide/deploy-apps/ssh-apps.html
@@ -55,6 +56,9 @@ func (cfAppSsh *CFAppSSH) appSSH(c echo.Context) error {
 
 	// Extract the Doppler endpoint from the CNSI record
 	cnsiRecord, err := p.GetCNSIRecord(cnsiGUID)
+	if err == nil {
+		err = p.CheckEndpointVisible(c, cnsiGUID)
+	}
 	if err != nil {
 		return sendSSHError("Could not get endpoint information")
 	}
@@ -68,7 +72,7 @@ func (cfAppSsh *CFAppSSH) appSSH(c echo.Context) error {
 		return sendSSHError("Can not get Cloud Foundry endpoint plugin")
 	}
 
-	_, info, err := cfPlugin.Info(apiEndpoint.String(), cnsiRecord.SkipSSLValidation)
+	_, info, err := cfPlugin.Info(apiEndpoint.String(), cnsiRecord.SkipSSLValidation, cnsiRecord.Connection)
 	if err != nil {
 		return sendSSHError("Can not get Cloud Foundry info")
 	}
@@ -97,7 +101,16 @@ func (cfAppSsh *CFAppSSH) appSSH(c echo.Context) error {
 		return sendSSHError("Couldn't get refresh token for CNSI with GUID %s", cnsiRecord.GUID)
 	}
 
-	code, err := getSSHCode(cnsiRecord.TokenEndpoint, cfInfo.AppSSHOauthCLient, refreshedTokenRec.AuthToken, cnsiRecord.SkipSSLValidation)
+	tlsConfig, err := p.GetEndpointTLSConfig(cnsiRecord.SkipSSLValidation, cnsiRecord.Connection)
+	if err != nil {
+		return sendSSHError("Invalid endpoint connection settings: %s", err)
+	}
+	proxy, err := p.GetEndpointProxy(cnsiRecord.Connection)
+	if err != nil {
+		return sendSSHError("Invalid endpoint connection settings: %s", err)
+	}
+
+	code, err := getSSHCode(cnsiRecord.TokenEndpoint, cfInfo.AppSSHOauthCLient, refreshedTokenRec.AuthToken, tlsConfig, proxy)
 	if err != nil {
 		return sendSSHError("Couldn't get SSH Code: %s", err)
 	}
@@ -110,7 +123,7 @@ func (cfAppSsh *CFAppSSH) appSSH(c echo.Context) error {
 		HostKeyCallback: sshHostKeyChecker(cfInfo.AppSSHHostKeyFingerprint),
 	}
 
-	connection, err := ssh.Dial("tcp", cfInfo.AppSSHEndpoint, sshConfig)
+	connection, err := dialSSH(cfInfo.AppSSHEndpoint, sshConfig, cnsiRecord.Connection.ProxyURL)
 	if err != nil {
 		return fmt.Errorf("Failed to dial: %s", err)
 	}
@@ -255,7 +268,33 @@ func pumpStdout(ws *websocket.Conn, r io.Reader, done chan struct{}) {
 // ErrPreventRedirect - Error to indicate a redirect - used to make a redirect that we want to prevent later
 var ErrPreventRedirect = errors.New("prevent-redirect")
 
-func getSSHCode(authorizeEndpoint, clientID, token string, skipSSLValidation bool) (string, error) {
+// dialSSH connects to the SSH endpoint, via the endpoint's proxy if it is a SOCKS proxy
+func dialSSH(addr string, config *ssh.ClientConfig, proxyURL string) (*ssh.Client, error) {
+	if len(proxyURL) > 0 {
+		u, err := url.Parse(proxyURL)
+		if err == nil && u.Scheme == "socks5" {
+			dialer, err := proxy.FromURL(u, proxy.Direct)
+			if err != nil {
+				return nil, err
+			}
+			conn, err := dialer.Dial("tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return ssh.NewClient(c, chans, reqs), nil
+		}
+		// SSH can not be tunnelled through an HTTP proxy
+		log.Debugf("Not using proxy for SSH connection to %s", addr)
+	}
+	return ssh.Dial("tcp", addr, config)
+}
+
+func getSSHCode(authorizeEndpoint, clientID, token string, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error)) (string, error) {
 	authorizeURL, err := url.Parse(authorizeEndpoint)
 	if err != nil {
 		return "", err
@@ -282,11 +321,9 @@ func getSSHCode(authorizeEndpoint, clientID, token string, skipSSLValidation boo
 		},
 		Timeout: 30 * time.Second,
 		Transport: &http.Transport{
-			DisableKeepAlives: true,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: skipSSLValidation,
-			},
-			Proxy:               http.ProxyFromEnvironment,
+			DisableKeepAlives:   true,
+			TLSClientConfig:     tlsConfig,
+			Proxy:               proxy,
 			TLSHandshakeTimeout: 10 * time.Second,
 		},
 	}
@@ -40,7 +40,8 @@ func (CFAppSSH *CFAppSSH) AddAdminGroupRoutes(echoGroup *echo.Group) {
 // AddSessionGroupRoutes adds the session routes for this plugin to the Echo server
 func (CFAppSSH *CFAppSSH) AddSessionGroupRoutes(echoGroup *echo.Group) {
 	// Application SSH
-	echoGroup.GET("/:cnsiGuid/apps/:appGuid/ssh/:appInstance", CFAppSSH.appSSH)
+	// Opens a shell in the app instance, so needs more than read access
+	CFAppSSH.portalProxy.RequirePermission(echoGroup.GET("/:cnsiGuid/apps/:appGuid/ssh/:appInstance", CFAppSSH.appSSH), interfaces.PermissionEndpointsUse)
 }
 
 // Init performs plugin initialization
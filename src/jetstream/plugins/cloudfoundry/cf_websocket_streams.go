@@ -83,6 +83,9 @@ func (c CloudFoundrySpecification) openNoaaConsumer(echoContext echo.Context) (*
 
 	// Extract the Doppler endpoint from the CNSI record
 	cnsiRecord, err := c.portalProxy.GetCNSIRecord(cnsiGUID)
+	if err == nil {
+		err = c.portalProxy.CheckEndpointVisible(echoContext, cnsiGUID)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get record for CNSI %s: [%v]", cnsiGUID, err)
 	}
@@ -114,9 +117,21 @@ func (c CloudFoundrySpecification) openNoaaConsumer(echoContext echo.Context) (*
 		return nil, fmt.Errorf("Error getting token for user %s on CNSI %s", userGUID, cnsiGUID)
 	}
 
+	// Doppler certificates have never been validated, unless the endpoint has its own CA bundle to validate them against
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if len(cnsiRecord.Connection.CACert) > 0 || len(cnsiRecord.Connection.ClientCert) > 0 {
+		if tlsConfig, err = c.portalProxy.GetEndpointTLSConfig(cnsiRecord.SkipSSLValidation, cnsiRecord.Connection); err != nil {
+			return nil, fmt.Errorf("Invalid connection settings for CNSI %s: [%v]", cnsiGUID, err)
+		}
+	}
+	proxy, err := c.portalProxy.GetEndpointProxy(cnsiRecord.Connection)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid connection settings for CNSI %s: [%v]", cnsiGUID, err)
+	}
+
 	// Open a Noaa consumer to the doppler endpoint
 	log.Debugf("Creating Noaa consumer for Doppler endpoint %s", dopplerAddress)
-	ac.consumer = consumer.New(dopplerAddress, &tls.Config{InsecureSkipVerify: true}, http.ProxyFromEnvironment)
+	ac.consumer = consumer.New(dopplerAddress, tlsConfig, proxy)
 
 	return ac, nil
 }
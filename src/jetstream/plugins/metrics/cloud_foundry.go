@@ -125,6 +125,9 @@ func (m *MetricsSpecification) getCloudFoundryMetrics(c echo.Context) error {
 		return errors.New("Could not find session user_id")
 	}
 	cnsiList := strings.Split(c.Request().Header.Get("x-cap-cnsi-list"), ",")
+	if err := m.checkEndpointsVisible(c, cnsiList); err != nil {
+		return err
+	}
 	// User must be an admin of the Cloud Foundry
 	// Check each in the list and if any is not, then return an error
 	canAccessMetrics := true
@@ -173,8 +176,10 @@ func (m *MetricsSpecification) makePrometheusRequest(c echo.Context, cnsiList []
 	}
 
 	// For each CNSI, find the metrics endpoint that we need to talk to
-	metrics, err2 := m.getMetricsEndpoints(userGUID, cnsiList)
-	if err2 != nil {
+	metrics, err2 := m.getMetricsEndpoints(c, userGUID, cnsiList)
+	if httpErr, ok := err2.(*echo.HTTPError); ok {
+		return httpErr
+	} else if err2 != nil {
 		return errors.New("Can not get metric endpoint metadata")
 	}
 
@@ -23,8 +23,10 @@ func (m *MetricsSpecification) getPodMetrics(c echo.Context) error {
 	}
 
 	// For each CNSI, find the metrics endpoint that we need to talk to
-	metrics, err2 := m.getMetricsEndpoints(userGUID, cnsiList)
-	if err2 != nil {
+	metrics, err2 := m.getMetricsEndpoints(c, userGUID, cnsiList)
+	if httpErr, ok := err2.(*echo.HTTPError); ok {
+		return httpErr
+	} else if err2 != nil {
 		log.Error("Error getting metrics", err2)
 
 		return errors.New("Can not get metric endpoint metadata")
@@ -169,7 +169,7 @@ func (m *MetricsSpecification) Connect(ec echo.Context, cnsiRecord interfaces.CN
 	}
 	m.addAuth(req, auth)
 
-	var h = m.portalProxy.GetHttpClient(cnsiRecord.SkipSSLValidation)
+	var h = m.portalProxy.GetHttpClientForEndpoint(cnsiRecord.SkipSSLValidation, cnsiRecord.Connection)
 	res, err := h.Do(req)
 
 	if err == nil && res.StatusCode == http.StatusNotFound {
@@ -291,7 +291,7 @@ func (m *MetricsSpecification) Init() error {
 	return nil
 }
 
-func (m *MetricsSpecification) Info(apiEndpoint string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
+func (m *MetricsSpecification) Info(apiEndpoint string, skipSSLValidation bool, connection interfaces.EndpointConnectionSettings) (interfaces.CNSIRecord, interface{}, error) {
 	log.Debug("Metrics Info")
 	var v2InfoResponse interfaces.V2Info
 	var newCNSI interfaces.CNSIRecord
@@ -303,7 +303,7 @@ func (m *MetricsSpecification) Info(apiEndpoint string, skipSSLValidation bool)
 		return newCNSI, nil, err
 	}
 
-	var httpClient = m.portalProxy.GetHttpClient(skipSSLValidation)
+	var httpClient = m.portalProxy.GetHttpClientForEndpoint(skipSSLValidation, connection)
 	resp, err := httpClient.Get(apiEndpoint)
 	if err != nil {
 		return newCNSI, nil, err
@@ -409,7 +409,19 @@ func getPort(u *url.URL) string {
 	return port
 }
 
-func (m *MetricsSpecification) getMetricsEndpoints(userGUID string, cnsiList []string) (map[string]EndpointMetricsRelation, error) {
+// checkEndpointsVisible reports endpoints that the user can't see as not found, as for any other endpoint request
+func (m *MetricsSpecification) checkEndpointsVisible(c echo.Context, cnsiList []string) error {
+	if err := m.portalProxy.CheckEndpointVisible(c, cnsiList...); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Endpoint not found")
+	}
+	return nil
+}
+
+// getMetricsEndpoints finds the metrics endpoint for each of the endpoints. Both must be visible to the user
+func (m *MetricsSpecification) getMetricsEndpoints(c echo.Context, userGUID string, cnsiList []string) (map[string]EndpointMetricsRelation, error) {
+	if err := m.checkEndpointsVisible(c, cnsiList); err != nil {
+		return nil, err
+	}
 
 	metricsProviders := make([]MetricsMetadata, 0)
 	endpointsMap := make(map[string]*interfaces.ConnectedEndpoint)
@@ -493,6 +505,14 @@ func (m *MetricsSpecification) getMetricsEndpoints(userGUID string, cnsiList []s
 	if len(endpointsMap) != 0 {
 		return nil, errors.New("Can not find a metric provider for all of the specified endpoints")
 	}
+
+	metricsList := make([]string, 0, len(results))
+	for _, relate := range results {
+		metricsList = append(metricsList, relate.metrics.EndpointGUID)
+	}
+	if err := m.checkEndpointsVisible(c, metricsList); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
 
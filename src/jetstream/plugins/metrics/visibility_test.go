@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// visibilityPortalProxy implements just enough of the portal proxy to find metrics endpoints
+type visibilityPortalProxy struct {
+	interfaces.PortalProxy
+	endpoints []*interfaces.ConnectedEndpoint
+	hidden    map[string]bool
+}
+
+func (p *visibilityPortalProxy) ListEndpointsByUser(userGUID string) ([]*interfaces.ConnectedEndpoint, error) {
+	if userGUID != "user-guid" {
+		return nil, nil
+	}
+	return p.endpoints, nil
+}
+
+func (p *visibilityPortalProxy) CheckEndpointVisible(c echo.Context, cnsiGUIDs ...string) error {
+	for _, cnsiGUID := range cnsiGUIDs {
+		if p.hidden[cnsiGUID] {
+			return errors.New("No match for that Endpoint")
+		}
+	}
+	return nil
+}
+
+func TestMetricsEndpointVisibility(t *testing.T) {
+	t.Parallel()
+
+	Convey("Metrics endpoint visibility", t, func() {
+		apiEndpoint, _ := url.Parse("https://api.example.com")
+		portalProxy := &visibilityPortalProxy{
+			endpoints: []*interfaces.ConnectedEndpoint{
+				{GUID: "cf-guid", CNSIType: "cf", APIEndpoint: apiEndpoint, DopplerLoggingEndpoint: "https://doppler.example.com:443"},
+				{GUID: "metrics-guid", CNSIType: EndpointType, TokenMetadata: `[{"type":"cf","url":"https://doppler.example.com"}]`},
+			},
+			hidden: make(map[string]bool),
+		}
+		m := &MetricsSpecification{portalProxy: portalProxy}
+		ctx := echo.New().NewContext(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder())
+
+		Convey("should find the metrics endpoint", func() {
+			metrics, err := m.getMetricsEndpoints(ctx, "user-guid", []string{"cf-guid"})
+			So(err, ShouldBeNil)
+			So(metrics["cf-guid"].metrics.EndpointGUID, ShouldEqual, "metrics-guid")
+		})
+
+		Convey("should not find an endpoint that the user can't see", func() {
+			portalProxy.hidden["cf-guid"] = true
+			_, err := m.getMetricsEndpoints(ctx, "user-guid", []string{"cf-guid"})
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("should not use a metrics endpoint that the user can't see", func() {
+			portalProxy.hidden["metrics-guid"] = true
+			_, err := m.getMetricsEndpoints(ctx, "user-guid", []string{"cf-guid"})
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -44,9 +44,10 @@ func (uf *UserFavorites) AddSessionGroupRoutes(echoGroup *echo.Group) {
 
 	// Add REST API for User Favorites
 	echoGroup.GET("/favorites", uf.getAll)
-	echoGroup.DELETE("/favorites/:guid", uf.delete)
-	echoGroup.POST("/favorites", uf.create)
-	echoGroup.POST("/favorites/:guid/metadata", uf.setMetadata)
+	// Favorites belong to the user, so viewers can manage them too
+	uf.portalProxy.RequirePermission(echoGroup.DELETE("/favorites/:guid", uf.delete), interfaces.PermissionConsoleRead)
+	uf.portalProxy.RequirePermission(echoGroup.POST("/favorites", uf.create), interfaces.PermissionConsoleRead)
+	uf.portalProxy.RequirePermission(echoGroup.POST("/favorites/:guid/metadata", uf.setMetadata), interfaces.PermissionConsoleRead)
 }
 
 // Init performs plugin initialization
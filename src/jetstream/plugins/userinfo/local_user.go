@@ -3,6 +3,7 @@ package userinfo
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -53,6 +54,18 @@ func (userInfo *LocalUserInfo) GetUserInfo(id string) (int, []byte, *http.Header
 
 	uaaUser.Meta.Version = 0
 
+	loginInfo, err := localUsersRepo.FindLoginInfo(id)
+	if err != nil {
+		return 500, nil, nil, err
+	}
+	if loginInfo.LastLogin != nil {
+		uaaUser.LastLogonTime = toEpochMillis(*loginInfo.LastLogin)
+	}
+	if loginInfo.LastFailedLogin != nil {
+		uaaUser.LastFailedLogonTime = toEpochMillis(*loginInfo.LastFailedLogin)
+	}
+	uaaUser.FailedLogonCount = loginInfo.FailedLoginCount
+
 	jsonString, err := json.Marshal(uaaUser)
 	if err != nil {
 		return 500, nil, nil, err
@@ -131,6 +144,16 @@ func (userInfo *LocalUserInfo) UpdatePassword(id string, passwordInfo *passwordC
 		)
 	}
 
+	// Check the new password meets the password policy
+	err = userInfo.portalProxy.CheckPasswordPolicy(user.Username, passwordInfo.NewPassword)
+	if err != nil {
+		return 500, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			err.Error(),
+			"New password does not meet the password policy: %v", err,
+		)
+	}
+
 	passwordHash, err := HashPassword(passwordInfo.NewPassword)
 	if err != nil {
 		return 500, err
@@ -145,6 +168,10 @@ func (userInfo *LocalUserInfo) UpdatePassword(id string, passwordInfo *passwordC
 	return 200, nil
 }
 
+func toEpochMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
 //HashPassword accepts a plaintext password string and generates a salted hash
 func HashPassword(password string) ([]byte, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
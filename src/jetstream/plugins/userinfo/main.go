@@ -48,8 +48,9 @@ func (userInfo *UserInfo) AddSessionGroupRoutes(echoGroup *echo.Group) {
 	// Only make available specific endpoints for the User Profile for now
 	// Get the user information for the current user session
 	echoGroup.GET("/users/:id", userInfo.userInfo)
-	echoGroup.PUT("/users/:id", userInfo.updateUserInfo)
-	echoGroup.PUT("/users/:id/password", userInfo.updateUserPassword)
+	// Users can always update their own profile
+	userInfo.portalProxy.RequirePermission(echoGroup.PUT("/users/:id", userInfo.updateUserInfo), interfaces.PermissionConsoleRead)
+	userInfo.portalProxy.RequirePermission(echoGroup.PUT("/users/:id/password", userInfo.updateUserPassword), interfaces.PermissionConsoleRead)
 }
 
 // Init performs plugin initialization
@@ -62,6 +63,14 @@ func (userInfo *UserInfo) getProvider(c echo.Context) Provider {
 		return InitLocalUserInfo(userInfo.portalProxy)
 	}
 
+	if interfaces.AuthEndpointTypes[userInfo.portalProxy.GetConfig().ConsoleConfig.AuthEndpointType] == interfaces.OIDC {
+		return InitOIDCUserInfo(userInfo.portalProxy)
+	}
+
+	if interfaces.AuthEndpointTypes[userInfo.portalProxy.GetConfig().ConsoleConfig.AuthEndpointType] == interfaces.LDAP {
+		return InitLDAPUserInfo(userInfo.portalProxy)
+	}
+
 	return InitUaaUserInfo(userInfo.portalProxy, c)
 }
 
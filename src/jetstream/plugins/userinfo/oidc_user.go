@@ -0,0 +1,72 @@
+package userinfo
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// OIDCUserInfo is a plugin to fetch user info for users that log in via OpenID Connect or LDAP, whose profile is
+// managed by the identity provider
+type OIDCUserInfo struct {
+	portalProxy interfaces.PortalProxy
+	origin      string
+}
+
+// InitOIDCUserInfo creates a new OpenID Connect user info provider
+func InitOIDCUserInfo(portalProxy interfaces.PortalProxy) Provider {
+	return &OIDCUserInfo{portalProxy: portalProxy, origin: "oidc"}
+}
+
+// InitLDAPUserInfo creates a new user info provider for users from an LDAP directory
+func InitLDAPUserInfo(portalProxy interfaces.PortalProxy) Provider {
+	return &OIDCUserInfo{portalProxy: portalProxy, origin: "ldap"}
+}
+
+// GetUserInfo gets info for the specified user from the claims of their ID token or their directory entry
+func (userInfo *OIDCUserInfo) GetUserInfo(id string) (int, []byte, *http.Header, error) {
+	user, err := userInfo.portalProxy.GetStratosUser(id)
+	if err != nil {
+		return 500, nil, nil, err
+	}
+
+	uaaUser := &uaaUser{
+		ID:       id,
+		Origin:   userInfo.origin,
+		Username: user.Name,
+	}
+
+	if len(user.Email) > 0 {
+		uaaUser.Emails = []uaaUserEmail{{Value: user.Email}}
+	}
+
+	groups := make([]uaaUserGroup, len(user.Scopes))
+	for i, scope := range user.Scopes {
+		groups[i] = uaaUserGroup{Display: scope}
+	}
+	uaaUser.Groups = groups
+
+	jsonString, err := json.Marshal(uaaUser)
+	if err != nil {
+		return 500, nil, nil, err
+	}
+
+	return 200, jsonString, nil, nil
+}
+
+// UpdateUserInfo is not supported - the user's profile is managed by the identity provider
+func (userInfo *OIDCUserInfo) UpdateUserInfo(profile *uaaUser) (int, error) {
+	return 403, interfaces.NewHTTPShadowError(
+		http.StatusForbidden,
+		"User profile is managed by the identity provider",
+		"User profile is managed by the identity provider")
+}
+
+// UpdatePassword is not supported - the user's password is managed by the identity provider
+func (userInfo *OIDCUserInfo) UpdatePassword(id string, passwordInfo *passwordChangeInfo) (int, error) {
+	return 403, interfaces.NewHTTPShadowError(
+		http.StatusForbidden,
+		"Password is managed by the identity provider",
+		"Password is managed by the identity provider")
+}
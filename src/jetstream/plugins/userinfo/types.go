@@ -35,6 +35,10 @@ type uaaUser struct {
 	Meta     struct {
 		Version int `json:"version"`
 	} `json:"meta"`
+	// Login times in milliseconds since the epoch, as used by UAA
+	LastLogonTime       int64 `json:"lastLogonTime,omitempty"`
+	LastFailedLogonTime int64 `json:"lastFailedLogonTime,omitempty"`
+	FailedLogonCount    int   `json:"failedLogonCount,omitempty"`
 }
 
 type passwordChangeInfo struct {
@@ -59,7 +59,7 @@ func (invite *UserInvite) refreshToken(clientID, clientSecret string, endpoint i
 		return nil, nil, fmt.Errorf(msg, err)
 	}
 
-	client := invite.portalProxy.GetHttpClientForRequest(req, endpoint.SkipSSLValidation)
+	client := invite.portalProxy.GetHttpClientForEndpointRequest(req, endpoint.SkipSSLValidation, endpoint.Connection)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
 
 	res, err := client.Do(req)
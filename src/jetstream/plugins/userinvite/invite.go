@@ -113,14 +113,24 @@ func (invite *UserInvite) invite(c echo.Context) error {
 
 	// Check user has correct permissions before making the call to the UAA
 	if err = invite.checkPermissions(c, endpoint, userInviteRequest); err != nil {
+		invite.portalProxy.Audit(c, interfaces.AuditUserInvite, inviteAuditTarget(userInviteRequest.Emails, userInviteRequest.Org), err)
 		return interfaces.NewHTTPError(http.StatusUnauthorized, "You are not authorized to invite users")
 	}
 
 	inviteResponse, err := invite.processUserInvites(c, endpoint, userInviteRequest)
 	if err != nil {
+		invite.portalProxy.Audit(c, interfaces.AuditUserInvite, inviteAuditTarget(userInviteRequest.Emails, userInviteRequest.Org), err)
 		return err
 	}
 
+	// Record each invitation separately, as some may have failed
+	for _, user := range inviteResponse.NewInvites {
+		invite.portalProxy.Audit(c, interfaces.AuditUserInvite, inviteAuditTarget([]string{user.Email}, userInviteRequest.Org), nil)
+	}
+	for _, user := range inviteResponse.FailedInvites {
+		invite.portalProxy.Audit(c, interfaces.AuditUserInvite, inviteAuditTarget([]string{user.Email}, userInviteRequest.Org), errors.New(user.ErrorMessage))
+	}
+
 	// Send back the response to the client
 	jsonString, err := json.Marshal(inviteResponse)
 	if err != nil {
@@ -131,6 +141,10 @@ func (invite *UserInvite) invite(c echo.Context) error {
 	return nil
 }
 
+func inviteAuditTarget(emails []string, org string) string {
+	return fmt.Sprintf("%s (org %s)", strings.Join(emails, ", "), org)
+}
+
 func (invite *UserInvite) processUserInvites(c echo.Context, endpoint interfaces.CNSIRecord, userInviteRequest *UserInviteReq) (*UserInviteResponse, error) {
 	cfGUID := c.Param("id")
 	userGUID := c.Get("user_id").(string)
@@ -248,7 +262,7 @@ func (invite *UserInvite) UAAUserInvite(c echo.Context, endpoint interfaces.CNSI
 	req.Header.Set("Authorization", "bearer "+token.AuthToken)
 	req.Header.Set("Accept", "application/json")
 
-	httpClient := invite.portalProxy.GetHttpClientForRequest(req, endpoint.SkipSSLValidation)
+	httpClient := invite.portalProxy.GetHttpClientForEndpointRequest(req, endpoint.SkipSSLValidation, endpoint.Connection)
 	res, err := httpClient.Do(req)
 	if err != nil || res.StatusCode != http.StatusOK {
 		log.Errorf("Error performing http request - response: %v, error: %v", res, err)
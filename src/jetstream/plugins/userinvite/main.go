@@ -54,9 +54,10 @@ func (userinvite *UserInvite) GetRoutePlugin() (interfaces.RoutePlugin, error) {
 
 // AddAdminGroupRoutes adds the admin routes for this plugin to the Echo server
 func (userinvite *UserInvite) AddAdminGroupRoutes(echoGroup *echo.Group) {
-	echoGroup.GET("/invite/:id", userinvite.status)
-	echoGroup.POST("/invite/:id", userinvite.configure)
-	echoGroup.DELETE("/invite/:id", userinvite.remove)
+	// Configuring the invite client is part of managing the endpoint
+	userinvite.portalProxy.RequirePermission(echoGroup.GET("/invite/:id", userinvite.status), interfaces.PermissionEndpointsManage)
+	userinvite.portalProxy.RequirePermission(echoGroup.POST("/invite/:id", userinvite.configure), interfaces.PermissionEndpointsManage)
+	userinvite.portalProxy.RequirePermission(echoGroup.DELETE("/invite/:id", userinvite.remove), interfaces.PermissionEndpointsManage)
 }
 
 // AddSessionGroupRoutes adds the session routes for this plugin to the Echo server
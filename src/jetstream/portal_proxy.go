@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"net"
 	"regexp"
 	"time"
 
@@ -21,6 +22,14 @@ type portalProxy struct {
 	SessionCookieName      string
 	EmptyCookieMatcher     *regexp.Regexp // Used to detect and remove empty Cookies sent by certain browsers
 	AuthProviders          map[string]interfaces.AuthProvider
+	ProxyResponseCache     interfaces.ProxyResponseCache
+	CircuitBreakers        *circuitBreakers
+	TokenRefresher         *tokenRefresher
+	Jobs                   *jobStore
+	RateLimiters           *rateLimiters
+	RoutePermissions       map[string]string
+	AuditForwarder         *auditForwarder
+	TrustedProxies         []*net.IPNet
 	env                    *env.VarSet
 }
 
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Defaults for when the batch proxy has not been configured
+const (
+	defaultProxyBatchMaxRequests   = 100
+	defaultProxyBatchConcurrency   = 10
+	defaultProxyBatchTimeoutInSecs = 120
+)
+
+// BatchRequest - A single request in a batch proxy request
+type BatchRequest struct {
+	ID       string            `json:"id"`
+	Endpoint string            `json:"endpoint"`
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	Headers  map[string]string `json:"headers"`
+	Body     json.RawMessage   `json:"body"`
+}
+
+// BatchResult - The result of a single request in a batch proxy request
+type BatchResult struct {
+	StatusCode int              `json:"statusCode"`
+	Status     string           `json:"status"`
+	Response   *json.RawMessage `json:"response"`
+	Error      string           `json:"error,omitempty"`
+	Attempts   int              `json:"attempts,omitempty"`
+}
+
+// The body can either be a JSON string, which is sent as-is, or any other JSON value which is sent as JSON
+func (b *BatchRequest) bodyBytes() []byte {
+	if len(b.Body) == 0 || string(b.Body) == "null" {
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b.Body, &str); err == nil {
+		return []byte(str)
+	}
+	return b.Body
+}
+
+func newBatchErrorResult(statusCode int, status string) *BatchResult {
+	return &BatchResult{
+		StatusCode: statusCode,
+		Status:     status,
+		Error:      status,
+	}
+}
+
+func newBatchResult(cnsiRequest *interfaces.CNSIRequest) *BatchResult {
+	result := &BatchResult{
+		StatusCode: cnsiRequest.StatusCode,
+		Status:     cnsiRequest.Status,
+	}
+	if cnsiRequest.Attempts > 1 {
+		result.Attempts = cnsiRequest.Attempts
+	}
+	if cnsiRequest.Error != nil {
+		result.Error = cnsiRequest.Error.Error()
+		if result.StatusCode <= 0 {
+			result.StatusCode = http.StatusInternalServerError
+		}
+	}
+
+	// Pass JSON responses through untouched, convert anything else to a string
+	response := cnsiRequest.Response
+	if len(response) > 0 {
+		if !isValidJSON(response) {
+			response = []byte(fmt.Sprintf("%q", response))
+		}
+		result.Response = (*json.RawMessage)(&response)
+	}
+	return result
+}
+
+// proxyBatch makes a set of requests, to any mix of endpoints, and returns the results keyed by the ID of each request
+func (p *portalProxy) proxyBatch(c echo.Context) error {
+	log.Debug("proxyBatch")
+	userGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	var requests []BatchRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&requests); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid batch request - expected a JSON array of requests",
+			"Invalid batch request: %v", err,
+		)
+	}
+
+	maxRequests := p.Config.ProxyBatchMaxRequests
+	if maxRequests <= 0 {
+		maxRequests = defaultProxyBatchMaxRequests
+	}
+	if len(requests) > maxRequests {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Too many requests in batch - the maximum is %d", maxRequests))
+	}
+
+	ids := make(map[string]bool)
+	for _, request := range requests {
+		if len(request.ID) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Every request in the batch must have an id")
+		}
+		if ids[request.ID] {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Duplicate request id in batch: %s", request.ID))
+		}
+		ids[request.ID] = true
+	}
+
+	// Requests to endpoints that the user can't see fail as if the endpoint wasn't registered
+	isVisible, err := p.getEndpointVisibility(c)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to check endpoint visibility",
+			"Unable to check endpoint visibility: %v", err,
+		)
+	}
+	visibleRequests := make([]BatchRequest, 0, len(requests))
+	hiddenResults := make(map[string]*BatchResult)
+	for _, request := range requests {
+		if isVisible(request.Endpoint) {
+			visibleRequests = append(visibleRequests, request)
+		} else {
+			hiddenResults[request.ID] = newBatchErrorResult(http.StatusBadRequest, fmt.Sprintf("Unknown endpoint: %s", request.Endpoint))
+		}
+	}
+
+	results := p.doBatchRequests(userGUID, visibleRequests)
+	for id, result := range hiddenResults {
+		results[id] = result
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+func (p *portalProxy) doBatchRequests(userGUID string, requests []BatchRequest) map[string]*BatchResult {
+	log.Debug("doBatchRequests")
+	concurrency := p.Config.ProxyBatchConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultProxyBatchConcurrency
+	}
+	timeout := p.Config.ProxyBatchTimeoutInSecs
+	if timeout <= 0 {
+		timeout = defaultProxyBatchTimeoutInSecs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]*BatchResult)
+	slots := make(chan struct{}, concurrency)
+	for _, request := range requests {
+		wg.Add(1)
+		go func(request BatchRequest) {
+			defer wg.Done()
+			var result *BatchResult
+			select {
+			case slots <- struct{}{}:
+				result = p.doBatchRequest(ctx, userGUID, request)
+				<-slots
+			case <-ctx.Done():
+				result = newBatchErrorResult(http.StatusGatewayTimeout, "Batch deadline exceeded")
+			}
+			lock.Lock()
+			results[request.ID] = result
+			lock.Unlock()
+		}(request)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (p *portalProxy) doBatchRequest(ctx context.Context, userGUID string, request BatchRequest) *BatchResult {
+	method := strings.ToUpper(request.Method)
+	if len(method) == 0 {
+		method = "GET"
+	}
+	if !strings.HasPrefix(request.Path, "/") {
+		return newBatchErrorResult(http.StatusBadRequest, "Request path must start with /")
+	}
+	uri, err := url.Parse(request.Path)
+	if err != nil {
+		return newBatchErrorResult(http.StatusBadRequest, "Invalid request path")
+	}
+
+	header := make(http.Header)
+	for k, v := range request.Headers {
+		header.Set(k, v)
+	}
+	body := request.bodyBytes()
+	if len(body) > 0 && len(header.Get("Content-Type")) == 0 {
+		header.Set("Content-Type", "application/json")
+	}
+
+	if ok, _ := p.RateLimiters.Endpoint.allow(request.Endpoint); !ok {
+		return newBatchErrorResult(http.StatusTooManyRequests, fmt.Sprintf("Too many requests to endpoint %s", request.Endpoint))
+	}
+
+	cnsiRequest, err := p.buildCNSIRequest(request.Endpoint, userGUID, method, uri, body, header)
+	if err != nil {
+		return newBatchErrorResult(http.StatusBadRequest, fmt.Sprintf("Unknown endpoint: %s", request.Endpoint))
+	}
+
+	p.doRequestWithContext(ctx, &cnsiRequest, nil)
+	if cnsiRequest.Error != nil && ctx.Err() == context.DeadlineExceeded {
+		return newBatchErrorResult(http.StatusGatewayTimeout, "Batch deadline exceeded")
+	}
+	return newBatchResult(&cnsiRequest)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestProxyBatchValidation(t *testing.T) {
+	t.Parallel()
+
+	Convey("Batch proxy validation tests", t, func() {
+
+		doBatch := func(body string) error {
+			req := setupMockReq("POST", "", nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(body))
+			_, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+			ctx.Set("user_id", mockUserGUID)
+			pp.Config.ProxyBatchMaxRequests = 2
+			return pp.proxyBatch(ctx)
+		}
+
+		Convey("should reject a body that is not an array", func() {
+			So(doBatch(`{"id":"a"}`), ShouldNotBeNil)
+		})
+
+		Convey("should reject requests without an id", func() {
+			So(doBatch(`[{"endpoint":"x","path":"/v2/info"}]`), ShouldNotBeNil)
+		})
+
+		Convey("should reject duplicate ids", func() {
+			So(doBatch(`[{"id":"a","path":"/v2/info"},{"id":"a","path":"/v2/info"}]`), ShouldNotBeNil)
+		})
+
+		Convey("should reject too many requests", func() {
+			So(doBatch(`[{"id":"a"},{"id":"b"},{"id":"c"}]`), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestProxyBatchRequests(t *testing.T) {
+	t.Parallel()
+
+	Convey("Batch proxy request tests", t, func() {
+		mockCFServer := setupMockServer(t,
+			msRoute("/v2/info"),
+			msMethod("GET"),
+			msStatus(http.StatusOK),
+			msBody(jsonMust(mockV2InfoResponse)))
+		defer mockCFServer.Close()
+
+		req := setupMockReq("POST", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		mockCFRow := func() sqlmock.Rows {
+			return sqlmock.NewRows(rowFieldsForCNSI).
+				AddRow(mockCFGUID, "Some fancy CF Cluster", "cf", mockCFServer.URL, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "", "", "", nil, "")
+		}
+
+		// p.buildCNSIRequest, then p.getCNSIRequestRecords from both p.sendRequest and p.doOauthFlowRequest
+		mock.ExpectQuery(selectAnyFromCNSIs).
+			WithArgs(mockCFGUID).
+			WillReturnRows(mockCFRow())
+		for i := 0; i < 2; i++ {
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCFGUID, mockUserGUID, mockAdminGUID).
+				WillReturnRows(expectEncryptedTokenRow(pp.Config.EncryptionKeyInBytes))
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WithArgs(mockCFGUID).
+				WillReturnRows(mockCFRow())
+		}
+
+		var requests []BatchRequest
+		err := json.Unmarshal([]byte(`[
+			{"id": "info", "endpoint": "`+mockCFGUID+`", "method": "get", "path": "/v2/info"},
+			{"id": "bad", "endpoint": "`+mockCFGUID+`", "path": "v2/info"}
+		]`), &requests)
+		So(err, ShouldBeNil)
+
+		results := pp.doBatchRequests(mockUserGUID, requests)
+
+		Convey("should have all expectations met", func() {
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should return the results keyed by id", func() {
+			So(results, ShouldHaveLength, 2)
+			So(results["info"].StatusCode, ShouldEqual, http.StatusOK)
+			So(string(*results["info"].Response), ShouldEqual, jsonMust(mockV2InfoResponse))
+			So(results["bad"].StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(results["bad"].Error, ShouldNotBeEmpty)
+		})
+	})
+}
+
+func TestBatchRequestBody(t *testing.T) {
+	t.Parallel()
+
+	Convey("Batch request body tests", t, func() {
+		So((&BatchRequest{}).bodyBytes(), ShouldBeNil)
+		So(string((&BatchRequest{Body: json.RawMessage(`"name=a"`)}).bodyBytes()), ShouldEqual, "name=a")
+		So(string((&BatchRequest{Body: json.RawMessage(`{"name":"a"}`)}).bodyBytes()), ShouldEqual, `{"name":"a"}`)
+	})
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+// Header used to bypass the proxy response cache for a request
+const noCacheHeader = "x-cap-no-cache"
+
+// Default maximum number of responses held in the in-memory cache
+const defaultProxyCacheMaxEntries = 1000
+
+// memoryProxyCache is the default, in-memory, ProxyResponseCache
+type memoryProxyCache struct {
+	sync.Mutex
+	entries    map[string]*interfaces.CachedResponse
+	maxEntries int
+}
+
+func newMemoryProxyCache(maxEntries int) *memoryProxyCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultProxyCacheMaxEntries
+	}
+	return &memoryProxyCache{
+		entries:    make(map[string]*interfaces.CachedResponse),
+		maxEntries: maxEntries,
+	}
+}
+
+func (m *memoryProxyCache) Get(key string) (*interfaces.CachedResponse, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	// Stale responses are only any use if they can be revalidated
+	if !entry.IsFresh() && len(entry.ETag) == 0 {
+		delete(m.entries, key)
+		return nil, false
+	}
+
+	return entry, true
+}
+
+func (m *memoryProxyCache) Set(key string, response *interfaces.CachedResponse) {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= m.maxEntries {
+		m.evict()
+	}
+	m.entries[key] = response
+}
+
+func (m *memoryProxyCache) InvalidateEndpoint(endpointGUID string) {
+	m.Lock()
+	defer m.Unlock()
+
+	for key, entry := range m.entries {
+		if entry.EndpointGUID == endpointGUID {
+			delete(m.entries, key)
+		}
+	}
+}
+
+func (m *memoryProxyCache) InvalidateConnection(endpointGUID, userGUID string) {
+	m.Lock()
+	defer m.Unlock()
+
+	for key, entry := range m.entries {
+		if entry.EndpointGUID == endpointGUID && entry.UserGUID == userGUID {
+			delete(m.entries, key)
+		}
+	}
+}
+
+// Make room for a new entry - drop stale entries, or the entry that expires soonest if none are stale
+func (m *memoryProxyCache) evict() {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range m.entries {
+		if !entry.IsFresh() {
+			delete(m.entries, key)
+			continue
+		}
+		if len(oldestKey) == 0 || entry.Expires.Before(oldest) {
+			oldestKey = key
+			oldest = entry.Expires
+		}
+	}
+
+	if len(m.entries) >= m.maxEntries {
+		delete(m.entries, oldestKey)
+	}
+}
+
+// SetProxyResponseCache replaces the cache used for proxied responses - a nil cache disables caching
+func (p *portalProxy) SetProxyResponseCache(cache interfaces.ProxyResponseCache) {
+	p.ProxyResponseCache = cache
+}
+
+// invalidateProxyCache drops the cached responses for a user's connection to an endpoint, once it has gone. All users
+// of the endpoint share the system shared token, so all of the endpoint's responses are dropped when that goes
+func (p *portalProxy) invalidateProxyCache(endpointGUID, userGUID string) {
+	if p.ProxyResponseCache == nil {
+		return
+	}
+	if userGUID == tokens.SystemSharedUserGuid {
+		p.ProxyResponseCache.InvalidateEndpoint(endpointGUID)
+		return
+	}
+	p.ProxyResponseCache.InvalidateConnection(endpointGUID, userGUID)
+}
+
+// Is the response for the request one that we can cache?
+func (p *portalProxy) isCacheableRequest(c echo.Context, cnsiRequest *interfaces.CNSIRequest) bool {
+	return p.ProxyResponseCache != nil && cnsiRequest.Method == "GET" && !cnsiRequest.LongRunning &&
+		"true" != c.Request().Header.Get(noCacheHeader) &&
+		len(c.Request().Header.Get("If-None-Match")) == 0
+}
+
+func makeProxyCacheKey(cnsiRequest *interfaces.CNSIRequest) string {
+	return fmt.Sprintf("%s:%s:%s:%s", cnsiRequest.UserGUID, cnsiRequest.GUID, cnsiRequest.Method, cnsiRequest.URL.String())
+}
+
+// doCachedRequest makes the request, using the cached response if it is fresh. A stale cached response is revalidated
+// with the endpoint using its ETag
+func (p *portalProxy) doCachedRequest(cnsiRequest *interfaces.CNSIRequest, done chan<- *interfaces.CNSIRequest) {
+	log.Debug("doCachedRequest")
+	key := makeProxyCacheKey(cnsiRequest)
+	cached, found := p.ProxyResponseCache.Get(key)
+	if found && cached.IsFresh() {
+		setFromCachedResponse(cnsiRequest, cached)
+		if done != nil {
+			done <- cnsiRequest
+		}
+		return
+	}
+
+	if found {
+		// Headers are shared between the requests to each endpoint, so take a copy before changing them
+		header := make(http.Header)
+		for k, v := range cnsiRequest.Header {
+			header[k] = v
+		}
+		header.Set("If-None-Match", cached.ETag)
+		cnsiRequest.Header = header
+	}
+
+	p.doRequest(cnsiRequest, nil)
+
+	if cnsiRequest.Error == nil {
+		if found && cnsiRequest.StatusCode == http.StatusNotModified {
+			if ttl, ok := p.proxyCacheTTL(cnsiRequest.ResponseHeader); ok {
+				refreshed := *cached
+				refreshed.Expires = time.Now().Add(ttl)
+				p.ProxyResponseCache.Set(key, &refreshed)
+			}
+			setFromCachedResponse(cnsiRequest, cached)
+		} else if cnsiRequest.StatusCode == http.StatusOK {
+			p.cacheResponse(key, cnsiRequest)
+		}
+	}
+
+	if done != nil {
+		done <- cnsiRequest
+	}
+}
+
+func (p *portalProxy) cacheResponse(key string, cnsiRequest *interfaces.CNSIRequest) {
+	ttl, ok := p.proxyCacheTTL(cnsiRequest.ResponseHeader)
+	etag := cnsiRequest.ResponseHeader.Get("ETag")
+	if !ok || (ttl <= 0 && len(etag) == 0) {
+		return
+	}
+
+	p.ProxyResponseCache.Set(key, &interfaces.CachedResponse{
+		EndpointGUID: cnsiRequest.GUID,
+		UserGUID:     cnsiRequest.UserGUID,
+		StatusCode:   cnsiRequest.StatusCode,
+		Status:       cnsiRequest.Status,
+		Header:       cnsiRequest.ResponseHeader,
+		Response:     cnsiRequest.Response,
+		ETag:         etag,
+		Expires:      time.Now().Add(ttl),
+	})
+}
+
+// Get the time for which a response can be cached - the configured TTL, reduced if the endpoint asks for less.
+// Returns false if the endpoint does not allow the response to be stored
+func (p *portalProxy) proxyCacheTTL(header http.Header) (time.Duration, bool) {
+	ttl := time.Duration(p.Config.ProxyCacheTTLInSecs) * time.Second
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store":
+			return 0, false
+		case directive == "no-cache":
+			// Can be stored, but must always be revalidated
+			ttl = 0
+		case strings.HasPrefix(directive, "max-age="):
+			if maxAge, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+				if maxAgeTTL := time.Duration(maxAge) * time.Second; maxAgeTTL < ttl {
+					ttl = maxAgeTTL
+				}
+			}
+		}
+	}
+
+	return ttl, true
+}
+
+func setFromCachedResponse(cnsiRequest *interfaces.CNSIRequest, cached *interfaces.CachedResponse) {
+	cnsiRequest.StatusCode = cached.StatusCode
+	cnsiRequest.Status = cached.Status
+	cnsiRequest.ResponseHeader = cached.Header
+	cnsiRequest.Response = cached.Response
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestMemoryProxyCache(t *testing.T) {
+	t.Parallel()
+
+	Convey("Memory proxy cache tests", t, func() {
+		cache := newMemoryProxyCache(2)
+
+		Convey("should return fresh entries", func() {
+			cache.Set("a", &interfaces.CachedResponse{EndpointGUID: mockCFGUID, Expires: time.Now().Add(time.Minute)})
+			_, found := cache.Get("a")
+			So(found, ShouldBeTrue)
+		})
+
+		Convey("should only return stale entries that can be revalidated", func() {
+			cache.Set("a", &interfaces.CachedResponse{EndpointGUID: mockCFGUID, Expires: time.Now().Add(-time.Minute)})
+			cache.Set("b", &interfaces.CachedResponse{EndpointGUID: mockCFGUID, Expires: time.Now().Add(-time.Minute), ETag: "W/\"1\""})
+			_, found := cache.Get("a")
+			So(found, ShouldBeFalse)
+			_, found = cache.Get("b")
+			So(found, ShouldBeTrue)
+		})
+
+		Convey("should evict the entry that expires soonest when full", func() {
+			cache.Set("a", &interfaces.CachedResponse{EndpointGUID: mockCFGUID, Expires: time.Now().Add(time.Minute)})
+			cache.Set("b", &interfaces.CachedResponse{EndpointGUID: mockCFGUID, Expires: time.Now().Add(time.Hour)})
+			cache.Set("c", &interfaces.CachedResponse{EndpointGUID: mockCFGUID, Expires: time.Now().Add(time.Hour)})
+			_, found := cache.Get("a")
+			So(found, ShouldBeFalse)
+			_, found = cache.Get("c")
+			So(found, ShouldBeTrue)
+		})
+
+		Convey("should invalidate all entries for an endpoint", func() {
+			cache.Set("a", &interfaces.CachedResponse{EndpointGUID: mockCFGUID, Expires: time.Now().Add(time.Minute)})
+			cache.Set("b", &interfaces.CachedResponse{EndpointGUID: mockCEGUID, Expires: time.Now().Add(time.Minute)})
+			cache.InvalidateEndpoint(mockCFGUID)
+			_, found := cache.Get("a")
+			So(found, ShouldBeFalse)
+			_, found = cache.Get("b")
+			So(found, ShouldBeTrue)
+		})
+
+		Convey("should invalidate the entries for a user's connection", func() {
+			cache.Set("a", &interfaces.CachedResponse{EndpointGUID: mockCFGUID, UserGUID: mockUserGUID, Expires: time.Now().Add(time.Minute)})
+			cache.Set("b", &interfaces.CachedResponse{EndpointGUID: mockCFGUID, UserGUID: mockAdminGUID, Expires: time.Now().Add(time.Minute)})
+			cache.InvalidateConnection(mockCFGUID, mockUserGUID)
+			_, found := cache.Get("a")
+			So(found, ShouldBeFalse)
+			_, found = cache.Get("b")
+			So(found, ShouldBeTrue)
+		})
+	})
+}
+
+func TestProxyCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	Convey("Proxy cache TTL tests", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+		pp.Config.ProxyCacheTTLInSecs = 60
+
+		header := make(http.Header)
+
+		Convey("should use the configured TTL by default", func() {
+			ttl, ok := pp.proxyCacheTTL(header)
+			So(ok, ShouldBeTrue)
+			So(ttl, ShouldEqual, time.Minute)
+		})
+
+		Convey("should use a shorter max-age from the endpoint", func() {
+			header.Set("Cache-Control", "private, max-age=10")
+			ttl, ok := pp.proxyCacheTTL(header)
+			So(ok, ShouldBeTrue)
+			So(ttl, ShouldEqual, 10*time.Second)
+		})
+
+		Convey("should not cache no-store responses", func() {
+			header.Set("Cache-Control", "no-store")
+			_, ok := pp.proxyCacheTTL(header)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestProxyDoCachedRequest(t *testing.T) {
+	t.Parallel()
+
+	Convey("Cached proxy request tests", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		pp.SetProxyResponseCache(newMemoryProxyCache(0))
+
+		cnsiRequest := &interfaces.CNSIRequest{
+			GUID:     mockCFGUID,
+			UserGUID: mockUserGUID,
+			Method:   "GET",
+			URL:      urlMust(mockAPIEndpoint + "/v2/info"),
+		}
+		pp.ProxyResponseCache.Set(makeProxyCacheKey(cnsiRequest), &interfaces.CachedResponse{
+			EndpointGUID: mockCFGUID,
+			StatusCode:   http.StatusOK,
+			Response:     []byte(jsonMust(mockV2InfoResponse)),
+			Expires:      time.Now().Add(time.Minute),
+		})
+
+		done := make(chan *interfaces.CNSIRequest)
+		go pp.doCachedRequest(cnsiRequest, done)
+		res := <-done
+
+		Convey("should use the cached response without contacting the endpoint", func() {
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(string(res.Response), ShouldEqual, jsonMust(mockV2InfoResponse))
+		})
+	})
+
+	Convey("Cached responses should not be used once the user disconnects", t, func() {
+		req := setupMockReq("POST", "", map[string]string{"cnsi_guid": mockCFGUID})
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		pp.SetProxyResponseCache(newMemoryProxyCache(0))
+		pp.setSessionValues(ctx, map[string]interface{}{"user_id": mockUserGUID})
+
+		cnsiRequest := &interfaces.CNSIRequest{
+			GUID:     mockCFGUID,
+			UserGUID: mockUserGUID,
+			Method:   "GET",
+			URL:      urlMust(mockAPIEndpoint + "/v2/info"),
+		}
+		pp.ProxyResponseCache.Set(makeProxyCacheKey(cnsiRequest), &interfaces.CachedResponse{
+			EndpointGUID: mockCFGUID,
+			UserGUID:     mockUserGUID,
+			StatusCode:   http.StatusOK,
+			Response:     []byte(jsonMust(mockV2InfoResponse)),
+			Expires:      time.Now().Add(time.Minute),
+		})
+
+		// Disconnect
+		mock.ExpectQuery(selectAnyFromCNSIs).
+			WithArgs(mockCFGUID).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForCNSI).
+				AddRow(mockCFGUID, "mockCF", "cf", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "", "", "", nil, ""))
+		mock.ExpectQuery(selectAnyFromTokens).
+			WithArgs(mockCFGUID, mockUserGUID, mockAdminGUID).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec(`DELETE FROM tokens WHERE token_type = 'cnsi' AND cnsi_guid = (.+)`).
+			WithArgs(mockCFGUID, mockUserGUID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		So(pp.logoutOfCNSI(ctx), ShouldBeNil)
+
+		// The request now fails, as there is no token
+		mock.ExpectQuery(selectAnyFromTokens).
+			WithArgs(mockCFGUID, mockUserGUID, mockAdminGUID).
+			WillReturnError(sql.ErrNoRows)
+
+		done := make(chan *interfaces.CNSIRequest)
+		go pp.doCachedRequest(cnsiRequest, done)
+		res := <-done
+
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+		So(res.Error, ShouldNotBeNil)
+		So(string(res.Response), ShouldNotEqual, jsonMust(mockV2InfoResponse))
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Header used to opt in to retrying PUT and DELETE requests - these are idempotent, but not always safe to repeat
+const retryIdempotentHeader = "x-cap-retry-idempotent"
+
+// Response header listing the endpoints whose requests needed more than one attempt, e.g. "guid1=2,guid2=3"
+const attemptsHeader = "x-cap-proxy-attempts"
+
+// Defaults for when retries have not been configured
+const (
+	defaultProxyRetryMaxAttempts         = 3
+	defaultProxyRetryBaseDelayInMillis   = 250
+	defaultProxyRetryBudgetInSecs        = 10
+	proxyRetryMaxDelayBeforeJitterInSecs = 5
+)
+
+// Can the request be safely retried?
+func isRetryableRequest(cnsiRequest *interfaces.CNSIRequest) bool {
+	switch cnsiRequest.Method {
+	case "GET", "HEAD":
+		return true
+	case "PUT", "DELETE":
+		return "true" == cnsiRequest.Header.Get(retryIdempotentHeader)
+	}
+	return false
+}
+
+// Is the failure one that could succeed if we try again? This covers connection failures and the errors that
+// routers and load balancers return when the backend is temporarily unavailable - other errors, e.g. a token that
+// could not be refreshed, would fail again
+func isRetryableFailure(res *http.Response, err error) bool {
+	if err != nil {
+		return res == nil && isTransportError(err)
+	}
+	return res != nil && isUnavailableStatus(res.StatusCode)
+}
+
+// Get the delay before the next attempt - exponential backoff with jitter, or the endpoint's Retry-After if longer
+func retryDelay(baseDelay time.Duration, attempt int, res *http.Response) time.Duration {
+	backoff := baseDelay << uint(attempt-1)
+	if max := proxyRetryMaxDelayBeforeJitterInSecs * time.Second; backoff > max || backoff <= 0 {
+		backoff = max
+	}
+	// Jitter between 50% and 100% of the backoff, so that clients retrying at the same time spread out
+	delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+
+	if res != nil {
+		if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok && retryAfter > delay {
+			delay = retryAfter
+		}
+	}
+
+	return delay
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+// sendRequestWithRetry sends the request, retrying transient failures of idempotent requests until either the
+// configured number of attempts or the time budget is used up. The endpoint's circuit breaker sees a single outcome
+// for the request, however many attempts it took
+func (p *portalProxy) sendRequestWithRetry(ctx context.Context, cnsiRequest *interfaces.CNSIRequest) (*http.Response, error) {
+	log.Debug("sendRequestWithRetry")
+	maxAttempts := p.Config.ProxyRetryMaxAttempts
+	if maxAttempts < 1 || !isRetryableRequest(cnsiRequest) {
+		maxAttempts = 1
+	}
+	baseDelay := time.Duration(p.Config.ProxyRetryBaseDelayInMillis) * time.Millisecond
+	deadline := time.Now().Add(time.Duration(p.Config.ProxyRetryBudgetInSecs) * time.Second)
+
+	if err := p.allowRequest(cnsiRequest); err != nil {
+		return nil, err
+	}
+	outcome := circuitResultNone
+	defer func() {
+		p.CircuitBreakers.record(cnsiRequest.GUID, outcome)
+	}()
+
+	for attempt := 1; ; attempt++ {
+		cnsiRequest.Attempts = attempt
+		var res *http.Response
+		var err error
+		res, outcome, err = p.sendRequestAttempt(ctx, cnsiRequest)
+		if attempt >= maxAttempts || !isRetryableFailure(res, err) {
+			return res, err
+		}
+
+		delay := retryDelay(baseDelay, attempt, res)
+		if time.Now().Add(delay).After(deadline) {
+			return res, err
+		}
+
+		log.Infof("Retrying request to %s in %v (attempt %d of %d)", cnsiRequest.URL.String(), delay, attempt+1, maxAttempts)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return res, err
+		}
+		if res != nil && res.Body != nil {
+			res.Body.Close()
+		}
+
+		// Clear any error details from the failed attempt
+		cnsiRequest.Error = nil
+		cnsiRequest.StatusCode = 0
+		cnsiRequest.Status = ""
+		cnsiRequest.Response = nil
+	}
+}
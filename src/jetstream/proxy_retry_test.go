@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestProxyRetryHelpers(t *testing.T) {
+	t.Parallel()
+
+	Convey("Proxy retry helper tests", t, func() {
+
+		Convey("should only retry idempotent requests", func() {
+			header := make(http.Header)
+			So(isRetryableRequest(&interfaces.CNSIRequest{Method: "GET"}), ShouldBeTrue)
+			So(isRetryableRequest(&interfaces.CNSIRequest{Method: "POST", Header: header}), ShouldBeFalse)
+			So(isRetryableRequest(&interfaces.CNSIRequest{Method: "PUT", Header: header}), ShouldBeFalse)
+			header.Set(retryIdempotentHeader, "true")
+			So(isRetryableRequest(&interfaces.CNSIRequest{Method: "PUT", Header: header}), ShouldBeTrue)
+			So(isRetryableRequest(&interfaces.CNSIRequest{Method: "POST", Header: header}), ShouldBeFalse)
+		})
+
+		Convey("should only retry transient failures", func() {
+			So(isRetryableFailure(nil, &endpointRequestError{err: errors.New("connection reset by peer")}), ShouldBeTrue)
+			So(isRetryableFailure(&http.Response{StatusCode: http.StatusServiceUnavailable}, nil), ShouldBeTrue)
+			So(isRetryableFailure(&http.Response{StatusCode: http.StatusInternalServerError}, nil), ShouldBeFalse)
+			So(isRetryableFailure(nil, &circuitOpenError{endpointGUID: mockCFGUID}), ShouldBeFalse)
+			So(isRetryableFailure(nil, &url.Error{Op: "Get", URL: mockAPIEndpoint, Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}), ShouldBeTrue)
+			So(isRetryableFailure(nil, &url.Error{Op: "Get", URL: mockAPIEndpoint, Err: &net.DNSError{Err: "i/o timeout", Name: "api.example.com", IsTimeout: true}}), ShouldBeTrue)
+		})
+
+		Convey("should not retry failures caused by the endpoint's configuration", func() {
+			tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer tlsServer.Close()
+
+			// Certificate that isn't trusted
+			_, err := (&http.Client{}).Get(tlsServer.URL)
+			So(err, ShouldNotBeNil)
+			So(isRetryableFailure(nil, err), ShouldBeFalse)
+			So(isRetryableFailure(nil, &url.Error{Op: "Get", URL: mockAPIEndpoint, Err: x509.HostnameError{Host: "api.example.com"}}), ShouldBeFalse)
+
+			// Host name that doesn't resolve
+			dnsErr := &net.DNSError{Err: "no such host", Name: "api.example.invalid"}
+			So(isRetryableFailure(nil, &url.Error{Op: "Get", URL: mockAPIEndpoint, Err: &net.OpError{Op: "dial", Err: dnsErr}}), ShouldBeFalse)
+
+			// Invalid connection settings for the endpoint
+			_, err = (&http.Client{Transport: &errorTransport{errors.New("Invalid endpoint connection settings")}}).Get(tlsServer.URL)
+			So(err, ShouldNotBeNil)
+			So(isRetryableFailure(nil, err), ShouldBeFalse)
+		})
+
+		Convey("should not retry cancelled requests", func() {
+			So(isRetryableFailure(nil, &url.Error{Op: "Get", URL: mockAPIEndpoint, Err: context.Canceled}), ShouldBeFalse)
+			So(isRetryableFailure(nil, &endpointRequestError{err: context.DeadlineExceeded}), ShouldBeFalse)
+		})
+
+		Convey("should back off exponentially with jitter", func() {
+			base := 100 * time.Millisecond
+			for attempt := 1; attempt <= 3; attempt++ {
+				backoff := base << uint(attempt-1)
+				delay := retryDelay(base, attempt, nil)
+				So(delay, ShouldBeGreaterThanOrEqualTo, backoff/2)
+				So(delay, ShouldBeLessThanOrEqualTo, backoff)
+			}
+		})
+
+		Convey("should honour Retry-After", func() {
+			res := &http.Response{Header: make(http.Header)}
+			res.Header.Set("Retry-After", "7")
+			So(retryDelay(time.Millisecond, 1, res), ShouldEqual, 7*time.Second)
+
+			_, ok := parseRetryAfter("soon")
+			So(ok, ShouldBeFalse)
+			delay, ok := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+			So(ok, ShouldBeTrue)
+			So(delay, ShouldBeGreaterThan, 50*time.Second)
+		})
+	})
+}
+
+func TestProxyRetryRequest(t *testing.T) {
+	t.Parallel()
+
+	Convey("Proxy retry request tests", t, func() {
+		var requests int32
+		mockCFServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, jsonMust(mockV2InfoResponse))
+		}))
+		defer mockCFServer.Close()
+
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		pp.Config.ProxyRetryMaxAttempts = 3
+		pp.Config.ProxyRetryBaseDelayInMillis = 1
+		pp.Config.ProxyRetryBudgetInSecs = 5
+
+		expectProxyRequestQueries(mock, pp.Config.EncryptionKeyInBytes, 2)
+
+		cnsiRequest := &interfaces.CNSIRequest{
+			GUID:     mockCFGUID,
+			UserGUID: mockUserGUID,
+			Method:   "GET",
+			URL:      urlMust(mockCFServer.URL + "/v2/info"),
+		}
+
+		done := make(chan *interfaces.CNSIRequest)
+		go pp.doRequest(cnsiRequest, done)
+		res := <-done
+
+		Convey("should succeed on the second attempt", func() {
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(res.Attempts, ShouldEqual, 2)
+			So(string(res.Response), ShouldEqual, jsonMust(mockV2InfoResponse))
+		})
+	})
+}
+
+func TestProxyRetryCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	Convey("Retried requests should record a single outcome with the circuit breaker", t, func() {
+		var requests int32
+		mockCFServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer mockCFServer.Close()
+
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		pp.Config.ProxyRetryMaxAttempts = 3
+		pp.Config.ProxyRetryBaseDelayInMillis = 1
+		pp.Config.ProxyRetryBudgetInSecs = 5
+		pp.CircuitBreakers = newCircuitBreakers(2, 30, 0)
+
+		expectProxyRequestQueries(mock, pp.Config.EncryptionKeyInBytes, 3)
+
+		cnsiRequest := &interfaces.CNSIRequest{
+			GUID:     mockCFGUID,
+			UserGUID: mockUserGUID,
+			Method:   "GET",
+			URL:      urlMust(mockCFServer.URL + "/v2/info"),
+		}
+
+		done := make(chan *interfaces.CNSIRequest)
+		go pp.doRequest(cnsiRequest, done)
+		res := <-done
+
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+		So(atomic.LoadInt32(&requests), ShouldEqual, 3)
+		So(res.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+		So(res.Attempts, ShouldEqual, 3)
+
+		status := pp.CircuitBreakers.status(mockCFGUID)
+		So(status.Failures, ShouldEqual, 1)
+		So(status.State, ShouldEqual, circuitClosed)
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// How often buckets that have refilled are discarded
+const rateLimitPurgeInterval = time.Minute
+
+// tokenBucket holds the tokens available to one user, endpoint or IP address
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a set of token buckets, one per key, that refill at a fixed rate up to a maximum burst size
+type rateLimiter struct {
+	sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastPurge time.Time
+}
+
+// rateLimiters holds the rate limiters for each of the things that we limit - a nil limiter means no limit
+type rateLimiters struct {
+	User     *rateLimiter
+	Endpoint *rateLimiter
+	Login    *rateLimiter
+}
+
+// newRateLimiter creates a limiter that allows the given number of requests per second, with bursts of up to the given size.
+// Returns nil if the rate is not set, which disables the limit
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastPurge: time.Now(),
+	}
+}
+
+// allow takes a token from the key's bucket. If there are none left, it returns how long until there will be
+func (r *rateLimiter) allow(key string) (bool, time.Duration) {
+	if r == nil {
+		return true, 0
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	r.purge(now)
+
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: r.burst, last: now}
+		r.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(r.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*r.rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / r.rate * float64(time.Second))
+	return false, wait
+}
+
+// Discard buckets that would have refilled by now - they are the same as new buckets
+func (r *rateLimiter) purge(now time.Time) {
+	if now.Sub(r.lastPurge) < rateLimitPurgeInterval {
+		return
+	}
+	r.lastPurge = now
+
+	refill := time.Duration(r.burst / r.rate * float64(time.Second))
+	for key, bucket := range r.buckets {
+		if now.Sub(bucket.last) > refill {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+func newRateLimiters(pc interfaces.PortalConfig) *rateLimiters {
+	return &rateLimiters{
+		User:     newRateLimiter(pc.RateLimitUserPerSec, pc.RateLimitUserBurst),
+		Endpoint: newRateLimiter(pc.RateLimitEndpointPerSec, pc.RateLimitEndpointBurst),
+		Login:    newRateLimiter(pc.RateLimitLoginPerSec, pc.RateLimitLoginBurst),
+	}
+}
+
+func rateLimitExceeded(c echo.Context, wait time.Duration, description string) error {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	return echo.NewHTTPError(http.StatusTooManyRequests, fmt.Sprintf("Too many requests %s - retry after %d second(s)", description, retryAfter))
+}
+
+// userRateLimitMiddleware limits the rate of requests made by each session user
+func (p *portalProxy) userRateLimitMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		log.Debug("userRateLimitMiddleware")
+		if userGUID, err := getPortalUserGUID(c); err == nil {
+			if ok, wait := p.RateLimiters.User.allow(userGUID); !ok {
+				log.Warnf("Rate limit exceeded for user %s", userGUID)
+				return rateLimitExceeded(c, wait, "from this user")
+			}
+		}
+		return h(c)
+	}
+}
+
+// endpointRateLimitMiddleware limits the rate of proxied requests made to each endpoint
+func (p *portalProxy) endpointRateLimitMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		log.Debug("endpointRateLimitMiddleware")
+		cnsiList := c.Request().Header.Get("x-cap-cnsi-list")
+		if len(cnsiList) > 0 {
+			for _, cnsiGUID := range strings.Split(cnsiList, ",") {
+				if ok, wait := p.RateLimiters.Endpoint.allow(cnsiGUID); !ok {
+					log.Warnf("Rate limit exceeded for endpoint %s", cnsiGUID)
+					return rateLimitExceeded(c, wait, "to endpoint "+cnsiGUID)
+				}
+			}
+		}
+		return h(c)
+	}
+}
+
+// loginRateLimitMiddleware limits the rate of login attempts from each IP address
+func (p *portalProxy) loginRateLimitMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		log.Debug("loginRateLimitMiddleware")
+		ip := p.clientIP(c)
+		if ok, wait := p.RateLimiters.Login.allow(ip); !ok {
+			log.Warnf("Login rate limit exceeded for %s", ip)
+			return rateLimitExceeded(c, wait, "from this address")
+		}
+		return h(c)
+	}
+}
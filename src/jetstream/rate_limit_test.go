@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	Convey("Rate limiter tests", t, func() {
+
+		Convey("should allow everything when there is no limit", func() {
+			limiter := newRateLimiter(0, 10)
+			So(limiter, ShouldBeNil)
+			for i := 0; i < 100; i++ {
+				ok, _ := limiter.allow("key")
+				So(ok, ShouldBeTrue)
+			}
+		})
+
+		Convey("should allow a burst and then deny", func() {
+			limiter := newRateLimiter(1, 3)
+			for i := 0; i < 3; i++ {
+				ok, _ := limiter.allow("key")
+				So(ok, ShouldBeTrue)
+			}
+			ok, wait := limiter.allow("key")
+			So(ok, ShouldBeFalse)
+			So(wait, ShouldBeGreaterThan, 0)
+
+			Convey("should limit each key separately", func() {
+				ok, _ := limiter.allow("another-key")
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("should default the burst to the rate", func() {
+			limiter := newRateLimiter(2.5, 0)
+			So(limiter.burst, ShouldEqual, 3)
+		})
+	})
+}
+
+func TestLoginRateLimitMiddleware(t *testing.T) {
+	t.Parallel()
+
+	Convey("Login rate limit middleware tests", t, func() {
+		req := setupMockReq("POST", "", nil)
+		res, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		pp.RateLimiters = &rateLimiters{Login: newRateLimiter(1, 1)}
+		handler := pp.loginRateLimitMiddleware(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+
+		So(handler(ctx), ShouldBeNil)
+
+		err := handler(ctx)
+		So(err, ShouldNotBeNil)
+		httpErr, ok := err.(*echo.HTTPError)
+		So(ok, ShouldBeTrue)
+		So(httpErr.Code, ShouldEqual, http.StatusTooManyRequests)
+		So(res.Header().Get("Retry-After"), ShouldEqual, "1")
+	})
+}
+
+func TestLoginRateLimitForwardedFor(t *testing.T) {
+	t.Parallel()
+
+	Convey("Login rate limit should not be avoided by changing X-Forwarded-For", t, func() {
+		req := setupMockReq("POST", "", nil)
+		req.RemoteAddr = "203.0.113.9:43210"
+		_, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		pp.RateLimiters = &rateLimiters{Login: newRateLimiter(1, 1)}
+		handler := pp.loginRateLimitMiddleware(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+
+		req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.1")
+		So(handler(ctx), ShouldBeNil)
+
+		req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.2")
+		err := handler(ctx)
+		So(err, ShouldNotBeNil)
+		So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusTooManyRequests)
+	})
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/roles"
+)
+
+const (
+	// Keys on the echo Context for the request's user and their roles
+	userContextKey      = "stratos_user"
+	userRolesContextKey = "user_roles"
+
+	// Role for users that haven't been assigned one, unless configured otherwise. This is what they could do before
+	// roles were introduced
+	defaultUserRole = interfaces.RoleEndpointOperator
+)
+
+// RolesRes - The console roles and who they have been assigned to
+type RolesRes struct {
+	Roles       map[string][]string         `json:"roles"`
+	Assignments []interfaces.RoleAssignment `json:"assignments"`
+}
+
+func routePermissionKey(method, path string) string {
+	return method + " " + path
+}
+
+// RequirePermission sets the permission that users need to make requests to the route. Routes in the session group
+// that don't have one need console.read for GET and HEAD requests and endpoints.use for anything else. Routes in the
+// admin group need console.admin
+func (p *portalProxy) RequirePermission(route *echo.Route, permission string) {
+	if p.RoutePermissions == nil {
+		p.RoutePermissions = make(map[string]string)
+	}
+	p.RoutePermissions[routePermissionKey(route.Method, route.Path)] = permission
+}
+
+func (p *portalProxy) getRoutePermission(c echo.Context, defaultPermission string) string {
+	if permission, ok := p.RoutePermissions[routePermissionKey(c.Request().Method, c.Path())]; ok {
+		return permission
+	}
+	return defaultPermission
+}
+
+// getUserRoles returns the roles assigned to the user, or to any of their UAA/OIDC groups. Console admins are
+// always given the console admin role
+func (p *portalProxy) getUserRoles(user *interfaces.ConnectedUser) ([]string, error) {
+	repo, err := roles.NewPgsqlRolesRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, err
+	}
+
+	userRoles, err := repo.FindRoles(user.GUID, user.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Admin && !ArrayContainsString(userRoles, interfaces.RoleConsoleAdmin) {
+		userRoles = append(userRoles, interfaces.RoleConsoleAdmin)
+	}
+	if len(userRoles) == 0 {
+		defaultRole := p.Config.RBACDefaultRole
+		if len(defaultRole) == 0 {
+			defaultRole = defaultUserRole
+		}
+		userRoles = append(userRoles, defaultRole)
+	}
+	return userRoles, nil
+}
+
+// getRequestUser returns the request's user, so that it is only looked up once per request
+func (p *portalProxy) getRequestUser(c echo.Context) (*interfaces.ConnectedUser, error) {
+	if user, ok := c.Get(userContextKey).(*interfaces.ConnectedUser); ok {
+		return user, nil
+	}
+
+	userID, err := p.GetSessionStringValue(c, "user_id")
+	if err != nil {
+		return nil, err
+	}
+	user, err := p.GetStratosUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Set(userContextKey, user)
+	return user, nil
+}
+
+func (p *portalProxy) getRequestUserRoles(c echo.Context) ([]string, error) {
+	if userRoles, ok := c.Get(userRolesContextKey).([]string); ok {
+		return userRoles, nil
+	}
+
+	user, err := p.getRequestUser(c)
+	if err != nil {
+		return nil, err
+	}
+	userRoles, err := p.getUserRoles(user)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Set(userRolesContextKey, userRoles)
+	return userRoles, nil
+}
+
+// getPermissions lists the permissions that the roles grant
+func getPermissions(userRoles []string) []string {
+	permissions := make([]string, 0)
+	for _, permission := range []string{
+		interfaces.PermissionConsoleRead,
+		interfaces.PermissionEndpointsUse,
+		interfaces.PermissionEndpointsManage,
+		interfaces.PermissionUsersManage,
+		interfaces.PermissionConsoleAdmin,
+	} {
+		if interfaces.RolesHavePermission(userRoles, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+// checkPermission makes sure that the request's user has the permission needed for the route
+func (p *portalProxy) checkPermission(c echo.Context, defaultPermission string) error {
+	permission := p.getRoutePermission(c, defaultPermission)
+
+	userRoles, err := p.getRequestUserRoles(c)
+	if err != nil {
+		log.Errorf("Unable to find the roles of the session user: %v", err)
+		return c.NoContent(http.StatusUnauthorized)
+	}
+
+	if !interfaces.RolesHavePermission(userRoles, permission) {
+		return interfaces.NewHTTPShadowError(
+			http.StatusForbidden,
+			fmt.Sprintf("You need the %s permission to access this API", permission),
+			"User with roles %v does not have the %s permission for %s %s", userRoles, permission, c.Request().Method, c.Path())
+	}
+	return nil
+}
+
+// permissionMiddleware checks the permission needed for each route in the session group
+func (p *portalProxy) permissionMiddleware(h echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		defaultPermission := interfaces.PermissionEndpointsUse
+		if method := c.Request().Method; method == http.MethodGet || method == http.MethodHead {
+			defaultPermission = interfaces.PermissionConsoleRead
+		}
+
+		if err := p.checkPermission(c, defaultPermission); err != nil {
+			return err
+		}
+		return h(c)
+	}
+}
+
+func (p *portalProxy) getRolesRepo() (roles.Repository, error) {
+	repo, err := roles.NewPgsqlRolesRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to access role assignments",
+			"Database error getting repo for role assignments: %v", err)
+	}
+	return repo, nil
+}
+
+func (p *portalProxy) listRoles(c echo.Context) error {
+	log.Debug("listRoles")
+	repo, err := p.getRolesRepo()
+	if err != nil {
+		return err
+	}
+
+	assignments, err := repo.List()
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to list role assignments",
+			"Unable to list role assignments: %v", err)
+	}
+	return c.JSON(http.StatusOK, &RolesRes{Roles: interfaces.RolePermissions, Assignments: assignments})
+}
+
+func validateRoleAssignment(assignment interfaces.RoleAssignment) error {
+	if assignment.PrincipalType != interfaces.RolePrincipalUser && assignment.PrincipalType != interfaces.RolePrincipalGroup {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid principal type '%s'", assignment.PrincipalType))
+	}
+	if len(assignment.Principal) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Needs a principal")
+	}
+	if !interfaces.IsValidRole(assignment.Role) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid role '%s'", assignment.Role))
+	}
+	return nil
+}
+
+// assignRole gives a role to a user or UAA/OIDC group
+func (p *portalProxy) assignRole(c echo.Context) error {
+	log.Debug("assignRole")
+	assignment := interfaces.RoleAssignment{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&assignment); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid role assignment",
+			"Invalid role assignment: %v", err)
+	}
+	setAuditTarget(c, fmt.Sprintf("%s %s: %s", assignment.PrincipalType, assignment.Principal, assignment.Role))
+	if err := validateRoleAssignment(assignment); err != nil {
+		return err
+	}
+
+	repo, err := p.getRolesRepo()
+	if err != nil {
+		return err
+	}
+	if err := repo.Add(assignment); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to assign role",
+			"Unable to assign role %s to %s %s: %v", assignment.Role, assignment.PrincipalType, assignment.Principal, err)
+	}
+
+	log.Infof("Assigned role %s to %s %s", assignment.Role, assignment.PrincipalType, assignment.Principal)
+	return c.JSON(http.StatusCreated, &assignment)
+}
+
+// auditRoleAssignment is the audit log target for a role assignment route
+func auditRoleAssignment(c echo.Context) string {
+	principal, _ := url.PathUnescape(c.Param("principal"))
+	return fmt.Sprintf("%s %s: %s", c.Param("type"), principal, c.Param("role"))
+}
+
+// unassignRole removes a role from a user or UAA/OIDC group
+func (p *portalProxy) unassignRole(c echo.Context) error {
+	log.Debug("unassignRole")
+	principal, err := url.PathUnescape(c.Param("principal"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid principal")
+	}
+	assignment := interfaces.RoleAssignment{
+		PrincipalType: c.Param("type"),
+		Principal:     principal,
+		Role:          c.Param("role"),
+	}
+	if err := validateRoleAssignment(assignment); err != nil {
+		return err
+	}
+
+	repo, err := p.getRolesRepo()
+	if err != nil {
+		return err
+	}
+	if err := repo.Delete(assignment); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Role assignment not found",
+			"Unable to remove role %s from %s %s: %v", assignment.Role, assignment.PrincipalType, assignment.Principal, err)
+	}
+
+	log.Infof("Removed role %s from %s %s", assignment.Role, assignment.PrincipalType, assignment.Principal)
+	return c.NoContent(http.StatusNoContent)
+}
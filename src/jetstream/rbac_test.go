@@ -0,0 +1,283 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/cfapppush"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/cfappssh"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	insertRoleAssignment = `INSERT INTO role_assignments (.+)`
+	deleteRoleAssignment = `DELETE FROM role_assignments (.+)`
+)
+
+func TestRolesHavePermission(t *testing.T) {
+	t.Parallel()
+
+	Convey("Role permission tests", t, func() {
+		viewer := []string{interfaces.RoleViewer}
+		So(interfaces.RolesHavePermission(viewer, interfaces.PermissionConsoleRead), ShouldBeTrue)
+		So(interfaces.RolesHavePermission(viewer, interfaces.PermissionEndpointsUse), ShouldBeFalse)
+
+		endpointAdmin := []string{interfaces.RoleEndpointAdmin}
+		So(interfaces.RolesHavePermission(endpointAdmin, interfaces.PermissionEndpointsManage), ShouldBeTrue)
+		So(interfaces.RolesHavePermission(endpointAdmin, interfaces.PermissionUsersManage), ShouldBeFalse)
+
+		consoleAdmin := []string{interfaces.RoleConsoleAdmin}
+		So(interfaces.RolesHavePermission(consoleAdmin, interfaces.PermissionUsersManage), ShouldBeTrue)
+		So(getPermissions(consoleAdmin), ShouldHaveLength, 5)
+
+		So(interfaces.RolesHavePermission([]string{"unknown"}, interfaces.PermissionConsoleRead), ShouldBeFalse)
+		So(interfaces.RolesHavePermission(nil, interfaces.PermissionConsoleRead), ShouldBeFalse)
+	})
+}
+
+func TestPermissionMiddleware(t *testing.T) {
+	t.Parallel()
+
+	Convey("Permission middleware tests", t, func() {
+		setup := func(method, path string) (echo.Context, *portalProxy, sqlmock.Sqlmock, func()) {
+			req := setupMockReq(method, "", nil)
+			_, _, ctx, pp, db, mock := setupHTTPTest(req)
+			pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+			pp.Config.ConsoleConfig.ConsoleAdminScope = UAAAdminIdentifier
+			ctx.SetPath(path)
+			pp.setSessionValues(ctx, map[string]interface{}{"user_id": mockUserGUID})
+			return ctx, pp, mock, func() { db.Close() }
+		}
+		expectUser := func(mock sqlmock.Sqlmock, scope string, assignments ...interfaces.RoleAssignment) {
+			mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("user", "", scope, "", "", false))
+			rows := sqlmock.NewRows(rowFieldsForRoleAssignment)
+			for _, assignment := range assignments {
+				rows.AddRow(assignment.PrincipalType, assignment.Principal, assignment.Role)
+			}
+			mock.ExpectQuery(findRoleAssignments).
+				WithArgs(interfaces.RolePrincipalUser, mockUserGUID, interfaces.RolePrincipalGroup).
+				WillReturnRows(rows)
+		}
+		viewer := interfaces.RoleAssignment{PrincipalType: interfaces.RolePrincipalUser, Principal: mockUserGUID, Role: interfaces.RoleViewer}
+
+		handlerCalled := false
+		handler := func(c echo.Context) error {
+			handlerCalled = true
+			return nil
+		}
+		expectForbidden := func(err error) {
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusForbidden)
+			So(handlerCalled, ShouldBeFalse)
+		}
+
+		Convey("should give users without roles the default role", func() {
+			ctx, pp, mock, done := setup("POST", "/pp/v1/auth/login/cnsi")
+			defer done()
+			expectUser(mock, "stratos.user")
+
+			So(pp.permissionMiddleware(handler)(ctx), ShouldBeNil)
+			So(handlerCalled, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should use the configured default role", func() {
+			ctx, pp, mock, done := setup("POST", "/pp/v1/auth/login/cnsi")
+			defer done()
+			pp.Config.RBACDefaultRole = interfaces.RoleViewer
+			expectUser(mock, "stratos.user")
+
+			expectForbidden(pp.permissionMiddleware(handler)(ctx))
+		})
+
+		Convey("should let viewers make GET requests", func() {
+			ctx, pp, mock, done := setup("GET", "/pp/v1/cnsis")
+			defer done()
+			expectUser(mock, "stratos.user", viewer)
+
+			So(pp.permissionMiddleware(handler)(ctx), ShouldBeNil)
+			So(handlerCalled, ShouldBeTrue)
+		})
+
+		Convey("should not let viewers make other requests", func() {
+			ctx, pp, mock, done := setup("POST", "/pp/v1/proxy/*")
+			defer done()
+			expectUser(mock, "stratos.user", viewer)
+
+			expectForbidden(pp.permissionMiddleware(handler)(ctx))
+		})
+
+		Convey("should use the permission declared for the route", func() {
+			ctx, pp, mock, done := setup("POST", "/pp/v1/favorites")
+			defer done()
+			pp.RequirePermission(&echo.Route{Method: "POST", Path: "/pp/v1/favorites"}, interfaces.PermissionConsoleRead)
+			expectUser(mock, "stratos.user", viewer)
+
+			So(pp.permissionMiddleware(handler)(ctx), ShouldBeNil)
+			So(handlerCalled, ShouldBeTrue)
+		})
+
+		Convey("should not let viewers use plugin routes that make changes", func() {
+			for _, path := range []string{"/pp/v1/:cnsiGuid/apps/:appGuid/ssh/:appInstance", "/pp/v1/:cnsiGuid/:orgGuid/:spaceGuid/deploy"} {
+				ctx, pp, mock, done := setup("GET", path)
+				for _, init := range []func(interfaces.PortalProxy) (interfaces.StratosPlugin, error){cfappssh.Init, cfapppush.Init} {
+					plugin, _ := init(pp)
+					routePlugin, _ := plugin.GetRoutePlugin()
+					routePlugin.AddSessionGroupRoutes(echo.New().Group("/pp/v1"))
+				}
+				expectUser(mock, "stratos.user", viewer)
+
+				expectForbidden(pp.permissionMiddleware(handler)(ctx))
+				done()
+			}
+		})
+
+		Convey("should match roles assigned to the user's groups", func() {
+			ctx, pp, mock, done := setup("POST", "/pp/v1/unregister")
+			defer done()
+			pp.RequirePermission(&echo.Route{Method: "POST", Path: "/pp/v1/unregister"}, interfaces.PermissionEndpointsManage)
+			expectUser(mock, "stratos.user",
+				interfaces.RoleAssignment{PrincipalType: interfaces.RolePrincipalGroup, Principal: "other.group", Role: interfaces.RoleConsoleAdmin},
+				interfaces.RoleAssignment{PrincipalType: interfaces.RolePrincipalGroup, Principal: "stratos.user", Role: interfaces.RoleEndpointAdmin})
+
+			So(pp.adminMiddleware(handler)(ctx), ShouldBeNil)
+			So(handlerCalled, ShouldBeTrue)
+		})
+
+		Convey("should need console.admin for admin routes that don't declare a permission", func() {
+			ctx, pp, mock, done := setup("GET", "/pp/v1/roles")
+			defer done()
+			expectUser(mock, "stratos.user",
+				interfaces.RoleAssignment{PrincipalType: interfaces.RolePrincipalUser, Principal: mockUserGUID, Role: interfaces.RoleEndpointAdmin})
+
+			expectForbidden(pp.adminMiddleware(handler)(ctx))
+		})
+
+		Convey("should always let console admins through", func() {
+			ctx, pp, mock, done := setup("DELETE", "/pp/v1/users/local/:id")
+			defer done()
+			pp.RequirePermission(&echo.Route{Method: "DELETE", Path: "/pp/v1/users/local/:id"}, interfaces.PermissionUsersManage)
+			expectUser(mock, UAAAdminIdentifier)
+
+			So(pp.adminMiddleware(handler)(ctx), ShouldBeNil)
+			So(handlerCalled, ShouldBeTrue)
+		})
+	})
+}
+
+func TestRoleAssignment(t *testing.T) {
+	t.Parallel()
+
+	Convey("Role assignment tests", t, func() {
+
+		Convey("should assign a role to a group", func() {
+			res, ctx, pp, db, mock := setupLocalUserAdminTest("POST", `{"principal_type":"group","principal":"ops","role":"endpoint-admin"}`, "")
+			defer db.Close()
+
+			mock.ExpectExec(insertRoleAssignment).
+				WithArgs(interfaces.RolePrincipalGroup, "ops", interfaces.RoleEndpointAdmin).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			So(pp.assignRole(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusCreated)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not assign an unknown role", func() {
+			_, ctx, pp, db, _ := setupLocalUserAdminTest("POST", `{"principal_type":"user","principal":"bob","role":"superuser"}`, "")
+			defer db.Close()
+
+			err := pp.assignRole(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should remove a role", func() {
+			res, ctx, pp, db, mock := setupLocalUserAdminTest("DELETE", "", "")
+			defer db.Close()
+			ctx.SetParamNames("type", "principal", "role")
+			ctx.SetParamValues("group", "cloud%20ops", "viewer")
+
+			mock.ExpectExec(deleteRoleAssignment).
+				WithArgs(interfaces.RolePrincipalGroup, "cloud ops", interfaces.RoleViewer).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			So(pp.unassignRole(ctx), ShouldBeNil)
+			So(res.Code, ShouldEqual, http.StatusNoContent)
+		})
+
+		Convey("should fail to remove a role that wasn't assigned", func() {
+			_, ctx, pp, db, mock := setupLocalUserAdminTest("DELETE", "", "")
+			defer db.Close()
+			ctx.SetParamNames("type", "principal", "role")
+			ctx.SetParamValues("user", "bob", "viewer")
+
+			mock.ExpectExec(deleteRoleAssignment).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			err := pp.unassignRole(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func TestSystemSharedTokenPermission(t *testing.T) {
+	t.Parallel()
+
+	Convey("System shared endpoint token tests", t, func() {
+		req := setupMockReq("POST", "", map[string]string{"cnsi_guid": mockCNSIGUID})
+		_, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		pp.setSessionValues(ctx, map[string]interface{}{"user_id": mockUserGUID})
+
+		mock.ExpectQuery(selectAnyFromCNSIs).
+			WithArgs(mockCNSIGUID).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForCNSI).
+				AddRow(mockCNSIGUID, "mockCF", "cf", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "", "", "", nil, ""))
+
+		Convey("should not let users that can't manage endpoints connect a shared token", func() {
+			// Roles, not being a UAA admin, decide
+			ctx.Set(userRolesContextKey, []string{interfaces.RoleViewer})
+			mock.ExpectQuery(listCNSIVisibility).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForCNSIVisibility))
+
+			_, err := pp.DoLoginToCNSI(ctx, mockCNSIGUID, true)
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusForbidden)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("with a shared token", func() {
+			encryptedToken, _ := crypto.EncryptToken(pp.Config.EncryptionKeyInBytes, mockUAAToken)
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCNSIGUID, mockUserGUID, mockAdminGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "disconnected", "auth_type", "meta_data", "user_guid", "linked_token"}).
+					AddRow(mockTokenGUID, encryptedToken, encryptedToken, mockTokenExpiry, false, interfaces.AuthTypeOAuth2, "", mockAdminGUID, nil))
+
+			Convey("should let endpoint admins disconnect it", func() {
+				ctx.Set(userRolesContextKey, []string{interfaces.RoleEndpointAdmin})
+				mock.ExpectExec(`DELETE FROM tokens WHERE token_type = 'cnsi' AND cnsi_guid = (.+)`).
+					WithArgs(mockCNSIGUID, mockAdminGUID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+
+				So(pp.logoutOfCNSI(ctx), ShouldBeNil)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+
+			Convey("should not let viewers disconnect it", func() {
+				ctx.Set(userRolesContextKey, []string{interfaces.RoleViewer})
+
+				err := pp.logoutOfCNSI(ctx)
+				So(err, ShouldNotBeNil)
+				So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusForbidden)
+				So(mock.ExpectationsWereMet(), ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,16 @@
+package apitokens
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Repository is an application of the repository pattern for storing personal API tokens
+type Repository interface {
+	Create(token interfaces.APIToken) error
+	FindByHash(tokenHash string) (interfaces.APIToken, error)
+	ListByUser(userGUID string) ([]interfaces.APIToken, error)
+	Delete(userGUID string, guid string) error
+	UpdateLastUsed(guid string, lastUsed time.Time) error
+}
@@ -0,0 +1,136 @@
+package apitokens
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+var insertAPIToken = `INSERT INTO api_tokens (guid, user_guid, name, token_hash, scopes, created, expires) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+var findAPITokenByHash = `SELECT guid, user_guid, name, scopes, created, expires, last_used FROM api_tokens WHERE token_hash = $1`
+var listAPITokensByUser = `SELECT guid, user_guid, name, scopes, created, expires, last_used FROM api_tokens WHERE user_guid = $1 ORDER BY created`
+var deleteAPIToken = `DELETE FROM api_tokens WHERE user_guid = $1 AND guid = $2`
+var updateAPITokenLastUsed = `UPDATE api_tokens SET last_used = $1 WHERE guid = $2`
+
+// PgsqlAPITokensRepository is a PostgreSQL-backed personal API token repository
+type PgsqlAPITokensRepository struct {
+	db *sql.DB
+}
+
+// NewPgsqlAPITokensRepository - get a reference to the API tokens data source
+func NewPgsqlAPITokensRepository(dcp *sql.DB) (Repository, error) {
+	log.Debug("NewPgsqlAPITokensRepository")
+	return &PgsqlAPITokensRepository{db: dcp}, nil
+}
+
+// InitRepositoryProvider - One time init for the given DB Provider
+func InitRepositoryProvider(databaseProvider string) {
+	// Modify the database statements if needed, for the given database type
+	insertAPIToken = datastore.ModifySQLStatement(insertAPIToken, databaseProvider)
+	findAPITokenByHash = datastore.ModifySQLStatement(findAPITokenByHash, databaseProvider)
+	listAPITokensByUser = datastore.ModifySQLStatement(listAPITokensByUser, databaseProvider)
+	deleteAPIToken = datastore.ModifySQLStatement(deleteAPIToken, databaseProvider)
+	updateAPITokenLastUsed = datastore.ModifySQLStatement(updateAPITokenLastUsed, databaseProvider)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIToken(row scanner) (interfaces.APIToken, error) {
+	var (
+		token  interfaces.APIToken
+		scopes string
+	)
+	if err := row.Scan(&token.GUID, &token.UserGUID, &token.Name, &scopes, &token.Created, &token.Expires, &token.LastUsed); err != nil {
+		return token, err
+	}
+	if len(scopes) > 0 {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	return token, nil
+}
+
+// Create saves a new API token
+func (p *PgsqlAPITokensRepository) Create(token interfaces.APIToken) error {
+	log.Debug("Create")
+	if token.GUID == "" || token.UserGUID == "" || token.TokenHash == "" {
+		return errors.New("Unable to save API token without a valid GUID, user GUID and hash")
+	}
+
+	if _, err := p.db.Exec(insertAPIToken, token.GUID, token.UserGUID, token.Name, token.TokenHash, strings.Join(token.Scopes, ","), token.Created, token.Expires); err != nil {
+		return fmt.Errorf("Unable to INSERT API token: %v", err)
+	}
+	return nil
+}
+
+// FindByHash finds the API token with the given hash
+func (p *PgsqlAPITokensRepository) FindByHash(tokenHash string) (interfaces.APIToken, error) {
+	log.Debug("FindByHash")
+	if tokenHash == "" {
+		return interfaces.APIToken{}, errors.New("Unable to find API token without a valid hash")
+	}
+
+	token, err := scanAPIToken(p.db.QueryRow(findAPITokenByHash, tokenHash))
+	if err != nil {
+		return token, fmt.Errorf("Unable to find API token: %v", err)
+	}
+	token.TokenHash = tokenHash
+	return token, nil
+}
+
+// ListByUser returns all of a user's API tokens
+func (p *PgsqlAPITokensRepository) ListByUser(userGUID string) ([]interfaces.APIToken, error) {
+	log.Debug("ListByUser")
+
+	rows, err := p.db.Query(listAPITokensByUser, userGUID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve API tokens: %v", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]interfaces.APIToken, 0)
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to scan API token: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to retrieve API tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+// Delete revokes one of a user's API tokens
+func (p *PgsqlAPITokensRepository) Delete(userGUID string, guid string) error {
+	log.Debug("Delete")
+
+	result, err := p.db.Exec(deleteAPIToken, userGUID, guid)
+	if err != nil {
+		return fmt.Errorf("Unable to DELETE API token: %v", err)
+	}
+	if rowsDeleted, err := result.RowsAffected(); err != nil || rowsDeleted < 1 {
+		return errors.New("Unable to DELETE API token: no rows were deleted")
+	}
+	return nil
+}
+
+// UpdateLastUsed records when a token was last used
+func (p *PgsqlAPITokensRepository) UpdateLastUsed(guid string, lastUsed time.Time) error {
+	log.Debug("UpdateLastUsed")
+
+	if _, err := p.db.Exec(updateAPITokenLastUsed, lastUsed, guid); err != nil {
+		return fmt.Errorf("Unable to UPDATE API token: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package audit
+
+import (
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Repository is an application of the repository pattern for the append-only audit log
+type Repository interface {
+	Add(event interfaces.AuditEvent) error
+	List(filter interfaces.AuditFilter) ([]interfaces.AuditEvent, int64, error)
+}
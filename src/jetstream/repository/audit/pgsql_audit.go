@@ -0,0 +1,128 @@
+package audit
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+var insertAuditEvent = `INSERT INTO audit_log (guid, event_time, actor_guid, actor_name, action, target, source_ip, outcome, detail) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+// Searches are built from the filter, so the placeholders are rewritten when they are used
+var listAuditEvents = `SELECT guid, event_time, actor_guid, actor_name, action, target, source_ip, outcome, detail FROM audit_log`
+var countAuditEvents = `SELECT COUNT(*) FROM audit_log`
+
+var databaseProvider string
+
+// PgsqlAuditRepository is a PostgreSQL-backed audit log repository
+type PgsqlAuditRepository struct {
+	db *sql.DB
+}
+
+// NewPgsqlAuditRepository - get a reference to the audit log data source
+func NewPgsqlAuditRepository(dcp *sql.DB) (Repository, error) {
+	log.Debug("NewPgsqlAuditRepository")
+	return &PgsqlAuditRepository{db: dcp}, nil
+}
+
+// InitRepositoryProvider - One time init for the given DB Provider
+func InitRepositoryProvider(provider string) {
+	// Modify the database statements if needed, for the given database type
+	databaseProvider = provider
+	insertAuditEvent = datastore.ModifySQLStatement(insertAuditEvent, databaseProvider)
+}
+
+// Add appends an event to the audit log
+func (p *PgsqlAuditRepository) Add(event interfaces.AuditEvent) error {
+	log.Debug("Add")
+	if event.GUID == "" || event.Action == "" {
+		return errors.New("Unable to add audit event without a valid guid and action")
+	}
+
+	if _, err := p.db.Exec(insertAuditEvent, event.GUID, event.Timestamp, event.ActorGUID, event.ActorName, event.Action,
+		event.Target, event.SourceIP, event.Outcome, event.Detail); err != nil {
+		return fmt.Errorf("Unable to INSERT audit event: %v", err)
+	}
+	return nil
+}
+
+func auditFilterWhere(filter interfaces.AuditFilter) (string, []interface{}) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if len(filter.Actor) > 0 {
+		// MySQL and SQLite placeholders can't be repeated, so the actor is passed twice
+		args = append(args, filter.Actor, filter.Actor)
+		conditions = append(conditions, fmt.Sprintf("(actor_guid = $%d OR actor_name = $%d)", len(args)-1, len(args)))
+	}
+	if len(filter.Action) > 0 {
+		add("action = $%d", filter.Action)
+	}
+	if len(filter.Target) > 0 {
+		add("target = $%d", filter.Target)
+	}
+	if len(filter.Outcome) > 0 {
+		add("outcome = $%d", filter.Outcome)
+	}
+	if filter.From != nil {
+		add("event_time >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("event_time < $%d", *filter.To)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// List returns a page of the audit events that match the filter, newest first, and the total number that match
+func (p *PgsqlAuditRepository) List(filter interfaces.AuditFilter) ([]interfaces.AuditEvent, int64, error) {
+	log.Debug("List")
+	where, args := auditFilterWhere(filter)
+
+	var total int64
+	query := datastore.ModifySQLStatement(countAuditEvents+where, databaseProvider)
+	if err := p.db.QueryRow(query, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("Unable to count audit events: %v", err)
+	}
+
+	query = datastore.ModifySQLStatement(fmt.Sprintf("%s%s ORDER BY event_time DESC LIMIT %d OFFSET %d",
+		listAuditEvents, where, filter.Limit, filter.Offset), databaseProvider)
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Unable to retrieve audit events: %v", err)
+	}
+	defer rows.Close()
+
+	events := make([]interfaces.AuditEvent, 0)
+	for rows.Next() {
+		var event interfaces.AuditEvent
+		var actorGUID, actorName, target, sourceIP, detail sql.NullString
+		if err := rows.Scan(&event.GUID, &event.Timestamp, &actorGUID, &actorName, &event.Action, &target, &sourceIP,
+			&event.Outcome, &detail); err != nil {
+			return nil, 0, fmt.Errorf("Unable to scan audit event: %v", err)
+		}
+		event.ActorGUID = actorGUID.String
+		event.ActorName = actorName.String
+		event.Target = target.String
+		event.SourceIP = sourceIP.String
+		event.Detail = detail.String
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("Unable to retrieve audit events: %v", err)
+	}
+	return events, total, nil
+}
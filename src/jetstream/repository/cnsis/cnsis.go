@@ -14,6 +14,10 @@ type Repository interface {
 	Save(guid string, cnsiRecord interfaces.CNSIRecord, encryptionKey []byte) error
 	Update(guid string, ssoAllowed bool) error
 	UpdateMetadata(guid string, metadata string) error
+	ListVisibility() (map[string][]interfaces.EndpointPrincipal, error)
+	FindVisibility(guid string) ([]interfaces.EndpointPrincipal, error)
+	SetVisibility(guid string, principals []interfaces.EndpointPrincipal) error
+	ReEncrypt(encryptionKey []byte) (int, error)
 }
 
 type Endpoint interface {
@@ -12,23 +12,23 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-var listCNSIs = `SELECT guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed, sub_type, meta_data
+var listCNSIs = `SELECT guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed, sub_type, meta_data, ca_cert, client_cert, client_cert_key, proxy_url
 							FROM cnsis`
 
 var listCNSIsByUser = `SELECT c.guid, c.name, c.cnsi_type, c.api_endpoint, c.doppler_logging_endpoint, t.user_guid, t.token_expiry, c.skip_ssl_validation, t.disconnected, t.meta_data, c.sub_type, c.meta_data as endpoint_metadata
 										FROM cnsis c, tokens t
 										WHERE c.guid = t.cnsi_guid AND t.token_type=$1 AND t.user_guid=$2 AND t.disconnected = '0'`
 
-var findCNSI = `SELECT guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed, sub_type, meta_data
+var findCNSI = `SELECT guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed, sub_type, meta_data, ca_cert, client_cert, client_cert_key, proxy_url
 						FROM cnsis
 						WHERE guid=$1`
 
-var findCNSIByAPIEndpoint = `SELECT guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed, sub_type, meta_data
+var findCNSIByAPIEndpoint = `SELECT guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed, sub_type, meta_data, ca_cert, client_cert, client_cert_key, proxy_url
 						FROM cnsis
 						WHERE api_endpoint=$1`
 
-var saveCNSI = `INSERT INTO cnsis (guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed, sub_type, meta_data)
-						VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+var saveCNSI = `INSERT INTO cnsis (guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed, sub_type, meta_data, ca_cert, client_cert, client_cert_key, proxy_url)
+						VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`
 
 var deleteCNSI = `DELETE FROM cnsis WHERE guid = $1`
 
@@ -38,6 +38,30 @@ var updateCNSI = `UPDATE cnsis SET sso_allowed = $1 WHERE guid = $2`
 // Update the metadata
 var updateCNSIMetadata = `UPDATE cnsis SET meta_data = $1 WHERE guid = $2`
 
+// connectionColumns are the nullable columns holding an endpoint's connection settings
+type connectionColumns struct {
+	caCert                  sql.NullString
+	clientCert              sql.NullString
+	cipherTextClientCertKey []byte
+	proxyURL                sql.NullString
+}
+
+func (c *connectionColumns) settings(encryptionKey []byte) (interfaces.EndpointConnectionSettings, error) {
+	settings := interfaces.EndpointConnectionSettings{
+		CACert:     c.caCert.String,
+		ClientCert: c.clientCert.String,
+		ProxyURL:   c.proxyURL.String,
+	}
+	if len(c.cipherTextClientCertKey) > 0 {
+		plaintextKey, err := crypto.DecryptToken(encryptionKey, c.cipherTextClientCertKey)
+		if err != nil {
+			return settings, err
+		}
+		settings.ClientCertKey = plaintextKey
+	}
+	return settings, nil
+}
+
 // PostgresCNSIRepository is a PostgreSQL-backed CNSI repository
 type PostgresCNSIRepository struct {
 	db *sql.DB
@@ -59,6 +83,12 @@ func InitRepositoryProvider(databaseProvider string) {
 	deleteCNSI = datastore.ModifySQLStatement(deleteCNSI, databaseProvider)
 	updateCNSI = datastore.ModifySQLStatement(updateCNSI, databaseProvider)
 	updateCNSIMetadata = datastore.ModifySQLStatement(updateCNSIMetadata, databaseProvider)
+	listCNSIVisibility = datastore.ModifySQLStatement(listCNSIVisibility, databaseProvider)
+	findCNSIVisibility = datastore.ModifySQLStatement(findCNSIVisibility, databaseProvider)
+	insertCNSIVisibility = datastore.ModifySQLStatement(insertCNSIVisibility, databaseProvider)
+	deleteCNSIVisibility = datastore.ModifySQLStatement(deleteCNSIVisibility, databaseProvider)
+	listCNSICipherText = datastore.ModifySQLStatement(listCNSICipherText, databaseProvider)
+	updateCNSICipherText = datastore.ModifySQLStatement(updateCNSICipherText, databaseProvider)
 }
 
 // List - Returns a list of CNSI Records
@@ -80,15 +110,21 @@ func (p *PostgresCNSIRepository) List(encryptionKey []byte) ([]*interfaces.CNSIR
 			cipherTextClientSecret []byte
 			subType                sql.NullString
 			metadata               sql.NullString
+			connection             connectionColumns
 		)
 
 		cnsi := new(interfaces.CNSIRecord)
 
-		err := rows.Scan(&cnsi.GUID, &cnsi.Name, &pCNSIType, &pURL, &cnsi.AuthorizationEndpoint, &cnsi.TokenEndpoint, &cnsi.DopplerLoggingEndpoint, &cnsi.SkipSSLValidation, &cnsi.ClientId, &cipherTextClientSecret, &cnsi.SSOAllowed, &subType, &metadata)
+		err := rows.Scan(&cnsi.GUID, &cnsi.Name, &pCNSIType, &pURL, &cnsi.AuthorizationEndpoint, &cnsi.TokenEndpoint, &cnsi.DopplerLoggingEndpoint, &cnsi.SkipSSLValidation, &cnsi.ClientId, &cipherTextClientSecret, &cnsi.SSOAllowed, &subType, &metadata,
+			&connection.caCert, &connection.clientCert, &connection.cipherTextClientCertKey, &connection.proxyURL)
 		if err != nil {
 			return nil, fmt.Errorf("Unable to scan CNSI records: %v", err)
 		}
 
+		if cnsi.Connection, err = connection.settings(encryptionKey); err != nil {
+			return nil, err
+		}
+
 		cnsi.CNSIType = pCNSIType
 
 		if cnsi.APIEndpoint, err = url.Parse(pURL); err != nil {
@@ -200,12 +236,14 @@ func (p *PostgresCNSIRepository) findBy(query, match string, encryptionKey []byt
 		cipherTextClientSecret []byte
 		subType                sql.NullString
 		metadata               sql.NullString
+		connection             connectionColumns
 	)
 
 	cnsi := new(interfaces.CNSIRecord)
 
 	err := p.db.QueryRow(query, match).Scan(&cnsi.GUID, &cnsi.Name, &pCNSIType, &pURL,
-		&cnsi.AuthorizationEndpoint, &cnsi.TokenEndpoint, &cnsi.DopplerLoggingEndpoint, &cnsi.SkipSSLValidation, &cnsi.ClientId, &cipherTextClientSecret, &cnsi.SSOAllowed, &subType, &metadata)
+		&cnsi.AuthorizationEndpoint, &cnsi.TokenEndpoint, &cnsi.DopplerLoggingEndpoint, &cnsi.SkipSSLValidation, &cnsi.ClientId, &cipherTextClientSecret, &cnsi.SSOAllowed, &subType, &metadata,
+		&connection.caCert, &connection.clientCert, &connection.cipherTextClientCertKey, &connection.proxyURL)
 
 	switch {
 	case err == sql.ErrNoRows:
@@ -224,6 +262,10 @@ func (p *PostgresCNSIRepository) findBy(query, match string, encryptionKey []byt
 		cnsi.Metadata = metadata.String
 	}
 
+	if cnsi.Connection, err = connection.settings(encryptionKey); err != nil {
+		return interfaces.CNSIRecord{}, err
+	}
+
 	cnsi.CNSIType = pCNSIType
 
 	if cnsi.APIEndpoint, err = url.Parse(pURL); err != nil {
@@ -251,9 +293,19 @@ func (p *PostgresCNSIRepository) Save(guid string, cnsi interfaces.CNSIRecord, e
 	if err != nil {
 		return err
 	}
+	// Store NULL rather than an empty value when there is no client certificate key
+	var cipherTextClientCertKey interface{}
+	if len(cnsi.Connection.ClientCertKey) > 0 {
+		cipherTextKey, err := crypto.EncryptToken(encryptionKey, cnsi.Connection.ClientCertKey)
+		if err != nil {
+			return err
+		}
+		cipherTextClientCertKey = cipherTextKey
+	}
 	if _, err := p.db.Exec(saveCNSI, guid, cnsi.Name, fmt.Sprintf("%s", cnsi.CNSIType),
 		fmt.Sprintf("%s", cnsi.APIEndpoint), cnsi.AuthorizationEndpoint, cnsi.TokenEndpoint, cnsi.DopplerLoggingEndpoint, cnsi.SkipSSLValidation,
-		cnsi.ClientId, cipherTextClientSecret, cnsi.SSOAllowed, cnsi.SubType, cnsi.Metadata); err != nil {
+		cnsi.ClientId, cipherTextClientSecret, cnsi.SSOAllowed, cnsi.SubType, cnsi.Metadata,
+		cnsi.Connection.CACert, cnsi.Connection.ClientCert, cipherTextClientCertKey, cnsi.Connection.ProxyURL); err != nil {
 		return fmt.Errorf("Unable to Save CNSI record: %v", err)
 	}
 
@@ -0,0 +1,89 @@
+package cnsis
+
+import (
+	"database/sql"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+)
+
+var listCNSICipherText = `SELECT guid, client_secret, client_cert_key FROM cnsis`
+
+var updateCNSICipherText = `UPDATE cnsis SET client_secret = $1, client_cert_key = $2 WHERE guid = $3`
+
+type cnsiCipherText struct {
+	guid          string
+	clientSecret  []byte
+	clientCertKey []byte
+}
+
+// ReEncrypt - Re-encrypt any endpoint secrets that were encrypted with a previous key. Returns the number of
+// endpoints updated
+func (p *PostgresCNSIRepository) ReEncrypt(encryptionKey []byte) (int, error) {
+	log.Debug("ReEncrypt")
+	txn, err := p.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to re-encrypt endpoint secrets: %v", err)
+	}
+
+	updated, err := reEncryptCNSIs(txn, encryptionKey)
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return 0, fmt.Errorf("Unable to re-encrypt endpoint secrets: %v", err)
+	}
+	return updated, nil
+}
+
+func reEncryptCNSIs(txn *sql.Tx, encryptionKey []byte) (int, error) {
+	rows, err := txn.Query(listCNSICipherText)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to retrieve CNSI records: %v", err)
+	}
+
+	// Read everything before updating, as not all drivers support updates while iterating over a query
+	cnsis := make([]cnsiCipherText, 0)
+	for rows.Next() {
+		var cnsi cnsiCipherText
+		if err := rows.Scan(&cnsi.guid, &cnsi.clientSecret, &cnsi.clientCertKey); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("Unable to scan CNSI record: %v", err)
+		}
+		cnsis = append(cnsis, cnsi)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("Unable to retrieve CNSI records: %v", err)
+	}
+
+	updated := 0
+	for _, cnsi := range cnsis {
+		clientSecret, clientSecretChanged, err := crypto.ReEncryptToken(encryptionKey, cnsi.clientSecret)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to re-encrypt client secret of endpoint %s: %v", cnsi.guid, err)
+		}
+		clientCertKey, clientCertKeyChanged, err := crypto.ReEncryptToken(encryptionKey, cnsi.clientCertKey)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to re-encrypt client certificate key of endpoint %s: %v", cnsi.guid, err)
+		}
+		if !clientSecretChanged && !clientCertKeyChanged {
+			continue
+		}
+
+		// Keep NULL rather than an empty value when there is no client certificate key
+		var cipherTextClientCertKey interface{}
+		if len(clientCertKey) > 0 {
+			cipherTextClientCertKey = clientCertKey
+		}
+		if _, err := txn.Exec(updateCNSICipherText, clientSecret, cipherTextClientCertKey, cnsi.guid); err != nil {
+			return 0, fmt.Errorf("Unable to UPDATE CNSI record %s: %v", cnsi.guid, err)
+		}
+		updated++
+	}
+	return updated, nil
+}
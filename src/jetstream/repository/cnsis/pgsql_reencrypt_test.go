@@ -0,0 +1,50 @@
+package cnsis
+
+import (
+	"testing"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPgSQLCNSIReEncrypt(t *testing.T) {
+
+	var (
+		selectCipherText       = `SELECT guid, client_secret, client_cert_key FROM cnsis`
+		updateCipherText       = `UPDATE cnsis SET client_secret = (.+) WHERE guid = (.+)`
+		rowFieldsForCipherText = []string{"guid", "client_secret", "client_cert_key"}
+		mockEncryptionKey      = make([]byte, 32)
+		mockClientSecret       = "stratos_secret"
+	)
+
+	Convey("Given endpoints with secrets stored before keys were versioned", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		legacySecret, _ := crypto.EncryptCFB(mockEncryptionKey, []byte(mockClientSecret))
+		currentSecret, _ := crypto.EncryptToken(mockEncryptionKey, mockClientSecret)
+
+		Convey("only the legacy secrets should be re-encrypted", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectCipherText).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForCipherText).
+					AddRow("legacy-cnsi", legacySecret, nil).
+					AddRow("current-cnsi", currentSecret, nil))
+			mock.ExpectExec(updateCipherText).
+				WithArgs(sqlmock.AnyArg(), nil, "legacy-cnsi").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			repository, _ := NewPostgresCNSIRepository(db)
+			updated, err := repository.ReEncrypt(mockEncryptionKey)
+			So(err, ShouldBeNil)
+			So(updated, ShouldEqual, 1)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,94 @@
+package cnsis
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+var listCNSIVisibility = `SELECT cnsi_guid, principal_type, principal FROM cnsi_visibility`
+var findCNSIVisibility = `SELECT cnsi_guid, principal_type, principal FROM cnsi_visibility WHERE cnsi_guid = $1`
+var insertCNSIVisibility = `INSERT INTO cnsi_visibility (cnsi_guid, principal_type, principal) VALUES ($1, $2, $3)`
+var deleteCNSIVisibility = `DELETE FROM cnsi_visibility WHERE cnsi_guid = $1`
+
+func (p *PostgresCNSIRepository) queryVisibility(query string, args ...interface{}) (map[string][]interfaces.EndpointPrincipal, error) {
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve endpoint visibility: %v", err)
+	}
+	defer rows.Close()
+
+	visibility := make(map[string][]interfaces.EndpointPrincipal)
+	for rows.Next() {
+		var guid string
+		var principal interfaces.EndpointPrincipal
+		if err := rows.Scan(&guid, &principal.PrincipalType, &principal.Principal); err != nil {
+			return nil, fmt.Errorf("Unable to scan endpoint visibility: %v", err)
+		}
+		visibility[guid] = append(visibility[guid], principal)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to retrieve endpoint visibility: %v", err)
+	}
+	return visibility, nil
+}
+
+// ListVisibility - Returns the users and groups that can see each restricted endpoint. Endpoints that aren't
+// restricted aren't included
+func (p *PostgresCNSIRepository) ListVisibility() (map[string][]interfaces.EndpointPrincipal, error) {
+	log.Debug("ListVisibility")
+	return p.queryVisibility(listCNSIVisibility)
+}
+
+// FindVisibility - Returns the users and groups that can see an endpoint. This is empty if everyone can see it
+func (p *PostgresCNSIRepository) FindVisibility(guid string) ([]interfaces.EndpointPrincipal, error) {
+	log.Debug("FindVisibility")
+	visibility, err := p.queryVisibility(findCNSIVisibility, guid)
+	if err != nil {
+		return nil, err
+	}
+	principals := visibility[guid]
+	if principals == nil {
+		principals = make([]interfaces.EndpointPrincipal, 0)
+	}
+	return principals, nil
+}
+
+// SetVisibility - Restricts an endpoint to the given users and groups. No principals makes it visible to everyone
+func (p *PostgresCNSIRepository) SetVisibility(guid string, principals []interfaces.EndpointPrincipal) error {
+	log.Debug("SetVisibility")
+	if guid == "" {
+		return errors.New("Unable to set visibility of Endpoint without a valid guid")
+	}
+
+	txn, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Unable to set endpoint visibility: %v", err)
+	}
+
+	if err := setVisibility(txn, guid, principals); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("Unable to set endpoint visibility: %v", err)
+	}
+	return nil
+}
+
+func setVisibility(txn *sql.Tx, guid string, principals []interfaces.EndpointPrincipal) error {
+	if _, err := txn.Exec(deleteCNSIVisibility, guid); err != nil {
+		return fmt.Errorf("Unable to DELETE endpoint visibility: %v", err)
+	}
+	for _, principal := range principals {
+		if _, err := txn.Exec(insertCNSIVisibility, guid, principal.PrincipalType, principal.Principal); err != nil {
+			return fmt.Errorf("Unable to INSERT endpoint visibility: %v", err)
+		}
+	}
+	return nil
+}
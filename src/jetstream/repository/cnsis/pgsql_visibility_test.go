@@ -0,0 +1,74 @@
+package cnsis
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPgSQLCNSIVisibility(t *testing.T) {
+
+	var (
+		mockCFGUID             = "some-cf-guid-1234"
+		selectFromVisibility   = `SELECT (.+) FROM cnsi_visibility`
+		deleteFromVisibility   = `DELETE FROM cnsi_visibility WHERE (.+)`
+		insertIntoVisibility   = `INSERT INTO cnsi_visibility (.+)`
+		rowFieldsForVisibility = []string{"cnsi_guid", "principal_type", "principal"}
+	)
+
+	Convey("Given a request for the visibility of all endpoints", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		mock.ExpectQuery(selectFromVisibility).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForVisibility).
+				AddRow(mockCFGUID, "user", "bob").
+				AddRow(mockCFGUID, "group", "ops"))
+
+		repository, _ := NewPostgresCNSIRepository(db)
+		visibility, err := repository.ListVisibility()
+		So(err, ShouldBeNil)
+		So(visibility, ShouldHaveLength, 1)
+		So(visibility[mockCFGUID], ShouldResemble, []interfaces.EndpointPrincipal{
+			{PrincipalType: "user", Principal: "bob"},
+			{PrincipalType: "group", Principal: "ops"},
+		})
+	})
+
+	Convey("Given a request to restrict an endpoint", t, func() {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		repository, _ := NewPostgresCNSIRepository(db)
+
+		Convey("the old principals should be replaced in a transaction", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec(deleteFromVisibility).WithArgs(mockCFGUID).WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec(insertIntoVisibility).WithArgs(mockCFGUID, "group", "ops").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			err := repository.SetVisibility(mockCFGUID, []interfaces.EndpointPrincipal{{PrincipalType: "group", Principal: "ops"}})
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("a failure should roll back", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec(deleteFromVisibility).WillReturnError(errors.New("Unknown Database Error"))
+			mock.ExpectRollback()
+
+			err := repository.SetVisibility(mockCFGUID, nil)
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
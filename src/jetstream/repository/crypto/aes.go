@@ -13,13 +13,63 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// Encrypt - Encrypt a token based on an encryption key
+// Encrypt - Encrypt a token based on an encryption key, using AES-GCM so that any tampering with the ciphertext is
+// detected when it is decrypted. The nonce is stored at the start of the ciphertext
+func Encrypt(key, text []byte) ([]byte, error) {
+	log.Debug("Encrypt")
+	return seal(key, text, nil)
+}
+
+// Decrypt - Decrypt a token encrypted by Encrypt
+func Decrypt(key, ciphertext []byte) ([]byte, error) {
+	log.Debug("Decrypt")
+	return open(key, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts and authenticates the text, and authenticates the additional data
+func seal(key, text, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(text)+gcm.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, text, additionalData), nil
+}
+
+func open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce := ciphertext[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, ciphertext[gcm.NonceSize():], additionalData)
+}
+
+// EncryptCFB - Encrypt a token based on an encryption key, using AES-CFB
 // The approach used here is based on the following direction on how to AES
 // encrypt/decrypt our secret information, in this case tokens (normal, refresh
 // and OAuth tokens).
 // Source: https://github.com/giorgisio/examples/blob/master/aes-encrypt/main.go
-func Encrypt(key, text []byte) (ciphertext []byte, err error) {
-	log.Debug("Encrypt")
+//
+// Deprecated: AES-CFB does not detect tampering. This is only kept to test reading tokens stored by older versions
+func EncryptCFB(key, text []byte) (ciphertext []byte, err error) {
+	log.Debug("EncryptCFB")
 	var block cipher.Block
 
 	if block, err = aes.NewCipher(key); err != nil {
@@ -40,13 +90,9 @@ func Encrypt(key, text []byte) (ciphertext []byte, err error) {
 	return
 }
 
-// Decrypt - Decrypt a token based on an encryption key
-// The approach used here is based on the following direction on how to AES
-// encrypt/decrypt our secret information, in this case tokens (normal, refresh
-// and OAuth tokens).
-// Source: https://github.com/giorgisio/examples/blob/master/aes-encrypt/main.go
-func Decrypt(key, ciphertext []byte) (plaintext []byte, err error) {
-	log.Debug("Decrypt")
+// DecryptCFB - Decrypt a token encrypted with AES-CFB by older versions
+func DecryptCFB(key, ciphertext []byte) (plaintext []byte, err error) {
+	log.Debug("DecryptCFB")
 
 	var block cipher.Block
 
@@ -62,10 +108,10 @@ func Decrypt(key, ciphertext []byte) (plaintext []byte, err error) {
 	iv := ciphertext[:aes.BlockSize]
 	ciphertext = ciphertext[aes.BlockSize:]
 
+	// Decrypt into a new slice, rather than over the caller's ciphertext
+	plaintext = make([]byte, len(ciphertext))
 	cfb := cipher.NewCFBDecrypter(block, iv)
-	cfb.XORKeyStream(ciphertext, ciphertext)
-
-	plaintext = ciphertext
+	cfb.XORKeyStream(plaintext, ciphertext)
 
 	return
 }
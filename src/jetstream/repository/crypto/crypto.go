@@ -17,29 +17,41 @@ import (
 // http://engineering.pivotal.io/post/ByteA_versus_TEXT_in_PostgreSQL/
 // I chose option 1.
 
-// EncryptToken - Encrypt a token being
+// EncryptToken - Encrypt a token being stored. The ID of the key is stored with the token, so that it can still be
+// decrypted after the key is rotated, and is authenticated along with it
 func EncryptToken(key []byte, t string) ([]byte, error) {
 	log.Debug("encryptToken")
 	var plaintextToken = []byte(t)
-	ciphertextToken, err := Encrypt(key, plaintextToken)
+	header := newTokenHeader(key)
+	ciphertextToken, err := seal(key, plaintextToken, header)
 	if err != nil {
 		msg := "Unable to encrypt token: %v"
 		log.Printf(msg, err)
 		return nil, fmt.Errorf(msg, err)
 	}
 
-	return ciphertextToken, nil
+	return append(header, ciphertextToken...), nil
 }
 
-// DecryptToken - Decrypt a token
+// DecryptToken - Decrypt a token, with the given key or whichever previous key it was encrypted with. Tokens stored
+// with AES-CFB by older versions are still decrypted, but can't be checked for tampering
 func DecryptToken(key, t []byte) (string, error) {
 	log.Debug("decryptToken")
-	plaintextToken, err := Decrypt(key, t)
-	if err != nil {
-		msg := "Unable to decrypt token: %v"
-		log.Printf(msg, err)
-		return "", fmt.Errorf(msg, err)
+	header := parseTokenHeader(t)
+	decryptionKey, err := decryptionKey(key, header)
+	if err == nil {
+		var plaintextToken []byte
+		if header.authenticated() {
+			plaintextToken, err = open(decryptionKey, t[header.length:], t[:header.length])
+		} else {
+			plaintextToken, err = DecryptCFB(decryptionKey, t[header.length:])
+		}
+		if err == nil {
+			return string(plaintextToken), nil
+		}
 	}
 
-	return string(plaintextToken), nil
+	msg := "Unable to decrypt token: %v"
+	log.Printf(msg, err)
+	return "", fmt.Errorf(msg, err)
 }
@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// Ciphertext is stored as <format prefix><key ID><separator><encrypted data>, so that it can still be decrypted
+// once the key it was encrypted with is no longer the current one. Ciphertext without a prefix predates key
+// versioning, and is AES-CFB
+const (
+	cfbPrefix      = "stk1:"
+	gcmPrefix      = "stk2:"
+	keyIDSeparator = ':'
+
+	// Number of bytes of the key's SHA-256 hash used to identify it
+	keyIDBytes  = 8
+	keyIDLength = keyIDBytes * 2
+)
+
+// tokenHeader describes how a stored token was encrypted
+type tokenHeader struct {
+	prefix string
+	keyID  string
+	length int
+}
+
+func (h tokenHeader) versioned() bool {
+	return len(h.prefix) > 0
+}
+
+func (h tokenHeader) authenticated() bool {
+	return h.prefix == gcmPrefix
+}
+
+var previousKeys = struct {
+	sync.RWMutex
+	byID   map[string][]byte
+	legacy []byte
+}{byID: make(map[string][]byte)}
+
+// KeyID - Identify an encryption key, without giving it away
+func KeyID(key []byte) string {
+	hash := sha256.Sum256(key)
+	return hex.EncodeToString(hash[:keyIDBytes])
+}
+
+// SetPreviousKeys - Set the keys, other than the current one, that stored secrets may be encrypted with, oldest first.
+// Secrets stored before keys were versioned are decrypted with the oldest key
+func SetPreviousKeys(keys [][]byte) {
+	previousKeys.Lock()
+	defer previousKeys.Unlock()
+
+	previousKeys.byID = make(map[string][]byte)
+	previousKeys.legacy = nil
+	for _, key := range keys {
+		previousKeys.byID[KeyID(key)] = key
+	}
+	if len(keys) > 0 {
+		previousKeys.legacy = keys[0]
+	}
+}
+
+// parseTokenHeader reads the header from stored ciphertext, if it has one
+func parseTokenHeader(t []byte) tokenHeader {
+	for _, prefix := range []string{gcmPrefix, cfbPrefix} {
+		length := len(prefix) + keyIDLength + 1
+		if len(t) >= length && bytes.HasPrefix(t, []byte(prefix)) && t[length-1] == keyIDSeparator {
+			return tokenHeader{prefix: prefix, keyID: string(t[len(prefix) : length-1]), length: length}
+		}
+	}
+	return tokenHeader{}
+}
+
+func newTokenHeader(key []byte) []byte {
+	header := make([]byte, 0, len(gcmPrefix)+keyIDLength+1)
+	header = append(header, gcmPrefix...)
+	header = append(header, KeyID(key)...)
+	return append(header, keyIDSeparator)
+}
+
+// decryptionKey finds the key that the ciphertext was encrypted with
+func decryptionKey(key []byte, header tokenHeader) ([]byte, error) {
+	if header.versioned() && header.keyID == KeyID(key) {
+		return key, nil
+	}
+
+	previousKeys.RLock()
+	defer previousKeys.RUnlock()
+	if !header.versioned() {
+		if previousKeys.legacy != nil {
+			return previousKeys.legacy, nil
+		}
+		return key, nil
+	}
+	if previousKey, ok := previousKeys.byID[header.keyID]; ok {
+		return previousKey, nil
+	}
+	return nil, errors.New("ciphertext was encrypted with an unknown key " + header.keyID)
+}
+
+// IsEncryptedWithKey - Is the ciphertext encrypted with the given key, in the current format?
+func IsEncryptedWithKey(key, t []byte) bool {
+	header := parseTokenHeader(t)
+	return header.authenticated() && header.keyID == KeyID(key)
+}
+
+// ReEncryptToken - Re-encrypt a token with the given key, if it was encrypted with a different one or in an older
+// format. Returns whether the token was changed
+func ReEncryptToken(key, t []byte) ([]byte, bool, error) {
+	if len(t) == 0 || IsEncryptedWithKey(key, t) {
+		return t, false, nil
+	}
+	plaintext, err := DecryptToken(key, t)
+	if err != nil {
+		return nil, false, err
+	}
+	ciphertext, err := EncryptToken(key, plaintext)
+	if err != nil {
+		return nil, false, err
+	}
+	return ciphertext, true, nil
+}
@@ -0,0 +1,120 @@
+package crypto
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeyRotation(t *testing.T) {
+
+	var (
+		oldKey     = make([]byte, 32)
+		currentKey = []byte("0123456789abcdef0123456789abcdef")
+		token      = "some-token"
+	)
+
+	Convey("Given tokens encrypted with an old key", t, func() {
+		legacy, err := EncryptCFB(oldKey, []byte(token))
+		So(err, ShouldBeNil)
+		versioned, err := EncryptToken(oldKey, token)
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			SetPreviousKeys(nil)
+		})
+
+		Convey("the key ID should be stored with the token", func() {
+			So(IsEncryptedWithKey(oldKey, versioned), ShouldBeTrue)
+			So(IsEncryptedWithKey(currentKey, versioned), ShouldBeFalse)
+			So(IsEncryptedWithKey(oldKey, legacy), ShouldBeFalse)
+			So(KeyID(oldKey), ShouldHaveLength, keyIDLength)
+			So(KeyID(oldKey), ShouldNotEqual, KeyID(currentKey))
+		})
+
+		Convey("they should not decrypt with the current key alone", func() {
+			_, err := DecryptToken(currentKey, versioned)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("they should decrypt once the old key is a previous key", func() {
+			SetPreviousKeys([][]byte{oldKey})
+
+			plaintext, err := DecryptToken(currentKey, versioned)
+			So(err, ShouldBeNil)
+			So(plaintext, ShouldEqual, token)
+
+			plaintext, err = DecryptToken(currentKey, legacy)
+			So(err, ShouldBeNil)
+			So(plaintext, ShouldEqual, token)
+		})
+
+		Convey("they should be re-encrypted with the current key", func() {
+			SetPreviousKeys([][]byte{oldKey})
+
+			ciphertext, changed, err := ReEncryptToken(currentKey, versioned)
+			So(err, ShouldBeNil)
+			So(changed, ShouldBeTrue)
+			So(IsEncryptedWithKey(currentKey, ciphertext), ShouldBeTrue)
+
+			SetPreviousKeys(nil)
+			plaintext, err := DecryptToken(currentKey, ciphertext)
+			So(err, ShouldBeNil)
+			So(plaintext, ShouldEqual, token)
+
+			_, changed, err = ReEncryptToken(currentKey, ciphertext)
+			So(err, ShouldBeNil)
+			So(changed, ShouldBeFalse)
+		})
+	})
+
+}
+
+func TestTokenFormats(t *testing.T) {
+
+	var (
+		key   = make([]byte, 32)
+		token = "some-token"
+	)
+
+	Convey("Given tokens stored in each format", t, func() {
+		legacy, _ := EncryptCFB(key, []byte(token))
+		cfb, _ := EncryptCFB(key, []byte(token))
+		versionedCFB := append([]byte(cfbPrefix+KeyID(key)+string(keyIDSeparator)), cfb...)
+		current, err := EncryptToken(key, token)
+		So(err, ShouldBeNil)
+
+		Convey("new tokens should use AES-GCM", func() {
+			So(string(current), ShouldStartWith, gcmPrefix)
+			So(IsEncryptedWithKey(key, current), ShouldBeTrue)
+			So(IsEncryptedWithKey(key, versionedCFB), ShouldBeFalse)
+		})
+
+		Convey("all of them should decrypt", func() {
+			for _, ciphertext := range [][]byte{legacy, versionedCFB, current} {
+				plaintext, err := DecryptToken(key, ciphertext)
+				So(err, ShouldBeNil)
+				So(plaintext, ShouldEqual, token)
+			}
+		})
+
+		Convey("tampering should be detected", func() {
+			tampered := append([]byte{}, current...)
+			tampered[len(tampered)-1] ^= 0x01
+			_, err := DecryptToken(key, tampered)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("AES-CFB tokens should be upgraded when re-encrypted", func() {
+			for _, ciphertext := range [][]byte{legacy, versionedCFB} {
+				upgraded, changed, err := ReEncryptToken(key, ciphertext)
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeTrue)
+				So(IsEncryptedWithKey(key, upgraded), ShouldBeTrue)
+			}
+			_, changed, err := ReEncryptToken(key, current)
+			So(err, ShouldBeNil)
+			So(changed, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,193 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Default field holding the key in a Vault secret
+	DefaultVaultField = "encryption_key"
+
+	vaultRequestTimeout = 30 * time.Second
+
+	// Authenticated with wrapped keys, so that they can't be mistaken for other ciphertext
+	wrappedKeyLabel = "stratos-data-key"
+)
+
+// SecretProvider - A source of the master encryption key
+type SecretProvider interface {
+	EncryptionKey() ([]byte, error)
+}
+
+// StaticKeyProvider - Provides a hex encoded key, e.g. from the ENCRYPTION_KEY environment variable
+type StaticKeyProvider struct {
+	Key string
+}
+
+// EncryptionKey - The decoded key
+func (p *StaticKeyProvider) EncryptionKey() ([]byte, error) {
+	log.Debug("StaticKeyProvider.EncryptionKey")
+	key32bytes, err := hex.DecodeString(p.Key)
+	if err != nil {
+		log.Error(err)
+	}
+
+	return key32bytes, nil
+}
+
+// FileKeyProvider - Provides a hex encoded key read from a file, given either by its path or as a file on a shared
+// volume
+type FileKeyProvider struct {
+	Path     string
+	Volume   string
+	Filename string
+}
+
+// EncryptionKey - Read the key from the file
+func (p *FileKeyProvider) EncryptionKey() ([]byte, error) {
+	log.Debug("FileKeyProvider.EncryptionKey")
+	if len(p.Path) == 0 {
+		return ReadEncryptionKey(p.Volume, p.Filename)
+	}
+
+	hexKey, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read key file: %v", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(hexKey)))
+	if err != nil {
+		return nil, fmt.Errorf("Key in %s is not valid hex: %v", p.Path, err)
+	}
+	return key, nil
+}
+
+// VaultKeyProvider - Provides a hex encoded key stored in a HashiCorp Vault compatible key/value secrets engine.
+// Both versions of the KV engine are supported
+type VaultKeyProvider struct {
+	Address string
+	Token   string
+	// Path of the secret, including the mount, e.g. secret/data/stratos for KV version 2
+	Path string
+	// Field of the secret that holds the key - defaults to encryption_key
+	Field  string
+	Client *http.Client
+}
+
+// EncryptionKey - Fetch the key from Vault
+func (p *VaultKeyProvider) EncryptionKey() ([]byte, error) {
+	log.Debug("VaultKeyProvider.EncryptionKey")
+	value, err := p.readField()
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Encryption key in Vault secret %s is not valid hex: %v", p.Path, err)
+	}
+	return key, nil
+}
+
+func (p *VaultKeyProvider) readField() (string, error) {
+	if len(p.Address) == 0 || len(p.Path) == 0 {
+		return "", errors.New("Vault address and secret path must be configured")
+	}
+	secretURL, err := url.Parse(strings.TrimSuffix(p.Address, "/") + "/v1/" + strings.TrimPrefix(p.Path, "/"))
+	if err != nil {
+		return "", fmt.Errorf("Invalid Vault address: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, secretURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if len(p.Token) > 0 {
+		req.Header.Set("X-Vault-Token", p.Token)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: vaultRequestTimeout}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read Vault secret %s: %v", p.Path, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unable to read Vault secret %s: %s", p.Path, res.Status)
+	}
+
+	secret := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("Unable to parse Vault secret %s: %v", p.Path, err)
+	}
+
+	field := p.Field
+	if len(field) == 0 {
+		field = DefaultVaultField
+	}
+
+	// KV version 2 nests the secret's fields in another data object
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, isField := data[field]; !isField {
+			data = nested
+		}
+	}
+	value, ok := data[field].(string)
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("Vault secret %s has no '%s' field", p.Path, field)
+	}
+	return value, nil
+}
+
+// EnvelopeKeyProvider - Provides a data key that has been wrapped (encrypted) with a key-encryption-key, so that the
+// data key itself is never stored. Use WrapKey to wrap a data key
+type EnvelopeKeyProvider struct {
+	KeyEncryptionKey SecretProvider
+	// Hex encoded wrapped data key
+	WrappedKey string
+}
+
+// EncryptionKey - Unwrap the data key
+func (p *EnvelopeKeyProvider) EncryptionKey() ([]byte, error) {
+	log.Debug("EnvelopeKeyProvider.EncryptionKey")
+	if p.KeyEncryptionKey == nil || len(p.WrappedKey) == 0 {
+		return nil, errors.New("A key-encryption-key and wrapped data key must be configured")
+	}
+
+	kek, err := p.KeyEncryptionKey.EncryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get the key-encryption-key: %v", err)
+	}
+	wrapped, err := hex.DecodeString(strings.TrimSpace(p.WrappedKey))
+	if err != nil {
+		return nil, fmt.Errorf("Wrapped data key is not valid hex: %v", err)
+	}
+	key, err := open(kek, wrapped, []byte(wrappedKeyLabel))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unwrap the data key: %v", err)
+	}
+	return key, nil
+}
+
+// WrapKey - Wrap a data key with a key-encryption-key, for use with EnvelopeKeyProvider
+func WrapKey(kek, key []byte) (string, error) {
+	wrapped, err := seal(kek, key, []byte(wrappedKeyLabel))
+	if err != nil {
+		return "", fmt.Errorf("Unable to wrap the data key: %v", err)
+	}
+	return hex.EncodeToString(wrapped), nil
+}
@@ -0,0 +1,174 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSecretProviders(t *testing.T) {
+
+	var (
+		key    = []byte("0123456789abcdef0123456789abcdef")
+		hexKey = hex.EncodeToString(key)
+	)
+
+	Convey("Static key provider", t, func() {
+		provider := &StaticKeyProvider{Key: hexKey}
+		k, err := provider.EncryptionKey()
+		So(err, ShouldBeNil)
+		So(k, ShouldResemble, key)
+	})
+
+	Convey("File key provider", t, func() {
+		dir, err := ioutil.TempDir("", "stratos-keys")
+		So(err, ShouldBeNil)
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+
+		Convey("should read a key file given by path", func() {
+			path := filepath.Join(dir, "kek")
+			So(ioutil.WriteFile(path, []byte(hexKey+"\n"), 0600), ShouldBeNil)
+			k, err := (&FileKeyProvider{Path: path}).EncryptionKey()
+			So(err, ShouldBeNil)
+			So(k, ShouldResemble, key)
+		})
+
+		Convey("should read a key file from a volume", func() {
+			So(ioutil.WriteFile(filepath.Join(dir, "key"), []byte(hexKey), 0600), ShouldBeNil)
+			k, err := (&FileKeyProvider{Volume: dir, Filename: "key"}).EncryptionKey()
+			So(err, ShouldBeNil)
+			So(k, ShouldResemble, key)
+		})
+
+		Convey("should fail if the key file is not hex", func() {
+			path := filepath.Join(dir, "kek")
+			So(ioutil.WriteFile(path, []byte("not a key"), 0600), ShouldBeNil)
+			_, err := (&FileKeyProvider{Path: path}).EncryptionKey()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should fail if the key file is missing", func() {
+			_, err := (&FileKeyProvider{Path: filepath.Join(dir, "missing")}).EncryptionKey()
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Vault key provider", t, func() {
+		var (
+			requestPath  string
+			requestToken string
+			status       = http.StatusOK
+			body         interface{}
+		)
+		vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestPath = r.URL.Path
+			requestToken = r.Header.Get("X-Vault-Token")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(body)
+		}))
+		Reset(func() {
+			vault.Close()
+		})
+
+		provider := &VaultKeyProvider{Address: vault.URL + "/", Token: "s.token", Path: "/secret/data/stratos"}
+
+		Convey("should read a key from a KV version 2 secret", func() {
+			body = map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     map[string]interface{}{"encryption_key": hexKey},
+					"metadata": map[string]interface{}{"version": 1},
+				},
+			}
+			k, err := provider.EncryptionKey()
+			So(err, ShouldBeNil)
+			So(k, ShouldResemble, key)
+			So(requestPath, ShouldEqual, "/v1/secret/data/stratos")
+			So(requestToken, ShouldEqual, "s.token")
+		})
+
+		Convey("should read a named field from a KV version 1 secret", func() {
+			body = map[string]interface{}{
+				"data": map[string]interface{}{"kek": hexKey},
+			}
+			provider.Path = "kv/stratos"
+			provider.Field = "kek"
+			k, err := provider.EncryptionKey()
+			So(err, ShouldBeNil)
+			So(k, ShouldResemble, key)
+			So(requestPath, ShouldEqual, "/v1/kv/stratos")
+		})
+
+		Convey("should fail if the field is missing", func() {
+			body = map[string]interface{}{
+				"data": map[string]interface{}{"other": hexKey},
+			}
+			_, err := provider.EncryptionKey()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "encryption_key")
+		})
+
+		Convey("should fail if Vault refuses the request", func() {
+			status = http.StatusForbidden
+			body = map[string]interface{}{"errors": []string{"permission denied"}}
+			_, err := provider.EncryptionKey()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "403")
+		})
+
+		Convey("should fail if not configured", func() {
+			_, err := (&VaultKeyProvider{}).EncryptionKey()
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Envelope key provider", t, func() {
+		kek := []byte("fedcba9876543210fedcba9876543210")
+		wrapped, err := WrapKey(kek, key)
+		So(err, ShouldBeNil)
+		So(wrapped, ShouldNotContainSubstring, hexKey)
+
+		Convey("should unwrap the data key with the key-encryption-key", func() {
+			provider := &EnvelopeKeyProvider{
+				KeyEncryptionKey: &StaticKeyProvider{Key: hex.EncodeToString(kek)},
+				WrappedKey:       wrapped,
+			}
+			k, err := provider.EncryptionKey()
+			So(err, ShouldBeNil)
+			So(k, ShouldResemble, key)
+		})
+
+		Convey("should fail with the wrong key-encryption-key", func() {
+			provider := &EnvelopeKeyProvider{
+				KeyEncryptionKey: &StaticKeyProvider{Key: hexKey},
+				WrappedKey:       wrapped,
+			}
+			_, err := provider.EncryptionKey()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should not unwrap other ciphertext", func() {
+			ciphertext, err := Encrypt(kek, key)
+			So(err, ShouldBeNil)
+			provider := &EnvelopeKeyProvider{
+				KeyEncryptionKey: &StaticKeyProvider{Key: hex.EncodeToString(kek)},
+				WrappedKey:       hex.EncodeToString(ciphertext),
+			}
+			_, err = provider.EncryptionKey()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should fail without a wrapped key", func() {
+			_, err := (&EnvelopeKeyProvider{KeyEncryptionKey: &StaticKeyProvider{Key: hexKey}}).EncryptionKey()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,32 @@
+package interfaces
+
+import "time"
+
+// Scopes that can be given to a personal API token
+const (
+	APITokenScopeRead  = "read"
+	APITokenScopeWrite = "write"
+	APITokenScopeAdmin = "admin"
+)
+
+// APIToken - A personal access token that a user can use for scripted access to Jetstream
+type APIToken struct {
+	GUID      string     `json:"guid"`
+	UserGUID  string     `json:"user_guid"`
+	Name      string     `json:"name"`
+	TokenHash string     `json:"-"`
+	Scopes    []string   `json:"scopes"`
+	Created   time.Time  `json:"created"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+// HasScope checks if the token has been given the scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
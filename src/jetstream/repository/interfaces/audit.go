@@ -0,0 +1,57 @@
+package interfaces
+
+import "time"
+
+// Audited actions
+const (
+	AuditLogin                 = "login"
+	AuditConsoleSetup          = "console.setup"
+	AuditEndpointRegister      = "endpoint.register"
+	AuditEndpointUnregister    = "endpoint.unregister"
+	AuditEndpointConnectShared = "endpoint.connect_shared"
+	AuditEndpointRewriteRules  = "endpoint.rewrite_rules"
+	AuditEndpointVisibility    = "endpoint.visibility"
+	AuditUserInvite            = "user.invite"
+	AuditLocalUserCreate       = "local_user.create"
+	AuditLocalUserUpdate       = "local_user.update"
+	AuditLocalUserDelete       = "local_user.delete"
+	AuditLocalUserPassword     = "local_user.password_reset"
+	AuditLocalUserTOTPReset    = "local_user.totp_reset"
+	AuditLoginUnlock           = "login.unlock"
+	AuditRoleAssign            = "role.assign"
+	AuditRoleUnassign          = "role.unassign"
+	AuditAPITokenCreate        = "api_token.create"
+	AuditAPITokenRevoke        = "api_token.revoke"
+	AuditConnectionsRevoke     = "endpoint.connections_revoke"
+)
+
+// Outcomes of audited actions
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent - A record of who did what, to what, and whether it worked
+type AuditEvent struct {
+	GUID      string    `json:"guid"`
+	Timestamp time.Time `json:"timestamp"`
+	ActorGUID string    `json:"actor_guid,omitempty"`
+	ActorName string    `json:"actor_name,omitempty"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target,omitempty"`
+	SourceIP  string    `json:"source_ip,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// AuditFilter - Criteria for searching the audit log. Empty fields match everything
+type AuditFilter struct {
+	Actor   string
+	Action  string
+	Target  string
+	Outcome string
+	From    *time.Time
+	To      *time.Time
+	Limit   int
+	Offset  int
+}
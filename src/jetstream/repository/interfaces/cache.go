@@ -0,0 +1,34 @@
+package interfaces
+
+import (
+	"net/http"
+	"time"
+)
+
+// CachedResponse - A response from an endpoint that is held in a ProxyResponseCache
+type CachedResponse struct {
+	EndpointGUID string
+	UserGUID     string
+	StatusCode   int
+	Status       string
+	Header       http.Header
+	Response     []byte
+	ETag         string
+	Expires      time.Time
+}
+
+// IsFresh returns whether the cached response can be used without checking with the endpoint
+func (c *CachedResponse) IsFresh() bool {
+	return time.Now().Before(c.Expires)
+}
+
+// ProxyResponseCache - Cache for the responses of idempotent proxied requests
+type ProxyResponseCache interface {
+	// Get returns the cached response for the key - this can be stale if it has an ETag that can be used to revalidate it
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, response *CachedResponse)
+	// InvalidateEndpoint removes all cached responses for an endpoint
+	InvalidateEndpoint(endpointGUID string)
+	// InvalidateConnection removes the cached responses for a user's requests to an endpoint
+	InvalidateConnection(endpointGUID, userGUID string)
+}
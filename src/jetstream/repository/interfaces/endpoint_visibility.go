@@ -0,0 +1,8 @@
+package interfaces
+
+// EndpointPrincipal - a user, or a UAA/OIDC group or scope, that can see a restricted endpoint. Principal types are
+// the same as for role assignments
+type EndpointPrincipal struct {
+	PrincipalType string `json:"principal_type"`
+	Principal     string `json:"principal"`
+}
@@ -5,7 +5,7 @@ import (
 )
 
 type EndpointPlugin interface {
-	Info(apiEndpoint string, skipSSLValidation bool) (CNSIRecord, interface{}, error)
+	Info(apiEndpoint string, skipSSLValidation bool, connection EndpointConnectionSettings) (CNSIRecord, interface{}, error)
 	GetType() string
 	Register(echoContext echo.Context) error
 	Connect(echoContext echo.Context, cnsiRecord CNSIRecord, userId string) (*TokenRecord, bool, error)
@@ -13,6 +13,9 @@ type EndpointPlugin interface {
 	UpdateMetadata(info *Info, userGUID string, echoContext echo.Context)
 }
 
+// RoutePlugin adds routes to the session and admin groups. Session routes need the console.read permission for GET
+// and HEAD requests and endpoints.use for anything else, while admin routes need console.admin. Plugins can declare
+// something different with PortalProxy.RequirePermission
 type RoutePlugin interface {
 	AddSessionGroupRoutes(echoContext *echo.Group)
 	AddAdminGroupRoutes(echoContext *echo.Group)
@@ -1,6 +1,7 @@
 package interfaces
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -20,6 +21,11 @@ type ErrHTTPRequest struct {
 	Response   string
 }
 
+// ErrRefreshTokenRejected - The token endpoint rejected the refresh token, so the user must connect again
+type ErrRefreshTokenRejected struct {
+	InnerError error
+}
+
 func (e ErrHTTPShadow) Error() string {
 	return fmt.Sprintf("HTTP Error: %v\nLog Message: %s", e.HTTPError, e.LogMessage)
 }
@@ -65,3 +71,21 @@ func LogHTTPError(r *http.Response, innerErr error) error {
 	}
 
 }
+
+func (e ErrRefreshTokenRejected) Error() string {
+	return fmt.Sprintf("Token refresh request failed: %v", e.InnerError)
+}
+
+// NewTokenRefreshError - Wrap the error from a token refresh request. Only an invalid_grant response from the token
+// endpoint means that the refresh token is no longer valid - anything else may succeed if tried again
+func NewTokenRefreshError(err error) error {
+	if httpErr, ok := err.(ErrHTTPRequest); ok && (httpErr.Status == http.StatusBadRequest || httpErr.Status == http.StatusUnauthorized) {
+		response := struct {
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal([]byte(httpErr.Response), &response) == nil && response.Error == "invalid_grant" {
+			return ErrRefreshTokenRejected{InnerError: err}
+		}
+	}
+	return fmt.Errorf("Token refresh request failed: %v", err)
+}
@@ -1,5 +1,7 @@
 package interfaces
 
+import "time"
+
 // LocalUser - Used for local user auth and management
 type LocalUser struct {
 	UserGUID     string `json:"user_guid"`
@@ -9,4 +11,26 @@ type LocalUser struct {
 	Scope        string `json:"scope"`
 	GivenName    string `json:"given_name"`
 	FamilyName   string `json:"family_name"`
+	Disabled     bool   `json:"disabled"`
+	// Only set when listing users
+	LastLogin *time.Time `json:"last_login,omitempty"`
+}
+
+// LocalUserTOTP - A local user's TOTP (time-based one-time password) enrollment
+type LocalUserTOTP struct {
+	UserGUID string
+	// Base32 encoded shared secret
+	Secret  string
+	Enabled bool
+	// Hashes of the unused recovery codes
+	RecoveryCodes []string
+	// Time step of the last code that was accepted, so that codes can't be replayed
+	LastUsedStep int64
+}
+
+// LocalUserLoginInfo - When a local user last logged in, and the failed logins since then
+type LocalUserLoginInfo struct {
+	LastLogin        *time.Time `json:"last_login,omitempty"`
+	LastFailedLogin  *time.Time `json:"last_failed_login,omitempty"`
+	FailedLoginCount int        `json:"failed_login_count"`
 }
@@ -0,0 +1,18 @@
+package interfaces
+
+import "time"
+
+// Types of failed login attempt that are tracked
+const (
+	LoginAttemptsUser = "user"
+	LoginAttemptsIP   = "ip"
+)
+
+// LoginAttempts - Failed login attempts for a username or a source IP address
+type LoginAttempts struct {
+	Type        string     `json:"type"`
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
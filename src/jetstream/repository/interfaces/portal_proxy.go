@@ -1,6 +1,7 @@
 package interfaces
 
 import (
+	"crypto/tls"
 	"database/sql"
 	"net/http"
 	"net/url"
@@ -13,9 +14,13 @@ import (
 type PortalProxy interface {
 	GetHttpClient(skipSSLValidation bool) http.Client
 	GetHttpClientForRequest(req *http.Request, skipSSLValidation bool) http.Client
+	GetHttpClientForEndpoint(skipSSLValidation bool, connection EndpointConnectionSettings) http.Client
+	GetHttpClientForEndpointRequest(req *http.Request, skipSSLValidation bool, connection EndpointConnectionSettings) http.Client
+	GetEndpointTLSConfig(skipSSLValidation bool, connection EndpointConnectionSettings) (*tls.Config, error)
+	GetEndpointProxy(connection EndpointConnectionSettings) (func(*http.Request) (*url.URL, error), error)
 	RegisterEndpoint(c echo.Context, fetchInfo InfoFunc) error
 
-	DoRegisterEndpoint(cnsiName string, apiEndpoint string, skipSSLValidation bool, clientId string, clientSecret string, ssoAllowed bool, subType string, fetchInfo InfoFunc) (CNSIRecord, error)
+	DoRegisterEndpoint(cnsiName string, apiEndpoint string, skipSSLValidation bool, connection EndpointConnectionSettings, clientId string, clientSecret string, ssoAllowed bool, subType string, fetchInfo InfoFunc) (CNSIRecord, error)
 
 	GetEndpointTypeSpec(typeName string) (EndpointPlugin, error)
 
@@ -37,6 +42,7 @@ type PortalProxy interface {
 	// Expose internal portal proxy records to extensions
 	GetCNSIRecord(guid string) (CNSIRecord, error)
 	GetCNSIRecordByEndpoint(endpoint string) (CNSIRecord, error)
+	CheckEndpointVisible(c echo.Context, cnsiGUIDs ...string) error
 	GetCNSITokenRecord(cnsiGUID string, userGUID string) (TokenRecord, bool)
 	GetCNSITokenRecordWithDisconnected(cnsiGUID string, userGUID string) (TokenRecord, bool)
 	GetCNSIUser(cnsiGUID string, userGUID string) (*ConnectedUser, bool)
@@ -54,12 +60,22 @@ type PortalProxy interface {
 	RefreshUAALogin(username, password string, store bool) error
 	GetUserTokenInfo(tok string) (u *JWTUserTokenInfo, err error)
 	GetUAAUser(userGUID string) (*ConnectedUser, error)
+	GetStratosUser(userGUID string) (*ConnectedUser, error)
+	CheckPasswordPolicy(username, password string) error
+
+	// RequirePermission sets the console permission needed to use a route, overriding the group's default
+	RequirePermission(route *echo.Route, permission string)
+
+	// Audit records an administrative or security-relevant action, and whether it succeeded, in the audit log
+	Audit(c echo.Context, action, target string, actionErr error)
 
 	// Proxy API requests
 	ProxyRequest(c echo.Context, uri *url.URL) (map[string]*CNSIRequest, error)
 	DoProxyRequest(requests []ProxyRequestInfo) (map[string]*CNSIRequest, error)
 	DoProxySingleRequest(cnsiGUID, userGUID, method, requestUrl string, headers http.Header, body []byte) (*CNSIRequest, error)
 	SendProxiedResponse(c echo.Context, responses map[string]*CNSIRequest) error
+	ProxyStreamRequest(c echo.Context, uri *url.URL) error
+	SetProxyResponseCache(cache ProxyResponseCache)
 
 	// Database Connection
 	GetDatabaseConnection() *sql.DB
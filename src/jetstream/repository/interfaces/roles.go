@@ -0,0 +1,62 @@
+package interfaces
+
+// Console permissions, checked per route
+const (
+	// PermissionConsoleRead - view the console and make read only requests to endpoints
+	PermissionConsoleRead = "console.read"
+	// PermissionEndpointsUse - connect to endpoints and make changes through them
+	PermissionEndpointsUse = "endpoints.use"
+	// PermissionEndpointsManage - register, unregister and configure endpoints
+	PermissionEndpointsManage = "endpoints.manage"
+	// PermissionUsersManage - manage local users and login lockouts
+	PermissionUsersManage = "users.manage"
+	// PermissionConsoleAdmin - everything, including role assignment
+	PermissionConsoleAdmin = "console.admin"
+)
+
+// Console roles
+const (
+	RoleViewer           = "viewer"
+	RoleEndpointOperator = "endpoint-operator"
+	RoleEndpointAdmin    = "endpoint-admin"
+	RoleConsoleAdmin     = "console-admin"
+)
+
+// Types of principal that roles can be assigned to
+const (
+	RolePrincipalUser  = "user"
+	RolePrincipalGroup = "group"
+)
+
+// RolePermissions - the permissions granted by each role
+var RolePermissions = map[string][]string{
+	RoleViewer:           {PermissionConsoleRead},
+	RoleEndpointOperator: {PermissionConsoleRead, PermissionEndpointsUse},
+	RoleEndpointAdmin:    {PermissionConsoleRead, PermissionEndpointsUse, PermissionEndpointsManage},
+	RoleConsoleAdmin:     {PermissionConsoleAdmin},
+}
+
+// RoleAssignment - a role given to a user, or to everyone in a UAA/OIDC group
+type RoleAssignment struct {
+	PrincipalType string `json:"principal_type"`
+	Principal     string `json:"principal"`
+	Role          string `json:"role"`
+}
+
+// IsValidRole checks that the role is one of the console roles
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// RolesHavePermission checks if any of the roles grant the permission. The console admin permission grants all others
+func RolesHavePermission(roles []string, permission string) bool {
+	for _, role := range roles {
+		for _, p := range RolePermissions[role] {
+			if p == permission || p == PermissionConsoleAdmin {
+				return true
+			}
+		}
+	}
+	return false
+}
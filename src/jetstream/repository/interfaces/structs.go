@@ -18,6 +18,8 @@ type AuthFlowHandlerFunc func(cnsiRequest *CNSIRequest, req *http.Request) (*htt
 type AuthProvider struct {
 	Handler  AuthFlowHandlerFunc
 	UserInfo GetUserInfoFromToken
+	// Optional - used to refresh tokens in the background before they expire
+	Refresh RefreshOAuthTokenFunc
 }
 
 type V2Info struct {
@@ -29,39 +31,62 @@ type V2Info struct {
 	AppSSHOauthCLient        string `json:"app_ssh_oauth_client"`
 }
 
-type InfoFunc func(apiEndpoint string, skipSSLValidation bool) (CNSIRecord, interface{}, error)
+type InfoFunc func(apiEndpoint string, skipSSLValidation bool, connection EndpointConnectionSettings) (CNSIRecord, interface{}, error)
 
 //TODO this could be moved back to cnsis subpackage, and extensions could import it?
 type CNSIRecord struct {
-	GUID                   string   `json:"guid"`
-	Name                   string   `json:"name"`
-	CNSIType               string   `json:"cnsi_type"`
-	APIEndpoint            *url.URL `json:"api_endpoint"`
-	AuthorizationEndpoint  string   `json:"authorization_endpoint"`
-	TokenEndpoint          string   `json:"token_endpoint"`
-	DopplerLoggingEndpoint string   `json:"doppler_logging_endpoint"`
-	SkipSSLValidation      bool     `json:"skip_ssl_validation"`
-	ClientId               string   `json:"client_id"`
-	ClientSecret           string   `json:"-"`
-	SSOAllowed             bool     `json:"sso_allowed"`
-	SubType                string   `json:"sub_type"`
-	Metadata               string   `json:"metadata"`
+	GUID                   string                     `json:"guid"`
+	Name                   string                     `json:"name"`
+	CNSIType               string                     `json:"cnsi_type"`
+	APIEndpoint            *url.URL                   `json:"api_endpoint"`
+	AuthorizationEndpoint  string                     `json:"authorization_endpoint"`
+	TokenEndpoint          string                     `json:"token_endpoint"`
+	DopplerLoggingEndpoint string                     `json:"doppler_logging_endpoint"`
+	SkipSSLValidation      bool                       `json:"skip_ssl_validation"`
+	ClientId               string                     `json:"client_id"`
+	ClientSecret           string                     `json:"-"`
+	SSOAllowed             bool                       `json:"sso_allowed"`
+	SubType                string                     `json:"sub_type"`
+	Metadata               string                     `json:"metadata"`
+	Connection             EndpointConnectionSettings `json:"connection"`
+}
+
+// EndpointConnectionSettings - Optional TLS and proxy settings used when connecting to an endpoint
+type EndpointConnectionSettings struct {
+	CACert        string `json:"ca_cert,omitempty"`
+	ClientCert    string `json:"client_cert,omitempty"`
+	ClientCertKey string `json:"-"`
+	ProxyURL      string `json:"-"`
+}
+
+// IsSet - Does the endpoint have any of its own connection settings
+func (s EndpointConnectionSettings) IsSet() bool {
+	return len(s.CACert) > 0 || len(s.ClientCert) > 0 || len(s.ProxyURL) > 0
 }
 
 // ConnectedEndpoint
 type ConnectedEndpoint struct {
-	GUID                   string   `json:"guid"`
-	Name                   string   `json:"name"`
-	CNSIType               string   `json:"cnsi_type"`
-	APIEndpoint            *url.URL `json:"api_endpoint"`
-	Account                string   `json:"account"`
-	TokenExpiry            int64    `json:"token_expiry"`
-	DopplerLoggingEndpoint string   `json:"-"`
-	AuthorizationEndpoint  string   `json:"-"`
-	SkipSSLValidation      bool     `json:"skip_ssl_validation"`
-	TokenMetadata          string   `json:"-"`
-	SubType                string   `json:"sub_type"`
-	EndpointMetadata       string   `json:"metadata"`
+	GUID                   string                `json:"guid"`
+	Name                   string                `json:"name"`
+	CNSIType               string                `json:"cnsi_type"`
+	APIEndpoint            *url.URL              `json:"api_endpoint"`
+	Account                string                `json:"account"`
+	TokenExpiry            int64                 `json:"token_expiry"`
+	DopplerLoggingEndpoint string                `json:"-"`
+	AuthorizationEndpoint  string                `json:"-"`
+	SkipSSLValidation      bool                  `json:"skip_ssl_validation"`
+	TokenMetadata          string                `json:"-"`
+	SubType                string                `json:"sub_type"`
+	EndpointMetadata       string                `json:"metadata"`
+	CircuitBreaker         *CircuitBreakerStatus `json:"circuit_breaker,omitempty"`
+}
+
+// CircuitBreakerStatus - State of the circuit breaker that protects an endpoint, and the endpoint's recent latency
+type CircuitBreakerStatus struct {
+	State           string `json:"state"`
+	Failures        int    `json:"failures"`
+	RetryAt         int64  `json:"retry_at,omitempty"`
+	LatencyInMillis int64  `json:"latency_ms,omitempty"`
 }
 
 const (
@@ -106,6 +131,35 @@ type TokenRecord struct {
 	CertificateKey string
 }
 
+// EndpointTokenFilter - Selects endpoint tokens by user, endpoint and/or age. Empty fields match all tokens
+type EndpointTokenFilter struct {
+	UserGUID string
+	CNSIGUID string
+	// Only tokens that expired before this time (Unix seconds) - tokens are refreshed when used, so this finds
+	// connections that have not been used since then
+	ExpiredBefore int64
+}
+
+// IsEmpty - Does the filter match all tokens?
+func (f EndpointTokenFilter) IsEmpty() bool {
+	return len(f.UserGUID) == 0 && len(f.CNSIGUID) == 0 && f.ExpiredBefore == 0
+}
+
+// EndpointTokenInfo - A user's connection to an endpoint, without the token's secrets
+type EndpointTokenInfo struct {
+	TokenGUID    string `json:"token_guid"`
+	UserGUID     string `json:"user_guid"`
+	UserName     string `json:"user_name,omitempty"`
+	CNSIGUID     string `json:"cnsi_guid"`
+	CNSIName     string `json:"cnsi_name,omitempty"`
+	CNSIType     string `json:"cnsi_type,omitempty"`
+	AuthType     string `json:"auth_type"`
+	TokenExpiry  int64  `json:"token_expiry"`
+	Disconnected bool   `json:"disconnected"`
+	SystemShared bool   `json:"system_shared"`
+	LinkedGUID   string `json:"linked_token,omitempty"`
+}
+
 type CFInfo struct {
 	EndpointGUID string
 	SpaceGUID    string
@@ -164,6 +218,10 @@ type ConnectedUser struct {
 	Name   string   `json:"name"`
 	Admin  bool     `json:"admin"`
 	Scopes []string `json:"scopes"`
+	Email  string   `json:"email,omitempty"`
+	// Console roles and the permissions they grant. Only filled in for the session user's info
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
 }
 
 type JWTUserTokenInfo struct {
@@ -210,11 +268,21 @@ type Info struct {
 // EndpointDetail extends CNSI Record and adds the user
 type EndpointDetail struct {
 	*CNSIRecord
-	EndpointMetadata  interface{}       `json:"endpoint_metadata,omitempty"`
-	User              *ConnectedUser    `json:"user"`
-	Metadata          map[string]string `json:"metadata,omitempty"`
-	TokenMetadata     string            `json:"-"`
-	SystemSharedToken bool              `json:"system_shared_token"`
+	EndpointMetadata  interface{}           `json:"endpoint_metadata,omitempty"`
+	User              *ConnectedUser        `json:"user"`
+	Metadata          map[string]string     `json:"metadata,omitempty"`
+	TokenMetadata     string                `json:"-"`
+	SystemSharedToken bool                  `json:"system_shared_token"`
+	CircuitBreaker    *CircuitBreakerStatus `json:"circuit_breaker,omitempty"`
+	TokenHealth       *TokenHealthStatus    `json:"token_health,omitempty"`
+}
+
+// TokenHealthStatus - State of the user's token for an endpoint
+type TokenHealthStatus struct {
+	TokenExpiry   int64  `json:"token_expiry,omitempty"`
+	LastRefresh   int64  `json:"last_refresh,omitempty"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	Disconnected  bool   `json:"disconnected"`
 }
 
 // Versions - response returned to caller from a getVersions action
@@ -231,6 +299,10 @@ const (
 	Remote AuthEndpointType = "remote"
 	//Local - String representation of remote auth endpoint type
 	Local AuthEndpointType = "local"
+	//OIDC - String representation of generic OpenID Connect auth endpoint type
+	OIDC AuthEndpointType = "oidc"
+	//LDAP - String representation of LDAP/Active Directory auth endpoint type
+	LDAP AuthEndpointType = "ldap"
 )
 
 //AuthEndpointTypes - Allows lookup of internal string representation by the
@@ -238,6 +310,8 @@ const (
 var AuthEndpointTypes = map[string]AuthEndpointType{
 	"remote": Remote,
 	"local":  Local,
+	"oidc":   OIDC,
+	"ldap":   LDAP,
 }
 
 // ConsoleConfig is essential configuration settings
@@ -253,6 +327,26 @@ type ConsoleConfig struct {
 	AuthEndpointType      string   `json:"auth_endpoint_type" configName:"AUTH_ENDPOINT_TYPE"`
 	SkipSSLValidation     bool     `json:"skip_ssl_validation" configName:"SKIP_SSL_VALIDATION"`
 	UseSSO                bool     `json:"use_sso" configName:"SSO_LOGIN"`
+	OIDCIssuerURL         string   `json:"oidc_issuer_url" configName:"OIDC_ISSUER_URL"`
+	OIDCScopes            string   `json:"oidc_scopes" configName:"OIDC_SCOPES"`
+	OIDCUserIDClaim       string   `json:"oidc_user_id_claim" configName:"OIDC_USER_ID_CLAIM"`
+	OIDCUserNameClaim     string   `json:"oidc_user_name_claim" configName:"OIDC_USER_NAME_CLAIM"`
+	OIDCEmailClaim        string   `json:"oidc_email_claim" configName:"OIDC_EMAIL_CLAIM"`
+	OIDCAdminClaim        string   `json:"oidc_admin_claim" configName:"OIDC_ADMIN_CLAIM"`
+	OIDCAdminValue        string   `json:"oidc_admin_value" configName:"OIDC_ADMIN_VALUE"`
+	LDAPURL               string   `json:"ldap_url" configName:"LDAP_URL"`
+	LDAPStartTLS          bool     `json:"ldap_start_tls" configName:"LDAP_START_TLS"`
+	LDAPBindDN            string   `json:"ldap_bind_dn" configName:"LDAP_BIND_DN"`
+	LDAPBindPassword      string   `json:"ldap_bind_password" configName:"LDAP_BIND_PASSWORD"`
+	LDAPUserBaseDN        string   `json:"ldap_user_base_dn" configName:"LDAP_USER_BASE_DN"`
+	LDAPUserFilter        string   `json:"ldap_user_filter" configName:"LDAP_USER_FILTER"`
+	LDAPUserDNTemplate    string   `json:"ldap_user_dn_template" configName:"LDAP_USER_DN_TEMPLATE"`
+	LDAPUsernameAttribute string   `json:"ldap_username_attribute" configName:"LDAP_USERNAME_ATTRIBUTE"`
+	LDAPEmailAttribute    string   `json:"ldap_email_attribute" configName:"LDAP_EMAIL_ATTRIBUTE"`
+	LDAPGroupAttribute    string   `json:"ldap_group_attribute" configName:"LDAP_GROUP_ATTRIBUTE"`
+	LDAPGroupBaseDN       string   `json:"ldap_group_base_dn" configName:"LDAP_GROUP_BASE_DN"`
+	LDAPGroupFilter       string   `json:"ldap_group_filter" configName:"LDAP_GROUP_FILTER"`
+	LDAPAdminGroup        string   `json:"ldap_admin_group" configName:"LDAP_ADMIN_GROUP"`
 }
 
 const defaultAdminScope = "stratos.admin"
@@ -289,6 +383,16 @@ func (consoleConfig *ConsoleConfig) IsSetupComplete() bool {
 		return true
 	}
 
+	// OIDC - need the issuer to discover the provider and a client to log in with
+	if AuthEndpointTypes[consoleConfig.AuthEndpointType] == OIDC {
+		return len(consoleConfig.OIDCIssuerURL) > 0 && len(consoleConfig.ConsoleClient) > 0
+	}
+
+	// LDAP - need the directory and a way to find users in it
+	if AuthEndpointTypes[consoleConfig.AuthEndpointType] == LDAP {
+		return len(consoleConfig.LDAPURL) > 0 && (len(consoleConfig.LDAPUserBaseDN) > 0 || len(consoleConfig.LDAPUserDNTemplate) > 0)
+	}
+
 	// UAA - check setup complete for UAA
 	if consoleConfig.UAAEndpoint == nil {
 		return false
@@ -311,9 +415,11 @@ type CNSIRequest struct {
 	PassThrough bool        `json:"-"`
 	LongRunning bool        `json:"-"`
 
-	Response     []byte `json:"-"`
-	Error        error  `json:"-"`
-	ResponseGUID string `json:"-"`
+	Response       []byte      `json:"-"`
+	ResponseHeader http.Header `json:"-"`
+	Attempts       int         `json:"-"`
+	Error          error       `json:"-"`
+	ResponseGUID   string      `json:"-"`
 }
 
 type PortalConfig struct {
@@ -333,6 +439,14 @@ type PortalConfig struct {
 	EncryptionKeyVolume                string   `configName:"ENCRYPTION_KEY_VOLUME"`
 	EncryptionKeyFilename              string   `configName:"ENCRYPTION_KEY_FILENAME"`
 	EncryptionKey                      string   `configName:"ENCRYPTION_KEY"`
+	EncryptionPreviousKeys             string   `configName:"ENCRYPTION_PREVIOUS_KEYS"`
+	EncryptionKeyProvider              string   `configName:"ENCRYPTION_KEY_PROVIDER"`
+	EncryptionKeyWrapped               string   `configName:"ENCRYPTION_KEY_WRAPPED"`
+	EncryptionKEKFile                  string   `configName:"ENCRYPTION_KEK_FILE"`
+	EncryptionKeyVaultPath             string   `configName:"ENCRYPTION_KEY_VAULT_PATH"`
+	EncryptionKeyVaultField            string   `configName:"ENCRYPTION_KEY_VAULT_FIELD"`
+	VaultAddress                       string   `configName:"VAULT_ADDR"`
+	VaultToken                         string   `configName:"VAULT_TOKEN"`
 	AutoRegisterCFUrl                  string   `configName:"AUTO_REG_CF_URL"`
 	AutoRegisterCFName                 string   `configName:"AUTO_REG_CF_NAME"`
 	SSOLogin                           bool     `configName:"SSO_LOGIN"`
@@ -341,6 +455,40 @@ type PortalConfig struct {
 	AuthEndpointType                   string   `configName:"AUTH_ENDPOINT_TYPE"`
 	CookieDomain                       string   `configName:"COOKIE_DOMAIN"`
 	LogLevel                           string   `configName:"LOG_LEVEL"`
+	ProxyPaginateMaxPages              int      `configName:"PROXY_PAGINATE_MAX_PAGES"`
+	ProxyCacheTTLInSecs                int64    `configName:"PROXY_CACHE_TTL_IN_SECS"`
+	ProxyCacheMaxEntries               int      `configName:"PROXY_CACHE_MAX_ENTRIES"`
+	CircuitBreakerFailureThreshold     int      `configName:"CIRCUIT_BREAKER_FAILURE_THRESHOLD"`
+	CircuitBreakerOpenInSecs           int64    `configName:"CIRCUIT_BREAKER_OPEN_IN_SECS"`
+	CircuitBreakerSlowCallInMillis     int64    `configName:"CIRCUIT_BREAKER_SLOW_CALL_IN_MILLIS"`
+	ProxyRetryMaxAttempts              int      `configName:"PROXY_RETRY_MAX_ATTEMPTS"`
+	ProxyRetryBaseDelayInMillis        int64    `configName:"PROXY_RETRY_BASE_DELAY_IN_MILLIS"`
+	ProxyRetryBudgetInSecs             int64    `configName:"PROXY_RETRY_BUDGET_IN_SECS"`
+	LongRunningJobRetentionInSecs      int64    `configName:"LONG_RUNNING_JOB_RETENTION_IN_SECS"`
+	ProxyBatchMaxRequests              int      `configName:"PROXY_BATCH_MAX_REQUESTS"`
+	ProxyBatchConcurrency              int      `configName:"PROXY_BATCH_CONCURRENCY"`
+	ProxyBatchTimeoutInSecs            int64    `configName:"PROXY_BATCH_TIMEOUT_IN_SECS"`
+	RateLimitUserPerSec                float64  `configName:"RATE_LIMIT_USER_PER_SEC"`
+	RateLimitUserBurst                 int      `configName:"RATE_LIMIT_USER_BURST"`
+	RateLimitEndpointPerSec            float64  `configName:"RATE_LIMIT_ENDPOINT_PER_SEC"`
+	RateLimitEndpointBurst             int      `configName:"RATE_LIMIT_ENDPOINT_BURST"`
+	RateLimitLoginPerSec               float64  `configName:"RATE_LIMIT_LOGIN_PER_SEC"`
+	RateLimitLoginBurst                int      `configName:"RATE_LIMIT_LOGIN_BURST"`
+	TrustedProxies                     []string `configName:"TRUSTED_PROXIES"`
+	SkipTokenVerification              bool     `configName:"SKIP_TOKEN_VERIFICATION"`
+	LocalUserPasswordMinLength         int      `configName:"LOCAL_USER_PASSWORD_MIN_LENGTH"`
+	LocalUserPasswordComplexity        bool     `configName:"LOCAL_USER_PASSWORD_COMPLEXITY"`
+	LoginLockoutThreshold              int      `configName:"LOGIN_LOCKOUT_THRESHOLD"`
+	LoginLockoutIPThreshold            int      `configName:"LOGIN_LOCKOUT_IP_THRESHOLD"`
+	LoginLockoutDurationInSecs         int64    `configName:"LOGIN_LOCKOUT_DURATION_IN_SECS"`
+	LoginLockoutMaxDurationInSecs      int64    `configName:"LOGIN_LOCKOUT_MAX_DURATION_IN_SECS"`
+	APITokenMaxLifetimeInSecs          int64    `configName:"API_TOKEN_MAX_LIFETIME_IN_SECS"`
+	RBACDefaultRole                    string   `configName:"RBAC_DEFAULT_ROLE"`
+	AuditLogSyslogAddress              string   `configName:"AUDIT_LOG_SYSLOG_ADDRESS"`
+	AuditLogWebhookURL                 string   `configName:"AUDIT_LOG_WEBHOOK_URL"`
+	TokenRefreshIntervalInSecs         int64    `configName:"TOKEN_REFRESH_INTERVAL_IN_SECS"`
+	TokenRefreshWindowInSecs           int64    `configName:"TOKEN_REFRESH_WINDOW_IN_SECS"`
+	TokenRefreshActiveUserInSecs       int64    `configName:"TOKEN_REFRESH_ACTIVE_USER_IN_SECS"`
 	CFAdminIdentifier                  string
 	CloudFoundryInfo                   *CFInfo
 	HTTPS                              bool
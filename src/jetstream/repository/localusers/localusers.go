@@ -16,4 +16,13 @@ type Repository interface {
 	FindUser(userGUID string) (interfaces.LocalUser, error)
 	UpdateLastLoginTime(userGUID string, loginTime time.Time) error
 	FindLastLoginTime(userGUID string) (time.Time, error)
+	UpdateLastFailedLoginTime(userGUID string, failedTime time.Time) error
+	FindLoginInfo(userGUID string) (interfaces.LocalUserLoginInfo, error)
+	ListLocalUsers() ([]interfaces.LocalUser, error)
+	SetLocalUserDisabled(userGUID string, disabled bool) error
+	DeleteLocalUser(userGUID string) error
+	FindTOTP(userGUID string, encryptionKey []byte) (*interfaces.LocalUserTOTP, error)
+	SaveTOTP(totp interfaces.LocalUserTOTP, encryptionKey []byte) error
+	DeleteTOTP(userGUID string) error
+	ReEncryptTOTP(encryptionKey []byte) (int, error)
 }
@@ -18,10 +18,15 @@ var findUserGUID = `SELECT user_guid FROM local_users WHERE user_name = $1`
 var findUserScope = `SELECT user_scope FROM local_users WHERE user_guid = $1`
 var insertLocalUser = `INSERT INTO local_users (user_guid, password_hash, user_name, user_email, user_scope, given_name, family_name) VALUES ($1, $2, $3, $4, $5, $6, $7)`
 var updateLocalUser = `UPDATE local_users SET password_hash=$1, user_name=$2, user_email=$3, user_scope=$4, given_name=$5, family_name=$6, last_updated=CURRENT_TIMESTAMP WHERE user_guid=$7`
-var updateLastLoginTime = `UPDATE local_users SET last_login=$1 WHERE user_guid = $2`
+var updateLastLoginTime = `UPDATE local_users SET last_login=$1, failed_login_count=0 WHERE user_guid = $2`
+var updateLastFailedLoginTime = `UPDATE local_users SET last_failed_login=$1, failed_login_count=failed_login_count+1 WHERE user_guid = $2`
+var findLoginInfo = `SELECT last_login, last_failed_login, failed_login_count FROM local_users WHERE user_guid = $1`
 var findLastLoginTime = `SELECT last_login FROM local_users WHERE user_guid = $1`
 var getTableCount = `SELECT count(user_guid) FROM local_users`
-var findUser = `SELECT user_name, user_email, user_scope, given_name, family_name FROM local_users WHERE user_guid = $1`
+var findUser = `SELECT user_name, user_email, user_scope, given_name, family_name, disabled FROM local_users WHERE user_guid = $1`
+var listLocalUsers = `SELECT user_guid, user_name, user_email, user_scope, given_name, family_name, disabled, last_login FROM local_users ORDER BY user_name`
+var setLocalUserDisabled = `UPDATE local_users SET disabled=$1, last_updated=CURRENT_TIMESTAMP WHERE user_guid=$2`
+var deleteLocalUser = `DELETE FROM local_users WHERE user_guid = $1`
 
 // PgsqlLocalUsersRepository is a PostgreSQL-backed local users repository
 type PgsqlLocalUsersRepository struct {
@@ -46,6 +51,17 @@ func InitRepositoryProvider(databaseProvider string) {
 	getTableCount = datastore.ModifySQLStatement(getTableCount, databaseProvider)
 	updateLastLoginTime = datastore.ModifySQLStatement(updateLastLoginTime, databaseProvider)
 	findLastLoginTime = datastore.ModifySQLStatement(findLastLoginTime, databaseProvider)
+	updateLastFailedLoginTime = datastore.ModifySQLStatement(updateLastFailedLoginTime, databaseProvider)
+	findLoginInfo = datastore.ModifySQLStatement(findLoginInfo, databaseProvider)
+	listLocalUsers = datastore.ModifySQLStatement(listLocalUsers, databaseProvider)
+	setLocalUserDisabled = datastore.ModifySQLStatement(setLocalUserDisabled, databaseProvider)
+	deleteLocalUser = datastore.ModifySQLStatement(deleteLocalUser, databaseProvider)
+	findTOTP = datastore.ModifySQLStatement(findTOTP, databaseProvider)
+	insertTOTP = datastore.ModifySQLStatement(insertTOTP, databaseProvider)
+	updateTOTP = datastore.ModifySQLStatement(updateTOTP, databaseProvider)
+	deleteTOTP = datastore.ModifySQLStatement(deleteTOTP, databaseProvider)
+	listTOTPSecrets = datastore.ModifySQLStatement(listTOTPSecrets, databaseProvider)
+	updateTOTPSecret = datastore.ModifySQLStatement(updateTOTPSecret, databaseProvider)
 }
 
 // FindPasswordHash returns the password hash from the datastore, for the given user
@@ -119,7 +135,7 @@ func (p *PgsqlLocalUsersRepository) FindUser(userGUID string) (interfaces.LocalU
 	)
 
 	// Look for the user
-	err := p.db.QueryRow(findUser, userGUID).Scan(&user.Username, &email, &scope, &givenName, &familyName)
+	err := p.db.QueryRow(findUser, userGUID).Scan(&user.Username, &email, &scope, &givenName, &familyName, &user.Disabled)
 	if err != nil {
 		msg := "Unable to find user: %v"
 		log.Debugf(msg, err)
@@ -324,3 +340,114 @@ func (p *PgsqlLocalUsersRepository) UpdateLocalUser(user interfaces.LocalUser) e
 
 	return err
 }
+
+// UpdateLastFailedLoginTime is called when a local user enters the wrong password. It records the time and counts
+// the failures since the last successful login
+func (p *PgsqlLocalUsersRepository) UpdateLastFailedLoginTime(userGUID string, failedTime time.Time) error {
+	log.Debug("UpdateLastFailedLoginTime")
+	if failedTime.IsZero() || userGUID == "" {
+		return errors.New("Unable to update last failed login time without a valid time or user GUID")
+	}
+
+	result, err := p.db.Exec(updateLastFailedLoginTime, failedTime, userGUID)
+	if err != nil {
+		return fmt.Errorf("Unable to update last failed login time for user %s: %v", userGUID, err)
+	}
+	return checkOneRowAffected(result, "UPDATE")
+}
+
+// FindLoginInfo returns the last successful and failed login times for the given user
+func (p *PgsqlLocalUsersRepository) FindLoginInfo(userGUID string) (interfaces.LocalUserLoginInfo, error) {
+	log.Debug("FindLoginInfo")
+	var info interfaces.LocalUserLoginInfo
+	if userGUID == "" {
+		return info, errors.New("Unable to find login info without a valid user GUID")
+	}
+
+	err := p.db.QueryRow(findLoginInfo, userGUID).Scan(&info.LastLogin, &info.LastFailedLogin, &info.FailedLoginCount)
+	if err != nil {
+		return info, fmt.Errorf("Unable to find login info: %v", err)
+	}
+	return info, nil
+}
+
+// ListLocalUsers returns all of the local users, ordered by name. Password hashes are not included
+func (p *PgsqlLocalUsersRepository) ListLocalUsers() ([]interfaces.LocalUser, error) {
+	log.Debug("ListLocalUsers")
+
+	rows, err := p.db.Query(listLocalUsers)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve local users: %v", err)
+	}
+	defer rows.Close()
+
+	users := make([]interfaces.LocalUser, 0)
+	for rows.Next() {
+		var (
+			user       interfaces.LocalUser
+			email      sql.NullString
+			scope      sql.NullString
+			givenName  sql.NullString
+			familyName sql.NullString
+			lastLogin  *time.Time
+		)
+		if err := rows.Scan(&user.UserGUID, &user.Username, &email, &scope, &givenName, &familyName, &user.Disabled, &lastLogin); err != nil {
+			return nil, fmt.Errorf("Unable to scan local user: %v", err)
+		}
+		user.Email = email.String
+		user.Scope = scope.String
+		user.GivenName = givenName.String
+		user.FamilyName = familyName.String
+		user.LastLogin = lastLogin
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to retrieve local users: %v", err)
+	}
+	return users, nil
+}
+
+// SetLocalUserDisabled disables or re-enables a local user. Disabled users can not log in
+func (p *PgsqlLocalUsersRepository) SetLocalUserDisabled(userGUID string, disabled bool) error {
+	log.Debug("SetLocalUserDisabled")
+	if userGUID == "" {
+		return errors.New("unable to disable local user without a valid User GUID")
+	}
+
+	result, err := p.db.Exec(setLocalUserDisabled, disabled, userGUID)
+	if err != nil {
+		return fmt.Errorf("unable to UPDATE local user: %v", err)
+	}
+	return checkOneRowAffected(result, "UPDATE")
+}
+
+// DeleteLocalUser removes a local user
+func (p *PgsqlLocalUsersRepository) DeleteLocalUser(userGUID string) error {
+	log.Debug("DeleteLocalUser")
+	if userGUID == "" {
+		return errors.New("unable to delete local user without a valid User GUID")
+	}
+
+	if err := p.DeleteTOTP(userGUID); err != nil {
+		return err
+	}
+
+	result, err := p.db.Exec(deleteLocalUser, userGUID)
+	if err != nil {
+		return fmt.Errorf("unable to DELETE local user: %v", err)
+	}
+	return checkOneRowAffected(result, "DELETE")
+}
+
+func checkOneRowAffected(result sql.Result, statement string) error {
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to %s local user: could not determine number of rows that were updated", statement)
+	} else if rowsUpdates < 1 {
+		return fmt.Errorf("unable to %s local user: no rows were updated", statement)
+	} else if rowsUpdates > 1 {
+		log.Warnf("%s local user: More than 1 row was updated (expected only 1)", statement)
+	}
+	return nil
+}
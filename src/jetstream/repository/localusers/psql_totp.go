@@ -0,0 +1,151 @@
+package localusers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+var findTOTP = `SELECT secret, enabled, recovery_codes, last_used_step FROM local_users_totp WHERE user_guid = $1`
+var insertTOTP = `INSERT INTO local_users_totp (user_guid, secret, enabled, recovery_codes, last_used_step) VALUES ($1, $2, $3, $4, $5)`
+var updateTOTP = `UPDATE local_users_totp SET secret=$1, enabled=$2, recovery_codes=$3, last_used_step=$4 WHERE user_guid=$5`
+var deleteTOTP = `DELETE FROM local_users_totp WHERE user_guid = $1`
+var listTOTPSecrets = `SELECT user_guid, secret FROM local_users_totp`
+var updateTOTPSecret = `UPDATE local_users_totp SET secret=$1 WHERE user_guid=$2`
+
+// FindTOTP returns the TOTP enrollment for the given user, or nil if the user has not enrolled
+func (p *PgsqlLocalUsersRepository) FindTOTP(userGUID string, encryptionKey []byte) (*interfaces.LocalUserTOTP, error) {
+	log.Debug("FindTOTP")
+	if userGUID == "" {
+		return nil, errors.New("Unable to find TOTP enrollment without a valid user GUID")
+	}
+
+	var (
+		cipherTextSecret []byte
+		recoveryCodes    sql.NullString
+	)
+
+	totp := &interfaces.LocalUserTOTP{UserGUID: userGUID}
+	err := p.db.QueryRow(findTOTP, userGUID).Scan(&cipherTextSecret, &totp.Enabled, &recoveryCodes, &totp.LastUsedStep)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("Unable to find TOTP enrollment: %v", err)
+	}
+
+	if totp.Secret, err = crypto.DecryptToken(encryptionKey, cipherTextSecret); err != nil {
+		return nil, fmt.Errorf("Unable to decrypt TOTP secret: %v", err)
+	}
+
+	if recoveryCodes.Valid && len(recoveryCodes.String) > 0 {
+		totp.RecoveryCodes = strings.Split(recoveryCodes.String, ",")
+	}
+
+	return totp, nil
+}
+
+// SaveTOTP adds or updates the TOTP enrollment for a user
+func (p *PgsqlLocalUsersRepository) SaveTOTP(totp interfaces.LocalUserTOTP, encryptionKey []byte) error {
+	log.Debug("SaveTOTP")
+	if totp.UserGUID == "" || totp.Secret == "" {
+		return errors.New("Unable to save TOTP enrollment without a valid user GUID and secret")
+	}
+
+	cipherTextSecret, err := crypto.EncryptToken(encryptionKey, totp.Secret)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt TOTP secret: %v", err)
+	}
+	recoveryCodes := strings.Join(totp.RecoveryCodes, ",")
+
+	result, err := p.db.Exec(updateTOTP, cipherTextSecret, totp.Enabled, recoveryCodes, totp.LastUsedStep, totp.UserGUID)
+	if err != nil {
+		return fmt.Errorf("Unable to UPDATE TOTP enrollment: %v", err)
+	}
+	if rowsUpdates, err := result.RowsAffected(); err == nil && rowsUpdates > 0 {
+		return nil
+	}
+
+	if _, err = p.db.Exec(insertTOTP, totp.UserGUID, cipherTextSecret, totp.Enabled, recoveryCodes, totp.LastUsedStep); err != nil {
+		return fmt.Errorf("Unable to INSERT TOTP enrollment: %v", err)
+	}
+	return nil
+}
+
+// DeleteTOTP removes a user's TOTP enrollment, if they have one
+func (p *PgsqlLocalUsersRepository) DeleteTOTP(userGUID string) error {
+	log.Debug("DeleteTOTP")
+	if userGUID == "" {
+		return errors.New("Unable to delete TOTP enrollment without a valid user GUID")
+	}
+
+	if _, err := p.db.Exec(deleteTOTP, userGUID); err != nil {
+		return fmt.Errorf("Unable to DELETE TOTP enrollment: %v", err)
+	}
+	return nil
+}
+
+// ReEncryptTOTP re-encrypts any TOTP secrets that were encrypted with a previous key. Returns the number updated
+func (p *PgsqlLocalUsersRepository) ReEncryptTOTP(encryptionKey []byte) (int, error) {
+	log.Debug("ReEncryptTOTP")
+	txn, err := p.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to re-encrypt TOTP secrets: %v", err)
+	}
+
+	updated, err := reEncryptTOTP(txn, encryptionKey)
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return 0, fmt.Errorf("Unable to re-encrypt TOTP secrets: %v", err)
+	}
+	return updated, nil
+}
+
+func reEncryptTOTP(txn *sql.Tx, encryptionKey []byte) (int, error) {
+	rows, err := txn.Query(listTOTPSecrets)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to retrieve TOTP enrollments: %v", err)
+	}
+
+	// Read everything before updating, as not all drivers support updates while iterating over a query
+	secrets := make(map[string][]byte)
+	for rows.Next() {
+		var userGUID string
+		var cipherTextSecret []byte
+		if err := rows.Scan(&userGUID, &cipherTextSecret); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("Unable to scan TOTP enrollment: %v", err)
+		}
+		secrets[userGUID] = cipherTextSecret
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("Unable to retrieve TOTP enrollments: %v", err)
+	}
+
+	updated := 0
+	for userGUID, cipherTextSecret := range secrets {
+		cipherTextSecret, changed, err := crypto.ReEncryptToken(encryptionKey, cipherTextSecret)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to re-encrypt TOTP secret for user %s: %v", userGUID, err)
+		}
+		if !changed {
+			continue
+		}
+		if _, err := txn.Exec(updateTOTPSecret, cipherTextSecret, userGUID); err != nil {
+			return 0, fmt.Errorf("Unable to UPDATE TOTP enrollment: %v", err)
+		}
+		updated++
+	}
+	return updated, nil
+}
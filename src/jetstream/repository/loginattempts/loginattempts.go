@@ -0,0 +1,16 @@
+package loginattempts
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Repository is an application of the repository pattern for storing failed login attempts
+type Repository interface {
+	Find(attemptType, key string) (interfaces.LoginAttempts, error)
+	AddFailure(attemptType, key string, now, forgetBefore time.Time) (int, error)
+	LockUntil(attemptType, key string, lockedUntil time.Time) error
+	Delete(attemptType, key string) error
+	ListLocked(now time.Time) ([]interfaces.LoginAttempts, error)
+}
@@ -0,0 +1,170 @@
+package loginattempts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+var findLoginAttempts = `SELECT failures, last_failure, locked_until FROM login_attempts WHERE attempt_type = $1 AND attempt_key = $2`
+var insertLoginAttempts = `INSERT INTO login_attempts (attempt_type, attempt_key, failures, last_failure, locked_until) VALUES ($1, $2, $3, $4, $5)`
+var addLoginFailure = `UPDATE login_attempts SET failures = CASE WHEN last_failure < $1 THEN 1 ELSE failures + 1 END, last_failure = $2 WHERE attempt_type = $3 AND attempt_key = $4`
+var findLoginFailures = `SELECT failures FROM login_attempts WHERE attempt_type = $1 AND attempt_key = $2`
+var lockLoginAttempts = `UPDATE login_attempts SET locked_until = $1 WHERE attempt_type = $2 AND attempt_key = $3 AND (locked_until IS NULL OR locked_until < $4)`
+var deleteLoginAttempts = `DELETE FROM login_attempts WHERE attempt_type = $1 AND attempt_key = $2`
+var listLockedLoginAttempts = `SELECT attempt_type, attempt_key, failures, last_failure, locked_until FROM login_attempts WHERE locked_until > $1 ORDER BY locked_until DESC`
+
+// PgsqlLoginAttemptsRepository is a PostgreSQL-backed failed login attempts repository
+type PgsqlLoginAttemptsRepository struct {
+	db *sql.DB
+}
+
+// NewPgsqlLoginAttemptsRepository - get a reference to the login attempts data source
+func NewPgsqlLoginAttemptsRepository(dcp *sql.DB) (Repository, error) {
+	log.Debug("NewPgsqlLoginAttemptsRepository")
+	return &PgsqlLoginAttemptsRepository{db: dcp}, nil
+}
+
+// InitRepositoryProvider - One time init for the given DB Provider
+func InitRepositoryProvider(databaseProvider string) {
+	// Modify the database statements if needed, for the given database type
+	findLoginAttempts = datastore.ModifySQLStatement(findLoginAttempts, databaseProvider)
+	insertLoginAttempts = datastore.ModifySQLStatement(insertLoginAttempts, databaseProvider)
+	addLoginFailure = datastore.ModifySQLStatement(addLoginFailure, databaseProvider)
+	findLoginFailures = datastore.ModifySQLStatement(findLoginFailures, databaseProvider)
+	lockLoginAttempts = datastore.ModifySQLStatement(lockLoginAttempts, databaseProvider)
+	deleteLoginAttempts = datastore.ModifySQLStatement(deleteLoginAttempts, databaseProvider)
+	listLockedLoginAttempts = datastore.ModifySQLStatement(listLockedLoginAttempts, databaseProvider)
+}
+
+// Find returns the failed login attempts for the given username or IP address. No failures are returned if there
+// haven't been any
+func (p *PgsqlLoginAttemptsRepository) Find(attemptType, key string) (interfaces.LoginAttempts, error) {
+	log.Debug("Find")
+	attempts := interfaces.LoginAttempts{Type: attemptType, Key: key}
+	if attemptType == "" || key == "" {
+		return attempts, errors.New("Unable to find login attempts without a valid type and key")
+	}
+
+	err := p.db.QueryRow(findLoginAttempts, attemptType, key).Scan(&attempts.Failures, &attempts.LastFailure, &attempts.LockedUntil)
+	switch {
+	case err == sql.ErrNoRows:
+		return attempts, nil
+	case err != nil:
+		return attempts, fmt.Errorf("Unable to find login attempts: %v", err)
+	}
+
+	return attempts, nil
+}
+
+// AddFailure counts a failed login for a username or IP address, returning the number of failures. Failures are
+// counted from one again if the last was before forgetBefore. The count is incremented in the database, so that
+// concurrent failures are all counted
+func (p *PgsqlLoginAttemptsRepository) AddFailure(attemptType, key string, now, forgetBefore time.Time) (int, error) {
+	log.Debug("AddFailure")
+	if attemptType == "" || key == "" {
+		return 0, errors.New("Unable to add login failure without a valid type and key")
+	}
+
+	failures, err := p.addFailure(attemptType, key, now, forgetBefore)
+	if err != nil {
+		// The first failure may have been added at the same time, in which case the row can now be updated
+		failures, err = p.addFailure(attemptType, key, now, forgetBefore)
+	}
+	return failures, err
+}
+
+func (p *PgsqlLoginAttemptsRepository) addFailure(attemptType, key string, now, forgetBefore time.Time) (int, error) {
+	txn, err := p.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to add login failure: %v", err)
+	}
+
+	failures, err := addLoginFailureInTxn(txn, attemptType, key, now, forgetBefore)
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return 0, fmt.Errorf("Unable to add login failure: %v", err)
+	}
+	return failures, nil
+}
+
+// The update locks the row until the transaction ends, so the count that is read back includes this failure
+func addLoginFailureInTxn(txn *sql.Tx, attemptType, key string, now, forgetBefore time.Time) (int, error) {
+	result, err := txn.Exec(addLoginFailure, forgetBefore, now, attemptType, key)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to UPDATE login attempts: %v", err)
+	}
+	if rowsUpdated, err := result.RowsAffected(); err == nil && rowsUpdated > 0 {
+		var failures int
+		if err := txn.QueryRow(findLoginFailures, attemptType, key).Scan(&failures); err != nil {
+			return 0, fmt.Errorf("Unable to find login attempts: %v", err)
+		}
+		return failures, nil
+	}
+
+	if _, err := txn.Exec(insertLoginAttempts, attemptType, key, 1, now, nil); err != nil {
+		return 0, fmt.Errorf("Unable to INSERT login attempts: %v", err)
+	}
+	return 1, nil
+}
+
+// LockUntil locks out logins for a username or IP address. An existing lockout is only ever extended
+func (p *PgsqlLoginAttemptsRepository) LockUntil(attemptType, key string, lockedUntil time.Time) error {
+	log.Debug("LockUntil")
+	if attemptType == "" || key == "" {
+		return errors.New("Unable to lock out logins without a valid type and key")
+	}
+
+	if _, err := p.db.Exec(lockLoginAttempts, lockedUntil, attemptType, key, lockedUntil); err != nil {
+		return fmt.Errorf("Unable to UPDATE login attempts: %v", err)
+	}
+	return nil
+}
+
+// Delete clears the failed login attempts for a username or IP address
+func (p *PgsqlLoginAttemptsRepository) Delete(attemptType, key string) error {
+	log.Debug("Delete")
+	if attemptType == "" || key == "" {
+		return errors.New("Unable to delete login attempts without a valid type and key")
+	}
+
+	if _, err := p.db.Exec(deleteLoginAttempts, attemptType, key); err != nil {
+		return fmt.Errorf("Unable to DELETE login attempts: %v", err)
+	}
+	return nil
+}
+
+// ListLocked returns the usernames and IP addresses that are locked out at the given time
+func (p *PgsqlLoginAttemptsRepository) ListLocked(now time.Time) ([]interfaces.LoginAttempts, error) {
+	log.Debug("ListLocked")
+
+	rows, err := p.db.Query(listLockedLoginAttempts, now)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve login attempts: %v", err)
+	}
+	defer rows.Close()
+
+	locked := make([]interfaces.LoginAttempts, 0)
+	for rows.Next() {
+		var attempts interfaces.LoginAttempts
+		if err := rows.Scan(&attempts.Type, &attempts.Key, &attempts.Failures, &attempts.LastFailure, &attempts.LockedUntil); err != nil {
+			return nil, fmt.Errorf("Unable to scan login attempts: %v", err)
+		}
+		locked = append(locked, attempts)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to retrieve login attempts: %v", err)
+	}
+	return locked, nil
+}
@@ -0,0 +1,122 @@
+package roles
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+var listRoleAssignments = `SELECT principal_type, principal, role FROM role_assignments ORDER BY principal_type, principal, role`
+var findRoleAssignments = `SELECT principal_type, principal, role FROM role_assignments WHERE (principal_type = $1 AND principal = $2) OR principal_type = $3`
+var insertRoleAssignment = `INSERT INTO role_assignments (principal_type, principal, role) VALUES ($1, $2, $3)`
+var deleteRoleAssignment = `DELETE FROM role_assignments WHERE principal_type = $1 AND principal = $2 AND role = $3`
+
+// PgsqlRolesRepository is a PostgreSQL-backed role assignment repository
+type PgsqlRolesRepository struct {
+	db *sql.DB
+}
+
+// NewPgsqlRolesRepository - get a reference to the role assignment data source
+func NewPgsqlRolesRepository(dcp *sql.DB) (Repository, error) {
+	log.Debug("NewPgsqlRolesRepository")
+	return &PgsqlRolesRepository{db: dcp}, nil
+}
+
+// InitRepositoryProvider - One time init for the given DB Provider
+func InitRepositoryProvider(databaseProvider string) {
+	// Modify the database statements if needed, for the given database type
+	listRoleAssignments = datastore.ModifySQLStatement(listRoleAssignments, databaseProvider)
+	findRoleAssignments = datastore.ModifySQLStatement(findRoleAssignments, databaseProvider)
+	insertRoleAssignment = datastore.ModifySQLStatement(insertRoleAssignment, databaseProvider)
+	deleteRoleAssignment = datastore.ModifySQLStatement(deleteRoleAssignment, databaseProvider)
+}
+
+func (p *PgsqlRolesRepository) query(query string, args ...interface{}) ([]interfaces.RoleAssignment, error) {
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve role assignments: %v", err)
+	}
+	defer rows.Close()
+
+	assignments := make([]interfaces.RoleAssignment, 0)
+	for rows.Next() {
+		var assignment interfaces.RoleAssignment
+		if err := rows.Scan(&assignment.PrincipalType, &assignment.Principal, &assignment.Role); err != nil {
+			return nil, fmt.Errorf("Unable to scan role assignment: %v", err)
+		}
+		assignments = append(assignments, assignment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to retrieve role assignments: %v", err)
+	}
+	return assignments, nil
+}
+
+// List returns all of the role assignments
+func (p *PgsqlRolesRepository) List() ([]interfaces.RoleAssignment, error) {
+	log.Debug("List")
+	return p.query(listRoleAssignments)
+}
+
+// FindRoles returns the roles assigned to the user, either directly or through one of their groups
+func (p *PgsqlRolesRepository) FindRoles(userGUID string, groups []string) ([]string, error) {
+	log.Debug("FindRoles")
+	assignments, err := p.query(findRoleAssignments, interfaces.RolePrincipalUser, userGUID, interfaces.RolePrincipalGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	inGroup := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		inGroup[group] = true
+	}
+
+	roles := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, assignment := range assignments {
+		if assignment.PrincipalType == interfaces.RolePrincipalGroup && !inGroup[assignment.Principal] {
+			continue
+		}
+		if !seen[assignment.Role] {
+			seen[assignment.Role] = true
+			roles = append(roles, assignment.Role)
+		}
+	}
+	return roles, nil
+}
+
+// Add assigns a role to a user or group
+func (p *PgsqlRolesRepository) Add(assignment interfaces.RoleAssignment) error {
+	log.Debug("Add")
+	if assignment.PrincipalType == "" || assignment.Principal == "" || assignment.Role == "" {
+		return errors.New("Unable to add role assignment without a valid principal and role")
+	}
+
+	if _, err := p.db.Exec(insertRoleAssignment, assignment.PrincipalType, assignment.Principal, assignment.Role); err != nil {
+		return fmt.Errorf("Unable to INSERT role assignment: %v", err)
+	}
+	return nil
+}
+
+// Delete removes a role from a user or group
+func (p *PgsqlRolesRepository) Delete(assignment interfaces.RoleAssignment) error {
+	log.Debug("Delete")
+	result, err := p.db.Exec(deleteRoleAssignment, assignment.PrincipalType, assignment.Principal, assignment.Role)
+	if err != nil {
+		return fmt.Errorf("Unable to DELETE role assignment: %v", err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return errors.New("Unable to DELETE role assignment: could not verify deletion")
+	}
+	if rowsUpdates < 1 {
+		return errors.New("No role assignment found")
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package roles
+
+import (
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Repository is an application of the repository pattern for storing console role assignments
+type Repository interface {
+	List() ([]interfaces.RoleAssignment, error)
+	FindRoles(userGUID string, groups []string) ([]string, error)
+	Add(assignment interfaces.RoleAssignment) error
+	Delete(assignment interfaces.RoleAssignment) error
+}
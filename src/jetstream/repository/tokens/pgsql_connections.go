@@ -0,0 +1,150 @@
+package tokens
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/datastore"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Searches are built from the filter, so the placeholders are rewritten when they are used
+var listCNSITokenInfo = `SELECT t.token_guid, t.user_guid, u.user_name, t.cnsi_guid, c.name, c.cnsi_type, t.auth_type, t.token_expiry, t.disconnected, t.linked_token
+										FROM tokens t
+										LEFT JOIN local_users u ON u.user_guid = t.user_guid
+										LEFT JOIN cnsis c ON c.guid = t.cnsi_guid
+										WHERE t.token_type = 'cnsi'`
+
+var deleteCNSITokenByGUID = `DELETE FROM tokens WHERE token_type = 'cnsi' AND token_guid = $1 AND user_guid = $2`
+
+var databaseProvider string
+
+// endpointTokenFilterConditions returns the conditions for the filter, to be appended to a WHERE clause. Column names
+// are given the prefix, if the tokens table has an alias
+func endpointTokenFilterConditions(filter interfaces.EndpointTokenFilter, prefix string) (string, []interface{}) {
+	conditions := ""
+	args := make([]interface{}, 0)
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions += fmt.Sprintf(" AND "+prefix+condition, len(args))
+	}
+
+	if len(filter.UserGUID) > 0 {
+		add("user_guid = $%d", filter.UserGUID)
+	}
+	if len(filter.CNSIGUID) > 0 {
+		add("cnsi_guid = $%d", filter.CNSIGUID)
+	}
+	if filter.ExpiredBefore > 0 {
+		add("token_expiry < $%d", filter.ExpiredBefore)
+	}
+	return conditions, args
+}
+
+// revokeTokenFilterConditions returns the conditions for the tokens to revoke. The system shared token is only
+// revoked if the filter asks for its user
+func revokeTokenFilterConditions(filter interfaces.EndpointTokenFilter, prefix string) (string, []interface{}) {
+	conditions, args := endpointTokenFilterConditions(filter, prefix)
+	if len(filter.UserGUID) == 0 {
+		args = append(args, SystemSharedUserGuid)
+		conditions += fmt.Sprintf(" AND "+prefix+"user_guid <> $%d", len(args))
+	}
+	return conditions, args
+}
+
+// ListCNSITokens - List the endpoint tokens that match the filter, with the names of their users and endpoints.
+// User names are only known for local users
+func (p *PgsqlTokenRepository) ListCNSITokens(filter interfaces.EndpointTokenFilter) ([]interfaces.EndpointTokenInfo, error) {
+	log.Debug("ListCNSITokens")
+	conditions, args := endpointTokenFilterConditions(filter, "t.")
+	return listCNSITokens(p.db, conditions, args)
+}
+
+// RevokeCNSITokens - Delete the endpoint tokens that match the filter, other than the system shared token unless the
+// filter is for its user. Returns the tokens that were deleted
+func (p *PgsqlTokenRepository) RevokeCNSITokens(filter interfaces.EndpointTokenFilter) ([]interfaces.EndpointTokenInfo, error) {
+	log.Debug("RevokeCNSITokens")
+	if filter.IsEmpty() {
+		return nil, errors.New("Unable to delete endpoint tokens without a user, endpoint or expiry")
+	}
+
+	txn, err := p.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to delete endpoint tokens: %v", err)
+	}
+
+	revoked, err := revokeCNSITokens(txn, filter)
+	if err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return nil, fmt.Errorf("Unable to delete endpoint tokens: %v", err)
+	}
+	return revoked, nil
+}
+
+func revokeCNSITokens(txn *sql.Tx, filter interfaces.EndpointTokenFilter) ([]interfaces.EndpointTokenInfo, error) {
+	conditions, args := revokeTokenFilterConditions(filter, "t.")
+	tokens, err := listCNSITokens(txn, conditions, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete exactly the tokens that were listed, so that the caller knows which connections have gone
+	revoked := make([]interfaces.EndpointTokenInfo, 0, len(tokens))
+	for _, token := range tokens {
+		result, err := txn.Exec(deleteCNSITokenByGUID, token.TokenGUID, token.UserGUID)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to delete endpoint token %s: %v", token.TokenGUID, err)
+		}
+		if deleted, err := result.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("Unable to delete endpoint token %s: %v", token.TokenGUID, err)
+		} else if deleted > 0 {
+			revoked = append(revoked, token)
+		}
+	}
+	return revoked, nil
+}
+
+// tokenQuerier is implemented by both the database and transactions
+type tokenQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func listCNSITokens(q tokenQuerier, conditions string, args []interface{}) ([]interfaces.EndpointTokenInfo, error) {
+	query := datastore.ModifySQLStatement(listCNSITokenInfo+conditions+" ORDER BY t.cnsi_guid, t.user_guid", databaseProvider)
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve endpoint tokens: %v", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]interfaces.EndpointTokenInfo, 0)
+	for rows.Next() {
+		var (
+			info                                   interfaces.EndpointTokenInfo
+			userName, cnsiName, cnsiType, authType sql.NullString
+			linkedToken                            sql.NullString
+		)
+		if err := rows.Scan(&info.TokenGUID, &info.UserGUID, &userName, &info.CNSIGUID, &cnsiName, &cnsiType, &authType,
+			&info.TokenExpiry, &info.Disconnected, &linkedToken); err != nil {
+			return nil, fmt.Errorf("Unable to scan endpoint token: %v", err)
+		}
+		info.UserName = userName.String
+		info.CNSIName = cnsiName.String
+		info.CNSIType = cnsiType.String
+		info.AuthType = authType.String
+		info.LinkedGUID = linkedToken.String
+		info.SystemShared = info.UserGUID == SystemSharedUserGuid
+		tokens = append(tokens, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to retrieve endpoint tokens: %v", err)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,84 @@
+package tokens
+
+import (
+	"database/sql"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+)
+
+var listTokenCipherText = `SELECT token_guid, user_guid, auth_token, refresh_token FROM tokens`
+
+var updateTokenCipherText = `UPDATE tokens SET auth_token = $1, refresh_token = $2 WHERE token_guid = $3 AND user_guid = $4`
+
+type tokenCipherText struct {
+	tokenGUID    string
+	userGUID     string
+	authToken    []byte
+	refreshToken []byte
+}
+
+// ReEncrypt - Re-encrypt any tokens that were encrypted with a previous key. Returns the number of tokens updated
+func (p *PgsqlTokenRepository) ReEncrypt(encryptionKey []byte) (int, error) {
+	log.Debug("ReEncrypt")
+	txn, err := p.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to re-encrypt tokens: %v", err)
+	}
+
+	updated, err := reEncryptTokens(txn, encryptionKey)
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return 0, fmt.Errorf("Unable to re-encrypt tokens: %v", err)
+	}
+	return updated, nil
+}
+
+func reEncryptTokens(txn *sql.Tx, encryptionKey []byte) (int, error) {
+	rows, err := txn.Query(listTokenCipherText)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to retrieve tokens: %v", err)
+	}
+
+	// Read everything before updating, as not all drivers support updates while iterating over a query
+	tokens := make([]tokenCipherText, 0)
+	for rows.Next() {
+		var token tokenCipherText
+		if err := rows.Scan(&token.tokenGUID, &token.userGUID, &token.authToken, &token.refreshToken); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("Unable to scan token: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("Unable to retrieve tokens: %v", err)
+	}
+
+	updated := 0
+	for _, token := range tokens {
+		authToken, authTokenChanged, err := crypto.ReEncryptToken(encryptionKey, token.authToken)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to re-encrypt token %s: %v", token.tokenGUID, err)
+		}
+		refreshToken, refreshTokenChanged, err := crypto.ReEncryptToken(encryptionKey, token.refreshToken)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to re-encrypt refresh token %s: %v", token.tokenGUID, err)
+		}
+		if !authTokenChanged && !refreshTokenChanged {
+			continue
+		}
+
+		if _, err := txn.Exec(updateTokenCipherText, authToken, refreshToken, token.tokenGUID, token.userGUID); err != nil {
+			return 0, fmt.Errorf("Unable to UPDATE token %s: %v", token.tokenGUID, err)
+		}
+		updated++
+	}
+	return updated, nil
+}
@@ -74,20 +74,24 @@ func NewPgsqlTokenRepository(dcp *sql.DB) (Repository, error) {
 }
 
 // InitRepositoryProvider - One time init for the given DB Provider
-func InitRepositoryProvider(databaseProvider string) {
+func InitRepositoryProvider(provider string) {
 	// Modify the database statements if needed, for the given database type
-	findAuthToken = datastore.ModifySQLStatement(findAuthToken, databaseProvider)
-	countAuthTokens = datastore.ModifySQLStatement(countAuthTokens, databaseProvider)
-	insertAuthToken = datastore.ModifySQLStatement(insertAuthToken, databaseProvider)
-	updateAuthToken = datastore.ModifySQLStatement(updateAuthToken, databaseProvider)
-	findCNSIToken = datastore.ModifySQLStatement(findCNSIToken, databaseProvider)
-	findCNSITokenConnected = datastore.ModifySQLStatement(findCNSITokenConnected, databaseProvider)
-	countCNSITokens = datastore.ModifySQLStatement(countCNSITokens, databaseProvider)
-	insertCNSIToken = datastore.ModifySQLStatement(insertCNSIToken, databaseProvider)
-	updateCNSIToken = datastore.ModifySQLStatement(updateCNSIToken, databaseProvider)
-	deleteCNSIToken = datastore.ModifySQLStatement(deleteCNSIToken, databaseProvider)
-	deleteCNSITokens = datastore.ModifySQLStatement(deleteCNSITokens, databaseProvider)
-	updateToken = datastore.ModifySQLStatement(updateToken, databaseProvider)
+	databaseProvider = provider
+	findAuthToken = datastore.ModifySQLStatement(findAuthToken, provider)
+	countAuthTokens = datastore.ModifySQLStatement(countAuthTokens, provider)
+	insertAuthToken = datastore.ModifySQLStatement(insertAuthToken, provider)
+	updateAuthToken = datastore.ModifySQLStatement(updateAuthToken, provider)
+	findCNSIToken = datastore.ModifySQLStatement(findCNSIToken, provider)
+	findCNSITokenConnected = datastore.ModifySQLStatement(findCNSITokenConnected, provider)
+	countCNSITokens = datastore.ModifySQLStatement(countCNSITokens, provider)
+	insertCNSIToken = datastore.ModifySQLStatement(insertCNSIToken, provider)
+	updateCNSIToken = datastore.ModifySQLStatement(updateCNSIToken, provider)
+	deleteCNSIToken = datastore.ModifySQLStatement(deleteCNSIToken, provider)
+	deleteCNSITokens = datastore.ModifySQLStatement(deleteCNSITokens, provider)
+	updateToken = datastore.ModifySQLStatement(updateToken, provider)
+	listTokenCipherText = datastore.ModifySQLStatement(listTokenCipherText, provider)
+	updateTokenCipherText = datastore.ModifySQLStatement(updateTokenCipherText, provider)
+	deleteCNSITokenByGUID = datastore.ModifySQLStatement(deleteCNSITokenByGUID, provider)
 }
 
 // saveAuthToken - Save the Auth token to the datastore
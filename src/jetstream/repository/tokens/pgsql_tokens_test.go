@@ -2,11 +2,13 @@ package tokens
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"log"
 	"testing"
 	"time"
 
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
 	. "github.com/smartystreets/goconvey/convey"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
@@ -34,6 +36,14 @@ var tokenRecord = interfaces.TokenRecord{
 	TokenExpiry:  mockTokenExpiry,
 }
 
+// encryptedWithKey matches tokens encrypted with the key in the current format
+type encryptedWithKey []byte
+
+func (key encryptedWithKey) Match(v driver.Value) bool {
+	t, ok := v.([]byte)
+	return ok && crypto.IsEncryptedWithKey(key, t)
+}
+
 func initialiseRepo(t *testing.T) (*sql.DB, sqlmock.Sqlmock, Repository) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -108,7 +118,7 @@ func TestSaveUAATokens(t *testing.T) {
 						WillReturnRows(sqlmock.NewRows([]string{"0"}))
 
 					mock.ExpectExec(insertTokenSql).
-						WithArgs(sqlmock.AnyArg(), mockUserGuid, "uaa", sqlmock.AnyArg(), sqlmock.AnyArg(), tokenRecord.TokenExpiry).
+						WithArgs(sqlmock.AnyArg(), mockUserGuid, "uaa", encryptedWithKey(mockEncryptionKey), encryptedWithKey(mockEncryptionKey), tokenRecord.TokenExpiry).
 						WillReturnResult(sqlmock.NewResult(1, 1))
 
 					err := repository.SaveAuthToken(mockUserGuid, tokenRecord, mockEncryptionKey)
@@ -136,6 +146,20 @@ func TestSaveUAATokens(t *testing.T) {
 					So(mock.ExpectationsWereMet(), ShouldBeNil)
 				})
 
+				Convey("Should write the updated token in the current format", func() {
+
+					mock.ExpectQuery(countTokensSql).
+						WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+					mock.ExpectExec(updateUAATokenSql).
+						WithArgs(encryptedWithKey(mockEncryptionKey), encryptedWithKey(mockEncryptionKey), tokenRecord.TokenExpiry, mockUserGuid, "uaa").
+						WillReturnResult(sqlmock.NewResult(0, 1))
+
+					err := repository.SaveAuthToken(mockUserGuid, tokenRecord, mockEncryptionKey)
+					So(err, ShouldBeNil)
+					So(mock.ExpectationsWereMet(), ShouldBeNil)
+				})
+
 				Reset(func() {
 					db.Close()
 				})
@@ -291,6 +315,38 @@ func TestFindUAATokens(t *testing.T) {
 			So(mock.ExpectationsWereMet(), ShouldBeNil)
 		})
 
+		Convey("should read tokens stored by older versions alongside current ones", func() {
+			legacyToken, _ := crypto.EncryptCFB(mockEncryptionKey, []byte(mockUAAToken))
+			currentToken, _ := crypto.EncryptToken(mockEncryptionKey, mockUAAToken)
+
+			rs := sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "auth_type", "meta_data"}).
+				AddRow(mockTokenGUID, legacyToken, currentToken, mockTokenExpiry, "oauth", "")
+
+			mock.ExpectQuery(findUAATokenSql).
+				WillReturnRows(rs)
+			tr, err := repository.FindAuthToken(mockUserGuid, mockEncryptionKey)
+
+			So(err, ShouldBeNil)
+			So(tr.AuthToken, ShouldEqual, mockUAAToken)
+			So(tr.RefreshToken, ShouldEqual, mockUAAToken)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should fail to read a token that has been tampered with", func() {
+			currentToken, _ := crypto.EncryptToken(mockEncryptionKey, mockUAAToken)
+			currentToken[len(currentToken)-1] ^= 0xff
+
+			rs := sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "auth_type", "meta_data"}).
+				AddRow(mockTokenGUID, currentToken, nil, mockTokenExpiry, "oauth", "")
+
+			mock.ExpectQuery(findUAATokenSql).
+				WillReturnRows(rs)
+			_, err := repository.FindAuthToken(mockUserGuid, mockEncryptionKey)
+
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
 		Convey("Success case", func() {
 
 			rs := sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "auth_type", "meta_data"}).
@@ -460,3 +516,213 @@ func TestDeleteCNSITokens(t *testing.T) {
 	})
 
 }
+
+func TestListCNSITokens(t *testing.T) {
+
+	Convey("ListCNSITokens Tests", t, func() {
+
+		db, mock, repository := initialiseRepo(t)
+		rowFields := []string{"token_guid", "user_guid", "user_name", "cnsi_guid", "name", "cnsi_type", "auth_type", "token_expiry", "disconnected", "linked_token"}
+
+		Convey("should list all endpoint tokens", func() {
+			mock.ExpectQuery(`SELECT (.+) FROM tokens t LEFT JOIN local_users u (.+) LEFT JOIN cnsis c (.+) WHERE t.token_type = 'cnsi' ORDER BY`).
+				WillReturnRows(sqlmock.NewRows(rowFields).
+					AddRow(mockTokenGUID, mockUserGuid, "admin", mockCNSIGuid, "cf", "cf", "OAuth2", mockTokenExpiry, false, nil).
+					AddRow("shared-token", SystemSharedUserGuid, nil, mockCNSIGuid, nil, nil, nil, mockTokenExpiry, true, "linked-token"))
+
+			list, err := repository.ListCNSITokens(interfaces.EndpointTokenFilter{})
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			So(list, ShouldHaveLength, 2)
+			So(list[0], ShouldResemble, interfaces.EndpointTokenInfo{
+				TokenGUID:   mockTokenGUID,
+				UserGUID:    mockUserGuid,
+				UserName:    "admin",
+				CNSIGUID:    mockCNSIGuid,
+				CNSIName:    "cf",
+				CNSIType:    "cf",
+				AuthType:    "OAuth2",
+				TokenExpiry: mockTokenExpiry,
+			})
+			So(list[1].UserName, ShouldBeEmpty)
+			So(list[1].SystemShared, ShouldBeTrue)
+			So(list[1].Disconnected, ShouldBeTrue)
+			So(list[1].LinkedGUID, ShouldEqual, "linked-token")
+		})
+
+		Convey("should filter by user, endpoint and expiry", func() {
+			mock.ExpectQuery(`WHERE t.token_type = 'cnsi' AND t.user_guid = \$1 AND t.cnsi_guid = \$2 AND t.token_expiry < \$3 ORDER BY`).
+				WithArgs(mockUserGuid, mockCNSIGuid, mockTokenExpiry).
+				WillReturnRows(sqlmock.NewRows(rowFields))
+
+			list, err := repository.ListCNSITokens(interfaces.EndpointTokenFilter{UserGUID: mockUserGuid, CNSIGUID: mockCNSIGuid, ExpiredBefore: mockTokenExpiry})
+			So(err, ShouldBeNil)
+			So(list, ShouldBeEmpty)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should throw exception when encountering DB error", func() {
+			mock.ExpectQuery(`SELECT (.+) FROM tokens`).
+				WillReturnError(errors.New("doesn't exist"))
+			_, err := repository.ListCNSITokens(interfaces.EndpointTokenFilter{})
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
+
+func TestRevokeCNSITokens(t *testing.T) {
+
+	Convey("RevokeCNSITokens Tests", t, func() {
+
+		db, mock, repository := initialiseRepo(t)
+		rowFields := []string{"token_guid", "user_guid", "user_name", "cnsi_guid", "name", "cnsi_type", "auth_type", "token_expiry", "disconnected", "linked_token"}
+		deleteToken := `DELETE FROM tokens WHERE token_type = 'cnsi' AND token_guid = \$1 AND user_guid = \$2`
+
+		Convey("should refuse to delete all tokens", func() {
+			_, err := repository.RevokeCNSITokens(interfaces.EndpointTokenFilter{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should delete the tokens of a user", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`WHERE t.token_type = 'cnsi' AND t.user_guid = \$1 ORDER BY`).
+				WithArgs(mockUserGuid).
+				WillReturnRows(sqlmock.NewRows(rowFields).
+					AddRow(mockTokenGUID, mockUserGuid, "admin", mockCNSIGuid, "cf", "cf", "OAuth2", mockTokenExpiry, false, nil).
+					AddRow("other-token", mockUserGuid, "admin", "other-cnsi", "cf", "cf", "OAuth2", mockTokenExpiry, false, nil))
+			mock.ExpectExec(deleteToken).
+				WithArgs(mockTokenGUID, mockUserGuid).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			// Already deleted by someone else
+			mock.ExpectExec(deleteToken).
+				WithArgs("other-token", mockUserGuid).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+
+			revoked, err := repository.RevokeCNSITokens(interfaces.EndpointTokenFilter{UserGUID: mockUserGuid})
+			So(err, ShouldBeNil)
+			So(revoked, ShouldHaveLength, 1)
+			So(revoked[0].TokenGUID, ShouldEqual, mockTokenGUID)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should delete old tokens for an endpoint", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`WHERE t.token_type = 'cnsi' AND t.cnsi_guid = \$1 AND t.token_expiry < \$2 AND t.user_guid <> \$3 ORDER BY`).
+				WithArgs(mockCNSIGuid, mockTokenExpiry, SystemSharedUserGuid).
+				WillReturnRows(sqlmock.NewRows(rowFields).
+					AddRow(mockTokenGUID, mockUserGuid, "admin", mockCNSIGuid, "cf", "cf", "OAuth2", mockTokenExpiry-1, false, nil))
+			mock.ExpectExec(deleteToken).
+				WithArgs(mockTokenGUID, mockUserGuid).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			revoked, err := repository.RevokeCNSITokens(interfaces.EndpointTokenFilter{CNSIGUID: mockCNSIGuid, ExpiredBefore: mockTokenExpiry})
+			So(err, ShouldBeNil)
+			So(revoked, ShouldHaveLength, 1)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should only delete the system shared token when asked for by its user", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`WHERE t.token_type = 'cnsi' AND t.cnsi_guid = \$1 AND t.user_guid <> \$2 ORDER BY`).
+				WithArgs(mockCNSIGuid, SystemSharedUserGuid).
+				WillReturnRows(sqlmock.NewRows(rowFields))
+			mock.ExpectCommit()
+			revoked, err := repository.RevokeCNSITokens(interfaces.EndpointTokenFilter{CNSIGUID: mockCNSIGuid})
+			So(err, ShouldBeNil)
+			So(revoked, ShouldBeEmpty)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`WHERE t.token_type = 'cnsi' AND t.user_guid = \$1 AND t.cnsi_guid = \$2 ORDER BY`).
+				WithArgs(SystemSharedUserGuid, mockCNSIGuid).
+				WillReturnRows(sqlmock.NewRows(rowFields).
+					AddRow("shared-token", SystemSharedUserGuid, nil, mockCNSIGuid, "cf", "cf", "OAuth2", mockTokenExpiry, false, nil))
+			mock.ExpectExec(deleteToken).
+				WithArgs("shared-token", SystemSharedUserGuid).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			revoked, err = repository.RevokeCNSITokens(interfaces.EndpointTokenFilter{UserGUID: SystemSharedUserGuid, CNSIGUID: mockCNSIGuid})
+			So(err, ShouldBeNil)
+			So(revoked, ShouldHaveLength, 1)
+			So(revoked[0].SystemShared, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should roll back when encountering DB error", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT (.+) FROM tokens`).
+				WithArgs(mockUserGuid).
+				WillReturnRows(sqlmock.NewRows(rowFields).
+					AddRow(mockTokenGUID, mockUserGuid, "admin", mockCNSIGuid, "cf", "cf", "OAuth2", mockTokenExpiry, false, nil))
+			mock.ExpectExec(deleteToken).
+				WillReturnError(errors.New("doesn't exist"))
+			mock.ExpectRollback()
+			_, err := repository.RevokeCNSITokens(interfaces.EndpointTokenFilter{UserGUID: mockUserGuid})
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
+
+func TestReEncryptTokens(t *testing.T) {
+
+	var (
+		listTokenCipherTextSql   = `SELECT token_guid, user_guid, auth_token, refresh_token FROM tokens`
+		updateTokenCipherTextSql = `UPDATE tokens SET auth_token = (.+) WHERE token_guid = (.+) AND user_guid = (.+)`
+		rowFieldsForCipherText   = []string{"token_guid", "user_guid", "auth_token", "refresh_token"}
+		previousEncryptionKey    = []byte("0123456789abcdef0123456789abcdef")
+	)
+
+	Convey("ReEncrypt Tests", t, func() {
+
+		db, mock, repository := initialiseRepo(t)
+		currentToken, _ := crypto.EncryptToken(mockEncryptionKey, mockUAAToken)
+		previousToken, _ := crypto.EncryptToken(previousEncryptionKey, mockUAAToken)
+
+		Reset(func() {
+			crypto.SetPreviousKeys(nil)
+			db.Close()
+		})
+
+		Convey("should only update tokens encrypted with a previous key", func() {
+			crypto.SetPreviousKeys([][]byte{previousEncryptionKey})
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(listTokenCipherTextSql).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForCipherText).
+					AddRow("current-token", mockUserGuid, currentToken, nil).
+					AddRow("previous-token", mockUserGuid, previousToken, nil))
+			mock.ExpectExec(updateTokenCipherTextSql).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "previous-token", mockUserGuid).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			updated, err := repository.ReEncrypt(mockEncryptionKey)
+			So(err, ShouldBeNil)
+			So(updated, ShouldEqual, 1)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should not update anything if a token can't be decrypted", func() {
+			mock.ExpectBegin()
+			mock.ExpectQuery(listTokenCipherTextSql).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForCipherText).
+					AddRow("previous-token", mockUserGuid, previousToken, nil))
+			mock.ExpectRollback()
+
+			_, err := repository.ReEncrypt(mockEncryptionKey)
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
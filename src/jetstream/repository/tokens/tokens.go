@@ -24,4 +24,11 @@ type Repository interface {
 
 	// Update a token's auth data
 	UpdateTokenAuth(userGUID string, tokenRecord interfaces.TokenRecord, encryptionKey []byte) error
+
+	// List and revoke the endpoint tokens of all users
+	ListCNSITokens(filter interfaces.EndpointTokenFilter) ([]interfaces.EndpointTokenInfo, error)
+	RevokeCNSITokens(filter interfaces.EndpointTokenFilter) ([]interfaces.EndpointTokenInfo, error)
+
+	// Re-encrypt tokens that were encrypted with a previous key
+	ReEncrypt(encryptionKey []byte) (int, error)
 }
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Key in the endpoint's metadata under which the rewrite rules are stored
+const rewriteRulesMetadataKey = "rewrite"
+
+// EndpointRewriteRules - Rules that rewrite the requests proxied to an endpoint, and their responses
+type EndpointRewriteRules struct {
+	Host            string              `json:"host,omitempty"`
+	PathPrefix      string              `json:"path_prefix,omitempty"`
+	RequestHeaders  *HeaderRewriteRules `json:"request_headers,omitempty"`
+	ResponseHeaders *HeaderRewriteRules `json:"response_headers,omitempty"`
+	ResponseBody    []BodyReplacement   `json:"response_body,omitempty"`
+}
+
+// HeaderRewriteRules - Headers to remove, replace and add, applied in that order
+type HeaderRewriteRules struct {
+	Remove  []string          `json:"remove,omitempty"`
+	Replace map[string]string `json:"replace,omitempty"`
+	Add     map[string]string `json:"add,omitempty"`
+}
+
+// BodyReplacement - Text in the response body to replace
+type BodyReplacement struct {
+	Find    string `json:"find"`
+	Replace string `json:"replace"`
+}
+
+// parseRewriteRules gets the rewrite rules from an endpoint's metadata. Returns nil if there are none
+func parseRewriteRules(metadata string) (*EndpointRewriteRules, error) {
+	if !strings.HasPrefix(metadata, "{") {
+		return nil, nil
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(metadata), &values); err != nil {
+		return nil, err
+	}
+	raw, ok := values[rewriteRulesMetadataKey]
+	if !ok || string(raw) == "null" {
+		return nil, nil
+	}
+
+	rules := &EndpointRewriteRules{}
+	if err := json.Unmarshal(raw, rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// setRewriteRules stores the rewrite rules in an endpoint's metadata, keeping any other metadata. Nil rules are removed
+func setRewriteRules(metadata string, rules *EndpointRewriteRules) (string, error) {
+	values := make(map[string]json.RawMessage)
+	if len(metadata) > 0 {
+		if !strings.HasPrefix(metadata, "{") {
+			return "", errors.New("Endpoint metadata is not a JSON object")
+		}
+		if err := json.Unmarshal([]byte(metadata), &values); err != nil {
+			return "", err
+		}
+	}
+
+	if rules == nil {
+		delete(values, rewriteRulesMetadataKey)
+	} else {
+		raw, err := json.Marshal(rules)
+		if err != nil {
+			return "", err
+		}
+		values[rewriteRulesMetadataKey] = raw
+	}
+
+	if len(values) == 0 {
+		return "", nil
+	}
+	updated, err := json.Marshal(values)
+	return string(updated), err
+}
+
+// hideRewriteRules removes the rewrite rules from an endpoint's metadata, keeping any other metadata
+func hideRewriteRules(metadata string) string {
+	if !strings.HasPrefix(metadata, "{") {
+		return metadata
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(metadata), &values); err != nil {
+		return metadata
+	}
+	if _, ok := values[rewriteRulesMetadataKey]; !ok {
+		return metadata
+	}
+	delete(values, rewriteRulesMetadataKey)
+
+	if len(values) == 0 {
+		return ""
+	}
+	hidden, err := json.Marshal(values)
+	if err != nil {
+		return ""
+	}
+	return string(hidden)
+}
+
+// rewriteRulesFilter returns a function that hides the rewrite rules in endpoint metadata from the request's user,
+// unless they can manage endpoints - headers added by the rules can hold secrets such as credentials. The user's roles
+// are only looked up if an endpoint has rewrite rules
+func (p *portalProxy) rewriteRulesFilter(c echo.Context) func(metadata string) string {
+	var checked, canSeeRules bool
+	return func(metadata string) string {
+		hidden := hideRewriteRules(metadata)
+		if hidden == metadata {
+			return metadata
+		}
+		if !checked {
+			userRoles, err := p.getRequestUserRoles(c)
+			canSeeRules = err == nil && interfaces.RolesHavePermission(userRoles, interfaces.PermissionEndpointsManage)
+			checked = true
+		}
+		if canSeeRules {
+			return metadata
+		}
+		return hidden
+	}
+}
+
+func (r *EndpointRewriteRules) validate() error {
+	if strings.ContainsAny(r.Host, "/?#@ ") {
+		return fmt.Errorf("Invalid host: %s", r.Host)
+	}
+	if len(r.PathPrefix) > 0 && !strings.HasPrefix(r.PathPrefix, "/") {
+		return errors.New("Path prefix must start with /")
+	}
+	for _, headers := range []*HeaderRewriteRules{r.RequestHeaders, r.ResponseHeaders} {
+		if err := headers.validate(); err != nil {
+			return err
+		}
+	}
+	// The auth handler sets the Authorization header after the request has been rewritten, so a rule could never change it
+	if r.RequestHeaders.touches("Authorization") {
+		return errors.New("Request rewrite rules can not change the Authorization header - it is set from the endpoint token")
+	}
+	for _, replacement := range r.ResponseBody {
+		if len(replacement.Find) == 0 {
+			return errors.New("Response body replacements must have text to find")
+		}
+	}
+	return nil
+}
+
+func (h *HeaderRewriteRules) validate() error {
+	if h == nil {
+		return nil
+	}
+	names := append([]string{}, h.Remove...)
+	for name := range h.Replace {
+		names = append(names, name)
+	}
+	for name := range h.Add {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if len(name) == 0 || strings.ContainsAny(name, " :\r\n") {
+			return fmt.Errorf("Invalid header name: '%s'", name)
+		}
+	}
+	return nil
+}
+
+// touches reports whether any of the rules removes, replaces or adds the given header
+func (h *HeaderRewriteRules) touches(header string) bool {
+	if h == nil {
+		return false
+	}
+	header = http.CanonicalHeaderKey(header)
+	for _, name := range h.Remove {
+		if http.CanonicalHeaderKey(name) == header {
+			return true
+		}
+	}
+	for name := range h.Replace {
+		if http.CanonicalHeaderKey(name) == header {
+			return true
+		}
+	}
+	for name := range h.Add {
+		if http.CanonicalHeaderKey(name) == header {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteURL overrides the host and adds the path prefix
+func (r *EndpointRewriteRules) rewriteURL(u *url.URL) {
+	if len(r.Host) > 0 {
+		u.Host = r.Host
+	}
+	if len(r.PathPrefix) > 0 {
+		prefix := strings.TrimRight(r.PathPrefix, "/")
+		u.Path = prefix + u.Path
+		if len(u.RawPath) > 0 {
+			u.RawPath = prefix + u.RawPath
+		}
+	}
+}
+
+func (h *HeaderRewriteRules) apply(header http.Header) {
+	if h == nil {
+		return
+	}
+	for _, name := range h.Remove {
+		header.Del(name)
+	}
+	for name, value := range h.Replace {
+		header.Set(name, value)
+	}
+	for name, value := range h.Add {
+		header.Add(name, value)
+	}
+}
+
+// rewriteRequest applies the rules to a request that is about to be sent to the endpoint
+func (r *EndpointRewriteRules) rewriteRequest(req *http.Request) {
+	r.rewriteURL(req.URL)
+	req.Host = req.URL.Host
+	r.RequestHeaders.apply(req.Header)
+}
+
+// rewriteResponse applies the rules to the endpoint's response. Body replacements mean that the body has to be read in full
+func (r *EndpointRewriteRules) rewriteResponse(res *http.Response) error {
+	r.ResponseHeaders.apply(res.Header)
+	if len(r.ResponseBody) == 0 || res.Body == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+	for _, replacement := range r.ResponseBody {
+		body = bytes.Replace(body, []byte(replacement.Find), []byte(replacement.Replace), -1)
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	if len(res.Header.Get("Content-Length")) > 0 {
+		res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return nil
+}
+
+func (p *portalProxy) getEndpointRewriteRules(c echo.Context) error {
+	log.Debug("getEndpointRewriteRules")
+	cnsiRecord, err := p.GetCNSIRecord(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Endpoint not found")
+	}
+
+	rules, err := parseRewriteRules(cnsiRecord.Metadata)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to read endpoint rewrite rules",
+			"Unable to read endpoint rewrite rules: %v", err,
+		)
+	}
+	if rules == nil {
+		rules = &EndpointRewriteRules{}
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+func (p *portalProxy) updateEndpointRewriteRules(c echo.Context) error {
+	log.Debug("updateEndpointRewriteRules")
+	rules := &EndpointRewriteRules{}
+	if err := json.NewDecoder(c.Request().Body).Decode(rules); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid rewrite rules",
+			"Invalid rewrite rules: %v", err,
+		)
+	}
+	if err := rules.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := p.saveEndpointRewriteRules(c.Param("id"), rules); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+func (p *portalProxy) deleteEndpointRewriteRules(c echo.Context) error {
+	log.Debug("deleteEndpointRewriteRules")
+	if err := p.saveEndpointRewriteRules(c.Param("id"), nil); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (p *portalProxy) saveEndpointRewriteRules(cnsiGUID string, rules *EndpointRewriteRules) error {
+	cnsiRecord, err := p.GetCNSIRecord(cnsiGUID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Endpoint not found")
+	}
+
+	metadata, err := setRewriteRules(cnsiRecord.Metadata, rules)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Unable to store rewrite rules in the endpoint's metadata",
+			"Unable to store rewrite rules in the endpoint's metadata: %v", err,
+		)
+	}
+
+	if err := p.UpdateEndointMetadata(cnsiGUID, metadata); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to update endpoint",
+			"Unable to update endpoint: %v", err,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestRewriteRulesMetadata(t *testing.T) {
+	t.Parallel()
+
+	Convey("Rewrite rules metadata tests", t, func() {
+
+		Convey("should have no rules without metadata", func() {
+			rules, err := parseRewriteRules("")
+			So(err, ShouldBeNil)
+			So(rules, ShouldBeNil)
+
+			rules, err = parseRewriteRules(`{"other":"value"}`)
+			So(err, ShouldBeNil)
+			So(rules, ShouldBeNil)
+		})
+
+		Convey("should keep other metadata when setting and removing rules", func() {
+			metadata, err := setRewriteRules(`{"other":"value"}`, &EndpointRewriteRules{PathPrefix: "/gateway"})
+			So(err, ShouldBeNil)
+			So(metadata, ShouldContainSubstring, `"other":"value"`)
+
+			rules, err := parseRewriteRules(metadata)
+			So(err, ShouldBeNil)
+			So(rules.PathPrefix, ShouldEqual, "/gateway")
+
+			metadata, err = setRewriteRules(metadata, nil)
+			So(err, ShouldBeNil)
+			So(metadata, ShouldEqual, `{"other":"value"}`)
+		})
+
+		Convey("should not overwrite metadata that is not JSON", func() {
+			_, err := setRewriteRules("some metadata", &EndpointRewriteRules{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should hide the rules and keep other metadata", func() {
+			So(hideRewriteRules(`{"other":"value","rewrite":{"host":"gateway"}}`), ShouldEqual, `{"other":"value"}`)
+			So(hideRewriteRules(`{"rewrite":{"host":"gateway"}}`), ShouldBeEmpty)
+			So(hideRewriteRules(`{"other":"value"}`), ShouldEqual, `{"other":"value"}`)
+			So(hideRewriteRules("some metadata"), ShouldEqual, "some metadata")
+		})
+
+		Convey("should validate the rules", func() {
+			So((&EndpointRewriteRules{Host: "gateway.example.com:8443", PathPrefix: "/gateway"}).validate(), ShouldBeNil)
+			So((&EndpointRewriteRules{Host: "gateway.example.com/path"}).validate(), ShouldNotBeNil)
+			So((&EndpointRewriteRules{PathPrefix: "gateway"}).validate(), ShouldNotBeNil)
+			So((&EndpointRewriteRules{RequestHeaders: &HeaderRewriteRules{Remove: []string{""}}}).validate(), ShouldNotBeNil)
+			So((&EndpointRewriteRules{ResponseBody: []BodyReplacement{{Replace: "a"}}}).validate(), ShouldNotBeNil)
+			So((&EndpointRewriteRules{RequestHeaders: &HeaderRewriteRules{Remove: []string{"authorization"}}}).validate(), ShouldNotBeNil)
+			So((&EndpointRewriteRules{RequestHeaders: &HeaderRewriteRules{Replace: map[string]string{"Authorization": "Basic abc"}}}).validate(), ShouldNotBeNil)
+			So((&EndpointRewriteRules{RequestHeaders: &HeaderRewriteRules{Add: map[string]string{"AUTHORIZATION": "Basic abc"}}}).validate(), ShouldNotBeNil)
+			So((&EndpointRewriteRules{ResponseHeaders: &HeaderRewriteRules{Remove: []string{"Authorization"}}}).validate(), ShouldBeNil)
+		})
+	})
+}
+
+func TestRewriteRules(t *testing.T) {
+	t.Parallel()
+
+	Convey("Rewrite rules tests", t, func() {
+		rules := &EndpointRewriteRules{
+			Host:       "gateway.example.com",
+			PathPrefix: "/cf/",
+			RequestHeaders: &HeaderRewriteRules{
+				Remove:  []string{"X-Remove"},
+				Replace: map[string]string{"X-Tenant": "tenant-1"},
+				Add:     map[string]string{"X-Extra": "extra"},
+			},
+			ResponseHeaders: &HeaderRewriteRules{
+				Remove: []string{"Server"},
+			},
+			ResponseBody: []BodyReplacement{{Find: "internal.example.com", Replace: "gateway.example.com"}},
+		}
+
+		Convey("should rewrite the request", func() {
+			req, _ := http.NewRequest("GET", "https://api.example.com/v2/apps?page=2", nil)
+			req.Header.Set("X-Remove", "a")
+			req.Header.Set("X-Tenant", "b")
+			req.Header.Set("X-Extra", "c")
+			rules.rewriteRequest(req)
+
+			So(req.URL.String(), ShouldEqual, "https://gateway.example.com/cf/v2/apps?page=2")
+			So(req.Host, ShouldEqual, "gateway.example.com")
+			So(req.Header.Get("X-Remove"), ShouldBeEmpty)
+			So(req.Header.Get("X-Tenant"), ShouldEqual, "tenant-1")
+			So(req.Header["X-Extra"], ShouldResemble, []string{"c", "extra"})
+		})
+
+		Convey("should rewrite the response", func() {
+			res := &http.Response{
+				Header: http.Header{"Server": []string{"gorouter"}, "Content-Length": []string{"38"}},
+				Body:   ioutil.NopCloser(strings.NewReader(`{"url":"https://internal.example.com"}`)),
+			}
+			So(rules.rewriteResponse(res), ShouldBeNil)
+
+			body, _ := ioutil.ReadAll(res.Body)
+			So(string(body), ShouldEqual, `{"url":"https://gateway.example.com"}`)
+			So(res.ContentLength, ShouldEqual, len(body))
+			So(res.Header.Get("Content-Length"), ShouldEqual, "37")
+			So(res.Header.Get("Server"), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestProxyWithRewriteRules(t *testing.T) {
+	t.Parallel()
+
+	Convey("Proxy with rewrite rules tests", t, func() {
+		var gotPath, gotTenant string
+		mockCFServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			gotTenant = r.Header.Get("X-Tenant")
+			w.Header().Set("X-Internal", "true")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"name":"internal"}`))
+		}))
+		defer mockCFServer.Close()
+
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		metadata := `{"rewrite":{"path_prefix":"/gateway","request_headers":{"replace":{"X-Tenant":"tenant-1"}},` +
+			`"response_headers":{"remove":["X-Internal"]},"response_body":[{"find":"internal","replace":"external"}]}}`
+		mockCFRow := func() sqlmock.Rows {
+			return sqlmock.NewRows(rowFieldsForCNSI).
+				AddRow(mockCFGUID, "Some fancy CF Cluster", "cf", mockCFServer.URL, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", metadata, "", "", nil, "")
+		}
+
+		// p.getCNSIRequestRecords from both p.sendRequest and p.doOauthFlowRequest
+		for i := 0; i < 2; i++ {
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCFGUID, mockUserGUID, mockAdminGUID).
+				WillReturnRows(expectEncryptedTokenRow(pp.Config.EncryptionKeyInBytes))
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WithArgs(mockCFGUID).
+				WillReturnRows(mockCFRow())
+		}
+
+		cnsiRequest := &interfaces.CNSIRequest{
+			GUID:     mockCFGUID,
+			UserGUID: mockUserGUID,
+			Method:   "GET",
+			URL:      urlMust(mockCFServer.URL + "/v2/info"),
+			Header:   http.Header{},
+		}
+		pp.doRequestWithContext(context.Background(), cnsiRequest, nil)
+
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+		So(cnsiRequest.Error, ShouldBeNil)
+		So(gotPath, ShouldEqual, "/gateway/v2/info")
+		So(gotTenant, ShouldEqual, "tenant-1")
+		So(string(cnsiRequest.Response), ShouldEqual, `{"name":"external"}`)
+		So(cnsiRequest.ResponseHeader.Get("X-Internal"), ShouldBeEmpty)
+	})
+}
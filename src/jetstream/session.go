@@ -103,6 +103,10 @@ func (p *portalProxy) writeSessionHook(c echo.Context) func() {
 		// Has the session been modified and need saving?
 		sessionModifed := c.Get(jetStreamSessionContextUpdatedKey)
 		sessionIntf := c.Get(jetStreamSessionContextKey)
+		// Requests authenticated with an API token don't have a session cookie to update
+		if getRequestAPIToken(c) != nil {
+			return
+		}
 		if sessionModifed != nil && sessionIntf != nil {
 			if session, ok := sessionIntf.(*sessions.Session); ok {
 				p.SessionStore.Save(c.Request(), c.Response().Writer, session)
@@ -30,6 +30,10 @@ const (
 
 func parseConsoleConfigFromForm(c echo.Context) (*interfaces.ConsoleConfig, error) {
 	consoleConfig := new(interfaces.ConsoleConfig)
+	if interfaces.AuthEndpointTypes[c.FormValue("auth_endpoint_type")] == interfaces.LDAP {
+		return parseLDAPConsoleConfigFromForm(c, consoleConfig)
+	}
+
 	url, err := url.Parse(c.FormValue("uaa_endpoint"))
 	if err != nil {
 		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid UAA Endpoint value")
@@ -57,6 +61,43 @@ func parseConsoleConfigFromForm(c echo.Context) (*interfaces.ConsoleConfig, erro
 	return consoleConfig, nil
 }
 
+func parseLDAPConsoleConfigFromForm(c echo.Context, consoleConfig *interfaces.ConsoleConfig) (*interfaces.ConsoleConfig, error) {
+	consoleConfig.AuthEndpointType = string(interfaces.LDAP)
+	consoleConfig.LDAPURL = c.FormValue("ldap_url")
+	consoleConfig.LDAPBindDN = c.FormValue("ldap_bind_dn")
+	consoleConfig.LDAPBindPassword = c.FormValue("ldap_bind_password")
+	consoleConfig.LDAPUserBaseDN = c.FormValue("ldap_user_base_dn")
+	consoleConfig.LDAPUserFilter = c.FormValue("ldap_user_filter")
+	consoleConfig.LDAPUserDNTemplate = c.FormValue("ldap_user_dn_template")
+	consoleConfig.LDAPUsernameAttribute = c.FormValue("ldap_username_attribute")
+	consoleConfig.LDAPEmailAttribute = c.FormValue("ldap_email_attribute")
+	consoleConfig.LDAPGroupAttribute = c.FormValue("ldap_group_attribute")
+	consoleConfig.LDAPGroupBaseDN = c.FormValue("ldap_group_base_dn")
+	consoleConfig.LDAPGroupFilter = c.FormValue("ldap_group_filter")
+	consoleConfig.LDAPAdminGroup = c.FormValue("ldap_admin_group")
+	consoleConfig.ConsoleAdminScope = c.FormValue("console_admin_scope")
+
+	if startTLS := c.FormValue("ldap_start_tls"); len(startTLS) > 0 {
+		useStartTLS, err := strconv.ParseBool(startTLS)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid StartTLS value")
+		}
+		consoleConfig.LDAPStartTLS = useStartTLS
+	}
+
+	skipSSLValidation, err := strconv.ParseBool(c.FormValue("skip_ssl_validation"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid Skip SSL Validation value")
+	}
+	consoleConfig.SkipSSLValidation = skipSSLValidation
+
+	if err := initialiseLDAPConfiguration(consoleConfig); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return consoleConfig, nil
+}
+
 // Check the initial parameter set and fetch the list of available scopes
 // This does not persist the configuration to the database at this stage
 func (p *portalProxy) setupConsoleCheck(c echo.Context) error {
@@ -74,9 +115,24 @@ func (p *portalProxy) setupConsoleCheck(c echo.Context) error {
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 
+	// Authenticate with the directory
+	if interfaces.AuthEndpointTypes[consoleConfig.AuthEndpointType] == interfaces.LDAP {
+		user, err := authenticateLDAP(consoleConfig, username, password)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, &interfaces.ConnectedUser{
+			GUID:   user.DN,
+			Name:   user.Username,
+			Email:  user.Email,
+			Admin:  user.Admin,
+			Scopes: user.Groups,
+		})
+	}
+
 	// Authenticate with UAA
 	authEndpoint := fmt.Sprintf("%s/oauth/token", consoleConfig.UAAEndpoint)
-	uaaRes, err := p.getUAATokenWithCreds(consoleConfig.SkipSSLValidation, username, password, consoleConfig.ConsoleClient, consoleConfig.ConsoleClientSecret, authEndpoint)
+	uaaRes, err := p.getUAATokenWithCreds(consoleConfig.SkipSSLValidation, interfaces.EndpointConnectionSettings{}, username, password, consoleConfig.ConsoleClient, consoleConfig.ConsoleClientSecret, authEndpoint)
 	if err != nil {
 		errInfo, ok := err.(interfaces.ErrHTTPRequest)
 		if ok {
@@ -114,6 +170,10 @@ func (p *portalProxy) setupConsoleCheck(c echo.Context) error {
 func saveConsoleConfig(consoleRepo console_config.Repository, consoleConfig *interfaces.ConsoleConfig) error {
 	log.Debugf("Saving ConsoleConfig: %+v", consoleConfig)
 
+	if interfaces.AuthEndpointTypes[consoleConfig.AuthEndpointType] == interfaces.LDAP {
+		return saveLDAPConsoleConfig(consoleRepo, consoleConfig)
+	}
+
 	if err := consoleRepo.SetValue(systemGroupName, "UAA_ENDPOINT", consoleConfig.UAAEndpoint.String()); err != nil {
 		return err
 	}
@@ -145,6 +205,35 @@ func saveConsoleConfig(consoleRepo console_config.Repository, consoleConfig *int
 	return nil
 }
 
+func saveLDAPConsoleConfig(consoleRepo console_config.Repository, consoleConfig *interfaces.ConsoleConfig) error {
+	values := map[string]string{
+		"AUTH_ENDPOINT_TYPE":      consoleConfig.AuthEndpointType,
+		"LDAP_URL":                consoleConfig.LDAPURL,
+		"LDAP_START_TLS":          strconv.FormatBool(consoleConfig.LDAPStartTLS),
+		"LDAP_BIND_DN":            consoleConfig.LDAPBindDN,
+		"LDAP_BIND_PASSWORD":      consoleConfig.LDAPBindPassword,
+		"LDAP_USER_BASE_DN":       consoleConfig.LDAPUserBaseDN,
+		"LDAP_USER_FILTER":        consoleConfig.LDAPUserFilter,
+		"LDAP_USER_DN_TEMPLATE":   consoleConfig.LDAPUserDNTemplate,
+		"LDAP_USERNAME_ATTRIBUTE": consoleConfig.LDAPUsernameAttribute,
+		"LDAP_EMAIL_ATTRIBUTE":    consoleConfig.LDAPEmailAttribute,
+		"LDAP_GROUP_ATTRIBUTE":    consoleConfig.LDAPGroupAttribute,
+		"LDAP_GROUP_BASE_DN":      consoleConfig.LDAPGroupBaseDN,
+		"LDAP_GROUP_FILTER":       consoleConfig.LDAPGroupFilter,
+		"LDAP_ADMIN_GROUP":        consoleConfig.LDAPAdminGroup,
+		"SKIP_SSL_VALIDATION":     strconv.FormatBool(consoleConfig.SkipSSLValidation),
+		"CONSOLE_ADMIN_SCOPE":     consoleConfig.ConsoleAdminScope,
+	}
+
+	for name, value := range values {
+		if err := consoleRepo.SetValue(systemGroupName, name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Save the console setup
 func (p *portalProxy) setupConsole(c echo.Context) error {
 
@@ -201,9 +290,19 @@ func (p *portalProxy) initialiseConsoleConfig(envLookup *env.VarSet) (*interface
 		} else if val == interfaces.Remote {
 			// Auth endpoint type is set to "remote", so need to load local user config vars
 			// Nothing to do
+		} else if val == interfaces.OIDC {
+			// Auth endpoint type is set to "oidc", so check the OpenID Connect provider config
+			if err := initialiseOIDCConfiguration(consoleConfig); err != nil {
+				return consoleConfig, err
+			}
+		} else if val == interfaces.LDAP {
+			// Auth endpoint type is set to "ldap", so check the directory config
+			if err := initialiseLDAPConfiguration(consoleConfig); err != nil {
+				return consoleConfig, err
+			}
 		} else {
 			//Auth endpoint type has been set to an invalid value
-			return consoleConfig, errors.New("AUTH_ENDPOINT_TYPE must be set to either \"local\" or \"remote\"")
+			return consoleConfig, errors.New("AUTH_ENDPOINT_TYPE must be set to either \"local\", \"remote\", \"oidc\" or \"ldap\"")
 		}
 	} else {
 		return consoleConfig, errors.New("AUTH_ENDPOINT_TYPE not found")
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Defaults for when background token refresh has not been configured
+const (
+	defaultTokenRefreshIntervalInSecs   = 60
+	defaultTokenRefreshWindowInSecs     = 300
+	defaultTokenRefreshActiveUserInSecs = 1800
+)
+
+// tokenHealth is the outcome of the most recent refresh of a user's token for an endpoint
+type tokenHealth struct {
+	lastRefresh  time.Time
+	failure      string
+	disconnected bool
+}
+
+// tokenRefresher tracks which users have been active recently, so that their endpoint tokens can be refreshed before
+// they expire, and the health of each user's endpoint tokens
+type tokenRefresher struct {
+	sync.Mutex
+	activeUsers  map[string]time.Time
+	health       map[string]*tokenHealth
+	interval     time.Duration
+	window       time.Duration
+	activeWithin time.Duration
+}
+
+func newTokenRefresher(intervalInSecs, windowInSecs, activeUserInSecs int64) *tokenRefresher {
+	return &tokenRefresher{
+		activeUsers:  make(map[string]time.Time),
+		health:       make(map[string]*tokenHealth),
+		interval:     time.Duration(intervalInSecs) * time.Second,
+		window:       time.Duration(windowInSecs) * time.Second,
+		activeWithin: time.Duration(activeUserInSecs) * time.Second,
+	}
+}
+
+// enabled returns whether tokens should be refreshed in the background
+func (tr *tokenRefresher) enabled() bool {
+	return tr != nil && tr.interval > 0
+}
+
+func tokenHealthKey(endpointGUID, userGUID string) string {
+	return endpointGUID + "/" + userGUID
+}
+
+// touch records that the user is active
+func (tr *tokenRefresher) touch(userGUID string) {
+	if !tr.enabled() {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	tr.activeUsers[userGUID] = time.Now()
+}
+
+// recentUsers returns the users that have been active recently, forgetting about those that have not and the health of
+// their tokens
+func (tr *tokenRefresher) recentUsers() []string {
+	tr.Lock()
+	defer tr.Unlock()
+
+	users := make([]string, 0, len(tr.activeUsers))
+	for userGUID, lastSeen := range tr.activeUsers {
+		if time.Since(lastSeen) > tr.activeWithin {
+			delete(tr.activeUsers, userGUID)
+			// Their tokens are no longer refreshed, so there is nothing to report for them
+			for key := range tr.health {
+				if strings.HasSuffix(key, "/"+userGUID) {
+					delete(tr.health, key)
+				}
+			}
+			continue
+		}
+		users = append(users, userGUID)
+	}
+	return users
+}
+
+func (tr *tokenRefresher) get(endpointGUID, userGUID string) *tokenHealth {
+	key := tokenHealthKey(endpointGUID, userGUID)
+	health, ok := tr.health[key]
+	if !ok {
+		health = &tokenHealth{}
+		tr.health[key] = health
+	}
+	return health
+}
+
+// recordRefresh updates the health of the user's token for an endpoint with the outcome of a refresh
+func (tr *tokenRefresher) recordRefresh(endpointGUID, userGUID string, err error) {
+	if tr == nil {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+
+	health := tr.get(endpointGUID, userGUID)
+	health.lastRefresh = time.Now()
+	health.failure = ""
+	if err != nil {
+		health.failure = err.Error()
+	}
+}
+
+// markDisconnected records that the user's token for an endpoint has been disconnected after it could not be refreshed
+func (tr *tokenRefresher) markDisconnected(endpointGUID, userGUID string) {
+	if tr == nil {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	tr.get(endpointGUID, userGUID).disconnected = true
+}
+
+// forget clears the health of the user's token for an endpoint, e.g. when the user connects again
+func (tr *tokenRefresher) forget(endpointGUID, userGUID string) {
+	if tr == nil {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	delete(tr.health, tokenHealthKey(endpointGUID, userGUID))
+}
+
+// forgetEndpoint clears the health of all users' tokens for an endpoint, e.g. when it is unregistered
+func (tr *tokenRefresher) forgetEndpoint(endpointGUID string) {
+	if tr == nil {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+	for key := range tr.health {
+		if strings.HasPrefix(key, endpointGUID+"/") {
+			delete(tr.health, key)
+		}
+	}
+}
+
+// status returns the health of the user's token for an endpoint, or nil if there is no token and nothing has been
+// recorded for it. The token is nil if the user is not connected
+func (tr *tokenRefresher) status(endpointGUID, userGUID string, token *interfaces.TokenRecord) *interfaces.TokenHealthStatus {
+	if tr == nil {
+		return nil
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+
+	health, ok := tr.health[tokenHealthKey(endpointGUID, userGUID)]
+	if !ok && token == nil {
+		return nil
+	}
+
+	status := &interfaces.TokenHealthStatus{}
+	if token != nil {
+		status.TokenExpiry = token.TokenExpiry
+	}
+	if ok {
+		status.LastRefresh = health.lastRefresh.Unix()
+		status.FailureReason = health.failure
+		status.Disconnected = health.disconnected && token == nil
+	}
+	return status
+}
+
+// runTokenRefresh periodically refreshes the endpoint tokens of recently active users that are about to expire
+func (p *portalProxy) runTokenRefresh() {
+	log.Infof("Refreshing endpoint tokens every %v", p.TokenRefresher.interval)
+	ticker := time.NewTicker(p.TokenRefresher.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.refreshExpiringTokens()
+	}
+}
+
+// refreshExpiringTokens refreshes the endpoint tokens of recently active users that expire within the refresh window
+func (p *portalProxy) refreshExpiringTokens() {
+	log.Debug("refreshExpiringTokens")
+	expiresBefore := time.Now().Add(p.TokenRefresher.window).Unix()
+
+	for _, userGUID := range p.TokenRefresher.recentUsers() {
+		endpoints, err := p.ListEndpointsByUser(userGUID)
+		if err != nil {
+			log.Warnf("Unable to list endpoint tokens for user %s: %v", userGUID, err)
+			continue
+		}
+
+		for _, endpoint := range endpoints {
+			if endpoint.TokenExpiry > 0 && endpoint.TokenExpiry <= expiresBefore {
+				p.refreshEndpointToken(endpoint.GUID, userGUID)
+			}
+		}
+	}
+}
+
+// refreshEndpointToken refreshes the user's token for an endpoint, marking it as disconnected if the endpoint rejects
+// the refresh token
+func (p *portalProxy) refreshEndpointToken(cnsiGUID, userGUID string) {
+	log.Debug("refreshEndpointToken")
+
+	// Disconnected tokens are not returned
+	tokenRecord, ok := p.GetCNSITokenRecord(cnsiGUID, userGUID)
+	if !ok || tokenRecord.SystemShared || len(tokenRecord.RefreshToken) == 0 {
+		return
+	}
+
+	refreshFunc := p.getTokenRefreshFunc(tokenRecord.AuthType)
+	if refreshFunc == nil {
+		return
+	}
+
+	cnsiRecord, err := p.GetCNSIRecord(cnsiGUID)
+	if err != nil {
+		log.Warnf("Unable to refresh token - could not find endpoint %s: %v", cnsiGUID, err)
+		return
+	}
+
+	_, err = refreshFunc(cnsiRecord.SkipSSLValidation, cnsiGUID, userGUID, cnsiRecord.ClientId, cnsiRecord.ClientSecret, cnsiRecord.TokenEndpoint)
+	p.TokenRefresher.recordRefresh(cnsiGUID, userGUID, err)
+	if err == nil {
+		return
+	}
+
+	// Only give up on the token once the endpoint has rejected it - other failures are retried on the next tick
+	if _, rejected := err.(interfaces.ErrRefreshTokenRejected); !rejected {
+		log.Warnf("Unable to refresh token for endpoint %s and user %s - will retry: %v", cnsiGUID, userGUID, err)
+		return
+	}
+
+	log.Warnf("Unable to refresh token for endpoint %s and user %s - disconnecting: %v", cnsiGUID, userGUID, err)
+	tokenRecord.Disconnected = true
+	if err := p.setCNSITokenRecord(cnsiGUID, userGUID, tokenRecord); err != nil {
+		return
+	}
+	p.TokenRefresher.markDisconnected(cnsiGUID, userGUID)
+}
+
+// getTokenRefreshFunc returns the function used to refresh tokens of the given auth type, or nil if they can not be
+// refreshed
+func (p *portalProxy) getTokenRefreshFunc(authType string) interfaces.RefreshOAuthTokenFunc {
+	if authProvider := p.GetAuthProvider(authType); authProvider.Refresh != nil {
+		return authProvider.Refresh
+	}
+	if authType == interfaces.AuthTypeOAuth2 {
+		return p.RefreshOAuthToken
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestTokenRefresher(t *testing.T) {
+	t.Parallel()
+
+	Convey("Token refresher tests", t, func() {
+		tr := newTokenRefresher(60, 300, 1800)
+
+		Convey("should track recently active users", func() {
+			tr.touch(mockUserGUID)
+			So(tr.recentUsers(), ShouldResemble, []string{mockUserGUID})
+
+			Convey("should forget users that have not been active recently", func() {
+				tr.recordRefresh(mockCFGUID, mockUserGUID, nil)
+				tr.recordRefresh(mockCFGUID, mockAdminGUID, nil)
+				tr.activeWithin = 0
+				So(tr.recentUsers(), ShouldBeEmpty)
+				So(tr.activeUsers, ShouldBeEmpty)
+				So(tr.status(mockCFGUID, mockUserGUID, nil), ShouldBeNil)
+				So(tr.status(mockCFGUID, mockAdminGUID, nil), ShouldNotBeNil)
+			})
+		})
+
+		Convey("should not track users if disabled", func() {
+			tr.interval = -1
+			tr.touch(mockUserGUID)
+			So(tr.activeUsers, ShouldBeEmpty)
+		})
+
+		Convey("should have no status for an endpoint that is not connected", func() {
+			So(tr.status(mockCFGUID, mockUserGUID, nil), ShouldBeNil)
+		})
+
+		Convey("should report the expiry of a connected token", func() {
+			status := tr.status(mockCFGUID, mockUserGUID, &interfaces.TokenRecord{TokenExpiry: mockTokenExpiry})
+			So(status.TokenExpiry, ShouldEqual, mockTokenExpiry)
+			So(status.LastRefresh, ShouldEqual, 0)
+			So(status.Disconnected, ShouldBeFalse)
+		})
+
+		Convey("should report the outcome of the last refresh", func() {
+			tr.recordRefresh(mockCFGUID, mockUserGUID, errors.New("refresh token expired"))
+			status := tr.status(mockCFGUID, mockUserGUID, &interfaces.TokenRecord{TokenExpiry: mockTokenExpiry})
+			So(status.LastRefresh, ShouldBeGreaterThan, 0)
+			So(status.FailureReason, ShouldEqual, "refresh token expired")
+
+			tr.recordRefresh(mockCFGUID, mockUserGUID, nil)
+			status = tr.status(mockCFGUID, mockUserGUID, &interfaces.TokenRecord{TokenExpiry: mockTokenExpiry})
+			So(status.FailureReason, ShouldBeEmpty)
+
+			Convey("should not affect other endpoints or users", func() {
+				So(tr.status(mockCEGUID, mockUserGUID, nil), ShouldBeNil)
+				So(tr.status(mockCFGUID, mockAdminGUID, nil), ShouldBeNil)
+			})
+		})
+
+		Convey("should report tokens disconnected after a failed refresh", func() {
+			tr.recordRefresh(mockCFGUID, mockUserGUID, errors.New("refresh token expired"))
+			tr.markDisconnected(mockCFGUID, mockUserGUID)
+			status := tr.status(mockCFGUID, mockUserGUID, nil)
+			So(status.Disconnected, ShouldBeTrue)
+			So(status.FailureReason, ShouldEqual, "refresh token expired")
+
+			Convey("should clear the status when the user connects again", func() {
+				tr.forget(mockCFGUID, mockUserGUID)
+				So(tr.status(mockCFGUID, mockUserGUID, nil), ShouldBeNil)
+			})
+
+			Convey("should clear the status when the endpoint is unregistered", func() {
+				tr.recordRefresh(mockCEGUID, mockUserGUID, nil)
+				tr.forgetEndpoint(mockCFGUID)
+				So(tr.status(mockCFGUID, mockUserGUID, nil), ShouldBeNil)
+				So(tr.status(mockCEGUID, mockUserGUID, nil), ShouldNotBeNil)
+			})
+		})
+
+		Convey("should be safe to use when not configured", func() {
+			var none *tokenRefresher
+			none.touch(mockUserGUID)
+			none.recordRefresh(mockCFGUID, mockUserGUID, nil)
+			So(none.enabled(), ShouldBeFalse)
+			So(none.status(mockCFGUID, mockUserGUID, nil), ShouldBeNil)
+		})
+	})
+}
+
+func TestRefreshEndpointToken(t *testing.T) {
+	t.Parallel()
+
+	Convey("Background refresh of an endpoint token", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		var refreshErr error
+		refreshed := 0
+		pp.AddAuthProvider("test", interfaces.AuthProvider{
+			Refresh: func(skipSSLValidation bool, cnsiGUID, userGUID, client, clientSecret, tokenEndpoint string) (interfaces.TokenRecord, error) {
+				refreshed++
+				return interfaces.TokenRecord{}, refreshErr
+			},
+		})
+
+		tokenExpiry := time.Now().Add(time.Minute).Unix()
+		encryptedToken, _ := crypto.EncryptToken(pp.Config.EncryptionKeyInBytes, mockUAAToken)
+		mock.ExpectQuery(selectAnyFromTokens).
+			WithArgs(mockCNSIGUID, mockUserGUID, mockAdminGUID).
+			WillReturnRows(sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "disconnected", "auth_type", "meta_data", "user_guid", "linked_token"}).
+				AddRow(mockTokenGUID, encryptedToken, encryptedToken, tokenExpiry, false, "test", "", mockUserGUID, nil))
+		mock.ExpectQuery(selectAnyFromCNSIs).
+			WithArgs(mockCNSIGUID).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForCNSI).
+				AddRow(mockCNSIGUID, "mockCF", "cf", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, "", "", "", "", nil, ""))
+
+		Convey("should record a successful refresh", func() {
+			pp.refreshEndpointToken(mockCNSIGUID, mockUserGUID)
+
+			So(refreshed, ShouldEqual, 1)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			status := pp.TokenRefresher.status(mockCNSIGUID, mockUserGUID, nil)
+			So(status.LastRefresh, ShouldBeGreaterThan, 0)
+			So(status.FailureReason, ShouldBeEmpty)
+			So(status.Disconnected, ShouldBeFalse)
+		})
+
+		Convey("should retry later if the refresh fails", func() {
+			refreshErr = interfaces.NewTokenRefreshError(interfaces.ErrHTTPRequest{Status: http.StatusServiceUnavailable, Response: "unavailable"})
+
+			pp.refreshEndpointToken(mockCNSIGUID, mockUserGUID)
+
+			So(refreshed, ShouldEqual, 1)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			status := pp.TokenRefresher.status(mockCNSIGUID, mockUserGUID, nil)
+			So(status.FailureReason, ShouldStartWith, "Token refresh request failed")
+			So(status.Disconnected, ShouldBeFalse)
+		})
+
+		Convey("should disconnect the token if the endpoint rejects the refresh token", func() {
+			refreshErr = interfaces.ErrRefreshTokenRejected{InnerError: errors.New("invalid_grant")}
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCNSIGUID, mockUserGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+			mock.ExpectExec(updateTokens).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), tokenExpiry, true, "", nil, mockCNSIGUID, mockUserGUID, "cnsi", "test").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			pp.refreshEndpointToken(mockCNSIGUID, mockUserGUID)
+
+			So(refreshed, ShouldEqual, 1)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+			status := pp.TokenRefresher.status(mockCNSIGUID, mockUserGUID, nil)
+			So(status.FailureReason, ShouldEqual, "Token refresh request failed: invalid_grant")
+			So(status.Disconnected, ShouldBeTrue)
+		})
+	})
+
+	Convey("Tokens that can not be refreshed should be skipped", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		encryptedToken, _ := crypto.EncryptToken(pp.Config.EncryptionKeyInBytes, mockUAAToken)
+		mock.ExpectQuery(selectAnyFromTokens).
+			WithArgs(mockCNSIGUID, mockUserGUID, mockAdminGUID).
+			WillReturnRows(sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "disconnected", "auth_type", "meta_data", "user_guid", "linked_token"}).
+				AddRow(mockTokenGUID, encryptedToken, encryptedToken, mockTokenExpiry, false, interfaces.AuthTypeHttpBasic, "", mockUserGUID, nil))
+
+		pp.refreshEndpointToken(mockCNSIGUID, mockUserGUID)
+
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+		So(pp.TokenRefresher.status(mockCNSIGUID, mockUserGUID, nil), ShouldBeNil)
+	})
+
+	Convey("Only an invalid_grant response should reject the refresh token", t, func() {
+		invalidGrant := `{"error":"invalid_grant","error_description":"Invalid refresh token"}`
+		_, rejected := interfaces.NewTokenRefreshError(interfaces.ErrHTTPRequest{Status: http.StatusBadRequest, Response: invalidGrant}).(interfaces.ErrRefreshTokenRejected)
+		So(rejected, ShouldBeTrue)
+		_, rejected = interfaces.NewTokenRefreshError(interfaces.ErrHTTPRequest{Status: http.StatusUnauthorized, Response: invalidGrant}).(interfaces.ErrRefreshTokenRejected)
+		So(rejected, ShouldBeTrue)
+		_, rejected = interfaces.NewTokenRefreshError(interfaces.ErrHTTPRequest{Status: http.StatusBadRequest, Response: `{"error":"invalid_request"}`}).(interfaces.ErrRefreshTokenRejected)
+		So(rejected, ShouldBeFalse)
+		_, rejected = interfaces.NewTokenRefreshError(interfaces.ErrHTTPRequest{Status: http.StatusBadGateway, Response: invalidGrant}).(interfaces.ErrRefreshTokenRejected)
+		So(rejected, ShouldBeFalse)
+		_, rejected = interfaces.NewTokenRefreshError(errors.New("connection refused")).(interfaces.ErrRefreshTokenRejected)
+		So(rejected, ShouldBeFalse)
+	})
+
+	Convey("Should find the refresh function for each auth type", t, func() {
+		pp := setupPortalProxy(nil)
+		So(pp.getTokenRefreshFunc(interfaces.AuthTypeOAuth2), ShouldNotBeNil)
+		So(pp.getTokenRefreshFunc(interfaces.AuthTypeOIDC), ShouldNotBeNil)
+		So(pp.getTokenRefreshFunc(interfaces.AuthTypeHttpBasic), ShouldBeNil)
+	})
+}
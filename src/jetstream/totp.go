@@ -0,0 +1,511 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/localusers"
+)
+
+// TOTP as described in RFC 6238 - 6 digit codes from HMAC-SHA1 with a 30 second time step, as used by the common
+// authenticator apps
+const (
+	totpDigits            = 6
+	totpPeriod            = 30
+	totpSkew              = 1
+	totpSecretSize        = 20
+	totpIssuer            = "Stratos"
+	totpRecoveryCodeCount = 10
+
+	// How long a user has to enter their code after logging in with their password
+	totpPendingTimeout = 5 * time.Minute
+	// How many codes can be tried before the user has to log in with their password again
+	totpMaxAttempts = 5
+
+	totpPendingUserSessionKey     = "totp_user_id"
+	totpPendingExpirySessionKey   = "totp_exp"
+	totpPendingAttemptsSessionKey = "totp_attempts"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPLoginRes - Returned when the user's password is correct but they need to provide a TOTP code to complete login
+type TOTPLoginRes struct {
+	TOTPRequired bool  `json:"totp_required"`
+	Expiry       int64 `json:"totp_expiry"`
+}
+
+// TOTPEnrollmentRes - The secret for a new enrollment, to be added to the user's authenticator app
+type TOTPEnrollmentRes struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+}
+
+// TOTPStatusRes - Whether the user has enabled TOTP
+type TOTPStatusRes struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// TOTPRecoveryCodesRes - New recovery codes. These are only shown once
+type TOTPRecoveryCodesRes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func generateTOTPSecret() (string, error) {
+	secret, err := generateRandomBytes(totpSecretSize)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpCode generates the code for the given time step (RFC 4226 section 5.3)
+func totpCode(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
+
+// validateTOTPCode checks a code against the current time step and the steps either side of it, to allow for clock
+// drift. Codes from steps at or before lastUsedStep are rejected so that a code can only be used once. Returns the
+// step the code was valid for
+func validateTOTPCode(secret string, code string, now time.Time, lastUsedStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		log.Warnf("Invalid TOTP secret: %v", err)
+		return 0, false
+	}
+
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURL is the key URI used by authenticator apps, usually shown as a QR code
+func totpURL(username, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("digits", fmt.Sprintf("%d", totpDigits))
+	params.Set("period", fmt.Sprintf("%d", totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// generateRecoveryCodes returns new recovery codes and the hashes that should be stored. The codes are random enough
+// that a plain SHA-256 hash is sufficient
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, totpRecoveryCodeCount)
+	hashes := make([]string, totpRecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// useRecoveryCode removes the recovery code from the enrollment, if it is one of the user's codes
+func useRecoveryCode(totp *interfaces.LocalUserTOTP, code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, recoveryCode := range totp.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(recoveryCode), []byte(hash)) == 1 {
+			totp.RecoveryCodes = append(totp.RecoveryCodes[:i], totp.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// checkTOTPCode checks a code from the user's authenticator app, or one of their recovery codes, and updates the
+// enrollment so that it can't be used again
+func checkTOTPCode(totp *interfaces.LocalUserTOTP, code string, recoveryCode string) bool {
+	if len(recoveryCode) > 0 {
+		return useRecoveryCode(totp, recoveryCode)
+	}
+
+	step, ok := validateTOTPCode(totp.Secret, code, time.Now(), totp.LastUsedStep)
+	if ok {
+		totp.LastUsedStep = step
+	}
+	return ok
+}
+
+func (p *portalProxy) getLocalUserTOTP(userGUID string) (localusers.Repository, *interfaces.LocalUserTOTP, error) {
+	localUsersRepo, err := localusers.NewPgsqlLocalUsersRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, nil, interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to access local users",
+			"Database error getting repo for local users: %v", err)
+	}
+
+	totp, err := localUsersRepo.FindTOTP(userGUID, p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return nil, nil, interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to find TOTP enrollment",
+			"Unable to find TOTP enrollment for user %s: %v", userGUID, err)
+	}
+	return localUsersRepo, totp, nil
+}
+
+// startTOTPLogin is called once the user's password has been checked. The session only records that the user has a
+// pending login - they are not logged in until the TOTP code has been verified
+func (p *portalProxy) startTOTPLogin(c echo.Context, userGUID string) error {
+	log.Debug("startTOTPLogin")
+
+	expiry := time.Now().Add(totpPendingTimeout).Unix()
+	sessionValues := make(map[string]interface{})
+	sessionValues[totpPendingUserSessionKey] = userGUID
+	sessionValues[totpPendingExpirySessionKey] = expiry
+	sessionValues[totpPendingAttemptsSessionKey] = int64(0)
+
+	// Ensure that login disregards cookies from the request
+	req := c.Request()
+	req.Header.Set("Cookie", "")
+	if err := p.setSessionValues(c, sessionValues); err != nil {
+		return err
+	}
+
+	p.ensureXSRFToken(c)
+	setLoginPending(c)
+	setAuditDetail(c, "Second factor required")
+	return c.JSON(http.StatusAccepted, &TOTPLoginRes{
+		TOTPRequired: true,
+		Expiry:       expiry,
+	})
+}
+
+func (p *portalProxy) clearTOTPLogin(c echo.Context) {
+	p.unsetSessionValue(c, totpPendingUserSessionKey)
+	p.unsetSessionValue(c, totpPendingExpirySessionKey)
+	p.unsetSessionValue(c, totpPendingAttemptsSessionKey)
+}
+
+// localLoginTOTP is the second step of a local login, for users that have enabled TOTP. Invalid codes count against
+// the user that is logging in, in the same way as invalid passwords
+func (p *portalProxy) localLoginTOTP(c echo.Context) error {
+	log.Debug("localLoginTOTP")
+	userGUID, _ := p.GetSessionStringValue(c, totpPendingUserSessionKey)
+	return p.withLoginLockout(c, userGUID, p.checkLoginTOTP)
+}
+
+func (p *portalProxy) checkLoginTOTP(c echo.Context) error {
+	log.Debug("checkLoginTOTP")
+
+	if interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] != interfaces.Local {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Local Login is not enabled",
+			"Local Login is not enabled")
+	}
+
+	loginFailed := func(msg string) error {
+		return interfaces.NewHTTPShadowError(http.StatusUnauthorized, msg, "TOTP login failed: %s", msg)
+	}
+
+	userGUID, err := p.GetSessionStringValue(c, totpPendingUserSessionKey)
+	if err != nil {
+		return loginFailed("No login is pending")
+	}
+	setAuditTarget(c, userGUID)
+	expiry, err := p.GetSessionInt64Value(c, totpPendingExpirySessionKey)
+	if err != nil || time.Now().Unix() > expiry {
+		p.clearTOTPLogin(c)
+		return loginFailed("Login has expired")
+	}
+	attempts, _ := p.GetSessionInt64Value(c, totpPendingAttemptsSessionKey)
+	if attempts >= totpMaxAttempts {
+		p.clearTOTPLogin(c)
+		return loginFailed("Too many attempts")
+	}
+
+	localUsersRepo, totp, err := p.getLocalUserTOTP(userGUID)
+	if err != nil {
+		return err
+	}
+	if totp == nil || !totp.Enabled {
+		p.clearTOTPLogin(c)
+		return loginFailed("TOTP is not enabled")
+	}
+
+	if !checkTOTPCode(totp, c.FormValue("code"), c.FormValue("recovery_code")) {
+		p.setSessionValues(c, map[string]interface{}{totpPendingAttemptsSessionKey: attempts + 1})
+		return loginFailed("Invalid code")
+	}
+	if err := localUsersRepo.SaveTOTP(*totp, p.Config.EncryptionKeyInBytes); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to update TOTP enrollment",
+			"Unable to update TOTP enrollment for user %s: %v", userGUID, err)
+	}
+
+	// Check the user is still allowed to log in
+	user, err := localUsersRepo.FindUser(userGUID)
+	if err != nil || user.Disabled || !p.isValidLocalUserScope(user.Scope) {
+		p.clearTOTPLogin(c)
+		return loginFailed("Access Denied")
+	}
+
+	// Failed passwords are only forgotten now that both factors have been checked
+	p.clearTOTPLogin(c)
+	p.clearLoginFailures(user.Username)
+	return p.completeLocalLogin(c, userGUID, user.Username, user.Scope)
+}
+
+// getTOTPSessionUser returns the logged in user, if they are a local user
+func (p *portalProxy) getTOTPSessionUser(c echo.Context) (string, error) {
+	if interfaces.AuthEndpointTypes[p.Config.ConsoleConfig.AuthEndpointType] != interfaces.Local {
+		return "", interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"TOTP is only available for local users",
+			"TOTP is only available for local users")
+	}
+
+	userGUID, err := p.GetSessionStringValue(c, "user_id")
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusForbidden, "Could not find session user_id")
+	}
+	return userGUID, nil
+}
+
+func (p *portalProxy) getTOTPStatus(c echo.Context) error {
+	log.Debug("getTOTPStatus")
+	userGUID, err := p.getTOTPSessionUser(c)
+	if err != nil {
+		return err
+	}
+
+	_, totp, err := p.getLocalUserTOTP(userGUID)
+	if err != nil {
+		return err
+	}
+
+	status := &TOTPStatusRes{}
+	if totp != nil && totp.Enabled {
+		status.Enabled = true
+		status.RecoveryCodesRemaining = len(totp.RecoveryCodes)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// enrollTOTP generates a new secret for the user. TOTP is not enabled until a code has been verified
+func (p *portalProxy) enrollTOTP(c echo.Context) error {
+	log.Debug("enrollTOTP")
+	userGUID, err := p.getTOTPSessionUser(c)
+	if err != nil {
+		return err
+	}
+
+	localUsersRepo, totp, err := p.getLocalUserTOTP(userGUID)
+	if err != nil {
+		return err
+	}
+	if totp != nil && totp.Enabled {
+		return echo.NewHTTPError(http.StatusConflict, "TOTP is already enabled")
+	}
+
+	user, err := localUsersRepo.FindUser(userGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"User not found",
+			"Unable to find local user %s: %v", userGUID, err)
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return err
+	}
+	pending := interfaces.LocalUserTOTP{
+		UserGUID: userGUID,
+		Secret:   secret,
+	}
+	if err := localUsersRepo.SaveTOTP(pending, p.Config.EncryptionKeyInBytes); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to save TOTP enrollment",
+			"Unable to save TOTP enrollment for user %s: %v", userGUID, err)
+	}
+
+	return c.JSON(http.StatusOK, &TOTPEnrollmentRes{
+		Secret: secret,
+		URL:    totpURL(user.Username, secret),
+	})
+}
+
+// confirmTOTP enables TOTP once the user has shown that their authenticator app generates the right codes
+func (p *portalProxy) confirmTOTP(c echo.Context) error {
+	log.Debug("confirmTOTP")
+	userGUID, err := p.getTOTPSessionUser(c)
+	if err != nil {
+		return err
+	}
+
+	localUsersRepo, totp, err := p.getLocalUserTOTP(userGUID)
+	if err != nil {
+		return err
+	}
+	if totp == nil || totp.Enabled {
+		return echo.NewHTTPError(http.StatusBadRequest, "No TOTP enrollment is pending")
+	}
+
+	if !checkTOTPCode(totp, c.FormValue("code"), "") {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	totp.Enabled = true
+	totp.RecoveryCodes = hashes
+	if err := localUsersRepo.SaveTOTP(*totp, p.Config.EncryptionKeyInBytes); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to save TOTP enrollment",
+			"Unable to save TOTP enrollment for user %s: %v", userGUID, err)
+	}
+
+	log.Infof("Enabled TOTP for local user %s", userGUID)
+	return c.JSON(http.StatusOK, &TOTPRecoveryCodesRes{RecoveryCodes: codes})
+}
+
+// regenerateTOTPRecoveryCodes replaces all of the user's recovery codes
+func (p *portalProxy) regenerateTOTPRecoveryCodes(c echo.Context) error {
+	log.Debug("regenerateTOTPRecoveryCodes")
+	userGUID, err := p.getTOTPSessionUser(c)
+	if err != nil {
+		return err
+	}
+
+	localUsersRepo, totp, err := p.getLocalUserTOTP(userGUID)
+	if err != nil {
+		return err
+	}
+	if totp == nil || !totp.Enabled {
+		return echo.NewHTTPError(http.StatusBadRequest, "TOTP is not enabled")
+	}
+	if !checkTOTPCode(totp, c.FormValue("code"), "") {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	totp.RecoveryCodes = hashes
+	if err := localUsersRepo.SaveTOTP(*totp, p.Config.EncryptionKeyInBytes); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to save TOTP enrollment",
+			"Unable to save TOTP enrollment for user %s: %v", userGUID, err)
+	}
+
+	return c.JSON(http.StatusOK, &TOTPRecoveryCodesRes{RecoveryCodes: codes})
+}
+
+// disableTOTP removes the user's own enrollment. A current code (or recovery code) is needed
+func (p *portalProxy) disableTOTP(c echo.Context) error {
+	log.Debug("disableTOTP")
+	userGUID, err := p.getTOTPSessionUser(c)
+	if err != nil {
+		return err
+	}
+
+	localUsersRepo, totp, err := p.getLocalUserTOTP(userGUID)
+	if err != nil {
+		return err
+	}
+	if totp == nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+	if totp.Enabled && !checkTOTPCode(totp, c.FormValue("code"), c.FormValue("recovery_code")) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid code")
+	}
+
+	if err := localUsersRepo.DeleteTOTP(userGUID); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to disable TOTP",
+			"Unable to disable TOTP for user %s: %v", userGUID, err)
+	}
+
+	log.Infof("Disabled TOTP for local user %s", userGUID)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// resetLocalUserTOTP lets an admin remove a user's enrollment, e.g. if they have lost their device and recovery codes
+func (p *portalProxy) resetLocalUserTOTP(c echo.Context) error {
+	log.Debug("resetLocalUserTOTP")
+	localUsersRepo, err := p.getLocalUsersRepo()
+	if err != nil {
+		return err
+	}
+
+	userGUID := c.Param("id")
+	if _, err := p.findLocalUser(localUsersRepo, userGUID); err != nil {
+		return err
+	}
+
+	if err := localUsersRepo.DeleteTOTP(userGUID); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to reset TOTP",
+			"Unable to reset TOTP for user %s: %v", userGUID, err)
+	}
+
+	log.Infof("Reset TOTP for local user %s", userGUID)
+	return c.NoContent(http.StatusNoContent)
+}
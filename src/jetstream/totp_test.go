@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Secret from the RFC 6238 test vectors ("12345678901234567890")
+const mockTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodes(t *testing.T) {
+	t.Parallel()
+
+	Convey("TOTP code tests", t, func() {
+		Convey("should match the RFC 6238 test vectors", func() {
+			key, _ := totpEncoding.DecodeString(mockTOTPSecret)
+			So(totpCode(key, 59/totpPeriod), ShouldEqual, "287082")
+			So(totpCode(key, 1111111109/totpPeriod), ShouldEqual, "081804")
+			So(totpCode(key, 1234567890/totpPeriod), ShouldEqual, "005924")
+		})
+
+		Convey("should accept codes either side of the current step", func() {
+			now := time.Unix(1234567890, 0)
+			key, _ := totpEncoding.DecodeString(mockTOTPSecret)
+			step := now.Unix() / totpPeriod
+
+			_, ok := validateTOTPCode(mockTOTPSecret, totpCode(key, step-1), now, 0)
+			So(ok, ShouldBeTrue)
+			_, ok = validateTOTPCode(mockTOTPSecret, totpCode(key, step+1), now, 0)
+			So(ok, ShouldBeTrue)
+			_, ok = validateTOTPCode(mockTOTPSecret, totpCode(key, step+2), now, 0)
+			So(ok, ShouldBeFalse)
+			_, ok = validateTOTPCode(mockTOTPSecret, "12345", now, 0)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("should not accept a code twice", func() {
+			now := time.Unix(1234567890, 0)
+			step, ok := validateTOTPCode(mockTOTPSecret, "005924", now, 0)
+			So(ok, ShouldBeTrue)
+			_, ok = validateTOTPCode(mockTOTPSecret, "005924", now, step)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("should generate a new secret", func() {
+			secret, err := generateTOTPSecret()
+			So(err, ShouldBeNil)
+			key, err := totpEncoding.DecodeString(secret)
+			So(err, ShouldBeNil)
+			So(key, ShouldHaveLength, totpSecretSize)
+			So(totpURL("jdoe", secret), ShouldStartWith, "otpauth://totp/Stratos:jdoe?")
+		})
+
+		Convey("recovery codes should only be used once", func() {
+			codes, hashes, err := generateRecoveryCodes()
+			So(err, ShouldBeNil)
+			So(codes, ShouldHaveLength, totpRecoveryCodeCount)
+
+			totp := &interfaces.LocalUserTOTP{RecoveryCodes: hashes}
+			So(useRecoveryCode(totp, strings.ToUpper(codes[3])), ShouldBeTrue)
+			So(totp.RecoveryCodes, ShouldHaveLength, totpRecoveryCodeCount-1)
+			So(useRecoveryCode(totp, codes[3]), ShouldBeFalse)
+		})
+	})
+}
+
+func TestLocalLoginWithTOTP(t *testing.T) {
+	t.Parallel()
+
+	Convey("Local login with TOTP", t, func() {
+		passwordHash, _ := HashPassword("changeme")
+		key, _ := totpEncoding.DecodeString(mockTOTPSecret)
+		encryptedSecret, _ := crypto.EncryptToken(mockEncryptionKey, mockTOTPSecret)
+		_, recoveryHashes, _ := generateRecoveryCodes()
+		totpRows := func() sqlmock.Rows {
+			return sqlmock.NewRows([]string{"secret", "enabled", "recovery_codes", "last_used_step"}).
+				AddRow(encryptedSecret, true, strings.Join(recoveryHashes, ","), 0)
+		}
+
+		req := setupMockReq("POST", "", map[string]string{
+			"username": "dev",
+			"password": "changeme",
+		})
+		res, _, ctx, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+		pp.Config.ConsoleConfig.AuthEndpointType = string(interfaces.Local)
+
+		mock.ExpectQuery(findUserGUID).WithArgs("dev").WillReturnRows(sqlmock.NewRows([]string{"user_guid"}).AddRow(mockUserGUID))
+		mock.ExpectQuery(findPasswordHash).WithArgs(mockUserGUID).WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(passwordHash))
+		mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+			WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("dev", "", defaultUserScope, "", "", false))
+		mock.ExpectExec(updateLastLoginTime).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(findTOTP).WithArgs(mockUserGUID).WillReturnRows(totpRows())
+
+		So(pp.localLogin(ctx), ShouldBeNil)
+		So(res.Code, ShouldEqual, http.StatusAccepted)
+		So(res.Body.String(), ShouldContainSubstring, `"totp_required":true`)
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+
+		// Not logged in until the code has been verified
+		_, err := pp.GetSessionStringValue(ctx, "user_id")
+		So(err, ShouldNotBeNil)
+
+		// The second step uses the same session
+		secondStep := func(code string) (*httptest.ResponseRecorder, error) {
+			req := setupMockReq("POST", "", map[string]string{"code": code})
+			res, _, totpCtx, _, _, _ := setupHTTPTest(req)
+			totpCtx.Set(jetStreamSessionContextKey, ctx.Get(jetStreamSessionContextKey))
+			err := pp.localLoginTOTP(totpCtx)
+			return res, err
+		}
+
+		Convey("should log in with a valid code", func() {
+			mock.ExpectQuery(findTOTP).WithArgs(mockUserGUID).WillReturnRows(totpRows())
+			mock.ExpectExec(`UPDATE local_users_totp (.+)`).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("dev", "", defaultUserScope, "", "", false))
+
+			result, err := secondStep(totpCode(key, time.Now().Unix()/totpPeriod))
+			So(err, ShouldBeNil)
+			So(result.Code, ShouldEqual, http.StatusOK)
+
+			loginRes := &interfaces.LoginRes{}
+			So(json.Unmarshal(result.Body.Bytes(), loginRes), ShouldBeNil)
+			So(loginRes.Account, ShouldEqual, "dev")
+			So(loginRes.Admin, ShouldBeFalse)
+
+			userGUID, err := pp.GetSessionStringValue(ctx, "user_id")
+			So(err, ShouldBeNil)
+			So(userGUID, ShouldEqual, mockUserGUID)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should reject an invalid code", func() {
+			mock.ExpectQuery(findTOTP).WithArgs(mockUserGUID).WillReturnRows(totpRows())
+
+			_, err := secondStep("000000")
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusUnauthorized)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should count an invalid code against the user", func() {
+			pp.Config.LoginLockoutThreshold = 3
+			mock.ExpectQuery(findLoginAttempts).WithArgs(interfaces.LoginAttemptsUser, mockUserGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"failures", "last_failure", "locked_until"}).AddRow(1, time.Now(), nil))
+			mock.ExpectQuery(findTOTP).WithArgs(mockUserGUID).WillReturnRows(totpRows())
+			mock.ExpectBegin()
+			mock.ExpectExec(addLoginFailure).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), interfaces.LoginAttemptsUser, mockUserGUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(findLoginFailures).WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(2))
+			mock.ExpectCommit()
+
+			_, err := secondStep("000000")
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should refuse codes while the user is locked out", func() {
+			pp.Config.LoginLockoutThreshold = 3
+			lockedUntil := time.Now().Add(time.Minute)
+			mock.ExpectQuery(findLoginAttempts).WithArgs(interfaces.LoginAttemptsUser, mockUserGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"failures", "last_failure", "locked_until"}).AddRow(3, time.Now(), &lockedUntil))
+
+			_, err := secondStep(totpCode(key, time.Now().Unix()/totpPeriod))
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusTooManyRequests)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should clear the user's failures once the code is valid", func() {
+			pp.Config.LoginLockoutThreshold = 3
+			mock.ExpectQuery(findLoginAttempts).WithArgs(interfaces.LoginAttemptsUser, mockUserGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"failures", "last_failure", "locked_until"}).AddRow(1, time.Now(), nil))
+			mock.ExpectQuery(findTOTP).WithArgs(mockUserGUID).WillReturnRows(totpRows())
+			mock.ExpectExec(`UPDATE local_users_totp (.+)`).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(findLocalUser).WithArgs(mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForLocalUser).AddRow("dev", "", defaultUserScope, "", "", false))
+			mock.ExpectExec(deleteLoginAttempts).WithArgs(interfaces.LoginAttemptsUser, "dev").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(deleteLoginAttempts).WithArgs(interfaces.LoginAttemptsUser, mockUserGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			result, err := secondStep(totpCode(key, time.Now().Unix()/totpPeriod))
+			So(err, ShouldBeNil)
+			So(result.Code, ShouldEqual, http.StatusOK)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should give up after too many attempts", func() {
+			pp.setSessionValues(ctx, map[string]interface{}{totpPendingAttemptsSessionKey: int64(totpMaxAttempts)})
+
+			_, err := secondStep(totpCode(key, time.Now().Unix()/totpPeriod))
+			So(err, ShouldNotBeNil)
+			_, err = pp.GetSessionStringValue(ctx, totpPendingUserSessionKey)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should reject an expired login", func() {
+			pp.setSessionValues(ctx, map[string]interface{}{totpPendingExpirySessionKey: time.Now().Add(-time.Minute).Unix()})
+
+			_, err := secondStep(totpCode(key, time.Now().Unix()/totpPeriod))
+			So(err, ShouldNotBeNil)
+			So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}